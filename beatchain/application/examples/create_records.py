@@ -61,12 +61,13 @@ print('AppDev user registered')
 
 
 print('Creating a new product')
-product_id = loop.run_until_complete(operations.invoke(creator_member['org'],
-                                                       creator_member['username'],
-                                                       creator_member['password'],
-                                                       constants.channel_name,
-                                                       function='AddProduct',
-                                                       args=['Test Product Name']))
+response = loop.run_until_complete(operations.invoke(creator_member['org'],
+                                                     creator_member['username'],
+                                                     creator_member['password'],
+                                                     constants.channel_name,
+                                                     function='AddProduct',
+                                                     args=['Test Product Name']))
+product_id = operations.record_id(response)
 print('New Product created with ID: ', product_id)
 
 
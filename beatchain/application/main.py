@@ -214,7 +214,7 @@ async def add_creator(req: constants.AddUserRecordRequest):
 
     # Attempt to parse out the creator ID from the response
     try:
-        creator_id = int(response)
+        creator_id = int(operations.record_id(response))
     except Exception as e:
         content = {'Status': 'Cannot parse int creator id from response: ' + response,
                    'ID': None,
@@ -279,7 +279,7 @@ async def add_appdev(req: constants.AddUserRecordRequest,
     # Attempt to parse out the appdev ID from the response
     print('add appdev response: ', response)
     try:
-        appdev_id = int(response)
+        appdev_id = int(operations.record_id(response))
     except Exception as e:
         content = {'Status': 'Cannot parse int appdev_id from response: ' + response,
                    'ID': None,
@@ -348,7 +348,7 @@ async def add_customer(req: constants.AddUserRecordRequest,
 
     # Attempt to parse out the creator ID from the response
     try:
-        customer_id = int(response)
+        customer_id = int(operations.record_id(response))
     except Exception as e:
         content = {'Status': 'Cannot parse int Customer id from response: ' + response,
                    'ID': None,
@@ -405,7 +405,7 @@ async def add_product(req: constants.AddProductRequest):
                    'Error': repr(e)}
         return JSONResponse(status_code=500, content=content)
     content = {'Status': 'Product Creation Succeeded',
-               'ID': response,
+               'ID': operations.record_id(response),
                'Error': None}
     return JSONResponse(status_code=201, content=content)
 
@@ -422,7 +422,9 @@ async def invoke_request(req: constants.InvokeRequest,
                    ):
     """
     Submits a blockchain transaction invocation to a subset of the peers in
-    the network.
+    the network. Confidential inputs, e.g. the terms and salt seed of an
+    OfferContract, must be sent base64-encoded in the request's transient
+    data rather than its args.
     """
     try:
         response = await operations.invoke(org_name,
@@ -430,7 +432,8 @@ async def invoke_request(req: constants.InvokeRequest,
                                      req.user_password,
                                      channel_name,
                                      function,
-                                     req.args)
+                                     req.args,
+                                     req.transient)
     except Exception as e:
         content = {'Status': 'Invoke Request failed',
                    'Response': None,
@@ -459,7 +462,8 @@ async def query_request(req: constants.InvokeRequest,
                                      req.user_password,
                                      channel_name,
                                      function,
-                                     req.args)
+                                     req.args,
+                                     req.transient)
     except Exception as e:
         content = {'Status': 'Query Request Failed',
                    'Response': None,
@@ -17,6 +17,34 @@ class InvokeFunctions(str, Enum):
     AcceptContract = "AcceptContract"
     RejectContract = "RejectContract"
     RequestSong = "RequestSong"
+    RecordEngagement = "RecordEngagement"
+    CreatePlaylist = "CreatePlaylist"
+    UpdatePlaylist = "UpdatePlaylist"
+    SharePlaylist = "SharePlaylist"
+    EnqueueSongs = "EnqueueSongs"
+    EnqueuePlaylist = "EnqueuePlaylist"
+    ClearPlayQueue = "ClearPlayQueue"
+    NextSong = "NextSong"
+    IssueInvoice = "IssueInvoice"
+    BulkImport = "BulkImport"
+    AddSubscription = "AddSubscription"
+    CancelSubscription = "CancelSubscription"
+    CloseCustomer = "CloseCustomer"
+    CloseCreator = "CloseCreator"
+    CloseAppDev = "CloseAppDev"
+    ApproveTransfer = "ApproveTransfer"
+    SetTransferPolicy = "SetTransferPolicy"
+    SetFraudRules = "SetFraudRules"
+    ReviewFlaggedStream = "ReviewFlaggedStream"
+    RequestWithdrawal = "RequestWithdrawal"
+    ApproveWithdrawal = "ApproveWithdrawal"
+    RejectWithdrawal = "RejectWithdrawal"
+    SetWithdrawalLimits = "SetWithdrawalLimits"
+    OpenDispute = "OpenDispute"
+    SubmitDisputeEvidence = "SubmitDisputeEvidence"
+    ResolveDispute = "ResolveDispute"
+    RebuildIndexes = "RebuildIndexes"
+    DeleteProduct = "DeleteProduct"
 
 class QueryFunctions(str, Enum):
     """
@@ -26,6 +54,45 @@ class QueryFunctions(str, Enum):
     """
     ListBankAccounts = "ListBankAccounts"
     ListCustomers = "ListCustomers"
+    ListAllCustomers = "ListAllCustomers"
+    ListAppCustomers = "ListAppCustomers"
+    GetContractTerms = "GetContractTerms"
+    GetCreatorStatement = "GetCreatorStatement"
+    GetAppDevPayables = "GetAppDevPayables"
+    GetRecordHistory = "GetRecordHistory"
+    MigrationStatus = "MigrationStatus"
+    ListWithdrawals = "ListWithdrawals"
+    ListTransferProposals = "ListTransferProposals"
+    ListDisputes = "ListDisputes"
+    GetFlaggedStreams = "GetFlaggedStreams"
+    GetPlaylists = "GetPlaylists"
+    GetPlayQueue = "GetPlayQueue"
+    ListCreatorProducts = "ListCreatorProducts"
+    ListAppDevContracts = "ListAppDevContracts"
+    ListProductContracts = "ListProductContracts"
+
+class ErrorCodes(str, Enum):
+    """
+    Codes of the JSON error body returned by failed chaincode calls
+    Note: These names must match those specified in
+    ../chaincode/src/github.com/beatchain/utils/errorUtils.go
+    """
+    NOT_FOUND = "NOT_FOUND"
+    FORBIDDEN = "FORBIDDEN"
+    INVALID_ARGUMENT = "INVALID_ARGUMENT"
+    INSUFFICIENT_FUNDS = "INSUFFICIENT_FUNDS"
+    CONFLICT = "CONFLICT"
+    INTERNAL = "INTERNAL"
+
+class ResponseFormats(str, Enum):
+    """
+    Formats of successful chaincode responses, requested per call under
+    response_format_transient_key
+    Note: These names must match those specified in
+    ../chaincode/src/github.com/beatchain/utils/responseUtils.go
+    """
+    JSON = "JSON"
+    TEXT = "TEXT"
 
 class OrgNames(str, Enum):
     """
@@ -94,6 +161,9 @@ class InvokeRequest(BaseModel):
     user_name: str
     user_password: str
     args: List[str] = []
+    # Transient data keyed by name with base64-encoded values, e.g. the
+    # 'contractterms' and 'contractsalt' of an OfferContract
+    transient: Dict[str, str] = {}
 
 class AddProductRequest(BaseModel):
     user_name: str
@@ -126,6 +196,10 @@ chaincode_name = 'beatchain'
 chaincode_version = 'v0.1'
 chaincode_store_path = '../chaincode'
 chaincode_gopath_rel_path = 'github.com/beatchain'
+chaincode_build_tags = ['experimental']  # private data collections of the vendored shim
+collections_config_path = '../chaincode/src/github.com/beatchain/collections_config.json'
+contract_salt_transient_key = 'contractsalt'  # seed of the salts of private contract terms
+response_format_transient_key = 'responseformat'  # ResponseFormats of a single call; JSON if not given
 
 FULL_CHANNEL_POLICY = [{
     'role': {
@@ -178,7 +252,7 @@ TEST_PRODUCT_UNREN_METRICS = "4"
 TEST_PRODUCT_ADD_METRICS = "0"
 TEST_PRODUCT_ACTIVE = "true"
 TEST_CONTRACT_PPS = "0.01"
-TEST_CONTRACT_STATUS = "true"
+TEST_CONTRACT_STATUS = "ACCEPTED"
 
 instantiation_args = [
     BEATCHAIN_ADMIN_BALANCE,
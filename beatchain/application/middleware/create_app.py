@@ -77,6 +77,41 @@ async def join_channel(hf_client: Client, channel_name: str) -> Client:
             raise ValueError(f'Org {org} failed to joined channel {channel_name}')
     return hf_client
 
+def stage_chaincode(chaincode_store_path: str, build_tags: List[str]) -> str:
+    """
+    Copies the chaincode to a staging folder with the given Go build tags applied. Peers build
+    installed Go chaincode without build tags, but the vendored shim only exposes the private
+    data collections holding contract terms under the 'experimental' tag. Files constrained to
+    one of the tags are kept unconstrained and files constrained to its negation are dropped,
+    so the peer builds the chaincode as if it were passed '-tags <build_tags>'.
+    Args:
+        chaincode_store_path: Folder holding the chaincode, laid out as for install_chaincode
+        build_tags: Go build tags to apply
+    Returns:
+        staged_store_path: Folder holding the staged copy of the chaincode
+    """
+    staged_store_path = os.path.abspath('./tmp/chaincode')
+    if os.path.exists(staged_store_path):
+        shutil.rmtree(staged_store_path)
+    shutil.copytree(chaincode_store_path, staged_store_path, symlinks=True)
+
+    for root, _, files in os.walk(staged_store_path):
+        for file_name in files:
+            if not file_name.endswith('.go'):
+                continue
+            file_path = os.path.join(root, file_name)
+            with open(file_path) as go_file:
+                lines = go_file.readlines()
+            if not lines:
+                continue
+            constraint = lines[0].strip()
+            if constraint in [f'// +build {tag}' for tag in build_tags]:
+                with open(file_path, 'w') as go_file:
+                    go_file.writelines(lines[1:])
+            elif constraint in [f'// +build !{tag}' for tag in build_tags]:
+                os.remove(file_path)
+    return staged_store_path
+
 async def install_chaincode(hf_client: Client,
                       chaincode_store_path: str,
                       chaincode_gopath_rel_path: str,
@@ -157,8 +192,9 @@ async def instantiate_chaincode(hf_client,
                                         cc_name=chaincode_name,
                                         cc_version=chaincode_version,
                                         cc_endorsement_policy=policy,
-                                        collections_config=None, # optional, for private data policy
-                                        transient_map=None, # optional, for private data
+                                        collections_config=constants.collections_config_path, # private contract terms
+                                        # seed of the salts hashed with the fixture contract's private terms
+                                        transient_map={constants.contract_salt_transient_key: os.urandom(32)},
                                         wait_for_event=True # optional, for being sure chaincode is instantiated
                                         )
     if response:
@@ -176,7 +212,7 @@ async def create_app(install_org: str,
     Bootstraps application creation by
     1. Creating the channel
     2. Joining all org peers to the channel
-    3. Install the chaincode on all peers
+    3. Install the chaincode, staged with its build tags, on all peers
     4. Instantiating the chaincode on all peers
 
     All network, channel, and chaincode settings are taken from constants
@@ -210,8 +246,12 @@ async def create_app(install_org: str,
 
     hf_client = await join_channel(hf_client=hf_client, channel_name=constants.channel_name)
 
+    # Build the chaincode with the tags enabling private data in the vendored shim
+    chaincode_store_path = stage_chaincode(chaincode_store_path=constants.chaincode_store_path,
+                                           build_tags=constants.chaincode_build_tags)
+
     hf_client = await install_chaincode(hf_client=hf_client,
-                                  chaincode_store_path=constants.chaincode_store_path,
+                                  chaincode_store_path=chaincode_store_path,
                                   chaincode_gopath_rel_path=constants.chaincode_gopath_rel_path,
                                   chaincode_name=constants.chaincode_name,
                                   chaincode_version=constants.chaincode_version)
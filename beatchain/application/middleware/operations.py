@@ -1,6 +1,8 @@
 # Blockchain & Applications Project 2: Beatchain
 # Owner(s): Cody Gilbert
 
+import base64
+import json
 import random
 from typing import List, Optional, Dict
 from hfc.fabric import Client
@@ -12,7 +14,8 @@ async def invoke(org_name: str,
            user_password: str,
            channel_name: str,
            function: str,
-           args: List[str]) -> str:
+           args: List[str],
+           transient: Optional[Dict[str, str]] = None) -> str:
     """
     Submits a blockchain transaction invocation to all the peers in
     the network.
@@ -24,6 +27,9 @@ async def invoke(org_name: str,
         channel_name: Name of the channel on which to connect client
         function: Name of the chaincode function to invoke
         args: A list of string arguments passed to the chaincode
+        transient: Optional transient data passed to the chaincode, keyed by name
+            with base64-encoded values. It is not recorded in the transaction, so
+            confidential inputs such as contract terms must be passed here.
     Returns:
         Response string from the *first* peer that responded with
         confirmation of the execution.
@@ -59,7 +65,7 @@ async def invoke(org_name: str,
                                                 fcn=function,
                                                 args=args,
                                                 cc_name=constants.chaincode_name,
-                                                transient_map=None, # optional, for private data
+                                                transient_map=transient_map(transient),
                                                 wait_for_event=True, # for being sure chaincode invocation has been commited in the ledger, default is on tx event
                                                )
 
@@ -73,7 +79,8 @@ async def query(org_name: str,
           user_password: str,
           channel_name: str,
           function: str,
-          args: List[str]) -> str:
+          args: List[str],
+          transient: Optional[Dict[str, str]] = None) -> str:
     """
     Submits a ledger query to a single peer within the specified org.
     Note that queries will NOT submit any changes to the ledger state,
@@ -85,6 +92,8 @@ async def query(org_name: str,
         channel_name: Name of the channel on which to connect client
         function: Name of the chaincode function to invoke
         args: A list of string arguments passed to the chaincode
+        transient: Optional transient data passed to the chaincode, keyed by name
+            with base64-encoded values, e.g. the page of a listing
     Returns:
         Response string from the given peer
     """
@@ -111,11 +120,40 @@ async def query(org_name: str,
                                                 fcn=function,
                                                 peers=[random_peer],
                                                 args=args,
-                                                cc_name=constants.chaincode_name)
+                                                cc_name=constants.chaincode_name,
+                                                transient_map=transient_map(transient))
     if not response:
         raise ValueError(f'Failure to query chaincode function {function} with response: {response}')
     return response
 
+def transient_map(transient: Optional[Dict[str, str]]) -> Optional[Dict[str, bytes]]:
+    """
+    Decodes the transient data of a request into the map passed to the chaincode.
+
+    Args:
+        transient: Transient data keyed by name with base64-encoded values
+    Returns:
+        The decoded transient map, or None if no transient data was given.
+    """
+    if not transient:
+        return None
+    return {key: base64.b64decode(value) for key, value in transient.items()}
+
+def record_id(response: str) -> str:
+    """
+    Extracts the ID of the record created by a chaincode invocation.
+
+    Args:
+        response: Response string returned by invoke
+    Returns:
+        The 'id' of the record in the response envelope's data, or the
+        response itself if the chaincode returns TEXT responses.
+    """
+    try:
+        return json.loads(response)['data']['id']
+    except (ValueError, KeyError, TypeError):
+        return response
+
 def get_network_info() -> Dict:
     '''
     Returns the dictionary containing the network information
@@ -0,0 +1,263 @@
+/*
+Methods calling the administrative transactions of transactions/admin
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/beatchain/utils"
+)
+
+func (client *Client) AddProduct(ctx context.Context, productName string) (*utils.Product, error) {
+	/*
+		Adds a product of the calling Creator. See admin.AddProduct.
+	*/
+	var product utils.Product
+	_, err := client.invoke(ctx, &Call{Function: "AddProduct", Args: []string{productName}}, &product)
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+func (client *Client) DeleteProduct(ctx context.Context, productId string) (*utils.Product, error) {
+	/*
+		Deactivates a product of the calling Creator. See admin.DeleteProduct.
+	*/
+	var product utils.Product
+	_, err := client.invoke(ctx, &Call{Function: "DeleteProduct", Args: []string{productId}}, &product)
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+func (client *Client) AddCustomerRecord(ctx context.Context, subscriptionFee float32) (*utils.CustomerRecord, error) {
+	/*
+		Adds a Customer subscribing through the calling AppDev. See admin.AddCustomerRecord.
+	*/
+	var customer utils.CustomerRecord
+	call := &Call{Function: "AddCustomerRecord", Args: []string{formatAmount(subscriptionFee)}}
+	_, err := client.invoke(ctx, call, &customer)
+	if err != nil {
+		return nil, err
+	}
+	return &customer, nil
+}
+
+func (client *Client) AddSubscription(ctx context.Context, customerId string, appDevId string, subscriptionFee float32) (*utils.CustomerRecord, error) {
+	/*
+		Subscribes an existing Customer through another AppDev. See admin.AddSubscription.
+	*/
+	var customer utils.CustomerRecord
+	call := &Call{Function: "AddSubscription", Args: []string{customerId, appDevId, formatAmount(subscriptionFee)}}
+	_, err := client.invoke(ctx, call, &customer)
+	if err != nil {
+		return nil, err
+	}
+	return &customer, nil
+}
+
+func (client *Client) CancelSubscription(ctx context.Context, customerId string, appDevId string) (*utils.CustomerRecord, error) {
+	/*
+		Ends a Customer's subscription through an AppDev. See admin.CancelSubscription.
+	*/
+	var customer utils.CustomerRecord
+	_, err := client.invoke(ctx, &Call{Function: "CancelSubscription", Args: []string{customerId, appDevId}}, &customer)
+	if err != nil {
+		return nil, err
+	}
+	return &customer, nil
+}
+
+func (client *Client) AddCreatorRecord(ctx context.Context) (*utils.CreatorRecord, error) {
+	/*
+		Adds a Creator. See admin.AddCreatorRecord.
+	*/
+	var creator utils.CreatorRecord
+	_, err := client.invoke(ctx, &Call{Function: "AddCreatorRecord"}, &creator)
+	if err != nil {
+		return nil, err
+	}
+	return &creator, nil
+}
+
+func (client *Client) AddAppDevRecord(ctx context.Context, adminFeeFrac float32) (*utils.AppDevRecord, error) {
+	/*
+		Adds an AppDev paying the given fraction of its subscription fees to the Beatchain
+		admin. See admin.AddAppDevRecord.
+	*/
+	var appDev utils.AppDevRecord
+	_, err := client.invoke(ctx, &Call{Function: "AddAppDevRecord", Args: []string{formatAmount(adminFeeFrac)}}, &appDev)
+	if err != nil {
+		return nil, err
+	}
+	return &appDev, nil
+}
+
+func (client *Client) BulkImport(ctx context.Context, recordType string, mode string, rows interface{}) (*utils.BulkImportReport, error) {
+	/*
+		Creates a batch of customers, creators or products. The rows are marshaled to the
+		JSON array the chaincode expects. See admin.BulkImport.
+	*/
+	var report utils.BulkImportReport
+
+	rowsBytes, err := json.Marshal(rows)
+	if err != nil {
+		return nil, err
+	}
+	call := &Call{Function: "BulkImport", Args: []string{recordType, mode, string(rowsBytes)}}
+	_, err = client.invoke(ctx, call, &report)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (client *Client) CloseCustomer(ctx context.Context, customerId string, disposition string) (*utils.Tombstone, error) {
+	/*
+		Offboards a Customer. See admin.CloseCustomer.
+	*/
+	return client.close(ctx, "CloseCustomer", customerId, disposition)
+}
+
+func (client *Client) CloseCreator(ctx context.Context, creatorId string, disposition string) (*utils.Tombstone, error) {
+	/*
+		Offboards a Creator. See admin.CloseCreator.
+	*/
+	return client.close(ctx, "CloseCreator", creatorId, disposition)
+}
+
+func (client *Client) CloseAppDev(ctx context.Context, appDevId string, disposition string) (*utils.Tombstone, error) {
+	/*
+		Offboards an AppDev. See admin.CloseAppDev.
+	*/
+	return client.close(ctx, "CloseAppDev", appDevId, disposition)
+}
+
+func (client *Client) close(ctx context.Context, function string, id string, disposition string) (*utils.Tombstone, error) {
+	var tombstone utils.Tombstone
+	_, err := client.invoke(ctx, &Call{Function: function, Args: []string{id, disposition}}, &tombstone)
+	if err != nil {
+		return nil, err
+	}
+	return &tombstone, nil
+}
+
+func (client *Client) ListBankAccounts(ctx context.Context, page *utils.Page) ([]*utils.BankAccount, *utils.Page, error) {
+	/*
+		Lists the bank accounts on the ledger. See admin.ListBankAccounts.
+	*/
+	var bankAccounts []*utils.BankAccount
+	returned, err := client.queryPage(ctx, &Call{Function: "ListBankAccounts"}, page, &bankAccounts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bankAccounts, returned, nil
+}
+
+func (client *Client) ListAllCustomers(ctx context.Context, page *utils.Page) ([]*utils.CustomerRecord, *utils.Page, error) {
+	/*
+		Lists the customers on the ledger. See admin.ListAllCustomers.
+	*/
+	var customers []*utils.CustomerRecord
+	returned, err := client.queryPage(ctx, &Call{Function: "ListAllCustomers"}, page, &customers)
+	if err != nil {
+		return nil, nil, err
+	}
+	return customers, returned, nil
+}
+
+func (client *Client) ListAppCustomers(ctx context.Context, appDevId string, page *utils.Page) ([]*utils.CustomerRecord, *utils.Page, error) {
+	/*
+		Lists the customers subscribed through an AppDev. See admin.ListAppCustomers.
+	*/
+	var customers []*utils.CustomerRecord
+	call := &Call{Function: "ListAppCustomers", Args: []string{appDevId}}
+	returned, err := client.queryPage(ctx, call, page, &customers)
+	if err != nil {
+		return nil, nil, err
+	}
+	return customers, returned, nil
+}
+
+func (client *Client) ListCreatorProducts(ctx context.Context, creatorId string, page *utils.Page) ([]*utils.Product, *utils.Page, error) {
+	/*
+		Lists the products of a Creator. See admin.ListCreatorProducts.
+	*/
+	var products []*utils.Product
+	call := &Call{Function: "ListCreatorProducts", Args: []string{creatorId}}
+	returned, err := client.queryPage(ctx, call, page, &products)
+	if err != nil {
+		return nil, nil, err
+	}
+	return products, returned, nil
+}
+
+func (client *Client) ListAppDevContracts(ctx context.Context, appDevId string, page *utils.Page) ([]*utils.Contract, *utils.Page, error) {
+	/*
+		Lists the contracts of an AppDev. See admin.ListAppDevContracts.
+	*/
+	var contracts []*utils.Contract
+	call := &Call{Function: "ListAppDevContracts", Args: []string{appDevId}}
+	returned, err := client.queryPage(ctx, call, page, &contracts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return contracts, returned, nil
+}
+
+func (client *Client) ListProductContracts(ctx context.Context, productId string, page *utils.Page) ([]*utils.Contract, *utils.Page, error) {
+	/*
+		Lists the contracts of a product. See admin.ListProductContracts.
+	*/
+	var contracts []*utils.Contract
+	call := &Call{Function: "ListProductContracts", Args: []string{productId}}
+	returned, err := client.queryPage(ctx, call, page, &contracts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return contracts, returned, nil
+}
+
+func (client *Client) GetRecordHistory(ctx context.Context, recordType string, ids []string, page *utils.Page) ([]utils.RecordHistoryEntry, *utils.Page, error) {
+	/*
+		Lists the changes made to a record, oldest first. Contracts take {CreatorID, AppDevID,
+		ProductID}. Each entry's record is decoded as a generic JSON object. See
+		admin.GetRecordHistory.
+	*/
+	var history []utils.RecordHistoryEntry
+	call := &Call{Function: "GetRecordHistory", Args: append([]string{recordType}, ids...)}
+	returned, err := client.queryPage(ctx, call, page, &history)
+	if err != nil {
+		return nil, nil, err
+	}
+	return history, returned, nil
+}
+
+func (client *Client) MigrationStatus(ctx context.Context) (*utils.MigrationStatus, error) {
+	/*
+		Reports the schema versions of the records on the ledger. See admin.MigrationStatus.
+	*/
+	var status utils.MigrationStatus
+	_, err := client.query(ctx, &Call{Function: "MigrationStatus"}, &status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (client *Client) RebuildIndexes(ctx context.Context) (*utils.IndexRebuild, error) {
+	/*
+		Rebuilds the secondary indexes from the stored records. See admin.RebuildIndexes.
+	*/
+	var rebuild utils.IndexRebuild
+	_, err := client.invoke(ctx, &Call{Function: "RebuildIndexes"}, &rebuild)
+	if err != nil {
+		return nil, err
+	}
+	return &rebuild, nil
+}
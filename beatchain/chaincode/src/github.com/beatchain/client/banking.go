@@ -0,0 +1,293 @@
+/*
+Methods calling the banking and subscription transactions of transactions/banking
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/beatchain/transactions/banking"
+	"github.com/beatchain/utils"
+)
+
+// Layout of the dates parsed by banking.GetCreatorStatement
+const statementDateLayout = "2006-01-02"
+
+func (client *Client) RenewSubscription(ctx context.Context) (*banking.SubscriptionPayment, error) {
+	/*
+		Renews the calling Customer's subscription for a month, through the client's AppDev
+		if one is set. See banking.RenewSubscription.
+	*/
+	var payment banking.SubscriptionPayment
+	call := &Call{Function: "RenewSubscription", Args: optionalArgs(nil, client.AppDevId)}
+	_, err := client.invoke(ctx, call, &payment)
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (client *Client) CollectPayment(ctx context.Context) (*banking.PaymentRun, error) {
+	/*
+		Settles the calling Creator's contracts. See banking.CollectPayment.
+	*/
+	var run banking.PaymentRun
+	_, err := client.invoke(ctx, &Call{Function: "CollectPayment"}, &run)
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (client *Client) TransferFunds(ctx context.Context, bankAccountId string, amount float32) (*utils.BankAccount, *utils.TransferProposal, error) {
+	/*
+		Moves funds between a bank account and off-chain. See banking.TransferFunds.
+
+		Returns:
+			bankAccount: The bank account, if the transfer was made. nil if it awaits approval.
+			proposal: The pending proposal, if the transfer needs approval by several admins
+			err: Error object. nil if no error occurred.
+	*/
+	var data json.RawMessage
+	var fields map[string]json.RawMessage
+
+	call := &Call{Function: "TransferFunds", Args: []string{bankAccountId, formatAmount(amount)}}
+	_, err := client.invoke(ctx, call, &data)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Only a proposal records who proposed it
+	if _, ok := fields["proposedby"]; ok {
+		var proposal utils.TransferProposal
+		err = json.Unmarshal(data, &proposal)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, &proposal, nil
+	}
+	var bankAccount utils.BankAccount
+	err = json.Unmarshal(data, &bankAccount)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &bankAccount, nil, nil
+}
+
+func (client *Client) ApproveTransfer(ctx context.Context, proposalId string) (*utils.TransferProposal, error) {
+	/*
+		Approves a pending transfer proposal as the calling admin. See banking.ApproveTransfer.
+	*/
+	var proposal utils.TransferProposal
+	_, err := client.invoke(ctx, &Call{Function: "ApproveTransfer", Args: []string{proposalId}}, &proposal)
+	if err != nil {
+		return nil, err
+	}
+	return &proposal, nil
+}
+
+func (client *Client) ListTransferProposals(ctx context.Context, status string, page *utils.Page) ([]*utils.TransferProposal, *utils.Page, error) {
+	/*
+		Lists transfer proposals with the given status, or all of them if status is empty.
+		See banking.ListTransferProposals.
+	*/
+	var proposals []*utils.TransferProposal
+	call := &Call{Function: "ListTransferProposals", Args: optionalArgs(nil, status)}
+	returned, err := client.queryPage(ctx, call, page, &proposals)
+	if err != nil {
+		return nil, nil, err
+	}
+	return proposals, returned, nil
+}
+
+func (client *Client) SetTransferPolicy(ctx context.Context, policy *utils.TransferPolicy) (*utils.TransferPolicy, error) {
+	/*
+		Sets when admin transfers need approval from several admins. Any admin may approve
+		if the policy names none. See banking.SetTransferPolicy.
+	*/
+	var set utils.TransferPolicy
+
+	args := []string{
+		formatAmount(policy.Threshold),
+		strconv.Itoa(policy.RequiredApprovals),
+		strconv.Itoa(policy.ExpiryHours)}
+	if len(policy.Admins) > 0 {
+		adminsBytes, err := json.Marshal(policy.Admins)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, string(adminsBytes))
+	}
+	_, err := client.invoke(ctx, &Call{Function: "SetTransferPolicy", Args: args}, &set)
+	if err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+func (client *Client) RequestWithdrawal(ctx context.Context, amount float32) (*utils.Withdrawal, error) {
+	/*
+		Requests a withdrawal off-chain from the calling Creator's or AppDev's bank account.
+		See banking.RequestWithdrawal.
+	*/
+	var withdrawal utils.Withdrawal
+	_, err := client.invoke(ctx, &Call{Function: "RequestWithdrawal", Args: []string{formatAmount(amount)}}, &withdrawal)
+	if err != nil {
+		return nil, err
+	}
+	return &withdrawal, nil
+}
+
+func (client *Client) ApproveWithdrawal(ctx context.Context, withdrawalId string, paymentReference string) (*utils.Withdrawal, error) {
+	/*
+		Approves a pending withdrawal paid off-chain. See banking.ApproveWithdrawal.
+	*/
+	return client.decideWithdrawal(ctx, "ApproveWithdrawal", withdrawalId, paymentReference)
+}
+
+func (client *Client) RejectWithdrawal(ctx context.Context, withdrawalId string, reason string) (*utils.Withdrawal, error) {
+	/*
+		Rejects a pending withdrawal. See banking.RejectWithdrawal.
+	*/
+	return client.decideWithdrawal(ctx, "RejectWithdrawal", withdrawalId, reason)
+}
+
+func (client *Client) decideWithdrawal(ctx context.Context, function string, withdrawalId string, detail string) (*utils.Withdrawal, error) {
+	var withdrawal utils.Withdrawal
+	_, err := client.invoke(ctx, &Call{Function: function, Args: []string{withdrawalId, detail}}, &withdrawal)
+	if err != nil {
+		return nil, err
+	}
+	return &withdrawal, nil
+}
+
+func (client *Client) ListWithdrawals(ctx context.Context, status string, page *utils.Page) ([]*utils.Withdrawal, *utils.Page, error) {
+	/*
+		Lists withdrawals with the given status, or all of them if status is empty. See
+		banking.ListWithdrawals.
+	*/
+	var withdrawals []*utils.Withdrawal
+	call := &Call{Function: "ListWithdrawals", Args: optionalArgs(nil, status)}
+	returned, err := client.queryPage(ctx, call, page, &withdrawals)
+	if err != nil {
+		return nil, nil, err
+	}
+	return withdrawals, returned, nil
+}
+
+func (client *Client) SetWithdrawalLimits(ctx context.Context, limits *utils.WithdrawalLimits) (*utils.WithdrawalLimits, error) {
+	/*
+		Sets the limits on moving funds off-chain. See banking.SetWithdrawalLimits.
+	*/
+	var set utils.WithdrawalLimits
+	call := &Call{Function: "SetWithdrawalLimits", Args: []string{formatAmount(limits.PerTransaction), formatAmount(limits.Daily)}}
+	_, err := client.invoke(ctx, call, &set)
+	if err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+func (client *Client) OpenDispute(ctx context.Context, creatorId string, appDevId string, productId string, settlementId string, amount float32, reason string) (*utils.Dispute, error) {
+	/*
+		Opens a dispute against a settlement. See banking.OpenDispute.
+	*/
+	var dispute utils.Dispute
+	call := &Call{Function: "OpenDispute", Args: []string{creatorId, appDevId, productId, settlementId, formatAmount(amount), reason}}
+	_, err := client.invoke(ctx, call, &dispute)
+	if err != nil {
+		return nil, err
+	}
+	return &dispute, nil
+}
+
+func (client *Client) SubmitDisputeEvidence(ctx context.Context, disputeId string, evidenceHash string, description string) (*utils.Dispute, error) {
+	/*
+		Records the hash of a piece of evidence against an open dispute. See
+		banking.SubmitDisputeEvidence.
+	*/
+	var dispute utils.Dispute
+	call := &Call{Function: "SubmitDisputeEvidence", Args: []string{disputeId, evidenceHash, description}}
+	_, err := client.invoke(ctx, call, &dispute)
+	if err != nil {
+		return nil, err
+	}
+	return &dispute, nil
+}
+
+func (client *Client) ResolveDispute(ctx context.Context, disputeId string, awardedAmount float32, ruling string) (*utils.Dispute, error) {
+	/*
+		Rules on an open dispute as its arbitrator. See banking.ResolveDispute.
+	*/
+	var dispute utils.Dispute
+	call := &Call{Function: "ResolveDispute", Args: []string{disputeId, formatAmount(awardedAmount), ruling}}
+	_, err := client.invoke(ctx, call, &dispute)
+	if err != nil {
+		return nil, err
+	}
+	return &dispute, nil
+}
+
+func (client *Client) ListDisputes(ctx context.Context, creatorId string, appDevId string, status string, page *utils.Page) ([]*utils.Dispute, *utils.Page, error) {
+	/*
+		Lists disputes, optionally only those of a Creator, an AppDev or with a given status.
+		See banking.ListDisputes.
+	*/
+	var disputes []*utils.Dispute
+	call := &Call{Function: "ListDisputes", Args: optionalArgs([]string{creatorId, appDevId}, status)}
+	returned, err := client.queryPage(ctx, call, page, &disputes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return disputes, returned, nil
+}
+
+func (client *Client) GetCreatorStatement(ctx context.Context, from time.Time, to time.Time, groupBy string) (*utils.CreatorStatement, error) {
+	/*
+		Reports the calling Creator's earnings over the days from and to, inclusive, grouped
+		by one of the utils.STATEMENT_GROUP_* options. See banking.GetCreatorStatement.
+	*/
+	var statement utils.CreatorStatement
+	call := &Call{Function: "GetCreatorStatement", Args: []string{
+		from.Format(statementDateLayout),
+		to.Format(statementDateLayout),
+		groupBy}}
+	_, err := client.query(ctx, call, &statement)
+	if err != nil {
+		return nil, err
+	}
+	return &statement, nil
+}
+
+func (client *Client) GetAppDevPayables(ctx context.Context) (*utils.AppDevPayables, error) {
+	/*
+		Projects what the calling AppDev owes each Creator. See banking.GetAppDevPayables.
+	*/
+	var payables utils.AppDevPayables
+	_, err := client.query(ctx, &Call{Function: "GetAppDevPayables"}, &payables)
+	if err != nil {
+		return nil, err
+	}
+	return &payables, nil
+}
+
+func (client *Client) IssueInvoice(ctx context.Context, creatorId string) (*utils.Invoice, error) {
+	/*
+		Invoices the calling AppDev's unremunerated usage of a Creator's products. See
+		banking.IssueInvoice.
+	*/
+	var invoice utils.Invoice
+	_, err := client.invoke(ctx, &Call{Function: "IssueInvoice", Args: []string{creatorId}}, &invoice)
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
@@ -0,0 +1,138 @@
+/*
+Typed Go client for the Beatchain chaincode. Each chaincode function has a method taking
+its arguments as Go values and returning the data of its response envelope decoded into
+the utils structs. Calls are made through a pluggable Transport.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/beatchain/utils"
+)
+
+type Call struct {
+	/*
+		Defines a single call of a chaincode function
+	*/
+	Function  string
+	Args      []string
+	Transient map[string][]byte // Transient data of the proposal. nil if none.
+}
+
+type Transport interface {
+	/*
+		Delivers calls to the chaincode. Invoked calls are committed to the ledger; queried
+		calls are answered by a single peer and their writes are never committed. Both
+		return the payload of a successful call, and a *utils.ChaincodeError carrying the
+		code of the failure if the chaincode rejected the call.
+	*/
+	Invoke(ctx context.Context, call *Call) ([]byte, error)
+	Query(ctx context.Context, call *Call) ([]byte, error)
+}
+
+type Client struct {
+	/*
+		Calls the chaincode functions as a single identity, whose credentials are held by
+		the transport
+	*/
+	transport Transport
+	Id        string // ID of the caller's record, passed where the chaincode still takes it as an argument
+	AppDevId  string // AppDev a Customer subscribing through several streams and renews through. Empty if they have one.
+}
+
+func New(transport Transport, id string) *Client {
+	/*
+		Creates a client calling the chaincode through the given transport
+
+		Args:
+			transport: Transport carrying the identity of the caller
+			id: ID of the caller's AppDev, Creator or Customer record. Only needed to offer,
+				accept and reject contracts.
+	*/
+	return &Client{transport: transport, Id: id}
+}
+
+func (client *Client) Through(appDevId string) *Client {
+	/*
+		Returns a copy of the client which streams and renews through the given AppDev, for
+		Customers subscribing through more than one
+	*/
+	through := *client
+	through.AppDevId = appDevId
+	return &through
+}
+
+func (client *Client) invoke(ctx context.Context, call *Call, data interface{}) (*utils.Response, error) {
+	payload, err := client.transport.Invoke(ctx, call)
+	if err != nil {
+		return nil, err
+	}
+	return decodeResponse(call.Function, payload, data)
+}
+
+func (client *Client) query(ctx context.Context, call *Call, data interface{}) (*utils.Response, error) {
+	payload, err := client.transport.Query(ctx, call)
+	if err != nil {
+		return nil, err
+	}
+	return decodeResponse(call.Function, payload, data)
+}
+
+func (client *Client) queryPage(ctx context.Context, call *Call, page *utils.Page, data interface{}) (*utils.Page, error) {
+	/*
+		Queries a listing, requesting the given page of it. The whole listing is returned if
+		page is nil.
+
+		Returns:
+			page: Page of the listing returned
+			err: Error object. nil if no error occurred.
+	*/
+	if page != nil {
+		pageBytes, err := json.Marshal(&utils.Page{Offset: page.Offset, Limit: page.Limit})
+		if err != nil {
+			return nil, err
+		}
+		call.Transient = map[string][]byte{utils.PAGE_TRANSIENT_KEY: pageBytes}
+	}
+	response, err := client.query(ctx, call, data)
+	if err != nil {
+		return nil, err
+	}
+	return response.Page, nil
+}
+
+func decodeResponse(function string, payload []byte, data interface{}) (*utils.Response, error) {
+	/*
+		Decodes the response envelope of a successful call, unmarshaling its data into the
+		given pointer. Responses in RESPONSE_FORMAT_TEXT carry no envelope and are rejected.
+	*/
+	response, err := utils.UnmarshalResponse(payload, data)
+	if err != nil || response.Function != function {
+		return nil, fmt.Errorf("response to %s is not a %s envelope: %s",
+			function, utils.RESPONSE_FORMAT_JSON, string(payload))
+	}
+	return response, nil
+}
+
+func optionalArgs(args []string, optional ...string) []string {
+	/*
+		Appends the optional arguments up to the last one given, so that a trailing empty
+		argument is left out rather than passed as ""
+	*/
+	last := -1
+	for i, arg := range optional {
+		if arg != "" {
+			last = i
+		}
+	}
+	return append(args, optional[:last+1]...)
+}
+
+func formatAmount(amount float32) string {
+	return strconv.FormatFloat(float64(amount), 'f', -1, 32)
+}
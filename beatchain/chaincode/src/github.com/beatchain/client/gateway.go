@@ -0,0 +1,135 @@
+/*
+Transport calling the chaincode on a real network through the application's REST gateway
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/beatchain/utils"
+)
+
+type GatewayTransport struct {
+	/*
+		Calls the chaincode on a real network through the REST gateway served by
+		application/main.py, which submits invocations to every peer and queries to a single
+		peer of the caller's org. The gateway only accepts the functions listed in
+		application/middleware/constants.py.
+	*/
+	URL          string       // Base URL of the gateway, e.g. http://localhost:8000
+	OrgName      string       // Org of the caller, one of the OrgNames in constants.py
+	ChannelName  string       // Channel the chaincode is instantiated on, e.g. fullchannel
+	UserName     string       // Enrolled user the calls are made as
+	UserPassword string       // Password the user is enrolled with
+	HTTPClient   *http.Client // nil to use http.DefaultClient
+}
+
+type gatewayRequest struct {
+	/*
+		Defines the body of an /invoke or /query request, constants.InvokeRequest
+	*/
+	UserName     string            `json:"user_name"`
+	UserPassword string            `json:"user_password"`
+	Args         []string          `json:"args"`
+	Transient    map[string][]byte `json:"transient,omitempty"` // Values are base64-encoded
+}
+
+type gatewayResponse struct {
+	/*
+		Defines the body returned by /invoke and /query
+	*/
+	Status   string          `json:"Status"`
+	Response json.RawMessage `json:"Response"` // Payload of a successful call as a JSON string
+	Error    *string         `json:"Error"`    // Python repr of the exception raised by a failed call
+}
+
+func (gateway *GatewayTransport) Invoke(ctx context.Context, call *Call) ([]byte, error) {
+	return gateway.call(ctx, "/invoke", call)
+}
+
+func (gateway *GatewayTransport) Query(ctx context.Context, call *Call) ([]byte, error) {
+	return gateway.call(ctx, "/query", call)
+}
+
+func (gateway *GatewayTransport) call(ctx context.Context, path string, call *Call) ([]byte, error) {
+	var response gatewayResponse
+	var payload string
+
+	requestBytes, err := json.Marshal(&gatewayRequest{
+		UserName:     gateway.UserName,
+		UserPassword: gateway.UserPassword,
+		Args:         append([]string{}, call.Args...),
+		Transient:    call.Transient})
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{
+		"org_name":     {gateway.OrgName},
+		"channel_name": {gateway.ChannelName},
+		"function":     {call.Function}}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimRight(gateway.URL, "/")+path+"?"+query.Encode(), bytes.NewReader(requestBytes))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	httpClient := gateway.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	httpResponse, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+	body, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Requests the gateway rejects itself, e.g. unlisted functions, carry no Status
+	err = json.Unmarshal(body, &response)
+	if err != nil || response.Status == "" {
+		return nil, fmt.Errorf("gateway returned %s: %s", httpResponse.Status, strings.TrimSpace(string(body)))
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		if response.Error == nil {
+			return nil, fmt.Errorf("gateway returned %s: %s", httpResponse.Status, response.Status)
+		}
+		return nil, parseGatewayError(*response.Error)
+	}
+
+	err = json.Unmarshal(response.Response, &payload)
+	if err != nil {
+		return nil, fmt.Errorf("gateway returned a response to %s which is not a string: %s", call.Function, string(response.Response))
+	}
+	return []byte(payload), nil
+}
+
+func parseGatewayError(message string) *utils.ChaincodeError {
+	/*
+		Extracts the JSON error body of the chaincode from the exception reported by the
+		gateway. Exceptions raised before the chaincode was reached are reported as
+		ERROR_INTERNAL.
+	*/
+	var chaincodeError utils.ChaincodeError
+
+	start := strings.Index(message, `{"code"`)
+	if start >= 0 {
+		// The body is followed by the rest of the exception's repr
+		err := json.NewDecoder(strings.NewReader(message[start:])).Decode(&chaincodeError)
+		if err == nil && chaincodeError.Code != "" {
+			return &chaincodeError
+		}
+	}
+	return &utils.ChaincodeError{Code: utils.ERROR_INTERNAL, Message: message}
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/beatchain/utils"
+)
+
+func newTestGateway(t *testing.T, handler func(w http.ResponseWriter, function string, request *gatewayRequest)) *Client {
+	/*
+		Serves a fake of the application's REST gateway, checking the identity each request
+		is made as before handing it to the handler
+	*/
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request gatewayRequest
+
+		query := r.URL.Query()
+		if query.Get("org_name") != "appdevorg.beatchain.com" || query.Get("channel_name") != "fullchannel" {
+			t.Errorf("Unexpected gateway query %s", r.URL.RawQuery)
+		}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil || request.UserName != "appdev" || request.UserPassword != "secret" {
+			t.Errorf("Unexpected gateway request %+v: %v", request, err)
+		}
+		handler(w, r.URL.Path+" "+query.Get("function"), &request)
+	}))
+	t.Cleanup(server.Close)
+
+	return New(&GatewayTransport{
+		URL:          server.URL + "/",
+		OrgName:      "appdevorg.beatchain.com",
+		ChannelName:  "fullchannel",
+		UserName:     "appdev",
+		UserPassword: "secret"}, "1111")
+}
+
+func writeGatewayResponse(w http.ResponseWriter, status int, content map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(content)
+}
+
+func TestGatewayTransport(t *testing.T) {
+	ctx := context.Background()
+	client := newTestGateway(t, func(w http.ResponseWriter, function string, request *gatewayRequest) {
+		switch function {
+		case "/invoke OfferContract":
+			var terms utils.ContractTerms
+			_ = json.Unmarshal(request.Transient[utils.CONTRACT_TERMS_TRANSIENT_KEY], &terms)
+			if len(request.Args) != 3 || request.Args[0] != "1111" || terms.CreatorPayPerStream != 0.02 ||
+				len(request.Transient[utils.CONTRACT_SALT_TRANSIENT_KEY]) < utils.CONTRACT_SALT_MIN_BYTES {
+				t.Errorf("Unexpected OfferContract request %+v", request)
+			}
+			envelope, _ := json.Marshal(&utils.Response{
+				Function: "OfferContract",
+				TxId:     "tx1",
+				Data:     &utils.Contract{CreatorId: "3333", AppDevId: "1111", ProductId: "4444", Status: "REQUESTED"}})
+			writeGatewayResponse(w, http.StatusOK, map[string]interface{}{
+				"Status": "Invoke Request successful", "Response": string(envelope), "Error": nil})
+		case "/query ListAppDevContracts":
+			var page utils.Page
+			_ = json.Unmarshal(request.Transient[utils.PAGE_TRANSIENT_KEY], &page)
+			if page.Limit != 10 {
+				t.Errorf("Unexpected ListAppDevContracts transient data %v", request.Transient)
+			}
+			envelope, _ := json.Marshal(&utils.Response{
+				Function: "ListAppDevContracts",
+				TxId:     "tx2",
+				Data:     []*utils.Contract{{CreatorId: "3333", AppDevId: "1111", ProductId: "4444"}},
+				Page:     &utils.Page{Returned: 1, Total: 1}})
+			writeGatewayResponse(w, http.StatusOK, map[string]interface{}{
+				"Status": "Query Request Successful", "Response": string(envelope), "Error": nil})
+		case "/invoke IssueInvoice":
+			writeGatewayResponse(w, http.StatusInternalServerError, map[string]interface{}{
+				"Status": "Invoke Request failed", "Response": nil,
+				"Error": `Exception('transaction returned with failure: {"code":"NOT_FOUND","message":"No record found for CreatorRecord.ID 0000"}')`})
+		case "/invoke RequestWithdrawal":
+			writeGatewayResponse(w, http.StatusInternalServerError, map[string]interface{}{
+				"Status": "Invoke Request failed", "Response": nil, "Error": `ConnectionError('peer unreachable')`})
+		default:
+			writeGatewayResponse(w, http.StatusUnprocessableEntity, map[string]interface{}{
+				"detail": []map[string]string{{"msg": "value is not a valid enumeration member"}}})
+		}
+	})
+
+	contract, err := client.OfferContract(ctx, "3333", "4444", 0.02)
+	if err != nil || contract.Status != "REQUESTED" || contract.ProductId != "4444" {
+		t.Fatalf("Unexpected contract %+v: %v", contract, err)
+	}
+	contracts, page, err := client.ListAppDevContracts(ctx, "1111", &utils.Page{Limit: 10})
+	if err != nil || len(contracts) != 1 || page.Total != 1 {
+		t.Fatalf("Unexpected contracts %+v, page %+v: %v", contracts, page, err)
+	}
+
+	// The chaincode's error body is recovered from the exception reported by the gateway
+	_, err = client.IssueInvoice(ctx, "0000")
+	if utils.ErrorCode(err) != utils.ERROR_NOT_FOUND || err.Error() != "No record found for CreatorRecord.ID 0000" {
+		t.Fatalf("Unexpected IssueInvoice error: %v", err)
+	}
+	_, err = client.RequestWithdrawal(ctx, 10)
+	if utils.ErrorCode(err) != utils.ERROR_INTERNAL || err.Error() != `ConnectionError('peer unreachable')` {
+		t.Fatalf("Unexpected RequestWithdrawal error: %v", err)
+	}
+	_, err = client.ClearPlayQueue(ctx)
+	if err == nil || utils.ErrorCode(err) != utils.ERROR_INTERNAL {
+		t.Fatalf("Function rejected by the gateway succeeded: %v", err)
+	}
+
+}
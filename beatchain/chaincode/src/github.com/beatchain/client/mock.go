@@ -0,0 +1,72 @@
+/*
+In-process transport calling the chaincode through a TestStub, for tests
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/beatchain/utils"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+type MockTransport struct {
+	/*
+		Calls the chaincode in-process through a TestStub, for tests. Like the peer, the
+		writes of failed and queried calls are discarded.
+	*/
+	Stub     *utils.TestStub
+	Identity *utils.TestIdentity // Identity calls are made as. nil to call as the stub's own identity, e.g. in test mode.
+	txSeq    int
+}
+
+func NewMockTransport(stub *utils.TestStub, identity *utils.TestIdentity) *MockTransport {
+	return &MockTransport{Stub: stub, Identity: identity}
+}
+
+func (transport *MockTransport) Invoke(ctx context.Context, call *Call) ([]byte, error) {
+	return transport.call(ctx, call, false)
+}
+
+func (transport *MockTransport) Query(ctx context.Context, call *Call) ([]byte, error) {
+	return transport.call(ctx, call, true)
+}
+
+func (transport *MockTransport) call(ctx context.Context, call *Call, query bool) ([]byte, error) {
+	var res pb.Response
+
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	args := [][]byte{[]byte(call.Function)}
+	for _, arg := range call.Args {
+		args = append(args, []byte(arg))
+	}
+	for key, value := range call.Transient {
+		transport.Stub.Transient[key] = value
+	}
+
+	// Each call is a transaction of its own, named after the identity making it
+	transport.txSeq += 1
+	txId := fmt.Sprintf("client-%d", transport.txSeq)
+	if transport.Identity != nil {
+		txId = fmt.Sprintf("%s-%d", transport.Identity.Name, transport.txSeq)
+		transport.Stub.Creator = transport.Identity.Creator
+	}
+	if query {
+		res = transport.Stub.MockQuery(txId, args)
+	} else {
+		res = transport.Stub.MockInvoke(txId, args)
+	}
+	transport.Stub.Creator = nil
+
+	if res.Status != shim.OK {
+		return nil, utils.ParseErrorResponse(res.Message)
+	}
+	return res.Payload, nil
+}
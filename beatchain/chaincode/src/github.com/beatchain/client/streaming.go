@@ -0,0 +1,280 @@
+/*
+Methods calling the contract and streaming transactions of transactions/streaming
+*/
+
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"strconv"
+
+	"github.com/beatchain/utils"
+)
+
+type ContractTermsStanding struct {
+	/*
+		Defines the terms of a contract along with its standing, as returned by
+		GetContractTerms
+	*/
+	utils.ContractTerms
+	Status             string  `json:"contractstatus"`
+	TermsHash          string  `json:"termshash"`
+	GuaranteeShortfall float32 `json:"guaranteeshortfall"` // Paid to the Creator once the guarantee term ends or on termination
+}
+
+func (client *Client) OfferContract(ctx context.Context, creatorId string, productId string, rate float32) (*utils.Contract, error) {
+	/*
+		Offers a contract paying rate in $USD per stream of a Creator's product, from the
+		client's AppDev. See streaming.OfferContract.
+	*/
+	return client.OfferContractTerms(ctx, creatorId, productId, &utils.ContractTerms{CreatorPayPerStream: rate})
+}
+
+func (client *Client) OfferContractTerms(ctx context.Context, creatorId string, productId string, terms *utils.ContractTerms) (*utils.Contract, error) {
+	/*
+		Offers a contract on the pay per stream, advance, minimum guarantee and metric rates
+		of the given terms, from the client's AppDev. The terms are passed as transient data,
+		along with a random seed for the salt of their hash, so that they are not recorded in
+		the transaction. See streaming.OfferContract.
+	*/
+	termsBytes, err := json.Marshal(terms)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 32)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	call := &Call{
+		Function: "OfferContract",
+		Args:     []string{client.Id, creatorId, productId},
+		Transient: map[string][]byte{
+			utils.CONTRACT_TERMS_TRANSIENT_KEY: termsBytes,
+			utils.CONTRACT_SALT_TRANSIENT_KEY:  salt}}
+	return client.contractCall(ctx, call, client.invoke)
+}
+
+func (client *Client) AcceptContract(ctx context.Context, productId string, appDevId string) (*utils.Contract, error) {
+	/*
+		Accepts a contract offered to the client's Creator. See streaming.AcceptContract.
+	*/
+	call := &Call{Function: "AcceptContract", Args: []string{client.Id, productId, appDevId}}
+	return client.contractCall(ctx, call, client.invoke)
+}
+
+func (client *Client) RejectContract(ctx context.Context, productId string, appDevId string) (*utils.Contract, error) {
+	/*
+		Rejects a contract offered to the client's Creator. See streaming.RejectContract.
+	*/
+	call := &Call{Function: "RejectContract", Args: []string{client.Id, productId, appDevId}}
+	return client.contractCall(ctx, call, client.invoke)
+}
+
+func (client *Client) contractCall(ctx context.Context, call *Call, send func(context.Context, *Call, interface{}) (*utils.Response, error)) (*utils.Contract, error) {
+	var contract utils.Contract
+	_, err := send(ctx, call, &contract)
+	if err != nil {
+		return nil, err
+	}
+	return &contract, nil
+}
+
+func (client *Client) GetContractTerms(ctx context.Context, creatorId string, productId string, appDevId string) (*ContractTermsStanding, error) {
+	/*
+		Returns the terms of a contract the caller is a party to. See
+		streaming.GetContractTerms.
+	*/
+	var standing ContractTermsStanding
+	call := &Call{Function: "GetContractTerms", Args: []string{creatorId, productId, appDevId}}
+	_, err := client.query(ctx, call, &standing)
+	if err != nil {
+		return nil, err
+	}
+	return &standing, nil
+}
+
+func (client *Client) RequestSong(ctx context.Context, productId string) (*utils.StreamResult, error) {
+	/*
+		Streams a product to the calling Customer. The result's Flagged is set if the stream
+		was held by the fraud rules. See streaming.RequestSong.
+	*/
+	var result utils.StreamResult
+	call := &Call{Function: "RequestSong", Args: optionalArgs([]string{productId}, client.AppDevId)}
+	_, err := client.invoke(ctx, call, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (client *Client) RecordEngagement(ctx context.Context, productId string, metricType string) (*utils.StreamResult, error) {
+	/*
+		Records the calling Customer's engagement with a product they have streamed. The
+		result's Flagged is set if the engagement was held by the fraud rules. See
+		streaming.RecordEngagement.
+	*/
+	var result utils.StreamResult
+	call := &Call{Function: "RecordEngagement", Args: optionalArgs([]string{productId, metricType}, client.AppDevId)}
+	_, err := client.invoke(ctx, call, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (client *Client) CreatePlaylist(ctx context.Context, name string, productIds []string) (*utils.Playlist, error) {
+	/*
+		Creates a playlist owned by the calling Customer. See streaming.CreatePlaylist.
+	*/
+	productIdsBytes, err := json.Marshal(productIds)
+	if err != nil {
+		return nil, err
+	}
+	return client.playlistCall(ctx, &Call{Function: "CreatePlaylist", Args: []string{name, string(productIdsBytes)}})
+}
+
+func (client *Client) UpdatePlaylist(ctx context.Context, playlistId string, productIds []string) (*utils.Playlist, error) {
+	/*
+		Replaces the products of a playlist owned by the calling Customer. See
+		streaming.UpdatePlaylist.
+	*/
+	productIdsBytes, err := json.Marshal(productIds)
+	if err != nil {
+		return nil, err
+	}
+	return client.playlistCall(ctx, &Call{Function: "UpdatePlaylist", Args: []string{playlistId, string(productIdsBytes)}})
+}
+
+func (client *Client) SharePlaylist(ctx context.Context, playlistId string, customerId string) (*utils.Playlist, error) {
+	/*
+		Shares a playlist owned by the calling Customer with another customer. See
+		streaming.SharePlaylist.
+	*/
+	return client.playlistCall(ctx, &Call{Function: "SharePlaylist", Args: []string{playlistId, customerId}})
+}
+
+func (client *Client) playlistCall(ctx context.Context, call *Call) (*utils.Playlist, error) {
+	var playlist utils.Playlist
+	_, err := client.invoke(ctx, call, &playlist)
+	if err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
+
+func (client *Client) GetPlaylists(ctx context.Context, page *utils.Page) ([]*utils.Playlist, *utils.Page, error) {
+	/*
+		Lists the playlists the calling Customer owns or has been shared. See
+		streaming.GetPlaylists.
+	*/
+	var playlists []*utils.Playlist
+	returned, err := client.queryPage(ctx, &Call{Function: "GetPlaylists"}, page, &playlists)
+	if err != nil {
+		return nil, nil, err
+	}
+	return playlists, returned, nil
+}
+
+func (client *Client) EnqueueSongs(ctx context.Context, productIds []string) (*utils.PlayQueue, error) {
+	/*
+		Appends products to the calling Customer's play queue. See streaming.EnqueueSongs.
+	*/
+	productIdsBytes, err := json.Marshal(productIds)
+	if err != nil {
+		return nil, err
+	}
+	call := &Call{Function: "EnqueueSongs", Args: optionalArgs([]string{string(productIdsBytes)}, client.AppDevId)}
+	return client.playQueueCall(ctx, call, client.invoke)
+}
+
+func (client *Client) EnqueuePlaylist(ctx context.Context, playlistId string) (*utils.PlayQueue, error) {
+	/*
+		Appends the products of a playlist to the calling Customer's play queue. See
+		streaming.EnqueuePlaylist.
+	*/
+	call := &Call{Function: "EnqueuePlaylist", Args: optionalArgs([]string{playlistId}, client.AppDevId)}
+	return client.playQueueCall(ctx, call, client.invoke)
+}
+
+func (client *Client) GetPlayQueue(ctx context.Context) (*utils.PlayQueue, error) {
+	/*
+		Returns the calling Customer's play queue. See streaming.GetPlayQueue.
+	*/
+	return client.playQueueCall(ctx, &Call{Function: "GetPlayQueue"}, client.query)
+}
+
+func (client *Client) ClearPlayQueue(ctx context.Context) (*utils.PlayQueue, error) {
+	/*
+		Removes every entry from the calling Customer's play queue. See
+		streaming.ClearPlayQueue.
+	*/
+	return client.playQueueCall(ctx, &Call{Function: "ClearPlayQueue"}, client.invoke)
+}
+
+func (client *Client) playQueueCall(ctx context.Context, call *Call, send func(context.Context, *Call, interface{}) (*utils.Response, error)) (*utils.PlayQueue, error) {
+	var playQueue utils.PlayQueue
+	_, err := send(ctx, call, &playQueue)
+	if err != nil {
+		return nil, err
+	}
+	return &playQueue, nil
+}
+
+func (client *Client) NextSong(ctx context.Context) (*utils.NextSongResult, error) {
+	/*
+		Streams the next product in the calling Customer's play queue. See
+		streaming.NextSong.
+	*/
+	var result utils.NextSongResult
+	_, err := client.invoke(ctx, &Call{Function: "NextSong", Args: optionalArgs(nil, client.AppDevId)}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (client *Client) SetFraudRules(ctx context.Context, rules *utils.FraudRules) (*utils.FraudRules, error) {
+	/*
+		Sets the rules a stream must pass to become payable. See streaming.SetFraudRules.
+	*/
+	var set utils.FraudRules
+	call := &Call{Function: "SetFraudRules", Args: []string{
+		strconv.Itoa(rules.MaxPlaysPerWindow),
+		strconv.Itoa(rules.VelocityWindowMinutes),
+		strconv.Itoa(rules.MinRepeatGapSeconds),
+		strconv.Itoa(rules.MaxDailyProductPlays)}}
+	_, err := client.invoke(ctx, call, &set)
+	if err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+func (client *Client) GetFlaggedStreams(ctx context.Context, appDevId string, productId string, status string, page *utils.Page) ([]*utils.FlaggedStream, *utils.Page, error) {
+	/*
+		Lists the streams flagged by the fraud rules, optionally only those through an
+		AppDev, of a product or with a given status. See streaming.GetFlaggedStreams.
+	*/
+	var streams []*utils.FlaggedStream
+	call := &Call{Function: "GetFlaggedStreams", Args: optionalArgs([]string{appDevId, productId}, status)}
+	returned, err := client.queryPage(ctx, call, page, &streams)
+	if err != nil {
+		return nil, nil, err
+	}
+	return streams, returned, nil
+}
+
+func (client *Client) ReviewFlaggedStream(ctx context.Context, streamId string, decision string) (*utils.FlaggedStream, error) {
+	/*
+		Releases or rejects a held stream. See streaming.ReviewFlaggedStream.
+	*/
+	var stream utils.FlaggedStream
+	_, err := client.invoke(ctx, &Call{Function: "ReviewFlaggedStream", Args: []string{streamId, decision}}, &stream)
+	if err != nil {
+		return nil, err
+	}
+	return &stream, nil
+}
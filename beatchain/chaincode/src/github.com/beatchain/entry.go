@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/beatchain/transactions/streaming"
@@ -18,6 +19,11 @@ type BeatchainChaincode struct {
 
 // Initialization template
 func (t *BeatchainChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	cache := utils.NewStateCache(stub)
+	return flushResponse(cache, t.initialize(cache))
+}
+
+func (t *BeatchainChaincode) initialize(stub shim.ChaincodeStubInterface) pb.Response {
 	var txn *utils.Transaction
 	var err error
 
@@ -26,19 +32,37 @@ func (t *BeatchainChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response
 	// Get the transaction details
 	txn, err = utils.GetTxInfo(stub, t.testMode)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	if len(txn.Args) == 0 {
-		// Using existing ledger
+		// Using existing ledger; bring records stored by earlier versions up to date
 		fmt.Println("Initializing with existing ledger")
+		run, err := utils.MigrateLedger(stub)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+		fmt.Printf("Migrated records to current schema versions: %v\n", run.Migrated)
 		return shim.Success(nil)
 	}
 
+	if isGenesisDocument(txn.Args) {
+		// Genesis document given; initialize ledger from it and report what was written
+		summary, err := genesisInit(stub, txn)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+		summaryBytes, err := json.Marshal(summary)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+		return shim.Success(summaryBytes)
+	}
+
 	// New variables given; initialize ledger
 	err = ledgerInit(stub, txn)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	return shim.Success(nil)
@@ -46,6 +70,26 @@ func (t *BeatchainChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response
 
 // Invocation template
 func (t *BeatchainChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	cache := utils.NewStateCache(stub)
+	return flushResponse(cache, t.invoke(cache))
+}
+
+func flushResponse(cache *utils.StateCache, res pb.Response) pb.Response {
+	/*
+		Writes the changes held by the transaction's StateCache to the ledger once its handler
+		has succeeded. The changes of a failed handler are dropped.
+	*/
+	if res.Status != shim.OK {
+		return res
+	}
+	err := cache.Flush()
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return res
+}
+
+func (t *BeatchainChaincode) invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	var txn *utils.Transaction
 	var err error
 
@@ -55,7 +99,11 @@ func (t *BeatchainChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Respons
 
 	txn, err = utils.GetTxInfo(stub, t.testMode)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
+	}
+	txn.ResponseFormat, err = utils.GetResponseFormat(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
 	}
 
 	/*
@@ -69,12 +117,36 @@ func (t *BeatchainChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Respons
 		return admin.ListAllCustomers(stub, txn)
 	case "ListAppCustomers":
 		return admin.ListAppCustomers(stub, txn)
+	case "ListCreatorProducts":
+		return admin.ListCreatorProducts(stub, txn)
+	case "ListAppDevContracts":
+		return admin.ListAppDevContracts(stub, txn)
+	case "ListProductContracts":
+		return admin.ListProductContracts(stub, txn)
+	case "MigrationStatus":
+		return admin.MigrationStatus(stub, txn)
+	case "RebuildIndexes":
+		return admin.RebuildIndexes(stub, txn)
+	case "GetRecordHistory":
+		return admin.GetRecordHistory(stub, txn)
 	case "AddProduct":
 		return admin.AddProduct(stub, txn)
 	case "DeleteProduct":
 		return admin.DeleteProduct(stub, txn)
 	case "AddCustomerRecord":
 		return admin.AddCustomerRecord(stub, txn)
+	case "AddSubscription":
+		return admin.AddSubscription(stub, txn)
+	case "CancelSubscription":
+		return admin.CancelSubscription(stub, txn)
+	case "BulkImport":
+		return admin.BulkImport(stub, txn)
+	case "CloseCustomer":
+		return admin.CloseCustomer(stub, txn)
+	case "CloseCreator":
+		return admin.CloseCreator(stub, txn)
+	case "CloseAppDev":
+		return admin.CloseAppDev(stub, txn)
 	case "AddAppDevRecord":
 		return admin.AddAppDevRecord(stub, txn)
 	case "AddCreatorRecord":
@@ -85,16 +157,74 @@ func (t *BeatchainChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Respons
 		return banking.CollectPayment(stub, txn)
 	case "TransferFunds":
 		return banking.TransferFunds(stub, txn)
+	case "ApproveTransfer":
+		return banking.ApproveTransfer(stub, txn)
+	case "ListTransferProposals":
+		return banking.ListTransferProposals(stub, txn)
+	case "SetTransferPolicy":
+		return banking.SetTransferPolicy(stub, txn)
+	case "RequestWithdrawal":
+		return banking.RequestWithdrawal(stub, txn)
+	case "ApproveWithdrawal":
+		return banking.ApproveWithdrawal(stub, txn)
+	case "RejectWithdrawal":
+		return banking.RejectWithdrawal(stub, txn)
+	case "ListWithdrawals":
+		return banking.ListWithdrawals(stub, txn)
+	case "SetWithdrawalLimits":
+		return banking.SetWithdrawalLimits(stub, txn)
+	case "OpenDispute":
+		return banking.OpenDispute(stub, txn)
+	case "SubmitDisputeEvidence":
+		return banking.SubmitDisputeEvidence(stub, txn)
+	case "ResolveDispute":
+		return banking.ResolveDispute(stub, txn)
+	case "ListDisputes":
+		return banking.ListDisputes(stub, txn)
+	case "GetCreatorStatement":
+		return banking.GetCreatorStatement(stub, txn)
+	case "GetAppDevPayables":
+		return banking.GetAppDevPayables(stub, txn)
+	case "IssueInvoice":
+		return banking.IssueInvoice(stub, txn)
 	case "OfferContract":
 		return streaming.OfferContract(stub, txn)
 	case "AcceptContract":
 		return streaming.AcceptContract(stub, txn)
 	case "RejectContract":
 		return streaming.RejectContract(stub, txn)
+	case "GetContractTerms":
+		return streaming.GetContractTerms(stub, txn)
 	case "RequestSong":
 		return streaming.RequestSong(stub, txn)
+	case "RecordEngagement":
+		return streaming.RecordEngagement(stub, txn)
+	case "CreatePlaylist":
+		return streaming.CreatePlaylist(stub, txn)
+	case "UpdatePlaylist":
+		return streaming.UpdatePlaylist(stub, txn)
+	case "SharePlaylist":
+		return streaming.SharePlaylist(stub, txn)
+	case "GetPlaylists":
+		return streaming.GetPlaylists(stub, txn)
+	case "EnqueueSongs":
+		return streaming.EnqueueSongs(stub, txn)
+	case "EnqueuePlaylist":
+		return streaming.EnqueuePlaylist(stub, txn)
+	case "GetPlayQueue":
+		return streaming.GetPlayQueue(stub, txn)
+	case "ClearPlayQueue":
+		return streaming.ClearPlayQueue(stub, txn)
+	case "NextSong":
+		return streaming.NextSong(stub, txn)
+	case "SetFraudRules":
+		return streaming.SetFraudRules(stub, txn)
+	case "GetFlaggedStreams":
+		return streaming.GetFlaggedStreams(stub, txn)
+	case "ReviewFlaggedStream":
+		return streaming.ReviewFlaggedStream(stub, txn)
 	default:
-		return shim.Error("Invalid invoke function name")
+		return utils.ErrorResponse(utils.InvalidArgumentError("function", "Invalid invoke function name"))
 	}
 
 }
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"github.com/beatchain/client"
+	"github.com/beatchain/transactions"
+	"github.com/beatchain/transactions/banking"
 	"github.com/beatchain/utils"
 	"fmt"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"io/ioutil"
+	"math"
+	"math/rand"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 
@@ -45,7 +55,7 @@ func getInitArguments() [][]byte {
 		[]byte(utils.TEST_CONTRACT_STATUS)}
 }
 
-func checkInit(t *testing.T, stub *shim.MockStub, args [][]byte) {
+func checkInit(t *testing.T, stub *utils.TestStub, args [][]byte) {
 	res := stub.MockInit("1", args)
 	if res.Status != shim.OK {
 		fmt.Println("Init failed", string(res.Message))
@@ -59,14 +69,101 @@ func checkInit(t *testing.T, stub *shim.MockStub, args [][]byte) {
 	utils.CheckBankAccount(t, stub, utils.TEST_CUSTOMER_BA_ID, float32(bal))
 }
 
-func beatchain_init(t *testing.T)  (*BeatchainChaincode, *shim.MockStub) {
+func beatchain_init(t *testing.T)  (*BeatchainChaincode, *utils.TestStub) {
 	scc := new(BeatchainChaincode)
 	scc.testMode = true
-	stub := shim.NewMockStub("Beatchain", scc)
+	stub := utils.NewTestStub("Beatchain", scc)
 	checkInit(t, stub, getInitArguments())
 	return scc, stub
 }
 
+func enrollTestIdentities(t *testing.T) map[string]*utils.TestIdentity {
+	/*
+		Enrolls a member of each org with its CA, plus callers who should be turned away:
+		an AppDev of another platform, a Creator certified by a CA outside the Creator Org
+		and a Creator whose certificate carries no attributes
+	*/
+	var ca *utils.TestCA
+	var err error
+
+	identities := make(map[string]*utils.TestIdentity)
+	enroll := func(key string, name string, attributes map[string]string) {
+		if err == nil {
+			identities[key], err = ca.Enroll(name, attributes)
+		}
+	}
+
+	ca, err = utils.NewTestCA(utils.BEATCHAIN_ADMIN_MSP, utils.BEATCHAIN_ADMIN_CA)
+	enroll("admin", "admin", map[string]string{"id": "admin", "role": "admin"})
+	if err == nil {
+		ca, err = utils.NewTestCA(utils.CUSTOMER_MSP, utils.CUSTOMER_CA)
+	}
+	enroll("customer", "customer", map[string]string{"id": utils.TEST_CUSTOMER_ID, "role": "client"})
+	if err == nil {
+		ca, err = utils.NewTestCA(utils.APPDEV_MSP, utils.APPDEV_CA)
+	}
+	enroll("appDev", "appdev", map[string]string{"id": utils.TEST_APPDEV_ID, "role": "client"})
+	enroll("otherAppDev", "otherappdev", map[string]string{"id": "9999", "role": "client"})
+	enroll("appDevAdmin", "appdevadmin", map[string]string{"id": "appdevadmin", "role": "admin"})
+	if err == nil {
+		ca, err = utils.NewTestCA(utils.CREATOR_MSP, utils.CREATOR_CA)
+	}
+	enroll("creator", "creator", map[string]string{"id": utils.TEST_CREATOR_ID, "role": "client"})
+	enroll("creatorAdmin", "creatoradmin", map[string]string{"id": "creatoradmin", "role": "admin"})
+	enroll("anonymous", "anonymous", nil)
+	if err == nil {
+		ca, err = utils.NewTestCA(utils.CREATOR_MSP, "ca.impostor.example.com")
+	}
+	enroll("impostor", "impostor", map[string]string{"id": utils.TEST_CREATOR_ID, "role": "admin"})
+
+	if err != nil {
+		fmt.Println("Cannot enroll test identities:", err.Error())
+		t.FailNow()
+	}
+	return identities
+}
+
+func beatchain_init_identities(t *testing.T) (*utils.TestStub, map[string]*utils.TestIdentity) {
+	/*
+		Initializes the ledger outside test mode, so that callers are authenticated from
+		the certificate of the identity each invocation is made as
+	*/
+	scc := new(BeatchainChaincode)
+	scc.testMode = false
+	stub := utils.NewTestStub("Beatchain", scc)
+	identities := enrollTestIdentities(t)
+
+	stub.Creator = identities["admin"].Creator
+	stub.Transient[utils.CONTRACT_SALT_TRANSIENT_KEY] = []byte(testContractSalt)
+	checkInit(t, stub, getInitArguments())
+	stub.Creator = nil
+	return stub, identities
+}
+
+// Seed of the contract salts given outside test mode
+const testContractSalt = "0123456789abcdef"
+
+func offerTerms(stub *utils.TestStub, terms string) {
+	/*
+		Passes contract terms, and the seed of their salt, to the next invocation as transient
+		data, as required outside test mode
+	*/
+	stub.Transient[utils.CONTRACT_TERMS_TRANSIENT_KEY] = []byte(terms)
+	stub.Transient[utils.CONTRACT_SALT_TRANSIENT_KEY] = []byte(testContractSalt)
+}
+
+func requestTestContract(t *testing.T, stub *utils.TestStub) {
+	/*
+		Returns the test contract, accepted at init, to REQUESTED, so that it can be offered
+		new terms
+	*/
+	contract := utils.FetchTestContractRecord(t, stub, utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID, utils.TEST_PRODUCT_ID)
+	contract.Status = transactions.REQUESTED
+	stub.MockTransactionStart("request")
+	_ = utils.SetContract(stub, contract)
+	stub.MockTransactionEnd("request")
+}
+
 func TestBeatchain_Init(t *testing.T) {
 	beatchain_init(t)
 }
@@ -112,39 +209,682 @@ func TestTransferFunctions(t *testing.T) {
 	utils.CheckBankAccount(t, stub, utils.TEST_APPDEV_BA_ID, float32(amount64))
 }
 
+func TestWithdrawals(t *testing.T) {
+	var withdrawal utils.Withdrawal
+	var withdrawals []utils.Withdrawal
+	var proposal utils.TransferProposal
+	_, stub := beatchain_init(t)
+
+	// Transfers over the configured per-transaction limit need multi-signature approval
+	_ = utils.ExecInvoke(t, stub, "SetWithdrawalLimits", []string{"300", "500"})
+	payload := utils.ExecInvoke(t, stub, "TransferFunds", []string{utils.TEST_APPDEV_BA_ID, "-400"})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &proposal)
+	if proposal.Status != transactions.PROPOSAL_PENDING {
+		fmt.Printf("TransferFunds over the per-transaction limit not proposed: %+v\n", proposal)
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, utils.TEST_APPDEV_BA_ID, 1000)
+
+	// Requested funds are held out of the balance
+	payload = utils.ExecInvoke(t, stub, "RequestWithdrawal", []string{"250"})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &withdrawal)
+	if withdrawal.Status != transactions.WITHDRAWAL_PENDING || withdrawal.BankAccountId != utils.TEST_CREATOR_BA_ID {
+		fmt.Printf("Unexpected withdrawal: %+v\n", withdrawal)
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, utils.TEST_CREATOR_BA_ID, 750)
+
+	// Limits apply per request and per day
+	res := stub.MockInvoke("1", [][]byte{[]byte("RequestWithdrawal"), []byte("300.01")})
+	if res.Status == shim.OK {
+		fmt.Println("RequestWithdrawal exceeded the per-transaction limit")
+		t.FailNow()
+	}
+	res = stub.MockInvoke("1", [][]byte{[]byte("RequestWithdrawal"), []byte("251")})
+	if res.Status == shim.OK {
+		fmt.Println("RequestWithdrawal exceeded the daily limit")
+		t.FailNow()
+	}
+
+	// Rejection refunds the hold and frees the daily limit
+	_ = utils.ExecInvoke(t, stub, "RejectWithdrawal", []string{withdrawal.Id, "Account under review"})
+	utils.CheckBankAccount(t, stub, utils.TEST_CREATOR_BA_ID, 1000)
+	payload = utils.ExecInvoke(t, stub, "RequestWithdrawal", []string{"300"})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &withdrawal)
+	utils.CheckBankAccount(t, stub, utils.TEST_CREATOR_BA_ID, 700)
+
+	// Approval records the off-chain payment; decided withdrawals are final
+	_ = utils.ExecInvoke(t, stub, "ApproveWithdrawal", []string{withdrawal.Id, "ACH-20181201-0001"})
+	utils.CheckBankAccount(t, stub, utils.TEST_CREATOR_BA_ID, 700)
+	res = stub.MockInvoke("1", [][]byte{[]byte("RejectWithdrawal"), []byte(withdrawal.Id), []byte("Too late")})
+	if res.Status == shim.OK {
+		fmt.Println("Rejected an approved withdrawal")
+		t.FailNow()
+	}
+
+	payload = utils.ExecInvoke(t, stub, "ListWithdrawals", []string{transactions.WITHDRAWAL_APPROVED})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &withdrawals)
+	if len(withdrawals) != 1 || withdrawals[0].PaymentReference != "ACH-20181201-0001" {
+		fmt.Printf("Unexpected approved withdrawals: %+v\n", withdrawals)
+		t.FailNow()
+	}
+
+	// Approved withdrawals count towards the daily limit, which starts afresh on the next UTC day
+	res = stub.MockInvoke("1", [][]byte{[]byte("RequestWithdrawal"), []byte("201")})
+	if res.Status == shim.OK {
+		fmt.Println("RequestWithdrawal exceeded the daily limit")
+		t.FailNow()
+	}
+	stub.TimeOffset = 24 * time.Hour
+	_ = utils.ExecInvoke(t, stub, "RequestWithdrawal", []string{"300"})
+	utils.CheckBankAccount(t, stub, utils.TEST_CREATOR_BA_ID, 400)
+
+	// Admin withdrawals count towards the same daily limit, and need multi-signature approval beyond it
+	_ = utils.ExecInvoke(t, stub, "TransferFunds", []string{utils.TEST_CREATOR_BA_ID, "-200"})
+	utils.CheckBankAccount(t, stub, utils.TEST_CREATOR_BA_ID, 200)
+	proposal = utils.TransferProposal{}
+	payload = utils.ExecInvoke(t, stub, "TransferFunds", []string{utils.TEST_CREATOR_BA_ID, "-100"})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &proposal)
+	if proposal.Status != transactions.PROPOSAL_PENDING {
+		fmt.Printf("TransferFunds over the daily limit not proposed: %+v\n", proposal)
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, utils.TEST_CREATOR_BA_ID, 200)
+}
+
+func TestDisputes(t *testing.T) {
+	var dispute utils.Dispute
+	var disputes []utils.Dispute
+	var report utils.BulkImportReport
+	var settlement utils.Settlement
+	_, stub := beatchain_init(t)
+	evidenceHash := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	stub.TimeOffset = time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC).Sub(time.Now())
+
+	// Stream and settle a product paying $10 a stream so that there is a settlement to dispute
+	payload := utils.ExecInvoke(t, stub, "BulkImport", []string{utils.PRODUCT_KEY_PREFIX, transactions.BULK_IMPORT_ATOMIC,
+		`[{"creatorid": "` + utils.TEST_CREATOR_ID + `", "productname": "Disputed Product"}]`})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &report)
+	productId := report.Rows[0].Id
+	openDispute := func(settlementId string, amount string) string {
+		res := stub.MockInvoke("1", [][]byte{[]byte("OpenDispute"), []byte(utils.TEST_CREATOR_ID), []byte(utils.TEST_APPDEV_ID),
+			[]byte(productId), []byte(settlementId), []byte(amount), []byte("Streams under-reported")})
+		return res.Message
+	}
+	stub.Transient[utils.CONTRACT_TERMS_TRANSIENT_KEY] = []byte(`{"creatorpayperstream": 10}`)
+	_ = utils.ExecInvoke(t, stub, "OfferContract", []string{utils.TEST_APPDEV_ID, utils.TEST_CREATOR_ID, productId})
+	_ = utils.ExecInvoke(t, stub, "AcceptContract", []string{utils.TEST_CREATOR_ID, productId, utils.TEST_APPDEV_ID})
+	for i := 0; i < 5; i++ {
+		stub.TimeOffset += 2 * time.Minute
+		_ = utils.ExecInvoke(t, stub, "RequestSong", []string{productId})
+	}
+	utils.ExecQuery(t, stub, "CollectPayment")
+	settlements, err := utils.GetCreatorSettlements(stub, utils.TEST_CREATOR_ID)
+	for _, settled := range settlements {
+		if settled.ProductId == productId {
+			settlement = *settled
+		}
+	}
+	if err != nil || settlement.NetAmount != 50 {
+		fmt.Printf("Unexpected settlements: %+v %v\n", settlements, err)
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, utils.TEST_APPDEV_BA_ID, 949.97)
+
+	// Claims are capped at the settled amount
+	message := openDispute(settlement.Id, "50.01")
+	if utils.ParseErrorResponse(message).Code != utils.ERROR_INVALID_ARGUMENT {
+		fmt.Println("Disputed more than the settled amount", message)
+		t.FailNow()
+	}
+
+	// Disputing holds the amount out of the AppDev's balance
+	payload = utils.ExecInvoke(t, stub, "OpenDispute", []string{utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID,
+		productId, settlement.Id, "30", "Streams under-reported"})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &dispute)
+	if dispute.Status != transactions.DISPUTE_OPEN || dispute.HeldBankAccountId != utils.TEST_APPDEV_BA_ID {
+		fmt.Printf("Unexpected dispute: %+v\n", dispute)
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, utils.TEST_APPDEV_BA_ID, 919.97)
+	message = openDispute(settlement.Id, "10")
+	if utils.ParseErrorResponse(message).Code != utils.ERROR_CONFLICT {
+		fmt.Println("Settlement disputed twice by the Creator", message)
+		t.FailNow()
+	}
+
+	// Disputes are over settlements, not the streams a contract has yet to pay for
+	message = openDispute("", "10")
+	if utils.ParseErrorResponse(message).Fields["SettlementID"] == "" {
+		fmt.Println("Disputed a contract without a settlement", message)
+		t.FailNow()
+	}
+
+	// Evidence is recorded by hash
+	_ = utils.ExecInvoke(t, stub, "SubmitDisputeEvidence", []string{dispute.Id, evidenceHash, "Play logs"})
+	for _, hash := range []string{evidenceHash, "not-a-hash"} {
+		res := stub.MockInvoke("1", [][]byte{[]byte("SubmitDisputeEvidence"), []byte(dispute.Id), []byte(hash), []byte("")})
+		if res.Status == shim.OK {
+			fmt.Println("Accepted evidence", hash)
+			t.FailNow()
+		}
+	}
+	payload = utils.ExecInvoke(t, stub, "ListDisputes", []string{utils.TEST_CREATOR_ID, "", transactions.DISPUTE_OPEN})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &disputes)
+	evidenced := 0
+	for _, open := range disputes {
+		if len(open.Evidence) == 1 && open.Evidence[0].Hash == evidenceHash {
+			evidenced++
+		}
+	}
+	if len(disputes) != 1 || evidenced != 1 {
+		fmt.Printf("Unexpected open disputes: %+v\n", disputes)
+		t.FailNow()
+	}
+
+	// Parties to an open dispute cannot be offboarded
+	res := stub.MockInvoke("1", [][]byte{[]byte("CloseCreator"), []byte(utils.TEST_CREATOR_ID), []byte(transactions.CLOSE_PAYOUT)})
+	if res.Status == shim.OK || !strings.Contains(res.Message, dispute.Id) {
+		fmt.Println("Closed a Creator with an open dispute", res.Message)
+		t.FailNow()
+	}
+
+	// The ruling splits the held amount between the parties
+	res = stub.MockInvoke("1", [][]byte{[]byte("ResolveDispute"), []byte(dispute.Id), []byte("60"), []byte("Too much")})
+	if res.Status == shim.OK {
+		fmt.Println("Awarded more than the disputed amount")
+		t.FailNow()
+	}
+	payload = utils.ExecInvoke(t, stub, "ResolveDispute", []string{dispute.Id, "20", "Partially substantiated"})
+	dispute = utils.Dispute{}
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &dispute)
+	if dispute.Status != transactions.DISPUTE_RESOLVED || dispute.AwardedAmount != 20 {
+		fmt.Printf("Unexpected resolved dispute: %+v\n", dispute)
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, utils.TEST_CREATOR_BA_ID, 1070.03)
+	utils.CheckBankAccount(t, stub, utils.TEST_APPDEV_BA_ID, 929.97)
+	res = stub.MockInvoke("1", [][]byte{[]byte("ResolveDispute"), []byte(dispute.Id), []byte("0"), []byte("Again")})
+	if res.Status == shim.OK {
+		fmt.Println("Resolved a dispute twice")
+		t.FailNow()
+	}
+
+	// Awards count against the settlement when it is disputed again
+	message = openDispute(settlement.Id, "30.01")
+	if utils.ParseErrorResponse(message).Code != utils.ERROR_INVALID_ARGUMENT {
+		fmt.Println("Disputed more than the settled amount less the award", message)
+		t.FailNow()
+	}
+	_ = utils.ExecInvoke(t, stub, "OpenDispute", []string{utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID,
+		productId, settlement.Id, "30", "Further streams under-reported"})
+	utils.CheckBankAccount(t, stub, utils.TEST_APPDEV_BA_ID, 899.97)
+}
+
+func TestTransferProposals(t *testing.T) {
+	var proposal utils.TransferProposal
+	var proposals []utils.TransferProposal
+	_, stub := beatchain_init(t)
+
+	_ = utils.ExecInvoke(t, stub, "SetTransferPolicy", []string{"500", "2", "24", `["alice", "bob", "carol"]`})
+
+	// Transfers under the threshold execute immediately
+	_ = utils.ExecInvoke(t, stub, "TransferFunds", []string{utils.TEST_APPDEV_BA_ID, "100"})
+	utils.CheckBankAccount(t, stub, utils.TEST_APPDEV_BA_ID, 1100)
+
+	// Only listed admins may propose or approve
+	res := stub.MockInvoke("1", [][]byte{[]byte("TransferFunds"), []byte(utils.TEST_APPDEV_BA_ID), []byte("2000")})
+	if res.Status == shim.OK {
+		fmt.Println("Unlisted admin proposed a transfer")
+		t.FailNow()
+	}
+
+	// Larger transfers wait for a second distinct approval
+	stub.Identity = "alice"
+	payload := utils.ExecInvoke(t, stub, "TransferFunds", []string{utils.TEST_APPDEV_BA_ID, "2000"})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &proposal)
+	if proposal.Status != transactions.PROPOSAL_PENDING || len(proposal.Approvals) != 1 || proposal.ProposedBy != "alice" {
+		fmt.Printf("Unexpected proposal: %+v\n", proposal)
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, utils.TEST_APPDEV_BA_ID, 1100)
+	res = stub.MockInvoke("1", [][]byte{[]byte("ApproveTransfer"), []byte(proposal.Id)})
+	if res.Status == shim.OK {
+		fmt.Println("Proposer approved their own proposal twice")
+		t.FailNow()
+	}
+
+	stub.Identity = "bob"
+	payload = utils.ExecInvoke(t, stub, "ApproveTransfer", []string{proposal.Id})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &proposal)
+	if proposal.Status != transactions.PROPOSAL_EXECUTED || proposal.Approvals[1].Identity != "bob" {
+		fmt.Printf("Proposal not executed: %+v\n", proposal)
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, utils.TEST_APPDEV_BA_ID, 3100)
+
+	// Proposals cannot be approved after they expire
+	payload = utils.ExecInvoke(t, stub, "TransferFunds", []string{utils.TEST_APPDEV_BA_ID, "-1000"})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &proposal)
+	stub.TimeOffset = 25 * time.Hour
+	stub.Identity = "carol"
+	res = stub.MockInvoke("1", [][]byte{[]byte("ApproveTransfer"), []byte(proposal.Id)})
+	if res.Status == shim.OK {
+		fmt.Println("Approved an expired proposal")
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, utils.TEST_APPDEV_BA_ID, 3100)
+
+	payload = utils.ExecInvoke(t, stub, "ListTransferProposals", []string{transactions.PROPOSAL_EXPIRED})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &proposals)
+	if len(proposals) != 1 || proposals[0].Id != proposal.Id {
+		fmt.Printf("Unexpected expired proposals: %+v\n", proposals)
+		t.FailNow()
+	}
+}
+
+func TestStreamFraudRules(t *testing.T) {
+	var result utils.StreamResult
+	var streams []utils.FlaggedStream
+	_, stub := beatchain_init(t)
+	checkListens := func(listens int64) {
+		product := utils.FetchTestProductRecord(t, stub, utils.TEST_PRODUCT_ID)
+		if product.UnRenumeratedListens != listens {
+			fmt.Printf("Expected %d payable listens, found %d\n", listens, product.UnRenumeratedListens)
+			t.FailNow()
+		}
+	}
+
+	// Stream while the test customer's subscription is active
+	stub.TimeOffset = time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC).Sub(time.Now())
+	_ = utils.ExecInvoke(t, stub, "SetFraudRules", []string{"2", "60", "60", "0"})
+
+	payload := utils.ExecInvoke(t, stub, "RequestSong", []string{utils.TEST_PRODUCT_ID})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &result)
+	if result.Flagged != nil {
+		fmt.Println("First play was flagged:", *payload)
+		t.FailNow()
+	}
+	checkListens(4)
+
+	// Repeating the product straight away is held
+	payload = utils.ExecInvoke(t, stub, "RequestSong", []string{utils.TEST_PRODUCT_ID})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &result)
+	flagged := result.Flagged
+	if flagged == nil || flagged.Status != transactions.STREAM_HELD || len(flagged.Reasons) != 1 || flagged.AppDevId != utils.TEST_APPDEV_ID {
+		fmt.Printf("Unexpected flagged stream: %+v\n", flagged)
+		t.FailNow()
+	}
+	repeatId := flagged.Id
+
+	// So is exceeding the velocity limit, even after the repeat gap
+	stub.TimeOffset += 5 * time.Minute
+	payload = utils.ExecInvoke(t, stub, "RequestSong", []string{utils.TEST_PRODUCT_ID})
+	result = utils.StreamResult{}
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &result)
+	flagged = result.Flagged
+	if flagged == nil || flagged.Id == repeatId || !strings.Contains(flagged.Reasons[0], "plays in 60 minutes") {
+		fmt.Printf("Velocity limit not enforced: %+v\n", flagged)
+		t.FailNow()
+	}
+	checkListens(4)
+
+	// Plays outside the window are payable again
+	stub.TimeOffset += 2 * time.Hour
+	payload = utils.ExecInvoke(t, stub, "RequestSong", []string{utils.TEST_PRODUCT_ID})
+	result = utils.StreamResult{}
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &result)
+	if result.Flagged != nil {
+		fmt.Println("Play outside the window was flagged:", *payload)
+		t.FailNow()
+	}
+	checkListens(5)
+
+	payload = utils.ExecInvoke(t, stub, "GetFlaggedStreams", []string{utils.TEST_APPDEV_ID, utils.TEST_PRODUCT_ID, transactions.STREAM_HELD})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &streams)
+	if len(streams) != 2 {
+		fmt.Printf("Unexpected held streams: %+v\n", streams)
+		t.FailNow()
+	}
+
+	// Released streams become payable; reviews are final
+	_ = utils.ExecInvoke(t, stub, "ReviewFlaggedStream", []string{repeatId, transactions.STREAM_RELEASED})
+	_ = utils.ExecInvoke(t, stub, "ReviewFlaggedStream", []string{flagged.Id, transactions.STREAM_REJECTED})
+	checkListens(6)
+	res := stub.MockInvoke("1", [][]byte{[]byte("ReviewFlaggedStream"), []byte(flagged.Id), []byte(transactions.STREAM_RELEASED)})
+	if res.Status == shim.OK {
+		fmt.Println("Released a rejected stream")
+		t.FailNow()
+	}
+	checkListens(6)
+}
+
+func TestEngagementMetrics(t *testing.T) {
+	var report utils.BulkImportReport
+	var payables utils.AppDevPayables
+	_, stub := beatchain_init(t)
+	stub.TimeOffset = time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC).Sub(time.Now())
+
+	// Price likes and playlist adds on a second product; skips are left unpriced
+	payload := utils.ExecInvoke(t, stub, "BulkImport", []string{utils.PRODUCT_KEY_PREFIX, transactions.BULK_IMPORT_ATOMIC,
+		`[{"creatorid": "` + utils.TEST_CREATOR_ID + `", "productname": "Engaging Product"}]`})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &report)
+	productId := report.Rows[0].Id
+	stub.Transient[utils.CONTRACT_TERMS_TRANSIENT_KEY] = []byte(`{"creatorpayperstream": 0.01, "metricrates": {"bogus": 1}}`)
+	res := stub.MockInvoke("1", [][]byte{[]byte("OfferContract"), []byte(utils.TEST_APPDEV_ID), []byte(utils.TEST_CREATOR_ID), []byte(productId)})
+	if res.Status == shim.OK {
+		fmt.Println("Offered a contract pricing an unknown metric")
+		t.FailNow()
+	}
+	stub.Transient[utils.CONTRACT_TERMS_TRANSIENT_KEY] = []byte(`{"creatorpayperstream": 0.01, "metricrates": {"like": 0.5, "playlistadd": 1}}`)
+	_ = utils.ExecInvoke(t, stub, "OfferContract", []string{utils.TEST_APPDEV_ID, utils.TEST_CREATOR_ID, productId})
+	_ = utils.ExecInvoke(t, stub, "AcceptContract", []string{utils.TEST_CREATOR_ID, productId, utils.TEST_APPDEV_ID})
+
+	// A second AppDev pricing skips is not paid for engagement through the first
+	var appDev utils.AppDevRecord
+	_, _ = utils.UnmarshalResponse([]byte(*utils.ExecInvoke(t, stub, "AddAppDevRecord", []string{"0.5"})), &appDev)
+	stub.Transient[utils.CONTRACT_TERMS_TRANSIENT_KEY] = []byte(`{"creatorpayperstream": 0.01, "metricrates": {"skip": 5}}`)
+	_ = utils.ExecInvoke(t, stub, "OfferContract", []string{appDev.Id, utils.TEST_CREATOR_ID, productId})
+	_ = utils.ExecInvoke(t, stub, "AcceptContract", []string{utils.TEST_CREATOR_ID, productId, appDev.Id})
+
+	// Engagement requires a stream of the product first
+	res = stub.MockInvoke("1", [][]byte{[]byte("RecordEngagement"), []byte(productId), []byte(transactions.METRIC_LIKE)})
+	if utils.ParseErrorResponse(res.Message).Code != utils.ERROR_CONFLICT {
+		fmt.Printf("Recorded engagement before streaming: %s\n", res.Message)
+		t.FailNow()
+	}
+	_ = utils.ExecInvoke(t, stub, "RequestSong", []string{productId})
+
+	for _, metricType := range []string{transactions.METRIC_LIKE, transactions.METRIC_LIKE, transactions.METRIC_PLAYLIST_ADD, transactions.METRIC_SKIP} {
+		stub.TimeOffset += 2 * time.Minute
+		_ = utils.ExecInvoke(t, stub, "RecordEngagement", []string{productId, metricType})
+	}
+	res = stub.MockInvoke("1", [][]byte{[]byte("RecordEngagement"), []byte(productId), []byte("share")})
+	if res.Status == shim.OK {
+		fmt.Println("Recorded an unknown metric type")
+		t.FailNow()
+	}
+
+	// Engagement is held by the fraud rules as streams are
+	var result utils.StreamResult
+	payload = utils.ExecInvoke(t, stub, "RecordEngagement", []string{productId, transactions.METRIC_SKIP})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &result)
+	if result.Flagged == nil || result.Flagged.MetricType != transactions.METRIC_SKIP {
+		fmt.Printf("Repeated skip not held: %+v\n", result)
+		t.FailNow()
+	}
+	contract, _ := utils.GetContract(stub, utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID, productId)
+	if contract.UnRenumeratedMetricCounts[transactions.METRIC_LIKE] != 2 || len(contract.UnRenumeratedMetricCounts) != 3 {
+		fmt.Printf("Unexpected metric counts: %+v\n", contract)
+		t.FailNow()
+	}
+	contract, _ = utils.GetContract(stub, utils.TEST_CREATOR_ID, appDev.Id, productId)
+	if len(contract.UnRenumeratedMetricCounts) != 0 {
+		fmt.Printf("Metrics counted against another AppDev: %+v\n", contract)
+		t.FailNow()
+	}
+
+	// 2 likes at $0.50 and a playlist add at $1.00 are payable alongside the stream
+	payload = utils.ExecInvoke(t, stub, "GetAppDevPayables", []string{})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &payables)
+	found := false
+	for _, payable := range payables.Contracts {
+		if payable.ProductId == productId {
+			found = payable.MetricsAmount == 2.0 && payable.GrossAmount == 2.01 && payable.Metrics[transactions.METRIC_SKIP] == 1
+		}
+	}
+	if !found {
+		fmt.Printf("Unexpected payables: %+v\n", payables)
+		t.FailNow()
+	}
+
+	// $2.01 for the engaging product, then $0.03 for the test product's streams, added as settlement does
+	utils.ExecQuery(t, stub, "CollectPayment")
+	balance := float32(1000)
+	for _, payment := range []float32{2.01, 0.03} {
+		balance += payment
+	}
+	utils.CheckBankAccount(t, stub, utils.TEST_CREATOR_BA_ID, balance)
+	product := utils.FetchTestProductRecord(t, stub, productId)
+	contract, _ = utils.GetContract(stub, utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID, productId)
+	if product.UnRenumeratedMetrics != 0 || product.TotalMetrics != 4 || len(contract.UnRenumeratedMetricCounts) != 0 {
+		fmt.Printf("Metrics not settled: %+v %+v\n", product, contract)
+		t.FailNow()
+	}
+}
+
+func TestPlaylistsAndQueues(t *testing.T) {
+	var report utils.BulkImportReport
+	var playlist utils.Playlist
+	var queue utils.PlayQueue
+	var next utils.NextSongResult
+	_, stub := beatchain_init(t)
+	stub.TimeOffset = time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC).Sub(time.Now())
+	_ = utils.ExecInvoke(t, stub, "SetFraudRules", []string{"0", "0", "0", "0"})
+
+	// A product the test AppDev holds no contract for can be listed but not queued
+	payload := utils.ExecInvoke(t, stub, "BulkImport", []string{utils.PRODUCT_KEY_PREFIX, transactions.BULK_IMPORT_ATOMIC,
+		`[{"creatorid": "` + utils.TEST_CREATOR_ID + `", "productname": "Uncontracted Product"}]`})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &report)
+	uncontractedId := report.Rows[0].Id
+
+	payload = utils.ExecInvoke(t, stub, "CreatePlaylist", []string{"Mix", `["` + utils.TEST_PRODUCT_ID + `", "` + uncontractedId + `"]`})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &playlist)
+	res := stub.MockInvoke("1", [][]byte{[]byte("EnqueuePlaylist"), []byte(playlist.Id)})
+	if res.Status == shim.OK {
+		fmt.Println("Enqueued a product without a contract")
+		t.FailNow()
+	}
+
+	// Reorder the playlist and queue it behind a single song
+	_ = utils.ExecInvoke(t, stub, "UpdatePlaylist", []string{playlist.Id, `["` + uncontractedId + `", "` + utils.TEST_PRODUCT_ID + `"]`})
+	_ = utils.ExecInvoke(t, stub, "UpdatePlaylist", []string{playlist.Id, `["` + utils.TEST_PRODUCT_ID + `"]`})
+	_ = utils.ExecInvoke(t, stub, "EnqueueSongs", []string{`["` + utils.TEST_PRODUCT_ID + `"]`})
+	payload = utils.ExecInvoke(t, stub, "EnqueuePlaylist", []string{playlist.Id})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &queue)
+	if len(queue.ProductIds) != 2 {
+		fmt.Printf("Unexpected play queue: %+v\n", queue)
+		t.FailNow()
+	}
+
+	// Each NextSong pops the queue and records a payable stream
+	for remaining := 1; remaining >= 0; remaining-- {
+		payload = utils.ExecInvoke(t, stub, "NextSong", []string{})
+		_, _ = utils.UnmarshalResponse([]byte(*payload), &next)
+		if next.ProductId != utils.TEST_PRODUCT_ID || next.Remaining != remaining || next.Flagged != nil {
+			fmt.Printf("Unexpected next song: %+v\n", next)
+			t.FailNow()
+		}
+	}
+	product := utils.FetchTestProductRecord(t, stub, utils.TEST_PRODUCT_ID)
+	if product.UnRenumeratedListens != 5 {
+		fmt.Printf("Queued streams not recorded: %+v\n", product)
+		t.FailNow()
+	}
+	res = stub.MockInvoke("1", [][]byte{[]byte("NextSong")})
+	if res.Status == shim.OK {
+		fmt.Println("NextSong succeeded on an empty queue")
+		t.FailNow()
+	}
+
+	// Entries which can no longer be streamed are dropped on the way to the next valid one
+	stub.Transient[utils.CONTRACT_TERMS_TRANSIENT_KEY] = []byte(`{"creatorpayperstream": 0.01}`)
+	_ = utils.ExecInvoke(t, stub, "OfferContract", []string{utils.TEST_APPDEV_ID, utils.TEST_CREATOR_ID, uncontractedId})
+	res = stub.MockInvoke("1", [][]byte{[]byte("RequestSong"), []byte(uncontractedId)})
+	if utils.ParseErrorResponse(res.Message).Code != utils.ERROR_CONFLICT {
+		fmt.Println("Streamed a product whose contract is not accepted:", res.Message)
+		t.FailNow()
+	}
+	_ = utils.ExecInvoke(t, stub, "AcceptContract", []string{utils.TEST_CREATOR_ID, uncontractedId, utils.TEST_APPDEV_ID})
+	_ = utils.ExecInvoke(t, stub, "EnqueueSongs", []string{`["` + uncontractedId + `", "` + utils.TEST_PRODUCT_ID + `", "` + uncontractedId + `"]`})
+	contract := utils.FetchTestContractRecord(t, stub, utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID, uncontractedId)
+	contract.Status = transactions.REJECTED
+	stub.MockTransactionStart("reject")
+	_ = utils.SetContract(stub, contract)
+	stub.MockTransactionEnd("reject")
+	for _, expected := range []utils.NextSongResult{
+		{ProductId: utils.TEST_PRODUCT_ID, Remaining: 1},
+		{ProductId: "", Remaining: 0}} {
+		next = utils.NextSongResult{}
+		payload = utils.ExecInvoke(t, stub, "NextSong", []string{})
+		_, _ = utils.UnmarshalResponse([]byte(*payload), &next)
+		if next.ProductId != expected.ProductId || next.Remaining != expected.Remaining ||
+			len(next.Skipped) != 1 || next.Skipped[0] != uncontractedId {
+			fmt.Printf("Unexpected next song: %+v\n", next)
+			t.FailNow()
+		}
+	}
+	res = stub.MockInvoke("1", [][]byte{[]byte("NextSong")})
+	if utils.ParseErrorResponse(res.Message).Code != utils.ERROR_CONFLICT {
+		fmt.Println("Dropped entries not removed from the queue")
+		t.FailNow()
+	}
+
+	// Shared playlists are visible to the customers they are shared with
+	payload = utils.ExecInvoke(t, stub, "BulkImport", []string{utils.CUSTOMER_RECORD_KEY_PREFIX, transactions.BULK_IMPORT_ATOMIC,
+		`[{"appdevid": "` + utils.TEST_APPDEV_ID + `", "subscriptionfee": 10}]`})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &report)
+	friendId := report.Rows[0].Id
+	_ = utils.ExecInvoke(t, stub, "SharePlaylist", []string{playlist.Id, friendId})
+	shared, err := utils.GetCustomerPlaylists(stub, friendId)
+	if err != nil || len(shared) != 1 || shared[0].Id != playlist.Id {
+		fmt.Printf("Playlist not shared: %+v %v\n", shared, err)
+		t.FailNow()
+	}
+
+	// Deleted products can no longer be streamed
+	product.IsActive = false
+	stub.MockTransactionStart("delete")
+	_ = utils.SetProduct(stub, product)
+	stub.MockTransactionEnd("delete")
+	res = stub.MockInvoke("1", [][]byte{[]byte("RequestSong"), []byte(utils.TEST_PRODUCT_ID)})
+	if utils.ParseErrorResponse(res.Message).Code != utils.ERROR_CONFLICT {
+		fmt.Println("Streamed an inactive product:", res.Message)
+		t.FailNow()
+	}
+}
+
+func TestMultipleSubscriptions(t *testing.T) {
+	legacyCustomerId := "6666"
+	_, stub := beatchain_init(t)
+	stub.TimeOffset = time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC).Sub(time.Now())
+	_ = utils.ExecInvoke(t, stub, "SetFraudRules", []string{"0", "0", "0", "0"})
+
+	// Subscribe the test customer through a second AppDev holding a contract for the test product
+	var appDev utils.AppDevRecord
+	_, _ = utils.UnmarshalResponse([]byte(*utils.ExecInvoke(t, stub, "AddAppDevRecord", []string{"0.5"})), &appDev)
+	appDevId := appDev.Id
+	stub.Transient[utils.CONTRACT_TERMS_TRANSIENT_KEY] = []byte(`{"creatorpayperstream": 0.01}`)
+	_ = utils.ExecInvoke(t, stub, "OfferContract", []string{appDevId, utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID})
+	_ = utils.ExecInvoke(t, stub, "AcceptContract", []string{utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID, appDevId})
+	_ = utils.ExecInvoke(t, stub, "AddSubscription", []string{utils.TEST_CUSTOMER_ID, appDevId, "5"})
+	res := stub.MockInvoke("1", [][]byte{[]byte("AddSubscription"), []byte(utils.TEST_CUSTOMER_ID), []byte(appDevId), []byte("5")})
+	if res.Status == shim.OK {
+		fmt.Println("Customer subscribed twice through one AppDev")
+		t.FailNow()
+	}
+
+	// Customers with several subscriptions must name the AppDev they stream through
+	res = stub.MockInvoke("1", [][]byte{[]byte("RequestSong"), []byte(utils.TEST_PRODUCT_ID)})
+	if res.Status == shim.OK {
+		fmt.Println("RequestSong succeeded without an AppDev")
+		t.FailNow()
+	}
+	_ = utils.ExecInvoke(t, stub, "RequestSong", []string{utils.TEST_PRODUCT_ID, appDevId})
+	_ = utils.ExecInvoke(t, stub, "RequestSong", []string{utils.TEST_PRODUCT_ID, utils.TEST_APPDEV_ID})
+	product := utils.FetchTestProductRecord(t, stub, utils.TEST_PRODUCT_ID)
+	if product.UnRenumeratedListens != 5 {
+		fmt.Printf("Streams not recorded: %+v\n", product)
+		t.FailNow()
+	}
+
+	// Renewal charges the named subscription from the customer's one bank account
+	_ = utils.ExecInvoke(t, stub, "RenewSubscription", []string{appDevId})
+	utils.CheckBankAccount(t, stub, utils.TEST_CUSTOMER_BA_ID, 995)
+	var subscribers []utils.CustomerRecord
+	payload := utils.ExecInvoke(t, stub, "ListAppCustomers", []string{appDevId})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &subscribers)
+	if len(subscribers) != 1 || subscribers[0].Id != utils.TEST_CUSTOMER_ID {
+		fmt.Printf("Subscriber not listed: %s\n", *payload)
+		t.FailNow()
+	}
+
+	// Cancelling leaves the customer's remaining subscription as the default
+	_ = utils.ExecInvoke(t, stub, "CancelSubscription", []string{utils.TEST_CUSTOMER_ID, appDevId})
+	customers, err := utils.GetAppDevCustomers(stub, appDevId)
+	if err != nil || len(customers) != 0 {
+		fmt.Printf("Cancelled subscriber still indexed: %+v %v\n", customers, err)
+		t.FailNow()
+	}
+	_ = utils.ExecInvoke(t, stub, "RequestSong", []string{utils.TEST_PRODUCT_ID})
+
+	// Customers stored with a single AppDev are migrated to a subscription and indexed on upgrade
+	customerKey, err := utils.GetRecordKey(stub, utils.CUSTOMER_RECORD_KEY_PREFIX, legacyCustomerId)
+	if err != nil {
+		t.FailNow()
+	}
+	stub.MockTransactionStart("legacy")
+	_ = stub.PutState(customerKey, []byte(`{"id":"`+legacyCustomerId+`","appdevid":"`+utils.TEST_APPDEV_ID+
+		`","bankaccountid":"","subscriptionfee":7.5,"subscriptionduedate":"2020-06-01T00:00:00Z","schemaversion":1}`))
+	stub.MockTransactionEnd("legacy")
+	customer, err := utils.GetCustomerRecord(stub, legacyCustomerId)
+	if err != nil || customer.Subscriptions[utils.TEST_APPDEV_ID] == nil || customer.Subscriptions[utils.TEST_APPDEV_ID].SubscriptionFee != 7.5 {
+		fmt.Printf("Legacy customer not migrated on read: %+v %v\n", customer, err)
+		t.FailNow()
+	}
+	res = stub.MockInit("upgrade", [][]byte{})
+	if res.Status != shim.OK {
+		fmt.Println("Upgrade failed", res.Message)
+		t.FailNow()
+	}
+	customers, err = utils.GetAppDevCustomers(stub, utils.TEST_APPDEV_ID)
+	if err != nil || len(customers) != 2 {
+		fmt.Printf("Legacy customer not indexed on upgrade: %+v %v\n", customers, err)
+		t.FailNow()
+	}
+}
+
 func TestAddFunctions(t *testing.T) {
-	var id *string
+	var payload *string
+	var appDev utils.AppDevRecord
+	var customer utils.CustomerRecord
+	var creator utils.CreatorRecord
+	var product utils.Product
 	_, stub := beatchain_init(t)
 
 	// Add an appdev record
-	id = utils.ExecInvoke(t, stub, "AddAppDevRecord", []string{"0.5"})
-	fmt.Println("Returned id:", *id)
-	appdevrec := utils.FetchTestAppdevRecord(t, stub, *id)
+	payload = utils.ExecInvoke(t, stub, "AddAppDevRecord", []string{"0.5"})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &appDev)
+	fmt.Println("Returned id:", appDev.Id)
+	appdevrec := utils.FetchTestAppdevRecord(t, stub, appDev.Id)
 	fmt.Printf("Record: %+v\n", appdevrec)
 	appdevbaRec := utils.FetchTestBankAccount(t, stub, appdevrec.BankAccountId)
 	fmt.Printf("Bank Account Record: %+v\n", appdevbaRec)
 
 
 	// Add a customer record
-	id = utils.ExecInvoke(t, stub, "AddCustomerRecord", []string{"20.00"})
-	fmt.Println("Returned id:", *id)
-	custrec := utils.FetchTestCustomerRecord(t, stub, *id)
+	payload = utils.ExecInvoke(t, stub, "AddCustomerRecord", []string{"20.00"})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &customer)
+	fmt.Println("Returned id:", customer.Id)
+	custrec := utils.FetchTestCustomerRecord(t, stub, customer.Id)
 	fmt.Printf("Record: %+v\n", custrec)
 	custbarec := utils.FetchTestBankAccount(t, stub, custrec.BankAccountId)
 	fmt.Printf("Bank Account Record: %+v\n", custbarec)
 
 	// Add a Creator record
-	id = utils.ExecInvoke(t, stub, "AddCreatorRecord", []string{})
-	fmt.Println("Returned id:", *id)
-	createrec := utils.FetchTestCreatorRecord(t, stub, *id)
+	payload = utils.ExecInvoke(t, stub, "AddCreatorRecord", []string{})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &creator)
+	fmt.Println("Returned id:", creator.Id)
+	createrec := utils.FetchTestCreatorRecord(t, stub, creator.Id)
 	fmt.Printf("Record: %+v\n", createrec)
 	createbarec := utils.FetchTestBankAccount(t, stub, createrec.BankAccountId)
 	fmt.Printf("Bank Account Record: %+v\n", createbarec)
 
 	// Add a product record
-	id = utils.ExecInvoke(t, stub, "AddProduct", []string{"test product name"})
-	fmt.Println("Returned id:", *id)
-	prodrec := utils.FetchTestProductRecord(t, stub, *id)
+	payload = utils.ExecInvoke(t, stub, "AddProduct", []string{"test product name"})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &product)
+	fmt.Println("Returned id:", product.Id)
+	prodrec := utils.FetchTestProductRecord(t, stub, product.Id)
 	fmt.Printf("Record: %+v\n", prodrec)
 
 }
@@ -154,6 +894,7 @@ func TestContractFunctions(t *testing.T) {
 	//var id *string
 	var contract *utils.Contract
 	_, stub := beatchain_init(t)
+	requestTestContract(t, stub)
 
 	_ = utils.ExecInvoke(t, stub, "OfferContract", []string{utils.TEST_APPDEV_ID, utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID, "0.02"})
 	contract = utils.FetchTestContractRecord(t, stub, utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID, utils.TEST_PRODUCT_ID)
@@ -168,3 +909,1399 @@ func TestContractFunctions(t *testing.T) {
 
 
 
+func TestContractAdvance(t *testing.T) {
+	var terms *utils.ContractTerms
+	_, stub := beatchain_init(t)
+	requestTestContract(t, stub)
+
+	// A minimum guarantee needs a term
+	res := stub.MockInvoke("1", stringToBytes([]string{"OfferContract", utils.TEST_APPDEV_ID, utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID, "0.02", "50", "60"}))
+	if res.Status == shim.OK {
+		fmt.Println("OfferContract accepted a minimum guarantee without a term")
+		t.FailNow()
+	}
+
+	// Offer a contract with a $50 advance against a $60 minimum guarantee over a term which has yet to end
+	_ = utils.ExecInvoke(t, stub, "OfferContract", []string{utils.TEST_APPDEV_ID, utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID, "0.02", "50", "60", "2100-01-01"})
+	_ = utils.ExecInvoke(t, stub, "AcceptContract", []string{utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID, utils.TEST_APPDEV_ID})
+	terms = utils.FetchTestContractTerms(t, stub, utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID, utils.TEST_PRODUCT_ID)
+	if terms.RecoupBalance != 50.0 {
+		fmt.Printf("Recoup balance %.2f != 50.00 expected\n", terms.RecoupBalance)
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, utils.TEST_APPDEV_BA_ID, 950.0)
+	utils.CheckBankAccount(t, stub, utils.TEST_CREATOR_BA_ID, 1050.0)
+
+	// Accepting twice must not pay the advance twice, and accepted contracts cannot be re-offered or rejected
+	for _, args := range [][]string{
+		{"AcceptContract", utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID, utils.TEST_APPDEV_ID},
+		{"OfferContract", utils.TEST_APPDEV_ID, utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID, "0.03"},
+		{"RejectContract", utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID, utils.TEST_APPDEV_ID},
+	} {
+		res = stub.MockInvoke("1", stringToBytes(args))
+		if utils.ParseErrorResponse(res.Message).Code != utils.ERROR_CONFLICT {
+			fmt.Printf("%s succeeded on an accepted contract: %s\n", args[0], res.Message)
+			t.FailNow()
+		}
+	}
+
+	// Stream earnings are recouped rather than paid out; the guarantee is not owed during its term
+	utils.ExecQuery(t, stub, "CollectPayment")
+	terms = utils.FetchTestContractTerms(t, stub, utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID, utils.TEST_PRODUCT_ID)
+	if terms.RecoupBalance != 49.94 || terms.TotalEarned != 0.06 || utils.GuaranteeShortfall(terms) != 10.0 {
+		fmt.Printf("Recoup balance %.2f and total earned %.2f != 49.94 and 0.06 expected\n",
+			terms.RecoupBalance, terms.TotalEarned)
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, utils.TEST_APPDEV_BA_ID, 950.0)
+	utils.CheckBankAccount(t, stub, utils.TEST_CREATOR_BA_ID, 1050.0)
+
+	// From the day the term ends the $10 left of the guarantee is owed on top of any earnings
+	termEnd := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+	if utils.GuaranteeDue(terms, termEnd.Add(-time.Second)) || !utils.GuaranteeDue(terms, termEnd) ||
+		utils.GuaranteeTopUp(terms, 0.02, 0.02) != 10.0 {
+		fmt.Println("Guarantee shortfall not owed from the end of its term")
+		t.FailNow()
+	}
+
+	utils.ExecInvoke(t, stub, "GetContractTerms", []string{utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID, utils.TEST_APPDEV_ID})
+}
+
+func TestPrivateContractTerms(t *testing.T) {
+	var contract *utils.Contract
+	var terms *utils.ContractTerms
+	_, stub := beatchain_init(t)
+	requestTestContract(t, stub)
+
+	// A salt seed, when given, must be long enough that the terms cannot be guessed from their hash
+	offerArgs := []string{"OfferContract", utils.TEST_APPDEV_ID, utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID}
+	stub.Transient[utils.CONTRACT_TERMS_TRANSIENT_KEY] = []byte(`{"creatorpayperstream": 0.05, "advance": 10}`)
+	stub.Transient[utils.CONTRACT_SALT_TRANSIENT_KEY] = []byte("short")
+	res := stub.MockInvoke("1", stringToBytes(offerArgs))
+	if res.Status == shim.OK {
+		fmt.Println("OfferContract accepted a short salt seed")
+		t.FailNow()
+	}
+
+	// Offer terms through the transient map so they stay off the public ledger
+	stub.Transient[utils.CONTRACT_TERMS_TRANSIENT_KEY] = []byte(`{"creatorpayperstream": 0.05, "advance": 10}`)
+	stub.Transient[utils.CONTRACT_SALT_TRANSIENT_KEY] = []byte("0123456789abcdef")
+	_ = utils.ExecInvoke(t, stub, "OfferContract", []string{utils.TEST_APPDEV_ID, utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID})
+
+	contract = utils.FetchTestContractRecord(t, stub, utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID, utils.TEST_PRODUCT_ID)
+	terms = utils.FetchTestContractTerms(t, stub, utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID, utils.TEST_PRODUCT_ID)
+	if terms.CreatorPayPerStream != 0.05 || terms.Advance != 10.0 {
+		fmt.Printf("Private terms %+v do not match the offer\n", terms)
+		t.FailNow()
+	}
+	if err := utils.VerifyContractTerms(contract, terms); err != nil {
+		fmt.Println(err.Error())
+		t.FailNow()
+	}
+	publicBytes := stub.State[mustContractKey(t, stub)]
+	if strings.Contains(string(publicBytes), "0.05") || strings.Contains(string(publicBytes), terms.Salt) {
+		fmt.Println("Public contract leaks the pay per stream or salt:", string(publicBytes))
+		t.FailNow()
+	}
+
+	// Tampered terms must fail verification during settlement
+	_ = utils.ExecInvoke(t, stub, "AcceptContract", []string{utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID, utils.TEST_APPDEV_ID})
+	terms = utils.FetchTestContractTerms(t, stub, utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID, utils.TEST_PRODUCT_ID)
+	terms.CreatorPayPerStream = 5.0
+	stub.MockTransactionStart("tamper")
+	_ = utils.SetContractTerms(stub, terms)
+	stub.MockTransactionEnd("tamper")
+	res = stub.MockInvoke("1", [][]byte{[]byte("CollectPayment")})
+	if res.Status == shim.OK {
+		fmt.Println("CollectPayment succeeded with tampered terms")
+		t.FailNow()
+	}
+}
+
+func mustContractKey(t *testing.T, stub *utils.TestStub) string {
+	key, err := utils.GetRecordKey(stub, utils.CONTRACT_KEY_PREFIX, utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID, utils.TEST_PRODUCT_ID)
+	if err != nil {
+		t.FailNow()
+	}
+	return key
+}
+
+func TestCreatorStatement(t *testing.T) {
+	var statement utils.CreatorStatement
+	_, stub := beatchain_init(t)
+
+	// Settle the fixture contract's 3 unremunerated streams at $0.01
+	utils.ExecQuery(t, stub, "CollectPayment")
+
+	today := time.Now().UTC().Format("2006-01-02")
+	payload := utils.ExecInvoke(t, stub, "GetCreatorStatement", []string{today, today, "product"})
+	_, err := utils.UnmarshalResponse([]byte(*payload), &statement)
+	if err != nil {
+		fmt.Println("Cannot unmarshal statement:", err.Error())
+		t.FailNow()
+	}
+	if len(statement.Lines) != 1 || statement.Lines[0].ProductId != utils.TEST_PRODUCT_ID {
+		fmt.Printf("Unexpected statement lines: %+v\n", statement.Lines)
+		t.FailNow()
+	}
+	if statement.Total.Streams != 3 || statement.Total.GrossAmount != 0.03 || statement.Total.NetAmount != 0.03 {
+		fmt.Printf("Unexpected statement total: %+v\n", statement.Total)
+		t.FailNow()
+	}
+
+	// Settlements outside the period are excluded
+	payload = utils.ExecInvoke(t, stub, "GetCreatorStatement", []string{"2000-01-01", "2000-01-31", "appdev"})
+	statement = utils.CreatorStatement{}
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &statement)
+	if len(statement.Lines) != 0 || statement.Total.Settlements != 0 {
+		fmt.Printf("Unexpected statement for empty period: %+v\n", statement)
+		t.FailNow()
+	}
+}
+
+func TestInvoices(t *testing.T) {
+	var payables utils.AppDevPayables
+	var invoice utils.Invoice
+	_, stub := beatchain_init(t)
+
+	// The fixture contract's 3 unremunerated streams at $0.01 are payable
+	payload := utils.ExecInvoke(t, stub, "GetAppDevPayables", []string{})
+	_, err := utils.UnmarshalResponse([]byte(*payload), &payables)
+	if err != nil {
+		fmt.Println("Cannot unmarshal payables:", err.Error())
+		t.FailNow()
+	}
+	if payables.TotalDue != 0.03 || payables.CreatorTotals[utils.TEST_CREATOR_ID] != 0.03 || payables.Shortfall != 0.0 {
+		fmt.Printf("Unexpected payables: %+v\n", payables)
+		t.FailNow()
+	}
+
+	payload = utils.ExecInvoke(t, stub, "IssueInvoice", []string{utils.TEST_CREATOR_ID})
+	_, err = utils.UnmarshalResponse([]byte(*payload), &invoice)
+	if err != nil {
+		fmt.Println("Cannot unmarshal invoice:", err.Error())
+		t.FailNow()
+	}
+	if invoice.Status != transactions.INVOICE_OPEN || invoice.AmountDue != 0.03 || len(invoice.Lines) != 1 {
+		fmt.Printf("Unexpected invoice: %+v\n", invoice)
+		t.FailNow()
+	}
+
+	// Only one invoice may be open at a time
+	res := stub.MockInvoke("1", [][]byte{[]byte("IssueInvoice"), []byte(utils.TEST_CREATOR_ID)})
+	if res.Status == shim.OK {
+		fmt.Println("IssueInvoice succeeded with an invoice already open")
+		t.FailNow()
+	}
+
+	// Collecting payment settles the invoice and references it from the settlement
+	utils.ExecQuery(t, stub, "CollectPayment")
+	invoices, err := utils.GetInvoices(stub, utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID)
+	if err != nil || len(invoices) != 1 || invoices[0].Status != transactions.INVOICE_SETTLED {
+		fmt.Printf("Invoice not settled: %+v %v\n", invoices, err)
+		t.FailNow()
+	}
+	settlements, err := utils.GetCreatorSettlements(stub, utils.TEST_CREATOR_ID)
+	if err != nil || len(settlements) != 1 || settlements[0].InvoiceId != invoice.Id ||
+		invoices[0].Lines[0].SettlementId != settlements[0].Id {
+		fmt.Printf("Settlement does not reference invoice: %+v %v\n", settlements, err)
+		t.FailNow()
+	}
+}
+
+func TestRecordHistory(t *testing.T) {
+	var history []struct {
+		TxId     string        `json:"txid"`
+		IsDelete bool          `json:"isdelete"`
+		Record   utils.Product `json:"record"`
+	}
+	_, stub := beatchain_init(t)
+
+	utils.ExecQuery(t, stub, "CollectPayment")
+
+	payload := utils.ExecInvoke(t, stub, "GetRecordHistory", []string{utils.PRODUCT_KEY_PREFIX, utils.TEST_PRODUCT_ID})
+	_, err := utils.UnmarshalResponse([]byte(*payload), &history)
+	if err != nil {
+		fmt.Println("Cannot unmarshal history:", err.Error())
+		t.FailNow()
+	}
+	if len(history) != 2 || history[0].Record.UnRenumeratedListens == 0 || history[1].Record.UnRenumeratedListens != 0 {
+		fmt.Printf("Unexpected product history: %+v\n", history)
+		t.FailNow()
+	}
+
+	// Contracts are identified by their creator, AppDev and product IDs
+	_ = utils.ExecInvoke(t, stub, "GetRecordHistory",
+		[]string{utils.CONTRACT_KEY_PREFIX, utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID, utils.TEST_PRODUCT_ID})
+	res := stub.MockInvoke("1", [][]byte{[]byte("GetRecordHistory"), []byte(utils.CREATOR_RECORD_KEY_PREFIX), []byte(utils.TEST_CREATOR_ID)})
+	if res.Status == shim.OK {
+		fmt.Println("GetRecordHistory succeeded for an unsupported record type")
+		t.FailNow()
+	}
+}
+
+func TestSchemaMigration(t *testing.T) {
+	var status utils.MigrationStatus
+	legacyProductId := "5555"
+	_, stub := beatchain_init(t)
+
+	// Store a product as written before records carried a schema version
+	productKey, err := utils.GetRecordKey(stub, utils.PRODUCT_KEY_PREFIX, legacyProductId)
+	if err != nil {
+		t.FailNow()
+	}
+	stub.MockTransactionStart("legacy")
+	_ = stub.PutState(productKey, []byte(`{"id":"`+legacyProductId+`","creatorid":"`+utils.TEST_CREATOR_ID+
+		`","productName":"Legacy","totalListens":12,"isActive":true}`))
+	stub.MockTransactionEnd("legacy")
+
+	// Legacy records are migrated lazily on read
+	product, err := utils.GetProduct(stub, legacyProductId)
+	if err != nil || product.TotalListens != 12 || product.SchemaVersion != utils.CurrentSchemaVersion(utils.PRODUCT_KEY_PREFIX) {
+		fmt.Printf("Legacy product not migrated on read: %+v %v\n", product, err)
+		t.FailNow()
+	}
+
+	payload := utils.ExecInvoke(t, stub, "MigrationStatus", []string{})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &status)
+	if pendingMigrations(status, utils.PRODUCT_KEY_PREFIX) != 1 || status.LastRun != nil {
+		fmt.Printf("Unexpected migration status before upgrade: %+v\n", status)
+		t.FailNow()
+	}
+
+	// Upgrading the chaincode migrates the stored records
+	res := stub.MockInit("upgrade", [][]byte{})
+	if res.Status != shim.OK {
+		fmt.Println("Upgrade failed", res.Message)
+		t.FailNow()
+	}
+	payload = utils.ExecInvoke(t, stub, "MigrationStatus", []string{})
+	status = utils.MigrationStatus{}
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &status)
+	if pendingMigrations(status, utils.PRODUCT_KEY_PREFIX) != 0 || status.LastRun == nil ||
+		status.LastRun.Migrated[utils.PRODUCT_KEY_PREFIX] != 1 {
+		fmt.Printf("Unexpected migration status after upgrade: %+v\n", status)
+		t.FailNow()
+	}
+}
+
+func pendingMigrations(status utils.MigrationStatus, recordType string) int {
+	for _, typeStatus := range status.RecordTypes {
+		if typeStatus.RecordType == recordType {
+			return typeStatus.Pending
+		}
+	}
+	return -1
+}
+
+func TestGenesisInit(t *testing.T) {
+	var summary GenesisSummary
+	scc := new(BeatchainChaincode)
+	scc.testMode = true
+	stub := utils.NewTestStub("Beatchain", scc)
+
+	genesisBytes, err := ioutil.ReadFile("genesis_example.json")
+	if err != nil {
+		fmt.Println("Cannot read genesis document:", err.Error())
+		t.FailNow()
+	}
+
+	// References that do not resolve reject the whole document
+	invalid := strings.Replace(string(genesisBytes), `"appdevid": "1112", "bankaccountid": "2223"`,
+		`"appdevid": "9999", "bankaccountid": "1111"`, 1)
+	invalid = strings.Replace(invalid, `"guaranteeend": "2030-01-01"`, `"guaranteeend": ""`, 1)
+	res := stub.MockInit("1", [][]byte{[]byte("init"), []byte(invalid)})
+	if res.Status == shim.OK || !strings.Contains(res.Message, "unknown AppDev 9999") ||
+		!strings.Contains(res.Message, "already owned by AppDev 1111") || !strings.Contains(res.Message, "guaranteeend") {
+		fmt.Println("Invalid genesis document accepted:", res.Message)
+		t.FailNow()
+	}
+	if len(stub.State) != 0 {
+		fmt.Println("Invalid genesis document wrote to the ledger")
+		t.FailNow()
+	}
+
+	res = stub.MockInit("1", [][]byte{[]byte("init"), genesisBytes})
+	if res.Status != shim.OK {
+		fmt.Println("Init failed", res.Message)
+		t.FailNow()
+	}
+	err = json.Unmarshal(res.Payload, &summary)
+	if err != nil || summary.BankAccounts != 7 || summary.Customers != 2 || summary.Contracts != 2 || summary.TotalBalance != 4550 {
+		fmt.Printf("Unexpected genesis summary: %+v %v\n", summary, err)
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, "2223", 50)
+
+	// The fixture contract settles as it does from positional arguments
+	utils.ExecQuery(t, stub, "CollectPayment")
+	utils.CheckBankAccount(t, stub, utils.TEST_CREATOR_BA_ID, 1000.03)
+
+	// Only the advances of accepted contracts are treated as paid
+	if terms := utils.FetchTestContractTerms(t, stub, "3334", "1112", "4445"); terms.RecoupBalance != 5 {
+		fmt.Printf("Unexpected terms of an accepted contract: %+v\n", terms)
+		t.FailNow()
+	}
+	requested := strings.Replace(string(genesisBytes), `"productid": "4445", "contractstatus": "ACCEPTED"`,
+		`"productid": "4445", "contractstatus": "REQUESTED"`, 1)
+	stub = utils.NewTestStub("Beatchain", scc)
+	res = stub.MockInit("1", [][]byte{[]byte("init"), []byte(requested)})
+	if res.Status != shim.OK {
+		fmt.Println("Init failed", res.Message)
+		t.FailNow()
+	}
+	if terms := utils.FetchTestContractTerms(t, stub, "3334", "1112", "4445"); terms.RecoupBalance != 0 || terms.Advance != 5 {
+		fmt.Printf("Unexpected terms of a requested contract: %+v\n", terms)
+		t.FailNow()
+	}
+}
+
+func TestBulkImport(t *testing.T) {
+	var report utils.BulkImportReport
+	_, stub := beatchain_init(t)
+
+	rows := `[{"appdevid": "` + utils.TEST_APPDEV_ID + `", "subscriptionfee": 2.5, "subscriptionduedate": "2020-07-01"},
+		{"appdevid": "9999", "subscriptionfee": 1},
+		{"appdevid": "` + utils.TEST_APPDEV_ID + `", "subscriptionfee": 1}]`
+
+	// An invalid row rejects an atomic batch
+	payload := utils.ExecInvoke(t, stub, "BulkImport", []string{utils.CUSTOMER_RECORD_KEY_PREFIX, transactions.BULK_IMPORT_ATOMIC, rows})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &report)
+	if report.Committed || report.Succeeded != 0 || report.Failed != 1 || !strings.Contains(report.Rows[1].Error, "9999") {
+		fmt.Printf("Unexpected atomic report: %+v\n", report)
+		t.FailNow()
+	}
+
+	// A partial batch imports the valid rows
+	payload = utils.ExecInvoke(t, stub, "BulkImport", []string{utils.CUSTOMER_RECORD_KEY_PREFIX, transactions.BULK_IMPORT_PARTIAL, rows})
+	report = utils.BulkImportReport{}
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &report)
+	if !report.Committed || report.Succeeded != 2 || report.Failed != 1 || report.Rows[1].Id != "" {
+		fmt.Printf("Unexpected partial report: %+v\n", report)
+		t.FailNow()
+	}
+	customer, err := utils.GetCustomerRecord(stub, report.Rows[0].Id)
+	if err != nil || customer.Subscriptions[utils.TEST_APPDEV_ID].SubscriptionFee != 2.5 || customer.BankAccountId != report.Rows[0].BankAccountId {
+		fmt.Printf("Imported customer does not match its row: %+v %v\n", customer, err)
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, report.Rows[2].BankAccountId, 0.0)
+
+	payload = utils.ExecInvoke(t, stub, "BulkImport", []string{utils.PRODUCT_KEY_PREFIX, transactions.BULK_IMPORT_ATOMIC,
+		`[{"creatorid": "` + utils.TEST_CREATOR_ID + `", "productname": "Bulk Product"}]`})
+	report = utils.BulkImportReport{}
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &report)
+	product, err := utils.GetProduct(stub, report.Rows[0].Id)
+	if err != nil || product.ProductName != "Bulk Product" || !product.IsActive {
+		fmt.Printf("Imported product does not match its row: %+v %v\n", product, err)
+		t.FailNow()
+	}
+
+	// Batches over the size limit are refused
+	tooMany := "[" + strings.Repeat("{},", transactions.BULK_IMPORT_MAX_ROWS) + "{}]"
+	res := stub.MockInvoke("1", [][]byte{[]byte("BulkImport"), []byte(utils.CREATOR_RECORD_KEY_PREFIX),
+		[]byte(transactions.BULK_IMPORT_PARTIAL), []byte(tooMany)})
+	if res.Status == shim.OK {
+		fmt.Println("BulkImport accepted a batch over the size limit")
+		t.FailNow()
+	}
+}
+
+func TestOffboarding(t *testing.T) {
+	var tombstone utils.Tombstone
+	var withdrawal utils.Withdrawal
+	var report utils.BulkImportReport
+	_, stub := beatchain_init(t)
+
+	// Offer a contract with a minimum guarantee on a second product
+	payload := utils.ExecInvoke(t, stub, "BulkImport", []string{utils.PRODUCT_KEY_PREFIX, transactions.BULK_IMPORT_ATOMIC,
+		`[{"creatorid": "` + utils.TEST_CREATOR_ID + `", "productname": "Guaranteed Product"}]`})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &report)
+	productId := report.Rows[0].Id
+	stub.Transient[utils.CONTRACT_TERMS_TRANSIENT_KEY] = []byte(`{"creatorpayperstream": 0.01, "minimumguarantee": 5, "guaranteeend": "2100-01-01"}`)
+	_ = utils.ExecInvoke(t, stub, "OfferContract", []string{utils.TEST_APPDEV_ID, utils.TEST_CREATOR_ID, productId})
+	_ = utils.ExecInvoke(t, stub, "AcceptContract", []string{utils.TEST_CREATOR_ID, productId, utils.TEST_APPDEV_ID})
+
+	// AppDevs cannot be closed while they have customers
+	res := stub.MockInvoke("1", [][]byte{[]byte("CloseAppDev"), []byte(utils.TEST_APPDEV_ID), []byte(transactions.CLOSE_SWEEP)})
+	if res.Status == shim.OK {
+		fmt.Println("CloseAppDev succeeded with customers remaining")
+		t.FailNow()
+	}
+
+	// Customers cannot be closed while their streams are held for review
+	var result utils.StreamResult
+	stub.TimeOffset = time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC).Sub(time.Now())
+	_ = utils.ExecInvoke(t, stub, "SetFraudRules", []string{"0", "0", "60", "0"})
+	_ = utils.ExecInvoke(t, stub, "RequestSong", []string{utils.TEST_PRODUCT_ID})
+	payload = utils.ExecInvoke(t, stub, "RequestSong", []string{utils.TEST_PRODUCT_ID})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &result)
+	res = stub.MockInvoke("1", [][]byte{[]byte("CloseCustomer"), []byte(utils.TEST_CUSTOMER_ID), []byte(transactions.CLOSE_SWEEP)})
+	if result.Flagged == nil || utils.ParseErrorResponse(res.Message).Code != utils.ERROR_CONFLICT {
+		fmt.Println("CloseCustomer succeeded with a held stream:", res.Message)
+		t.FailNow()
+	}
+	_ = utils.ExecInvoke(t, stub, "ReviewFlaggedStream", []string{result.Flagged.Id, transactions.STREAM_REJECTED})
+
+	payload = utils.ExecInvoke(t, stub, "CloseCustomer", []string{utils.TEST_CUSTOMER_ID, transactions.CLOSE_SWEEP})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &tombstone)
+	if tombstone.FinalBalance != 1000 || tombstone.BankAccountId != utils.TEST_CUSTOMER_BA_ID {
+		fmt.Printf("Unexpected customer tombstone: %+v\n", tombstone)
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, utils.BEATCHAIN_ADMIN_BANK_ACCOUNT_ID, 2000)
+	account, _ := utils.GetBankAccount(stub, utils.TEST_CUSTOMER_BA_ID)
+	if account.InUse || account.Balance != 0 {
+		fmt.Printf("Customer BA not released: %+v\n", account)
+		t.FailNow()
+	}
+	if _, err := utils.GetCustomerRecord(stub, utils.TEST_CUSTOMER_ID); err == nil {
+		fmt.Println("Closed customer record still present")
+		t.FailNow()
+	}
+
+	// The released account is reassigned to the next entity
+	var creator utils.CreatorRecord
+	payload = utils.ExecInvoke(t, stub, "AddCreatorRecord", []string{})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &creator)
+	if creator.BankAccountId != utils.TEST_CUSTOMER_BA_ID {
+		fmt.Printf("Released BA not reassigned: %+v\n", creator)
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, utils.TEST_CUSTOMER_BA_ID, 0)
+
+	// Paying out the final balance is subject to the withdrawal limits
+	res = stub.MockInvoke("1", [][]byte{[]byte("CloseCreator"), []byte(utils.TEST_CREATOR_ID), []byte(transactions.CLOSE_PAYOUT)})
+	if utils.ParseErrorResponse(res.Message).Code != utils.ERROR_INVALID_ARGUMENT {
+		fmt.Println("CloseCreator paid out over the withdrawal limit:", res.Message)
+		t.FailNow()
+	}
+	_ = utils.ExecInvoke(t, stub, "SetWithdrawalLimits", []string{"2000", "5000"})
+
+	// Pending withdrawals must be decided first
+	payload = utils.ExecInvoke(t, stub, "RequestWithdrawal", []string{"10"})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &withdrawal)
+	res = stub.MockInvoke("1", [][]byte{[]byte("CloseCreator"), []byte(utils.TEST_CREATOR_ID), []byte(transactions.CLOSE_PAYOUT)})
+	if utils.ParseErrorResponse(res.Message).Code != utils.ERROR_CONFLICT {
+		fmt.Println("CloseCreator succeeded with a pending withdrawal:", res.Message)
+		t.FailNow()
+	}
+	_ = utils.ExecInvoke(t, stub, "RejectWithdrawal", []string{withdrawal.Id, "closing"})
+
+	// Closing the creator settles usage, including the customer's payable stream, pays the guarantee shortfall
+	// and terminates contracts. The final balance is held in a withdrawal awaiting approval.
+	payload = utils.ExecInvoke(t, stub, "CloseCreator", []string{utils.TEST_CREATOR_ID, transactions.CLOSE_PAYOUT})
+	tombstone = utils.Tombstone{}
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &tombstone)
+	if tombstone.TerminatedContracts != 2 || tombstone.ShortfallPaid != 5 || tombstone.FinalBalance != 1005.04 {
+		fmt.Printf("Unexpected creator tombstone: %+v\n", tombstone)
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, utils.TEST_CREATOR_BA_ID, 0)
+	payout, err := utils.GetWithdrawal(stub, tombstone.WithdrawalId)
+	if err != nil || !payout.Closing || payout.Status != transactions.WITHDRAWAL_PENDING || payout.Amount != 1005.04 {
+		fmt.Printf("Unexpected payout withdrawal: %+v %v\n", payout, err)
+		t.FailNow()
+	}
+
+	// A rejected payout goes to the admin account, as the creator's account has been released
+	_ = utils.ExecInvoke(t, stub, "RejectWithdrawal", []string{payout.Id, "unknown payee"})
+	utils.CheckBankAccount(t, stub, utils.TEST_CREATOR_BA_ID, 0)
+	utils.CheckBankAccount(t, stub, utils.BEATCHAIN_ADMIN_BANK_ACCOUNT_ID, 3005.04)
+	utils.CheckBankAccount(t, stub, utils.TEST_APPDEV_BA_ID, 994.96)
+	contract := utils.FetchTestContractRecord(t, stub, utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID, utils.TEST_PRODUCT_ID)
+	if contract.Status != transactions.TERMINATED {
+		fmt.Printf("Contract not terminated: %+v\n", contract)
+		t.FailNow()
+	}
+
+	// A transfer proposal for the AppDev's account must be executed or expire first
+	_ = utils.ExecInvoke(t, stub, "TransferFunds", []string{utils.TEST_APPDEV_BA_ID, "3000"})
+	res = stub.MockInvoke("1", [][]byte{[]byte("CloseAppDev"), []byte(utils.TEST_APPDEV_ID), []byte(transactions.CLOSE_PAYOUT)})
+	if utils.ParseErrorResponse(res.Message).Code != utils.ERROR_CONFLICT {
+		fmt.Println("CloseAppDev succeeded with a pending transfer proposal:", res.Message)
+		t.FailNow()
+	}
+	stub.TimeOffset += time.Duration(utils.DEFAULT_MULTISIG_EXPIRY_HOURS+1) * time.Hour
+
+	// The AppDev's contracts are already terminated, so it can now be closed
+	payload = utils.ExecInvoke(t, stub, "CloseAppDev", []string{utils.TEST_APPDEV_ID, transactions.CLOSE_PAYOUT})
+	tombstone = utils.Tombstone{}
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &tombstone)
+	if tombstone.TerminatedContracts != 0 || tombstone.FinalBalance != 994.96 {
+		fmt.Printf("Unexpected AppDev tombstone: %+v\n", tombstone)
+		t.FailNow()
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	_, stub := beatchain_init(t)
+	cases := []struct {
+		args  []string
+		code  string
+		field string
+	}{
+		{[]string{"NoSuchFunction"}, utils.ERROR_INVALID_ARGUMENT, "function"},
+		{[]string{"RequestWithdrawal", "0"}, utils.ERROR_INVALID_ARGUMENT, "Amount"},
+		{[]string{"ReviewFlaggedStream", "9999", transactions.STREAM_RELEASED}, utils.ERROR_NOT_FOUND, ""},
+		{[]string{"AddSubscription", utils.TEST_CUSTOMER_ID, utils.TEST_APPDEV_ID, "5"}, utils.ERROR_CONFLICT, ""},
+		{[]string{"OpenDispute", utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID, utils.TEST_PRODUCT_ID, "", "5000", "Overclaimed"},
+			utils.ERROR_INVALID_ARGUMENT, "SettlementID"},
+		{[]string{"OfferContract", utils.TEST_APPDEV_ID, utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID, "free"},
+			utils.ERROR_INVALID_ARGUMENT, "CreatorPayPerStream"},
+		{[]string{"CreatePlaylist", "Mix", "[" + utils.TEST_PRODUCT_ID}, utils.ERROR_INVALID_ARGUMENT, "ProductIDs"},
+		{[]string{"EnqueueSongs", "{}"}, utils.ERROR_INVALID_ARGUMENT, "ProductIDs"},
+		{[]string{"BulkImport", utils.PRODUCT_KEY_PREFIX, transactions.BULK_IMPORT_ATOMIC, "{}"}, utils.ERROR_INVALID_ARGUMENT, "Rows"},
+		{[]string{"SetTransferPolicy", "500", "2", "24", "alice"}, utils.ERROR_INVALID_ARGUMENT, "Admins"},
+	}
+
+	for _, c := range cases {
+		res := stub.MockInvoke("1", stringToBytes(c.args))
+		if res.Status == shim.OK {
+			fmt.Printf("%s succeeded\n", c.args[0])
+			t.FailNow()
+		}
+		chaincodeError := utils.ParseErrorResponse(res.Message)
+		if chaincodeError.Code != c.code || (c.field != "" && chaincodeError.Fields[c.field] == "") {
+			fmt.Printf("Unexpected error from %s: %s\n", c.args[0], res.Message)
+			t.FailNow()
+		}
+	}
+}
+
+func TestResponseFormats(t *testing.T) {
+	var accounts []utils.BankAccount
+	var run banking.PaymentRun
+	_, stub := beatchain_init(t)
+
+	// Responses are enveloped, and listings carry their page
+	payload := utils.ExecInvoke(t, stub, "ListBankAccounts", []string{})
+	response, err := utils.UnmarshalResponse([]byte(*payload), &accounts)
+	if err != nil || response.Function != "ListBankAccounts" || response.Page == nil ||
+		response.Page.Total != len(accounts) || len(accounts) < 3 {
+		fmt.Printf("Unexpected listing: %s\n", *payload)
+		t.FailNow()
+	}
+	total := len(accounts)
+	stub.Transient[utils.PAGE_TRANSIENT_KEY] = []byte(`{"offset": 1, "limit": 2}`)
+	payload = utils.ExecInvoke(t, stub, "ListBankAccounts", []string{})
+	accounts = nil
+	response, _ = utils.UnmarshalResponse([]byte(*payload), &accounts)
+	if len(accounts) != 2 || response.Page.Offset != 1 || response.Page.Returned != 2 || response.Page.Total != total {
+		fmt.Printf("Unexpected page: %s\n", *payload)
+		t.FailNow()
+	}
+
+	// Payments are broken down by settlement
+	payload = utils.ExecInvoke(t, stub, "CollectPayment", []string{})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &run)
+	if len(run.Settlements) != 1 || run.Settlements[0].NetAmount != run.TotalPayment || len(run.Shortfalls) != 0 {
+		fmt.Printf("Unexpected payment run: %s\n", *payload)
+		t.FailNow()
+	}
+
+	// Text responses are returned to callers requesting them
+	text := func() {
+		stub.Transient[utils.RESPONSE_FORMAT_TRANSIENT_KEY] = []byte(utils.RESPONSE_FORMAT_TEXT)
+	}
+	text()
+	payload = utils.ExecInvoke(t, stub, "AddProduct", []string{"text product"})
+	if _, err = strconv.Atoi(*payload); err != nil {
+		fmt.Printf("Expected a bare ID: %s\n", *payload)
+		t.FailNow()
+	}
+	text()
+	payload = utils.ExecInvoke(t, stub, "ListBankAccounts", []string{})
+	if !strings.HasPrefix(*payload, "Bank Account ID: ") || strings.Count(*payload, "\n") != total-1 {
+		fmt.Printf("Expected a text listing: %s\n", *payload)
+		t.FailNow()
+	}
+	text()
+	payload = utils.ExecInvoke(t, stub, "CollectPayment", []string{})
+	if *payload != "No payable opportunities found." {
+		fmt.Printf("Expected a text payment run: %s\n", *payload)
+		t.FailNow()
+	}
+
+	// Requests without a format are still answered with the envelope
+	payload = utils.ExecInvoke(t, stub, "AddProduct", []string{"json product"})
+	if _, err = utils.UnmarshalResponse([]byte(*payload), &utils.Product{}); err != nil {
+		fmt.Printf("Expected a response envelope: %s\n", *payload)
+		t.FailNow()
+	}
+	stub.Transient[utils.RESPONSE_FORMAT_TRANSIENT_KEY] = []byte("XML")
+	res := stub.MockInvoke("1", [][]byte{[]byte("ListBankAccounts")})
+	if utils.ParseErrorResponse(res.Message).Code != utils.ERROR_INVALID_ARGUMENT {
+		fmt.Println("Unknown response format accepted", res.Message)
+		t.FailNow()
+	}
+}
+
+func TestAccessControl(t *testing.T) {
+	stub, ids := beatchain_init_identities(t)
+	// Keep the test Customer's subscription active
+	stub.TimeOffset = time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC).Sub(time.Now())
+
+	// Members of the right org and CA are let through
+	_ = utils.ExecInvokeAs(t, stub, ids["admin"], "SetFraudRules", []string{"0", "0", "0", "0"})
+	_ = utils.ExecInvokeAs(t, stub, ids["creatorAdmin"], "AddCreatorRecord", []string{})
+	_ = utils.ExecInvokeAs(t, stub, ids["creator"], "AddProduct", []string{"certified product"})
+	_ = utils.ExecInvokeAs(t, stub, ids["customer"], "RequestSong", []string{utils.TEST_PRODUCT_ID})
+	_ = utils.ExecInvokeAs(t, stub, ids["appDev"], "GetFlaggedStreams", []string{utils.TEST_APPDEV_ID, ""})
+	_ = utils.ExecInvokeAs(t, stub, ids["creator"], "CollectPayment", []string{})
+
+	// Members of other orgs are denied
+	utils.CheckForbidden(t, stub, ids["customer"], "SetFraudRules", []string{"0", "0", "0", "0"})
+	utils.CheckForbidden(t, stub, ids["customer"], "AddProduct", []string{"uncertified product"})
+	utils.CheckForbidden(t, stub, ids["creator"], "RequestSong", []string{utils.TEST_PRODUCT_ID})
+	utils.CheckForbidden(t, stub, ids["appDev"], "CollectPayment", []string{})
+
+	// The org's MSP alone is not enough; the certificate must be issued by the org's CA
+	utils.CheckForbidden(t, stub, ids["impostor"], "AddProduct", []string{"impostor product"})
+	utils.CheckForbidden(t, stub, ids["impostor"], "AddCreatorRecord", []string{})
+
+	// Callers are identified by the attributes of their certificate
+	utils.CheckForbidden(t, stub, ids["creator"], "AddCreatorRecord", []string{})
+	utils.CheckForbidden(t, stub, ids["anonymous"], "AddProduct", []string{"anonymous product"})
+	utils.CheckForbidden(t, stub, ids["otherAppDev"], "GetFlaggedStreams", []string{utils.TEST_APPDEV_ID, ""})
+	utils.CheckForbidden(t, stub, ids["creator"], "CloseCreator", []string{"9999", transactions.CLOSE_PAYOUT})
+
+	// Only the Customer can subscribe themselves through another AppDev
+	var appDev utils.AppDevRecord
+	payload := utils.ExecInvokeAs(t, stub, ids["appDevAdmin"], "AddAppDevRecord", []string{"0.1"})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &appDev)
+	utils.CheckForbidden(t, stub, ids["appDev"], "AddSubscription", []string{utils.TEST_CUSTOMER_ID, appDev.Id, "0"})
+	utils.CheckForbidden(t, stub, ids["admin"], "AddSubscription", []string{utils.TEST_CUSTOMER_ID, appDev.Id, "0"})
+	_ = utils.ExecInvokeAs(t, stub, ids["customer"], "AddSubscription", []string{utils.TEST_CUSTOMER_ID, appDev.Id, "5"})
+}
+
+func TestStateCache(t *testing.T) {
+	_, stub := beatchain_init(t)
+
+	// Writes are held until flushed, but seen by later reads within the transaction
+	stub.MockTransactionStart("cache")
+	cache := utils.NewStateCache(stub)
+	bankAccount, err := utils.GetBankAccount(cache, utils.TEST_APPDEV_BA_ID)
+	if err != nil {
+		fmt.Println("Cannot read BankAccount:", err.Error())
+		t.FailNow()
+	}
+	bankAccount.Balance -= 10
+	err = utils.SetBankAccount(cache, bankAccount)
+	if err != nil {
+		fmt.Println("Cannot set BankAccount:", err.Error())
+		t.FailNow()
+	}
+	utils.CheckBankAccount(t, stub, utils.TEST_APPDEV_BA_ID, 1000)
+	// Cached records are copies, so changes are only seen once the record is set
+	bankAccount.Balance = 0
+	reread, err := utils.GetBankAccount(cache, utils.TEST_APPDEV_BA_ID)
+	if err != nil || reread == bankAccount || reread.Balance != 990 {
+		fmt.Println("Re-read BankAccount was not a copy of the one set:", err)
+		t.FailNow()
+	}
+	reread.Balance = 0
+	reread, _ = utils.GetBankAccount(cache, utils.TEST_APPDEV_BA_ID)
+	if reread.Balance != 990 {
+		fmt.Println("Change to a BankAccount which was not set seen by a later read")
+		t.FailNow()
+	}
+	err = cache.Flush()
+	if err != nil {
+		fmt.Println("Cannot flush StateCache:", err.Error())
+		t.FailNow()
+	}
+	stub.MockTransactionEnd("cache")
+	utils.CheckBankAccount(t, stub, utils.TEST_APPDEV_BA_ID, 990)
+
+	// Deleted private data reads as missing within the transaction and is deleted on flush
+	stub.PrivateState[utils.CONTRACT_TERMS_COLLECTION] = map[string][]byte{"terms": []byte("{}")}
+	stub.MockTransactionStart("private")
+	cache = utils.NewStateCache(stub)
+	err = cache.DelPrivateData(utils.CONTRACT_TERMS_COLLECTION, "terms")
+	value, _ := cache.GetPrivateData(utils.CONTRACT_TERMS_COLLECTION, "terms")
+	if err != nil || value != nil || len(stub.PrivateState[utils.CONTRACT_TERMS_COLLECTION]) != 1 {
+		fmt.Println("Private data deleted before flushing, or still read:", err)
+		t.FailNow()
+	}
+	err = cache.Flush()
+	stub.MockTransactionEnd("private")
+	if _, ok := stub.PrivateState[utils.CONTRACT_TERMS_COLLECTION]["terms"]; err != nil || ok {
+		fmt.Println("Private data not deleted on flush:", err)
+		t.FailNow()
+	}
+
+	// Private partial key queries reflect the transaction's private writes
+	stub.PrivateState[utils.CONTRACT_TERMS_COLLECTION] = map[string][]byte{"\x00Terms\x00a\x00": []byte("{}")}
+	stub.MockTransactionStart("privateQuery")
+	cache = utils.NewStateCache(stub)
+	err = cache.DelPrivateData(utils.CONTRACT_TERMS_COLLECTION, "\x00Terms\x00a\x00")
+	if err == nil {
+		err = cache.PutPrivateData(utils.CONTRACT_TERMS_COLLECTION, "\x00Terms\x00b\x00", []byte("{}"))
+	}
+	iterator, err := cache.GetPrivateDataByPartialCompositeKey(utils.CONTRACT_TERMS_COLLECTION, "Terms", []string{})
+	if err != nil {
+		fmt.Println("Cannot query private data:", err.Error())
+		t.FailNow()
+	}
+	result, err := iterator.Next()
+	if err != nil || result.Key != "\x00Terms\x00b\x00" || iterator.HasNext() {
+		fmt.Println("Private partial key query ignores the transaction's writes:", result, err)
+		t.FailNow()
+	}
+	stub.MockTransactionEnd("privateQuery")
+
+	// Settling two contracts with the same AppDev debits the AppDev for both
+	stub, ids := beatchain_init_identities(t)
+	stub.TimeOffset = time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC).Sub(time.Now())
+	var product utils.Product
+	payload := utils.ExecInvokeAs(t, stub, ids["creator"], "AddProduct", []string{"second product"})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &product)
+	// Outside test mode terms are only accepted as transient data, with the seed of their salt
+	offerArgs := []string{"OfferContract", utils.TEST_APPDEV_ID, utils.TEST_CREATOR_ID, product.Id}
+	res := stub.MockInvokeAs("1", ids["appDev"], stringToBytes(append(offerArgs, "0.05")))
+	if utils.ParseErrorResponse(res.Message).Fields[utils.CONTRACT_TERMS_TRANSIENT_KEY] == "" {
+		fmt.Println("Positional contract terms accepted outside test mode:", res.Message)
+		t.FailNow()
+	}
+	stub.Transient[utils.CONTRACT_TERMS_TRANSIENT_KEY] = []byte(`{"creatorpayperstream": 0.05}`)
+	res = stub.MockInvokeAs("1", ids["appDev"], stringToBytes(offerArgs))
+	if utils.ParseErrorResponse(res.Message).Fields[utils.CONTRACT_SALT_TRANSIENT_KEY] == "" {
+		fmt.Println("Contract terms accepted without a salt:", res.Message)
+		t.FailNow()
+	}
+	offerTerms(stub, `{"creatorpayperstream": 0.05}`)
+	_ = utils.ExecInvokeAs(t, stub, ids["appDev"], "OfferContract", offerArgs[1:])
+	_ = utils.ExecInvokeAs(t, stub, ids["creator"], "AcceptContract", []string{utils.TEST_CREATOR_ID, product.Id, utils.TEST_APPDEV_ID})
+	_ = utils.ExecInvokeAs(t, stub, ids["customer"], "RequestSong", []string{product.Id})
+	_ = utils.ExecInvokeAs(t, stub, ids["creator"], "CollectPayment", []string{})
+	// 3 earlier streams of the test product at $0.01 and 1 of the second at $0.05
+	utils.CheckBankAccount(t, stub, utils.TEST_APPDEV_BA_ID, 999.92)
+	utils.CheckBankAccount(t, stub, utils.TEST_CREATOR_BA_ID, 1000.08)
+}
+
+func TestRepository(t *testing.T) {
+	_, stub := beatchain_init(t)
+
+	// Missing records are reported alike whatever their type
+	exists, err := utils.RecordExists(stub, utils.PRODUCT_KEY_PREFIX, utils.TEST_PRODUCT_ID)
+	if err != nil || !exists {
+		fmt.Println("Test product not found by RecordExists")
+		t.FailNow()
+	}
+	for _, recordType := range utils.VersionedRecordTypes {
+		_, err = utils.GetRecord(stub, recordType, "0000")
+		chaincodeError, ok := err.(*utils.ChaincodeError)
+		if !ok || chaincodeError.Code != utils.ERROR_NOT_FOUND ||
+			chaincodeError.Message != "No record found for "+recordType+".ID 0000" {
+			fmt.Printf("Unexpected error getting missing %s: %v\n", recordType, err)
+			t.FailNow()
+		}
+	}
+	contracts, err := utils.ListRecords(stub, utils.CONTRACT_KEY_PREFIX, utils.TEST_CREATOR_ID)
+	if err != nil || len(contracts) != 1 || contracts[0].(*utils.Contract).ProductId != utils.TEST_PRODUCT_ID {
+		fmt.Printf("Unexpected contracts of test Creator: %v %v\n", contracts, err)
+		t.FailNow()
+	}
+
+	// Declared index entries follow the record as it is put and deleted
+	stub.MockTransactionStart("index")
+	cache := utils.NewStateCache(stub)
+	customer, err := utils.GetCustomerRecord(cache, utils.TEST_CUSTOMER_ID)
+	if err != nil {
+		t.FailNow()
+	}
+	customer.Subscriptions["5555"] = &utils.Subscription{AppDevId: "5555"}
+	delete(customer.Subscriptions, utils.TEST_APPDEV_ID)
+	err = utils.PutRecord(cache, customer)
+	if err != nil || cache.Flush() != nil {
+		fmt.Println("Cannot put CustomerRecord:", err)
+		t.FailNow()
+	}
+	stub.MockTransactionEnd("index")
+	for appDevId, count := range map[string]int{utils.TEST_APPDEV_ID: 0, "5555": 1} {
+		customers, err := utils.GetAppDevCustomers(stub, appDevId)
+		if err != nil || len(customers) != count {
+			fmt.Printf("Expected %d customers indexed under AppDev %s. Found %d %v\n", count, appDevId, len(customers), err)
+			t.FailNow()
+		}
+	}
+
+	// Listings within a transaction reflect the records it has put and deleted
+	stub.MockTransactionStart("list")
+	cache = utils.NewStateCache(stub)
+	contract, err := utils.GetContract(cache, utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID, utils.TEST_PRODUCT_ID)
+	if err != nil || utils.DeleteRecord(cache, contract) != nil {
+		fmt.Println("Cannot delete Contract:", err)
+		t.FailNow()
+	}
+	err = utils.PutRecord(cache, &utils.CustomerRecord{Id: "7777", Subscriptions: map[string]*utils.Subscription{"5555": {AppDevId: "5555"}}})
+	if err != nil {
+		fmt.Println("Cannot put CustomerRecord:", err)
+		t.FailNow()
+	}
+	contracts, _ = utils.ListRecords(cache, utils.CONTRACT_KEY_PREFIX, utils.TEST_CREATOR_ID)
+	listed, _ := utils.ListRecords(cache, utils.CUSTOMER_RECORD_KEY_PREFIX)
+	indexed, _ := utils.ListIndexedRecords(cache, utils.CUSTOMER_RECORD_KEY_PREFIX, utils.SUBSCRIPTION_KEY_PREFIX, "5555")
+	if len(contracts) != 0 || len(listed) != 2 || len(indexed) != 2 || indexed[1].(*utils.CustomerRecord).Id != "7777" {
+		fmt.Printf("Listings ignore the transaction's writes: %v %v %v\n", contracts, listed, indexed)
+		t.FailNow()
+	}
+	stub.MockTransactionEnd("list")
+
+	stub.MockTransactionStart("delete")
+	err = utils.DeleteRecord(stub, customer)
+	stub.MockTransactionEnd("delete")
+	customers, _ := utils.GetAppDevCustomers(stub, "5555")
+	exists, _ = utils.RecordExists(stub, utils.CUSTOMER_RECORD_KEY_PREFIX, utils.TEST_CUSTOMER_ID)
+	if err != nil || len(customers) != 0 || exists {
+		fmt.Println("Deleted CustomerRecord or its index entries remain:", err)
+		t.FailNow()
+	}
+
+	// Creators are paid into their own bank account, whose ID is not the Creator's
+	stub, ids := beatchain_init_identities(t)
+	stub.TimeOffset = time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC).Sub(time.Now())
+	var creatorRecord utils.CreatorRecord
+	var product utils.Product
+	payload := utils.ExecInvokeAs(t, stub, ids["creatorAdmin"], "AddCreatorRecord", []string{})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &creatorRecord)
+	if creatorRecord.BankAccountId == creatorRecord.Id {
+		fmt.Println("New Creator's bank account shares its ID")
+		t.FailNow()
+	}
+	ca, err := utils.NewTestCA(utils.CREATOR_MSP, utils.CREATOR_CA)
+	if err != nil {
+		t.FailNow()
+	}
+	newCreator, err := ca.Enroll("newCreator", map[string]string{"id": creatorRecord.Id, "role": "client"})
+	if err != nil {
+		t.FailNow()
+	}
+	payload = utils.ExecInvokeAs(t, stub, newCreator, "AddProduct", []string{"new creator product"})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &product)
+	offerTerms(stub, `{"creatorpayperstream": 0.05}`)
+	_ = utils.ExecInvokeAs(t, stub, ids["appDev"], "OfferContract", []string{utils.TEST_APPDEV_ID, creatorRecord.Id, product.Id})
+	_ = utils.ExecInvokeAs(t, stub, newCreator, "AcceptContract", []string{creatorRecord.Id, product.Id, utils.TEST_APPDEV_ID})
+	_ = utils.ExecInvokeAs(t, stub, ids["customer"], "RequestSong", []string{product.Id})
+	_ = utils.ExecInvokeAs(t, stub, newCreator, "CollectPayment", []string{})
+	utils.CheckBankAccount(t, stub, creatorRecord.BankAccountId, 0.05)
+}
+
+func TestSecondaryIndexes(t *testing.T) {
+	stub, ids := beatchain_init_identities(t)
+	listed := func(function string, id string) int {
+		var records []map[string]interface{}
+		payload := utils.ExecInvokeAs(t, stub, ids["admin"], function, []string{id})
+		_, err := utils.UnmarshalResponse([]byte(*payload), &records)
+		if err != nil {
+			t.FailNow()
+		}
+		return len(records)
+	}
+
+	// Index entries are written along with the records
+	_ = utils.ExecInvokeAs(t, stub, ids["creator"], "AddProduct", []string{"indexed product"})
+	if count := listed("ListCreatorProducts", utils.TEST_CREATOR_ID); count != 2 {
+		fmt.Printf("Expected 2 products of the test Creator. Found %d\n", count)
+		t.FailNow()
+	}
+	if listed("ListAppDevContracts", utils.TEST_APPDEV_ID) != 1 || listed("ListProductContracts", utils.TEST_PRODUCT_ID) != 1 {
+		fmt.Println("Test contract not indexed by AppDev and product")
+		t.FailNow()
+	}
+
+	// Records stored before their index existed are indexed by RebuildIndexes, and stale entries removed
+	stub.MockTransactionStart("legacy")
+	for _, entry := range [][]string{
+		{utils.CREATOR_PRODUCT_KEY_PREFIX, utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID},
+		{utils.APPDEV_CONTRACT_KEY_PREFIX, utils.TEST_APPDEV_ID, utils.TEST_CREATOR_ID, utils.TEST_APPDEV_ID, utils.TEST_PRODUCT_ID}} {
+		key, _ := stub.CreateCompositeKey(utils.KEY_OBJECT_FORMAT, entry)
+		_ = stub.DelState(key)
+	}
+	staleKey, _ := stub.CreateCompositeKey(utils.KEY_OBJECT_FORMAT, []string{utils.PRODUCT_CONTRACT_KEY_PREFIX, "0000", "0000", "0000", "0000"})
+	_ = stub.PutState(staleKey, []byte{0x00})
+	stub.MockTransactionEnd("legacy")
+	if listed("ListCreatorProducts", utils.TEST_CREATOR_ID) != 1 || listed("ListAppDevContracts", utils.TEST_APPDEV_ID) != 0 {
+		fmt.Println("Removed index entries still listed")
+		t.FailNow()
+	}
+
+	var rebuild utils.IndexRebuild
+	payload := utils.ExecInvokeAs(t, stub, ids["admin"], "RebuildIndexes", []string{})
+	_, _ = utils.UnmarshalResponse([]byte(*payload), &rebuild)
+	if rebuild.Entries[utils.CREATOR_PRODUCT_KEY_PREFIX] != 2 || rebuild.Entries[utils.APPDEV_CONTRACT_KEY_PREFIX] != 1 ||
+		rebuild.Entries[utils.PRODUCT_CONTRACT_KEY_PREFIX] != 1 || rebuild.Entries[utils.SUBSCRIPTION_KEY_PREFIX] != 1 {
+		fmt.Printf("Unexpected index entries rebuilt: %+v\n", rebuild)
+		t.FailNow()
+	}
+	if listed("ListCreatorProducts", utils.TEST_CREATOR_ID) != 2 || listed("ListAppDevContracts", utils.TEST_APPDEV_ID) != 1 {
+		fmt.Println("Rebuilt index entries not listed")
+		t.FailNow()
+	}
+	exists, _ := stub.GetState(staleKey)
+	if len(exists) != 0 {
+		fmt.Println("Stale index entry survived RebuildIndexes")
+		t.FailNow()
+	}
+
+	// Contracts are listed only to their parties
+	_ = utils.ExecInvokeAs(t, stub, ids["appDev"], "ListAppDevContracts", []string{utils.TEST_APPDEV_ID})
+	_ = utils.ExecInvokeAs(t, stub, ids["creator"], "ListProductContracts", []string{utils.TEST_PRODUCT_ID})
+	utils.CheckForbidden(t, stub, ids["otherAppDev"], "ListAppDevContracts", []string{utils.TEST_APPDEV_ID})
+	utils.CheckForbidden(t, stub, ids["customer"], "ListProductContracts", []string{utils.TEST_PRODUCT_ID})
+	utils.CheckForbidden(t, stub, ids["creator"], "RebuildIndexes", []string{})
+}
+
+func TestClient(t *testing.T) {
+	stub, ids := beatchain_init_identities(t)
+	requestTestContract(t, stub)
+	ctx := context.Background()
+	as := func(identity string, id string) *client.Client {
+		return client.New(client.NewMockTransport(stub, ids[identity]), id)
+	}
+	admin := as("admin", "")
+	appDev := as("appDev", utils.TEST_APPDEV_ID)
+	creator := as("creator", utils.TEST_CREATOR_ID)
+	customer := as("customer", utils.TEST_CUSTOMER_ID)
+
+	// Responses are decoded into the utils structs
+	payment, err := customer.RenewSubscription(ctx)
+	if err != nil || payment.CustomerId != utils.TEST_CUSTOMER_ID || payment.AppDevId != utils.TEST_APPDEV_ID {
+		fmt.Printf("Unexpected subscription payment %+v: %v\n", payment, err)
+		t.FailNow()
+	}
+	contract, err := appDev.OfferContract(ctx, utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID, 0.02)
+	if err != nil || contract.Status != transactions.REQUESTED || contract.AppDevId != utils.TEST_APPDEV_ID {
+		fmt.Printf("Unexpected contract offered %+v: %v\n", contract, err)
+		t.FailNow()
+	}
+	contract, err = creator.AcceptContract(ctx, utils.TEST_PRODUCT_ID, utils.TEST_APPDEV_ID)
+	if err != nil || contract.Status != transactions.ACCEPTED {
+		fmt.Printf("Unexpected contract accepted %+v: %v\n", contract, err)
+		t.FailNow()
+	}
+	standing, err := appDev.GetContractTerms(ctx, utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID, utils.TEST_APPDEV_ID)
+	if err != nil || standing.CreatorPayPerStream != 0.02 || standing.Status != transactions.ACCEPTED {
+		fmt.Printf("Unexpected contract terms %+v: %v\n", standing, err)
+		t.FailNow()
+	}
+	run, err := creator.CollectPayment(ctx)
+	if err != nil || len(run.Settlements) != 1 || run.TotalPayment != 0.06 {
+		fmt.Printf("Unexpected payment run %+v: %v\n", run, err)
+		t.FailNow()
+	}
+	_, err = creator.AddProduct(ctx, "client product")
+	if err != nil {
+		t.FailNow()
+	}
+	products, page, err := creator.ListCreatorProducts(ctx, utils.TEST_CREATOR_ID, &utils.Page{Offset: 1, Limit: 1})
+	if err != nil || len(products) != 1 || page.Total != 2 || page.Returned != 1 {
+		fmt.Printf("Unexpected page %+v of products: %v\n", page, err)
+		t.FailNow()
+	}
+	product, err := creator.DeleteProduct(ctx, products[0].Id)
+	if err != nil || product.IsActive {
+		fmt.Printf("Product %+v not deactivated: %v\n", product, err)
+		t.FailNow()
+	}
+	bankAccount, proposal, err := admin.TransferFunds(ctx, utils.TEST_CUSTOMER_BA_ID, 5)
+	if err != nil || proposal != nil || bankAccount.Id != utils.TEST_CUSTOMER_BA_ID {
+		fmt.Printf("Unexpected transfer %+v, %+v: %v\n", bankAccount, proposal, err)
+		t.FailNow()
+	}
+
+	// Failures carry the chaincode's error code
+	_, err = customer.CollectPayment(ctx)
+	if utils.ErrorCode(err) != utils.ERROR_FORBIDDEN {
+		fmt.Println("CollectPayment as a Customer not denied:", err)
+		t.FailNow()
+	}
+	_, err = appDev.GetContractTerms(ctx, utils.TEST_CREATOR_ID, "0000", utils.TEST_APPDEV_ID)
+	if utils.ErrorCode(err) != utils.ERROR_NOT_FOUND {
+		fmt.Println("Terms of a missing contract found:", err)
+		t.FailNow()
+	}
+
+	// Queries are answered without committing their writes
+	before := utils.FetchTestBankAccount(t, stub, utils.TEST_CUSTOMER_BA_ID).Balance
+	transport := client.NewMockTransport(stub, ids["admin"])
+	_, err = transport.Query(ctx, &client.Call{Function: "TransferFunds", Args: []string{utils.TEST_CUSTOMER_BA_ID, "10"}})
+	if err != nil || utils.FetchTestBankAccount(t, stub, utils.TEST_CUSTOMER_BA_ID).Balance != before {
+		fmt.Println("Queried transfer was committed:", err)
+		t.FailNow()
+	}
+
+	// TEXT responses are only returned on request, and cannot be decoded
+	payload, err := client.NewMockTransport(stub, ids["customer"]).Invoke(ctx, &client.Call{Function: "RenewSubscription",
+		Transient: map[string][]byte{utils.RESPONSE_FORMAT_TRANSIENT_KEY: []byte(utils.RESPONSE_FORMAT_TEXT)}})
+	if err != nil || string(payload) != "SUCCESS" {
+		fmt.Printf("Unexpected TEXT response %s: %v\n", payload, err)
+		t.FailNow()
+	}
+	_, err = customer.RenewSubscription(ctx)
+	if err != nil {
+		fmt.Println("JSON response not decoded:", err)
+		t.FailNow()
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = customer.RenewSubscription(cancelled)
+	if err != context.Canceled {
+		fmt.Println("Call made after its context was cancelled:", err)
+		t.FailNow()
+	}
+}
+
+type ledgerSnapshot struct {
+	/*
+		Captures the ledger state that the invariants of a transaction sequence are checked on
+	*/
+	Balances map[string]float32       // BankAccount ID -> balance
+	Held     float64                  // Held out of balances by pending withdrawals and open disputes
+	PaidOut  float64                  // Withdrawn off-chain by approved withdrawals
+	Products map[string]*utils.Product // Product ID -> product
+	UniqueId int64
+}
+
+func (snapshot *ledgerSnapshot) total() float64 {
+	total := snapshot.Held + snapshot.PaidOut
+	for _, balance := range snapshot.Balances {
+		total += float64(balance)
+	}
+	return total
+}
+
+func scanLedger(stub *utils.TestStub, prefix string, decode func(recordBytes []byte) error) error {
+	keysIterator, err := stub.GetStateByPartialCompositeKey(utils.KEY_OBJECT_FORMAT, []string{prefix})
+	if err != nil {
+		return err
+	}
+	defer keysIterator.Close()
+	for keysIterator.HasNext() {
+		result, err := keysIterator.Next()
+		if err != nil {
+			return err
+		}
+		err = decode(result.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func takeLedgerSnapshot(stub *utils.TestStub) (*ledgerSnapshot, error) {
+	snapshot := &ledgerSnapshot{Balances: make(map[string]float32), Products: make(map[string]*utils.Product)}
+
+	err := scanLedger(stub, utils.BANK_ACCOUNT_KEY_PREFIX, func(recordBytes []byte) error {
+		var bankAccount utils.BankAccount
+		err := json.Unmarshal(recordBytes, &bankAccount)
+		snapshot.Balances[bankAccount.Id] = bankAccount.Balance
+		return err
+	})
+	if err == nil {
+		err = scanLedger(stub, utils.WITHDRAWAL_KEY_PREFIX, func(recordBytes []byte) error {
+			var withdrawal utils.Withdrawal
+			err := json.Unmarshal(recordBytes, &withdrawal)
+			if withdrawal.Status == transactions.WITHDRAWAL_PENDING {
+				snapshot.Held += float64(withdrawal.Amount)
+			} else if withdrawal.Status == transactions.WITHDRAWAL_APPROVED {
+				snapshot.PaidOut += float64(withdrawal.Amount)
+			}
+			return err
+		})
+	}
+	if err == nil {
+		err = scanLedger(stub, utils.DISPUTE_KEY_PREFIX, func(recordBytes []byte) error {
+			var dispute utils.Dispute
+			err := json.Unmarshal(recordBytes, &dispute)
+			if dispute.Status == transactions.DISPUTE_OPEN {
+				snapshot.Held += float64(dispute.Amount)
+			}
+			return err
+		})
+	}
+	if err == nil {
+		err = scanLedger(stub, utils.PRODUCT_KEY_PREFIX, func(recordBytes []byte) error {
+			var product utils.Product
+			err := json.Unmarshal(recordBytes, &product)
+			snapshot.Products[product.Id] = &product
+			return err
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	uniqueIdBytes, err := stub.GetState(utils.UNIQUE_ID_KEY)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.UniqueId, err = strconv.ParseInt(string(uniqueIdBytes), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func checkLedgerInvariants(before *ledgerSnapshot, after *ledgerSnapshot, transferred float64) error {
+	/*
+		Checks the invariants every transaction must keep: money is only added or removed
+		by TransferFunds, no balance is negative and counters never decrease
+	*/
+	change := after.total() - before.total()
+	if math.Abs(change-transferred) >= 0.005 {
+		return fmt.Errorf("money not conserved: ledger total changed by %.4f but %.2f was transferred", change, transferred)
+	}
+	for id, balance := range after.Balances {
+		if balance < 0 {
+			return fmt.Errorf("BankAccount %s has a negative balance of %.2f", id, balance)
+		}
+	}
+	if after.UniqueId < before.UniqueId {
+		return fmt.Errorf("unique ID counter decreased from %d to %d", before.UniqueId, after.UniqueId)
+	}
+	for id, product := range before.Products {
+		current, found := after.Products[id]
+		if !found {
+			return fmt.Errorf("Product %s was removed", id)
+		}
+		if current.TotalListens < product.TotalListens || current.TotalMetrics < product.TotalMetrics ||
+			current.TotalListens+current.UnRenumeratedListens < product.TotalListens+product.UnRenumeratedListens {
+			return fmt.Errorf("listen or metric counters of Product %s decreased", id)
+		}
+	}
+	return nil
+}
+
+type sequenceStep struct {
+	/*
+		A randomly generated transaction. Its parties are picked when the step is replayed
+		from those onboarded by earlier steps, so that every subsequence of a sequence can
+		be replayed too.
+	*/
+	Action string
+	Picks  [3]int
+	Amount float64 // Fraction of the largest amount the action uses
+}
+
+type sequenceParty struct {
+	Id            string
+	BankAccountId string
+	Owner         string // AppDev of a Customer or Creator of a Product
+}
+
+var sequenceActions = []string{
+	"deposit", "deposit", "deposit",
+	"appdev", "customer", "customer", "creator", "product", "product",
+	"offer", "offer", "accept", "accept",
+	"renew", "renew", "stream", "stream", "stream", "stream", "stream",
+	"engage", "engage", "collect", "collect",
+}
+
+func randomSequence(rng *rand.Rand, length int) []sequenceStep {
+	steps := make([]sequenceStep, length)
+	for i := range steps {
+		steps[i] = sequenceStep{
+			Action: sequenceActions[rng.Intn(len(sequenceActions))],
+			Picks: [3]int{rng.Intn(1000), rng.Intn(1000), rng.Intn(1000)},
+			Amount: rng.Float64()}
+	}
+	return steps
+}
+
+func pickSteps(steps []sequenceStep, indices []int) []sequenceStep {
+	picked := make([]sequenceStep, len(indices))
+	for i, index := range indices {
+		picked[i] = steps[index]
+	}
+	return picked
+}
+
+func replaySequence(t *testing.T, steps []sequenceStep) ([]string, error) {
+	/*
+		Replays a sequence of steps on a fresh ledger, checking the ledger invariants after
+		each step. Steps whose parties have yet to be onboarded are skipped.
+
+		Returns:
+			trace: Description of each step replayed and its outcome
+			err: The first invariant broken. nil if all invariants held.
+	*/
+	var appDevs, customers, creators, products []sequenceParty
+	var offers [][3]string // {AppDevID, CreatorID, ProductID}
+	var trace []string
+
+	stub, ids := beatchain_init_identities(t)
+	cas := make(map[string]*utils.TestCA)
+	enrolled := make(map[string]*utils.TestIdentity)
+	member := func(mspId string, ca string, id string) *utils.TestIdentity {
+		if enrolled[mspId+id] == nil {
+			if cas[mspId] == nil {
+				cas[mspId], _ = utils.NewTestCA(mspId, ca)
+			}
+			enrolled[mspId+id], _ = cas[mspId].Enroll(id, map[string]string{"id": id, "role": "client"})
+		}
+		return enrolled[mspId+id]
+	}
+	appDevs = []sequenceParty{{Id: utils.TEST_APPDEV_ID, BankAccountId: utils.TEST_APPDEV_BA_ID}}
+	customers = []sequenceParty{{Id: utils.TEST_CUSTOMER_ID, BankAccountId: utils.TEST_CUSTOMER_BA_ID, Owner: utils.TEST_APPDEV_ID}}
+	creators = []sequenceParty{{Id: utils.TEST_CREATOR_ID, BankAccountId: utils.TEST_CREATOR_BA_ID}}
+	products = []sequenceParty{{Id: utils.TEST_PRODUCT_ID, Owner: utils.TEST_CREATOR_ID}}
+	offers = [][3]string{{utils.TEST_APPDEV_ID, utils.TEST_CREATOR_ID, utils.TEST_PRODUCT_ID}}
+
+	// Lift the fraud rules so that repeated streams are payable
+	_ = utils.ExecInvokeAs(t, stub, ids["admin"], "SetFraudRules", []string{"0", "0", "0", "0"})
+	before, err := takeLedgerSnapshot(stub)
+	if err != nil {
+		return trace, err
+	}
+
+	for i, step := range steps {
+		var caller *utils.TestIdentity
+		var function string
+		var args []string
+		var appDev, customer, creator, product sequenceParty
+
+		if len(appDevs) > 0 {
+			appDev = appDevs[step.Picks[0]%len(appDevs)]
+		}
+		if len(customers) > 0 {
+			customer = customers[step.Picks[0]%len(customers)]
+		}
+		if len(creators) > 0 {
+			creator = creators[step.Picks[0]%len(creators)]
+		}
+		if len(products) > 0 {
+			product = products[step.Picks[1]%len(products)]
+		}
+
+		switch step.Action {
+		case "deposit":
+			parties := append(append(append([]sequenceParty{}, appDevs...), customers...), creators...)
+			caller, function = ids["admin"], "TransferFunds"
+			args = []string{parties[step.Picks[0]%len(parties)].BankAccountId, fmt.Sprintf("%.2f", 1+step.Amount*499)}
+		case "appdev":
+			caller, function = ids["appDevAdmin"], "AddAppDevRecord"
+			args = []string{fmt.Sprintf("%.2f", step.Amount/2)}
+		case "customer":
+			caller, function = member(utils.APPDEV_MSP, utils.APPDEV_CA, appDev.Id), "AddCustomerRecord"
+			args = []string{fmt.Sprintf("%.2f", 0.5+step.Amount*4.5)}
+		case "creator":
+			caller, function = ids["creatorAdmin"], "AddCreatorRecord"
+			args = []string{}
+		case "product":
+			caller, function = member(utils.CREATOR_MSP, utils.CREATOR_CA, creator.Id), "AddProduct"
+			args = []string{fmt.Sprintf("product %d", i)}
+		case "offer":
+			// Half of the offers carry an advance
+			advance := 0.0
+			if step.Picks[2]%2 == 0 {
+				advance = step.Amount * 50
+			}
+			caller, function = member(utils.APPDEV_MSP, utils.APPDEV_CA, appDev.Id), "OfferContract"
+			args = []string{appDev.Id, product.Owner, product.Id, fmt.Sprintf("%.2f", 0.01+step.Amount/10),
+				fmt.Sprintf("%.2f", advance)}
+		case "accept":
+			offer := offers[step.Picks[0]%len(offers)]
+			caller, function = member(utils.CREATOR_MSP, utils.CREATOR_CA, offer[1]), "AcceptContract"
+			args = []string{offer[1], offer[2], offer[0]}
+		case "renew":
+			caller, function = member(utils.CUSTOMER_MSP, utils.CUSTOMER_CA, customer.Id), "RenewSubscription"
+			args = []string{}
+		case "stream":
+			caller, function = member(utils.CUSTOMER_MSP, utils.CUSTOMER_CA, customer.Id), "RequestSong"
+			args = []string{product.Id}
+		case "engage":
+			caller, function = member(utils.CUSTOMER_MSP, utils.CUSTOMER_CA, customer.Id), "RecordEngagement"
+			args = []string{product.Id, transactions.METRIC_TYPES[step.Picks[2]%len(transactions.METRIC_TYPES)]}
+		case "collect":
+			caller, function = member(utils.CREATOR_MSP, utils.CREATOR_CA, creator.Id), "CollectPayment"
+			args = []string{}
+		}
+
+		res := stub.MockInvokeAs("1", caller, stringToBytes(append([]string{function}, args...)))
+		outcome := "OK"
+		transferred := 0.0
+		if res.Status != shim.OK {
+			outcome = utils.ParseErrorResponse(res.Message).Code
+		} else {
+			var record sequenceParty
+			_, err = utils.UnmarshalResponse(res.Payload, &struct {
+				Id            *string `json:"id"`
+				BankAccountId *string `json:"bankaccountid"`
+			}{&record.Id, &record.BankAccountId})
+			if err != nil {
+				return trace, err
+			}
+			switch step.Action {
+			case "deposit":
+				transferred, _ = strconv.ParseFloat(args[1], 64)
+			case "appdev":
+				appDevs = append(appDevs, record)
+			case "customer":
+				customers = append(customers, sequenceParty{Id: record.Id, BankAccountId: record.BankAccountId, Owner: appDev.Id})
+			case "creator":
+				creators = append(creators, record)
+			case "product":
+				products = append(products, sequenceParty{Id: record.Id, Owner: creator.Id})
+			case "offer":
+				offers = append(offers, [3]string{appDev.Id, product.Owner, product.Id})
+			}
+		}
+		trace = append(trace, fmt.Sprintf("%d: %s %v as %s -> %s", i, function, args, caller.Name, outcome))
+
+		after, err := takeLedgerSnapshot(stub)
+		if err != nil {
+			return trace, err
+		}
+		err = checkLedgerInvariants(before, after, transferred)
+		if err != nil {
+			return trace, err
+		}
+		before = after
+	}
+	return trace, nil
+}
+
+func TestLedgerInvariants(t *testing.T) {
+	/*
+		Replays random sequences of onboarding, deposits, renewals, streams, contracts and
+		settlements, shrinking any sequence which breaks a ledger invariant to a minimal case
+	*/
+	for seed := int64(1); seed <= 20; seed++ {
+		steps := randomSequence(rand.New(rand.NewSource(seed)), 40)
+		_, err := replaySequence(t, steps)
+		if err == nil {
+			continue
+		}
+
+		minimal := pickSteps(steps, utils.ShrinkSequence(len(steps), func(indices []int) bool {
+			_, err := replaySequence(t, pickSteps(steps, indices))
+			return err != nil
+		}))
+		trace, err := replaySequence(t, minimal)
+		fmt.Printf("Sequence with seed %d breaks a ledger invariant: %v\nMinimal sequence:\n%s\n",
+			seed, err, strings.Join(trace, "\n"))
+		t.FailNow()
+	}
+}
+
+func TestShrinkSequence(t *testing.T) {
+	// A sequence failing only when steps 3 and 7 are both replayed shrinks to those steps
+	minimal := utils.ShrinkSequence(20, func(steps []int) bool {
+		found := 0
+		for _, step := range steps {
+			if step == 3 || step == 7 {
+				found += 1
+			}
+		}
+		return found == 2
+	})
+	if len(minimal) != 2 || minimal[0] != 3 || minimal[1] != 7 {
+		fmt.Println("Sequence shrunk to", minimal, "and not [3 7]")
+		t.FailNow()
+	}
+}
+
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beatchain/transactions"
+	"github.com/beatchain/utils"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+type GenesisBankAccount struct {
+	Id      string  `json:"id"`
+	Balance float32 `json:"balance"`
+}
+
+type GenesisCustomer struct {
+	Id                  string  `json:"id"`
+	AppDevId            string  `json:"appdevid"`
+	BankAccountId       string  `json:"bankaccountid"`
+	SubscriptionFee     float32 `json:"subscriptionfee"`
+	SubscriptionDueDate string  `json:"subscriptionduedate"` // YYYY-MM-DD
+}
+
+type GenesisContract struct {
+	CreatorId           string  `json:"creatorid"`
+	AppDevId            string  `json:"appdevid"`
+	ProductId           string  `json:"productid"`
+	Status              string  `json:"contractstatus"`
+	CreatorPayPerStream float32 `json:"creatorpayperstream"`
+	Advance             float32 `json:"advance"` // Treated as already paid if accepted; recouped from future streams
+	MinimumGuarantee    float32 `json:"minimumguarantee"`
+	GuaranteeEnd        string  `json:"guaranteeend"` // YYYY-MM-DD, required with a minimumguarantee
+}
+
+type GenesisDocument struct {
+	/*
+		Declaratively describes the starting state of the ledger. Passed to Init as its
+		only argument.
+	*/
+	UniqueId     string                `json:"uniqueid"` // Defaults to UNIQUE_STARTING_ID
+	BankAccounts []GenesisBankAccount  `json:"bankaccounts"`
+	AppDevs      []utils.AppDevRecord  `json:"appdevs"`
+	Creators     []utils.CreatorRecord `json:"creators"`
+	Customers    []GenesisCustomer     `json:"customers"`
+	Products     []utils.Product       `json:"products"`
+	Contracts    []GenesisContract     `json:"contracts"`
+}
+
+type GenesisSummary struct {
+	/*
+		Summarizes the ledger state written from a GenesisDocument
+	*/
+	UniqueId     string  `json:"uniqueid"`
+	BankAccounts int     `json:"bankaccounts"`
+	AppDevs      int     `json:"appdevs"`
+	Creators     int     `json:"creators"`
+	Customers    int     `json:"customers"`
+	Products     int     `json:"products"`
+	Contracts    int     `json:"contracts"`
+	TotalBalance float32 `json:"totalbalance"`
+}
+
+func isGenesisDocument(args []string) bool {
+	return len(args) == 1 && strings.HasPrefix(strings.TrimSpace(args[0]), "{")
+}
+
+func validateGenesis(genesis *GenesisDocument) []string {
+	/*
+		Validates a GenesisDocument as a whole, checking that every ID is unique and every
+		reference resolves. All problems found are reported together.
+
+		Returns:
+			problems: Description of each problem found. Empty if the document is valid.
+	*/
+	var problems []string
+	var uniqueId int64
+	var err error
+
+	problem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if genesis.UniqueId == "" {
+		genesis.UniqueId = utils.UNIQUE_STARTING_ID
+	}
+	uniqueId, err = strconv.ParseInt(genesis.UniqueId, 10, 64)
+	if err != nil {
+		problem("uniqueid %s is not an integer", genesis.UniqueId)
+	}
+	// IDs handed out by GetUniqueId must not collide with those given here
+	checkId := func(kind string, id string) {
+		if id == "" {
+			problem("%s with empty id", kind)
+			return
+		}
+		numericId, err := strconv.ParseInt(id, 10, 64)
+		if err == nil && uniqueId != 0 && numericId >= uniqueId {
+			problem("%s id %s is not below uniqueid %d", kind, id, uniqueId)
+		}
+	}
+
+	// Each bank account may be owned by at most one entity; the admin owns its own
+	accountOwners := make(map[string]string)
+	for _, account := range genesis.BankAccounts {
+		checkId("bank account", account.Id)
+		if _, ok := accountOwners[account.Id]; ok {
+			problem("duplicate bank account id %s", account.Id)
+		}
+		accountOwners[account.Id] = ""
+		if account.Balance < 0 {
+			problem("bank account %s has negative balance %.2f", account.Id, account.Balance)
+		}
+	}
+	if _, ok := accountOwners[utils.BEATCHAIN_ADMIN_BANK_ACCOUNT_ID]; !ok {
+		problem("missing Beatchain admin bank account %s", utils.BEATCHAIN_ADMIN_BANK_ACCOUNT_ID)
+	}
+	accountOwners[utils.BEATCHAIN_ADMIN_BANK_ACCOUNT_ID] = "Beatchain admin"
+	claimAccount := func(owner string, accountId string) {
+		currentOwner, ok := accountOwners[accountId]
+		if !ok {
+			problem("%s references unknown bank account %s", owner, accountId)
+		} else if currentOwner != "" {
+			problem("%s references bank account %s already owned by %s", owner, accountId, currentOwner)
+		} else {
+			accountOwners[accountId] = owner
+		}
+	}
+
+	appDevs := make(map[string]bool)
+	for _, appDev := range genesis.AppDevs {
+		checkId("AppDev", appDev.Id)
+		if appDevs[appDev.Id] {
+			problem("duplicate AppDev id %s", appDev.Id)
+		}
+		appDevs[appDev.Id] = true
+		claimAccount("AppDev "+appDev.Id, appDev.BankAccountId)
+		if appDev.AdminFeeFrac < 0 || appDev.AdminFeeFrac > 1 {
+			problem("AppDev %s adminfeefrac %.2f is not between 0 and 1", appDev.Id, appDev.AdminFeeFrac)
+		}
+	}
+
+	creators := make(map[string]bool)
+	for _, creator := range genesis.Creators {
+		checkId("Creator", creator.Id)
+		if creators[creator.Id] {
+			problem("duplicate Creator id %s", creator.Id)
+		}
+		creators[creator.Id] = true
+		claimAccount("Creator "+creator.Id, creator.BankAccountId)
+	}
+
+	customers := make(map[string]bool)
+	for _, customer := range genesis.Customers {
+		checkId("Customer", customer.Id)
+		if customers[customer.Id] {
+			problem("duplicate Customer id %s", customer.Id)
+		}
+		customers[customer.Id] = true
+		claimAccount("Customer "+customer.Id, customer.BankAccountId)
+		if !appDevs[customer.AppDevId] {
+			problem("Customer %s references unknown AppDev %s", customer.Id, customer.AppDevId)
+		}
+		if customer.SubscriptionFee < 0 {
+			problem("Customer %s has negative subscriptionfee %.2f", customer.Id, customer.SubscriptionFee)
+		}
+		_, err = time.Parse(layoutISO, customer.SubscriptionDueDate)
+		if err != nil {
+			problem("Customer %s subscriptionduedate %s is not in form YYYY-MM-DD", customer.Id, customer.SubscriptionDueDate)
+		}
+	}
+
+	productCreators := make(map[string]string)
+	for _, product := range genesis.Products {
+		checkId("Product", product.Id)
+		if _, ok := productCreators[product.Id]; ok {
+			problem("duplicate Product id %s", product.Id)
+		}
+		productCreators[product.Id] = product.CreatorId
+		if !creators[product.CreatorId] {
+			problem("Product %s references unknown Creator %s", product.Id, product.CreatorId)
+		}
+		if product.TotalListens < 0 || product.UnRenumeratedListens < 0 || product.TotalMetrics < 0 ||
+			product.UnRenumeratedMetrics < 0 || product.AdditionalMetrics < 0 {
+			problem("Product %s has negative listens or metrics", product.Id)
+		}
+	}
+
+	contracts := make(map[string]bool)
+	for _, contract := range genesis.Contracts {
+		name := fmt.Sprintf("Contract %s/%s/%s", contract.CreatorId, contract.AppDevId, contract.ProductId)
+		if contracts[name] {
+			problem("duplicate %s", name)
+		}
+		contracts[name] = true
+		if !creators[contract.CreatorId] {
+			problem("%s references unknown Creator %s", name, contract.CreatorId)
+		}
+		if !appDevs[contract.AppDevId] {
+			problem("%s references unknown AppDev %s", name, contract.AppDevId)
+		}
+		if productCreator, ok := productCreators[contract.ProductId]; !ok {
+			problem("%s references unknown Product %s", name, contract.ProductId)
+		} else if productCreator != contract.CreatorId {
+			problem("%s references Product %s owned by Creator %s", name, contract.ProductId, productCreator)
+		}
+		switch contract.Status {
+		case transactions.REQUESTED, transactions.ACCEPTED, transactions.REJECTED:
+		default:
+			problem("%s has unknown contractstatus %s", name, contract.Status)
+		}
+		if contract.CreatorPayPerStream < 0 || contract.Advance < 0 || contract.MinimumGuarantee < 0 {
+			problem("%s has negative terms", name)
+		}
+		if contract.MinimumGuarantee > 0 {
+			_, err := time.Parse(utils.DATE_LAYOUT, contract.GuaranteeEnd)
+			if err != nil {
+				problem("%s guaranteeend %s is not in form YYYY-MM-DD", name, contract.GuaranteeEnd)
+			}
+		}
+	}
+
+	return problems
+}
+
+func genesisInit(stub shim.ChaincodeStubInterface, txn *utils.Transaction) (*GenesisSummary, error) {
+	/*
+		Bootstraps the ledger state from a GenesisDocument given as Init's only argument.
+		Nothing is written unless the whole document is valid. Outside test mode the salts
+		of the contracts' terms are derived from the seed given in the transient map under
+		CONTRACT_SALT_TRANSIENT_KEY.
+
+		Args:
+			stub: HF shim interface
+			txn: parsed Transaction object
+
+		Returns:
+			summary: Counts of the records written
+			err: Error object. nil if no error occurred.
+	*/
+	var genesis GenesisDocument
+	var problems []string
+	var err error
+
+	err = json.Unmarshal([]byte(txn.Args[0]), &genesis)
+	if err != nil {
+		return nil, utils.InvalidArgumentError("", "Cannot parse genesis document: %s", err.Error())
+	}
+
+	problems = validateGenesis(&genesis)
+	if len(problems) != 0 {
+		return nil, utils.InvalidArgumentError("Genesis", "Invalid genesis document:\n%s", strings.Join(problems, "\n"))
+	}
+
+	fmt.Println("Initializing ledger from genesis document")
+	summary := &GenesisSummary{
+		UniqueId:     genesis.UniqueId,
+		BankAccounts: len(genesis.BankAccounts),
+		AppDevs:      len(genesis.AppDevs),
+		Creators:     len(genesis.Creators),
+		Customers:    len(genesis.Customers),
+		Products:     len(genesis.Products),
+		Contracts:    len(genesis.Contracts)}
+
+	err = stub.PutState(utils.UNIQUE_ID_KEY, []byte(genesis.UniqueId))
+	if err != nil {
+		return nil, err
+	}
+
+	// Accounts referenced by an owner are marked in use
+	inUse := map[string]bool{utils.BEATCHAIN_ADMIN_BANK_ACCOUNT_ID: true}
+	for _, appDev := range genesis.AppDevs {
+		inUse[appDev.BankAccountId] = true
+	}
+	for _, creator := range genesis.Creators {
+		inUse[creator.BankAccountId] = true
+	}
+	for _, customer := range genesis.Customers {
+		inUse[customer.BankAccountId] = true
+	}
+	for _, account := range genesis.BankAccounts {
+		err = utils.SetBankAccount(stub, &utils.BankAccount{
+			Id:      account.Id,
+			Balance: utils.RoundCents(float64(account.Balance)),
+			InUse:   inUse[account.Id],
+		})
+		if err != nil {
+			return nil, err
+		}
+		summary.TotalBalance = utils.RoundCents(float64(summary.TotalBalance) + float64(account.Balance))
+	}
+
+	for i := range genesis.AppDevs {
+		err = utils.SetAppDevRecord(stub, &genesis.AppDevs[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range genesis.Creators {
+		err = utils.SetCreatorRecord(stub, &genesis.Creators[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, customer := range genesis.Customers {
+		dueDate, _ := time.Parse(layoutISO, customer.SubscriptionDueDate)
+		err = utils.SetCustomerRecord(stub, &utils.CustomerRecord{
+			Id:            customer.Id,
+			BankAccountId: customer.BankAccountId,
+			Subscriptions: map[string]*utils.Subscription{
+				customer.AppDevId: {
+					AppDevId:            customer.AppDevId,
+					SubscriptionFee:     customer.SubscriptionFee,
+					SubscriptionDueDate: dueDate,
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range genesis.Products {
+		err = utils.SetProduct(stub, &genesis.Products[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, contract := range genesis.Contracts {
+		// Terms are kept private with only their hash on the public contract
+		salt, err := utils.DeriveContractSalt(stub, txn.TestMode, contract.CreatorId, contract.AppDevId, contract.ProductId)
+		if err != nil {
+			return nil, err
+		}
+		terms := &utils.ContractTerms{
+			CreatorId:           contract.CreatorId,
+			AppDevId:            contract.AppDevId,
+			ProductId:           contract.ProductId,
+			CreatorPayPerStream: contract.CreatorPayPerStream,
+			Advance:             contract.Advance,
+			MinimumGuarantee:    contract.MinimumGuarantee,
+			Salt:                salt,
+		}
+		if contract.MinimumGuarantee > 0 {
+			terms.GuaranteeEnd = contract.GuaranteeEnd
+		}
+		// Only accepted contracts have had their advance paid; the rest start recouping once accepted
+		if contract.Status == transactions.ACCEPTED {
+			terms.RecoupBalance = contract.Advance
+		}
+		err = utils.SetContractTerms(stub, terms)
+		if err != nil {
+			return nil, err
+		}
+		termsHash, err := utils.HashContractTerms(terms)
+		if err != nil {
+			return nil, err
+		}
+		err = utils.SetContract(stub, &utils.Contract{
+			CreatorId: contract.CreatorId,
+			AppDevId:  contract.AppDevId,
+			ProductId: contract.ProductId,
+			Status:    contract.Status,
+			TermsHash: termsHash,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return summary, nil
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"github.com/beatchain/utils"
-	"errors"
 	"fmt"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"strconv"
@@ -30,12 +29,13 @@ func ledgerInit(stub shim.ChaincodeStubInterface, txn *utils.Transaction) error
 	var creatorRecord *utils.CreatorRecord
 	var product *utils.Product
 	var contract *utils.Contract
+	var contractTerms *utils.ContractTerms
 	var beatchainAdminBA, customerBA, appDevBA, creatorBA *utils.BankAccount
 	var err error
 
 	// Validate length of arguments
 	if len(txn.Args) < 23 {
-		return errors.New(fmt.Sprintf("Too few arguments given; given %d arguments", len(txn.Args)))
+		return utils.InvalidArgumentError("", "Too few arguments given; given %d arguments", len(txn.Args))
 	}
 
 	fmt.Println("Initializing with new starting unique key ")
@@ -54,7 +54,7 @@ func ledgerInit(stub shim.ChaincodeStubInterface, txn *utils.Transaction) error
 	fmt.Printf("Beatchain Admin BankAccount Initial Balance: %s\n", txn.Args[0])
 	beatchainAdminBABalance, err := strconv.ParseFloat(txn.Args[0], 32)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot parse given beatchainAdminBABalance to float32: %s", txn.Args[0]))
+		return utils.InvalidArgumentError("beatchainAdminBABalance", "Cannot parse given beatchainAdminBABalance to float32: %s", txn.Args[0])
 	}
 
 	beatchainAdminBA = &utils.BankAccount{
@@ -79,11 +79,11 @@ func ledgerInit(stub shim.ChaincodeStubInterface, txn *utils.Transaction) error
 	testAppDevBAId := txn.Args[2]
 	testAppDevAdminFeeFrac, err := strconv.ParseFloat(txn.Args[3], 32)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot parse given testAppDevAdminFeeFrac to float32: %s", txn.Args[3]))
+		return utils.InvalidArgumentError("testAppDevAdminFeeFrac", "Cannot parse given testAppDevAdminFeeFrac to float32: %s", txn.Args[3])
 	}
 	testAppDevBABalance, err := strconv.ParseFloat(txn.Args[4], 32)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot parse given testAppDevBABalance to float32: %s", txn.Args[4]))
+		return utils.InvalidArgumentError("testAppDevBABalance", "Cannot parse given testAppDevBABalance to float32: %s", txn.Args[4])
 	}
 
 	appDevRecord = &utils.AppDevRecord{
@@ -119,15 +119,15 @@ func ledgerInit(stub shim.ChaincodeStubInterface, txn *utils.Transaction) error
 	testCustomerBAId := txn.Args[6]
 	testCustomerSubscriptionFee, err := strconv.ParseFloat(txn.Args[7], 32)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot parse given testCustomerSubscriptionFee to float32: %s", txn.Args[7]))
+		return utils.InvalidArgumentError("testCustomerSubscriptionFee", "Cannot parse given testCustomerSubscriptionFee to float32: %s", txn.Args[7])
 	}
 	testCustomerSubscriptionDueDate, err := time.Parse(layoutISO, txn.Args[8])
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot parse given testCustomerSubscriptionDueDate to date in form YYYY-MM-DD: %s", txn.Args[8]))
+		return utils.InvalidArgumentError("testCustomerSubscriptionDueDate", "Cannot parse given testCustomerSubscriptionDueDate to date in form YYYY-MM-DD: %s", txn.Args[8])
 	}
 	testCustomerBABalance, err := strconv.ParseFloat(txn.Args[9], 32)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot parse given testCustomerBABalance to float32: %s", txn.Args[9]))
+		return utils.InvalidArgumentError("testCustomerBABalance", "Cannot parse given testCustomerBABalance to float32: %s", txn.Args[9])
 	}
 
 	customerBA = &utils.BankAccount{
@@ -141,13 +141,17 @@ func ledgerInit(stub shim.ChaincodeStubInterface, txn *utils.Transaction) error
 	}
 
 	customerRecord = &utils.CustomerRecord{
-		Id:                  testCustomerId,
-		AppDevId:            testAppDevId,
-		BankAccountId:       testCustomerBAId,
-		SubscriptionFee:     float32(testCustomerSubscriptionFee),
-		SubscriptionDueDate: testCustomerSubscriptionDueDate,
-		QueuedSong:          "",
-		PreviousSong:        "",
+		Id:            testCustomerId,
+		BankAccountId: testCustomerBAId,
+		Subscriptions: map[string]*utils.Subscription{
+			testAppDevId: {
+				AppDevId:            testAppDevId,
+				SubscriptionFee:     float32(testCustomerSubscriptionFee),
+				SubscriptionDueDate: testCustomerSubscriptionDueDate,
+			},
+		},
+		QueuedSong:   "",
+		PreviousSong: "",
 	}
 	err = utils.SetCustomerRecord(stub, customerRecord)
 	if err != nil {
@@ -165,7 +169,7 @@ func ledgerInit(stub shim.ChaincodeStubInterface, txn *utils.Transaction) error
 	testCreatorBAId := txn.Args[11]
 	testCreatorBABalance, err := strconv.ParseFloat(txn.Args[12], 32)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot parse given testCreatorBABalance to float32: %s", txn.Args[12]))
+		return utils.InvalidArgumentError("testCreatorBABalance", "Cannot parse given testCreatorBABalance to float32: %s", txn.Args[12])
 	}
 
 	creatorRecord = &utils.CreatorRecord{
@@ -203,27 +207,27 @@ func ledgerInit(stub shim.ChaincodeStubInterface, txn *utils.Transaction) error
 	testProductName := txn.Args[14]
 	testProductTotListens, err := strconv.ParseInt(txn.Args[15], 10, 64)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot parse given testProductTotListens to int64: %s", txn.Args[15]))
+		return utils.InvalidArgumentError("testProductTotListens", "Cannot parse given testProductTotListens to int64: %s", txn.Args[15])
 	}
 	testProductUnListens, err := strconv.ParseInt(txn.Args[16], 10, 64)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot parse given testProductUnListens to int64: %s", txn.Args[16]))
+		return utils.InvalidArgumentError("testProductUnListens", "Cannot parse given testProductUnListens to int64: %s", txn.Args[16])
 	}
 	testProductTotMetrics, err := strconv.ParseInt(txn.Args[17], 10, 64)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot parse given testProductTotMetrics to int64: %s", txn.Args[17]))
+		return utils.InvalidArgumentError("testProductTotMetrics", "Cannot parse given testProductTotMetrics to int64: %s", txn.Args[17])
 	}
 	testProductUnMetrics, err := strconv.ParseInt(txn.Args[18], 10, 64)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot parse given testProductUnMetrics to int64: %s", txn.Args[18]))
+		return utils.InvalidArgumentError("testProductUnMetrics", "Cannot parse given testProductUnMetrics to int64: %s", txn.Args[18])
 	}
 	testProductAddMetrics, err := strconv.ParseInt(txn.Args[19], 10, 64)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot parse given testProductAddMetrics to int64: %s", txn.Args[19]))
+		return utils.InvalidArgumentError("testProductAddMetrics", "Cannot parse given testProductAddMetrics to int64: %s", txn.Args[19])
 	}
 	testProductStatus, err := strconv.ParseBool(txn.Args[20])
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot parse given testProductStatus to bool: %s", txn.Args[20]))
+		return utils.InvalidArgumentError("testProductStatus", "Cannot parse given testProductStatus to bool: %s", txn.Args[20])
 	}
 
 	product = &utils.Product{
@@ -250,16 +254,36 @@ func ledgerInit(stub shim.ChaincodeStubInterface, txn *utils.Transaction) error
 
 	testContractPPS, err := strconv.ParseFloat(txn.Args[21], 32)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot parse given testContractPPS to float32: %s", txn.Args[21]))
+		return utils.InvalidArgumentError("testContractPPS", "Cannot parse given testContractPPS to float32: %s", txn.Args[21])
 	}
 	testContractStatus := txn.Args[22]
 
-	contract = &utils.Contract{
+	salt, err := utils.DeriveContractSalt(stub, txn.TestMode, testCreatorId, testAppDevId, testProductId)
+	if err != nil {
+		return err
+	}
+	contractTerms = &utils.ContractTerms{
 		CreatorId:           testCreatorId,
 		AppDevId:            testAppDevId,
 		ProductId:           testProductId,
 		CreatorPayPerStream: float32(testContractPPS),
-		Status:              testContractStatus,
+		Salt:                salt,
+	}
+	err = utils.SetContractTerms(stub, contractTerms)
+	if err != nil {
+		return err
+	}
+	termsHash, err := utils.HashContractTerms(contractTerms)
+	if err != nil {
+		return err
+	}
+
+	contract = &utils.Contract{
+		CreatorId: testCreatorId,
+		AppDevId:  testAppDevId,
+		ProductId: testProductId,
+		Status:    testContractStatus,
+		TermsHash: termsHash,
 	}
 	err = utils.SetContract(stub, contract)
 	if err != nil {
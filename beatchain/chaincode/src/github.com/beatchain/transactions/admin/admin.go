@@ -1,7 +1,6 @@
 package admin
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -24,27 +23,27 @@ func AddProduct(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Res
 
 	// Access control: Only a Creator can invoke this transaction
 	if !txn.TestMode && !utils.AuthenticateCreator(txn) {
-		return shim.Error("Caller not a member of Creator Org. Access denied.")
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Creator Org. Access denied."))
 	}
 
 	if txn.CreatorId == "" {
-		return shim.Error("Transaction invoker Creator ID not found in ecert attributes")
+		return utils.ErrorResponse(utils.ForbiddenError("Transaction invoker Creator ID not found in ecert attributes"))
 	}
 
 	if len(txn.Args) != 1 {
-		err := errors.New(fmt.Sprintf("Incorrect number of arguments. Expecting 1: {ProductName}. Found %d", len(txn.Args)))
-		return shim.Error(err.Error())
+		err := utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 1: {ProductName}. Found %d", len(txn.Args))
+		return utils.ErrorResponse(err)
 	}
 
 	// check for valid Creator
 	_, err = utils.GetCreatorRecord(stub, txn.CreatorId)
 	if !txn.TestMode && err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 	// Get a unique key
 	id, err = utils.GetUniqueId(stub, txn)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	rawProduct := &utils.Product{Id: id,
@@ -59,14 +58,14 @@ func AddProduct(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Res
 
 	err = utils.SetProduct(stub, rawProduct) //tbd SetProduct
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 	fmt.Println("Product created successfully with the following attributes :")
 	fmt.Printf("ProductId : %s", id)
 	fmt.Printf("ProductName : %s", txn.Args[0])
 	fmt.Printf("CreatorID : %s", txn.CreatorId)
 
-	return shim.Success([]byte(id))
+	return utils.TextResponse(stub, txn, rawProduct, id)
 }
 
 func DeleteProduct(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
@@ -81,16 +80,16 @@ func DeleteProduct(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.
 
 	// Access control: Only a Creator can invoke this transaction
 	if !txn.TestMode && !utils.AuthenticateCreator(txn) {
-		return shim.Error("Caller not a member of Creator Org. Access denied.")
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Creator Org. Access denied."))
 	}
 
 	if txn.CreatorId == "" {
-		return shim.Error("Transaction invoker Creator ID not found in ecert attributes")
+		return utils.ErrorResponse(utils.ForbiddenError("Transaction invoker Creator ID not found in ecert attributes"))
 	}
 
 	if len(txn.Args) != 1 {
-		err := errors.New(fmt.Sprintf("Incorrect number of arguments. Expecting 1: {ProductID}. Found %d", len(txn.Args)))
-		return shim.Error(err.Error())
+		err := utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 1: {ProductID}. Found %d", len(txn.Args))
+		return utils.ErrorResponse(err)
 	}
 
 	productId := txn.Args[0]
@@ -98,39 +97,40 @@ func DeleteProduct(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.
 	// check for valid Creator
 	creator, err := utils.GetCreatorRecord(stub, txn.CreatorId)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	// check for valid Product and verify Creator owns Product
-	product, err := utils.GetProduct(stub, txn.Args[1])
+	product, err := utils.GetProduct(stub, productId)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	if creator.Id != product.CreatorId {
-		err = errors.New(fmt.Sprintf("Creator ID %s does not match Product's creator id %s", creator.Id, product.CreatorId))
-		return shim.Error(err.Error())
+		err = utils.InvalidArgumentError("CreatorID", "Creator ID %s does not match Product's creator id %s", creator.Id, product.CreatorId)
+		return utils.ErrorResponse(err)
 	}
 
 	product.IsActive = false
 
 	err = utils.SetProduct(stub, product)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	fmt.Printf("Product with id %s has been successfully deleted", productId)
 
-	return shim.Success([]byte("SUCCESS"))
+	return utils.TextResponse(stub, txn, product, "SUCCESS")
 }
 
 func createNewBankAccHelper(stub shim.ChaincodeStubInterface, txn *utils.Transaction) (string, error) {
 	/*
 		Helper function to generate a bank account and returning the ID for
-		later use in creating other objects.
+		later use in creating other objects. An empty account released by an
+		offboarded entity is reassigned before a new one is created.
 
 		Returns:
-			BankAccountID (string): ID of the newly-created BankAccount object
+			BankAccountID (string): ID of the assigned BankAccount object
 			err (error): Error object
 	*/
 	var id string
@@ -138,6 +138,21 @@ func createNewBankAccHelper(stub shim.ChaincodeStubInterface, txn *utils.Transac
 	var err error
 	floatBalance = 0.0
 
+	freeBankAccounts, err := utils.GetFreeBankAccounts(stub)
+	if err != nil {
+		return "", err
+	}
+	for _, bankAccount := range freeBankAccounts {
+		if bankAccount.Balance == 0.0 {
+			bankAccount.InUse = true
+			err = utils.SetBankAccount(stub, bankAccount)
+			if err != nil {
+				return "", err
+			}
+			return bankAccount.Id, nil
+		}
+	}
+
 	// Get a unique key
 	id, err = utils.GetUniqueId(stub, txn)
 	if err != nil {
@@ -166,35 +181,35 @@ func AddCustomerRecord(stub shim.ChaincodeStubInterface, txn *utils.Transaction)
 
 	// Access control: Only appdev org can invoke this transaction
 	if !txn.TestMode && !utils.AuthenticateAppDev(txn) {
-		return shim.Error("Caller not a member of appdev org.")
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of appdev org."))
 	}
 
 	if txn.CreatorId == "" {
-		return shim.Error("Transaction invoker AppDev ID not found in ecert attributes")
+		return utils.ErrorResponse(utils.ForbiddenError("Transaction invoker AppDev ID not found in ecert attributes"))
 	}
 
 	if len(txn.Args) != 1 {
-		err := errors.New(fmt.Sprintf("Incorrect number of arguments. Expecting 1: {subscriptionFee}. Found %d", len(txn.Args)))
-		return shim.Error(err.Error())
+		err := utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 1: {subscriptionFee}. Found %d", len(txn.Args))
+		return utils.ErrorResponse(err)
 	}
 
 	subscriptionFee, err := strconv.ParseFloat(txn.Args[0], 32)
 	if err != nil {
-		err = errors.New(fmt.Sprintf("Cannot parse given subscriptionFee to float32: %s", txn.Args[0]))
-		return shim.Error(err.Error())
+		err = utils.InvalidArgumentError("SubscriptionFee", "Cannot parse given subscriptionFee to float32: %s", txn.Args[0])
+		return utils.ErrorResponse(err)
 	}
 
 	// check for valid AppDev
 	_, err = utils.GetAppDevRecord(stub, txn.CreatorId)
 	if !txn.TestMode && err != nil {
 		fmt.Printf("Cannot find AppDevRecord with ID %s", txn.CreatorId)
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	// Get a unique key
 	id, err = utils.GetUniqueId(stub, txn)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	// Sub due 1 month from creation date
@@ -204,26 +219,138 @@ func AddCustomerRecord(stub shim.ChaincodeStubInterface, txn *utils.Transaction)
 
 	bankAccountId, err := createNewBankAccHelper(stub, txn)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	rawCustomer := &utils.CustomerRecord{
 		Id: id,
-		AppDevId: txn.CreatorId,
 		BankAccountId: bankAccountId,
-		SubscriptionFee: float32(subscriptionFee),
-		SubscriptionDueDate: subscriptionDueDate,
+		Subscriptions: map[string]*utils.Subscription{
+			txn.CreatorId: {
+				AppDevId: txn.CreatorId,
+				SubscriptionFee: float32(subscriptionFee),
+				SubscriptionDueDate: subscriptionDueDate}},
 		QueuedSong: "",
 		PreviousSong: ""}
 
 	err = utils.SetCustomerRecord(stub, rawCustomer)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	fmt.Printf("Customer successfully created with id: %s", id)
 
-	return shim.Success([]byte(id))
+	return utils.TextResponse(stub, txn, rawCustomer, id)
+}
+
+func validateSubscriptionChange(stub shim.ChaincodeStubInterface, txn *utils.Transaction, expectedArgs int) (*utils.CustomerRecord, error) {
+	/*
+		Validates the inputs common to AddSubscription and CancelSubscription
+	*/
+	if len(txn.Args) != expectedArgs {
+		return nil, utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting %d. Found %d", expectedArgs, len(txn.Args))
+	}
+
+	_, err := utils.GetAppDevRecord(stub, txn.Args[1])
+	if err != nil {
+		return nil, err
+	}
+	return utils.GetCustomerRecord(stub, txn.Args[0])
+}
+
+func AddSubscription(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Subscribes an existing Customer through another AppDev. The Customer keeps their
+		identity and bank account, and the first month of the subscription is free as it is
+		for a new Customer. Invoked by the Customer, as the fee is paid from their account;
+		the AppDev may cancel a subscription whose fee it did not agree to.
+
+		Args:
+			CustomerID (string): ID of the Customer
+			AppDevID (string): ID of the AppDev to subscribe through
+			SubscriptionFee (float32): Monthly subscription fee in $USD
+	*/
+	var customerRecord *utils.CustomerRecord
+	var txTime time.Time
+	var err error
+
+	// Access control: Only the Customer can subscribe themselves
+	if !txn.TestMode && !(utils.AuthenticateCustomer(txn) && len(txn.Args) > 0 && txn.CreatorId == txn.Args[0]) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller is not the Customer. Access denied."))
+	}
+
+	customerRecord, err = validateSubscriptionChange(stub, txn, 3)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	appDevId := txn.Args[1]
+
+	subscriptionFee, err := strconv.ParseFloat(txn.Args[2], 32)
+	if err != nil || subscriptionFee < 0 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("SubscriptionFee", "SubscriptionFee must be a non-negative amount. Given: %s", txn.Args[2]))
+	}
+	if _, ok := customerRecord.Subscriptions[appDevId]; ok {
+		return utils.ErrorResponse(utils.ConflictError("Customer %s is already subscribed through AppDev %s", customerRecord.Id, appDevId))
+	}
+
+	txTime, err = utils.GetTxTime(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	date := time.Date(txTime.Year(), txTime.Month(), txTime.Day(), 0, 0, 0, 0, txTime.Location())
+
+	if customerRecord.Subscriptions == nil {
+		customerRecord.Subscriptions = make(map[string]*utils.Subscription)
+	}
+	customerRecord.Subscriptions[appDevId] = &utils.Subscription{
+		AppDevId: appDevId,
+		SubscriptionFee: utils.RoundCents(subscriptionFee),
+		SubscriptionDueDate: date.Add(time.Hour * 24 * 30)}
+
+	err = utils.SetCustomerRecord(stub, customerRecord)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.TextResponse(stub, txn, customerRecord, "SUCCESS")
+}
+
+func CancelSubscription(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Ends a Customer's subscription through an AppDev. Their other subscriptions and
+		their bank account are unaffected. Invoked by a Beatchain admin, the subscription's
+		AppDev or the Customer.
+
+		Args:
+			CustomerID (string): ID of the Customer
+			AppDevID (string): ID of the AppDev subscribed through
+	*/
+	var customerRecord *utils.CustomerRecord
+	var err error
+
+	customerRecord, err = validateSubscriptionChange(stub, txn, 2)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	appDevId := txn.Args[1]
+
+	// Access control: Only a Beatchain Admin, the subscription's AppDev or the Customer can invoke this transaction
+	if !txn.TestMode && !utils.AuthenticateBeatchainAdmin(txn) &&
+		!(utils.AuthenticateAppDev(txn) && txn.CreatorId == appDevId) &&
+		!(utils.AuthenticateCustomer(txn) && txn.CreatorId == customerRecord.Id) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller is not a Beatchain Admin, the subscription's AppDev or the Customer. Access denied."))
+	}
+
+	if _, ok := customerRecord.Subscriptions[appDevId]; !ok {
+		return utils.ErrorResponse(utils.ConflictError("Customer %s is not subscribed through AppDev %s", customerRecord.Id, appDevId))
+	}
+	delete(customerRecord.Subscriptions, appDevId)
+
+	// Setting the record removes it from the AppDev's subscription index
+	err = utils.SetCustomerRecord(stub, customerRecord)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.TextResponse(stub, txn, customerRecord, "SUCCESS")
 }
 
 func CreateNewBankAccount(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
@@ -242,20 +369,20 @@ func CreateNewBankAccount(stub shim.ChaincodeStubInterface, txn *utils.Transacti
 
 	// Check for admin rights
 	if !txn.CreatorAdmin {
-		err := errors.New(fmt.Sprint("access denied: function requires admin privileges"))
-		return shim.Error(err.Error())
+		err := utils.ForbiddenError("access denied: function requires admin privileges")
+		return utils.ErrorResponse(err)
 	}
 
 	if len(txn.Args) != 1 {
-		err := errors.New(fmt.Sprintf("Expecting 0 arguments. Found %d", len(txn.Args)))
-		return shim.Error(err.Error())
+		err := utils.InvalidArgumentError("", "Expecting 0 arguments. Found %d", len(txn.Args))
+		return utils.ErrorResponse(err)
 	}
 
 
 	// Get a unique key
 	id, err = utils.GetUniqueId(stub, txn)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	// All BAs initialized to $0.00 to prevent money creation via account creation
@@ -263,12 +390,12 @@ func CreateNewBankAccount(stub shim.ChaincodeStubInterface, txn *utils.Transacti
 
 	err = utils.SetBankAccount(stub, rawBankAccount)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	fmt.Printf("Bank Account successfully created with id: %s", id)
 
-	return shim.Success([]byte(id))
+	return utils.TextResponse(stub, txn, rawBankAccount, id)
 }
 
 func AddCreatorRecord(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
@@ -283,40 +410,40 @@ func AddCreatorRecord(stub shim.ChaincodeStubInterface, txn *utils.Transaction)
 
 	// Check for admin rights
 	if !txn.CreatorAdmin {
-		err := errors.New(fmt.Sprint("access denied: function requires admin privileges"))
-		return shim.Error(err.Error())
+		err := utils.ForbiddenError("access denied: function requires admin privileges")
+		return utils.ErrorResponse(err)
 	}
 
 	// Access control: Only a creator org can invoke this transaction
 	if !txn.TestMode && !utils.AuthenticateCreator(txn) {
-		return shim.Error("Caller not a member of Creator org.")
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Creator org."))
 	}
 
 	if len(txn.Args) != 0 {
-		err := errors.New(fmt.Sprintf("Incorrect number of arguments. Expecting 0. Found %d", len(txn.Args)))
-		return shim.Error(err.Error())
+		err := utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 0. Found %d", len(txn.Args))
+		return utils.ErrorResponse(err)
 	}
 
 	bankAccountId, err := createNewBankAccHelper(stub, txn)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	// Get a unique key
 	id, err = utils.GetUniqueId(stub, txn)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	rawCreator := &utils.CreatorRecord{Id: id, BankAccountId: bankAccountId}
 	err = utils.SetCreatorRecord(stub, rawCreator)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	fmt.Printf("Creator successfully created with id: %s and bank account id: %s and balance = 0.0", id, bankAccountId)
 
-	return shim.Success([]byte(id))
+	return utils.TextResponse(stub, txn, rawCreator, id)
 }
 
 func AddAppDevRecord(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
@@ -331,49 +458,49 @@ func AddAppDevRecord(stub shim.ChaincodeStubInterface, txn *utils.Transaction) p
 	var err error
 
 	if !txn.CreatorAdmin {
-		err := errors.New(fmt.Sprint("access denied: function requires admin privileges"))
-		return shim.Error(err.Error())
+		err := utils.ForbiddenError("access denied: function requires admin privileges")
+		return utils.ErrorResponse(err)
 	}
 
 	// Access control: Only an admin can invoke this transaction
 	if !txn.TestMode && !utils.AuthenticateAppDev(txn) {
-		return shim.Error("Caller not a member of appdev org.")
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of appdev org."))
 	}
 
 	if len(txn.Args) != 1 {
-		err = errors.New(fmt.Sprintf("Incorrect number of arguments. Expecting 1: {AdminFeeFrac}. Found %d", len(txn.Args)))
-		return shim.Error(err.Error())
+		err = utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 1: {AdminFeeFrac}. Found %d", len(txn.Args))
+		return utils.ErrorResponse(err)
 	}
 
 	adminFeeFrac64, err := strconv.ParseFloat(txn.Args[0], 32)
 	if err != nil {
-		err = errors.New(fmt.Sprintf("Cannot parse given adminFeeFrac to float32: %s", txn.Args[0]))
-		return shim.Error(err.Error())
+		err = utils.InvalidArgumentError("AdminFeeFrac", "Cannot parse given adminFeeFrac to float32: %s", txn.Args[0])
+		return utils.ErrorResponse(err)
 	}
 	adminFeeFrac := float32(adminFeeFrac64)
 
 	if adminFeeFrac < 0.0 || adminFeeFrac > 1.0 {
-		err = errors.New(fmt.Sprintf("Admin fee frac must be between 0 and 1"))
-		return shim.Error(err.Error())
+		err = utils.InvalidArgumentError("AdminFeeFrac", "Admin fee frac must be between 0 and 1")
+		return utils.ErrorResponse(err)
 	}
 
 	bankAccountId, err := createNewBankAccHelper(stub, txn)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	id, err = utils.GetUniqueId(stub, txn)
 	if err != nil {
-		return shim.Error(err.Error() + "; id:" + id)
+		return utils.ErrorResponse(utils.WrapError(err, "Cannot assign id %s", id))
 	}
 
 	rawAppDevRecord := &utils.AppDevRecord{Id: id, BankAccountId: bankAccountId, AdminFeeFrac: adminFeeFrac}
 	err = utils.SetAppDevRecord(stub, rawAppDevRecord)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	fmt.Printf("Appdev Record successfully created with id: %s, bank accounts id %s and admin fee frac : %.2f", id, bankAccountId, adminFeeFrac)
 
-	return shim.Success([]byte(id))
+	return utils.TextResponse(stub, txn, rawAppDevRecord, id)
 }
\ No newline at end of file
@@ -0,0 +1,266 @@
+/*
+Handles onboarding batches of customers, creators or products in a single transaction
+*/
+
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/beatchain/transactions"
+	"github.com/beatchain/utils"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+type bulkCustomerRow struct {
+	AppDevId            string  `json:"appdevid"`
+	SubscriptionFee     float32 `json:"subscriptionfee"`
+	SubscriptionDueDate string  `json:"subscriptionduedate"` // YYYY-MM-DD; defaults to 30 days out
+}
+
+type bulkProductRow struct {
+	CreatorId   string `json:"creatorid"`
+	ProductName string `json:"productname"`
+}
+
+// Creates the ledger records for a validated row, filling in the row's result
+type bulkImporter func(result *utils.BulkImportRowResult) error
+
+func validateBulkImport(transaction *utils.Transaction) (string, string, []json.RawMessage, error) {
+	/*
+		Validates the inputs to the BulkImport function
+	*/
+	var rows []json.RawMessage
+
+	// Access control: Only a Beatchain Admin can invoke this transaction
+	if !transaction.TestMode && !utils.AuthenticateBeatchainAdmin(transaction) {
+		return "", "", nil, utils.ForbiddenError("Caller not a member of Beatchain Admin Org. Access denied.")
+	}
+	if len(transaction.Args) != 3 {
+		return "", "", nil, utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 3: {RecordType, Mode, Rows}. Found %d", len(transaction.Args))
+	}
+
+	recordType := transaction.Args[0]
+	switch recordType {
+	case utils.CUSTOMER_RECORD_KEY_PREFIX, utils.CREATOR_RECORD_KEY_PREFIX, utils.PRODUCT_KEY_PREFIX:
+	default:
+		return "", "", nil, utils.InvalidArgumentError("RecordType", "RecordType must be one of %s, %s or %s. Given: %s",
+			utils.CUSTOMER_RECORD_KEY_PREFIX, utils.CREATOR_RECORD_KEY_PREFIX, utils.PRODUCT_KEY_PREFIX, recordType)
+	}
+
+	mode := transaction.Args[1]
+	if mode != transactions.BULK_IMPORT_ATOMIC && mode != transactions.BULK_IMPORT_PARTIAL {
+		return "", "", nil, utils.InvalidArgumentError("Mode", "Mode must be %s or %s. Given: %s",
+			transactions.BULK_IMPORT_ATOMIC, transactions.BULK_IMPORT_PARTIAL, mode)
+	}
+
+	err := json.Unmarshal([]byte(transaction.Args[2]), &rows)
+	if err != nil {
+		return "", "", nil, utils.InvalidArgumentError("Rows", "Cannot parse Rows as a JSON array: %s", err.Error())
+	}
+	if len(rows) == 0 {
+		return "", "", nil, utils.InvalidArgumentError("Rows", "Rows is empty")
+	}
+	if len(rows) > transactions.BULK_IMPORT_MAX_ROWS {
+		return "", "", nil, utils.InvalidArgumentError("", "Batch of %d rows exceeds the limit of %d",
+			len(rows), transactions.BULK_IMPORT_MAX_ROWS)
+	}
+	return recordType, mode, rows, nil
+}
+
+func prepareCustomerRow(stub shim.ChaincodeStubInterface, txn *utils.Transaction, rowBytes json.RawMessage,
+	appDevs map[string]bool, txTime time.Time) (bulkImporter, error) {
+	/*
+		Validates a customer row, returning the function which creates the customer and
+		their bank account
+	*/
+	var row bulkCustomerRow
+	var dueDate time.Time
+	var err error
+
+	err = json.Unmarshal(rowBytes, &row)
+	if err != nil {
+		return nil, utils.InvalidArgumentError("", "cannot parse customer row: %s", err.Error())
+	}
+	if row.AppDevId == "" {
+		return nil, utils.InvalidArgumentError("", "appdevid is required")
+	}
+	if _, ok := appDevs[row.AppDevId]; !ok {
+		_, err = utils.GetAppDevRecord(stub, row.AppDevId)
+		appDevs[row.AppDevId] = err == nil
+	}
+	if !appDevs[row.AppDevId] {
+		return nil, utils.InvalidArgumentError("", "unknown AppDev %s", row.AppDevId)
+	}
+	if row.SubscriptionFee < 0 {
+		return nil, utils.InvalidArgumentError("", "negative subscriptionfee %.2f", row.SubscriptionFee)
+	}
+	if row.SubscriptionDueDate == "" {
+		// Sub due 1 month from creation date
+		date := time.Date(txTime.Year(), txTime.Month(), txTime.Day(), 0, 0, 0, 0, txTime.Location())
+		dueDate = date.Add(time.Hour * 24 * 30)
+	} else {
+		dueDate, err = time.Parse("2006-01-02", row.SubscriptionDueDate)
+		if err != nil {
+			return nil, utils.InvalidArgumentError("", "subscriptionduedate %s is not in form YYYY-MM-DD", row.SubscriptionDueDate)
+		}
+	}
+
+	return func(result *utils.BulkImportRowResult) error {
+		bankAccountId, err := createNewBankAccHelper(stub, txn)
+		if err != nil {
+			return err
+		}
+		id, err := utils.GetUniqueId(stub, txn)
+		if err != nil {
+			return err
+		}
+		result.Id = id
+		result.BankAccountId = bankAccountId
+		return utils.SetCustomerRecord(stub, &utils.CustomerRecord{
+			Id:            id,
+			BankAccountId: bankAccountId,
+			Subscriptions: map[string]*utils.Subscription{
+				row.AppDevId: {
+					AppDevId:            row.AppDevId,
+					SubscriptionFee:     utils.RoundCents(float64(row.SubscriptionFee)),
+					SubscriptionDueDate: dueDate}}})
+	}, nil
+}
+
+func prepareCreatorRow(stub shim.ChaincodeStubInterface, txn *utils.Transaction) (bulkImporter, error) {
+	/*
+		Returns the function which creates a creator and their bank account. Creator rows
+		carry no fields, so there is nothing to validate.
+	*/
+	return func(result *utils.BulkImportRowResult) error {
+		bankAccountId, err := createNewBankAccHelper(stub, txn)
+		if err != nil {
+			return err
+		}
+		id, err := utils.GetUniqueId(stub, txn)
+		if err != nil {
+			return err
+		}
+		result.Id = id
+		result.BankAccountId = bankAccountId
+		return utils.SetCreatorRecord(stub, &utils.CreatorRecord{Id: id, BankAccountId: bankAccountId})
+	}, nil
+}
+
+func prepareProductRow(stub shim.ChaincodeStubInterface, txn *utils.Transaction, rowBytes json.RawMessage,
+	creators map[string]bool) (bulkImporter, error) {
+	/*
+		Validates a product row, returning the function which creates the product
+	*/
+	var row bulkProductRow
+	var err error
+
+	err = json.Unmarshal(rowBytes, &row)
+	if err != nil {
+		return nil, utils.InvalidArgumentError("", "cannot parse product row: %s", err.Error())
+	}
+	if row.ProductName == "" {
+		return nil, utils.InvalidArgumentError("", "productname is required")
+	}
+	if row.CreatorId == "" {
+		return nil, utils.InvalidArgumentError("", "creatorid is required")
+	}
+	if _, ok := creators[row.CreatorId]; !ok {
+		_, err = utils.GetCreatorRecord(stub, row.CreatorId)
+		creators[row.CreatorId] = err == nil
+	}
+	if !creators[row.CreatorId] {
+		return nil, utils.InvalidArgumentError("", "unknown Creator %s", row.CreatorId)
+	}
+
+	return func(result *utils.BulkImportRowResult) error {
+		id, err := utils.GetUniqueId(stub, txn)
+		if err != nil {
+			return err
+		}
+		result.Id = id
+		return utils.SetProduct(stub, &utils.Product{
+			Id:          id,
+			CreatorId:   row.CreatorId,
+			ProductName: row.ProductName,
+			IsActive:    true})
+	}, nil
+}
+
+func BulkImport(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Creates a batch of customers, creators or products in one transaction. Every row is
+		validated before anything is written. In atomic mode a single invalid row rejects
+		the whole batch; in partial mode the valid rows are imported. Customers and creators
+		are each given a new bank account, as with AddCustomerRecord and AddCreatorRecord.
+		Returns a per-row report as JSON.
+
+		Args:
+			RecordType (string): "CustomerRecord", "CreatorRecord" or "Product"
+			Mode (string): "atomic" or "partial"
+			Rows (string): JSON array of rows. Customer rows take {appdevid, subscriptionfee,
+				subscriptionduedate (optional, YYYY-MM-DD)}, product rows take {creatorid,
+				productname} and creator rows take {}.
+	*/
+	var rows []json.RawMessage
+	var importers []bulkImporter
+	var importer bulkImporter
+	var recordType, mode string
+	var txTime time.Time
+	var err error
+
+	recordType, mode, rows, err = validateBulkImport(txn)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	txTime, err = utils.GetTxTime(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	report := &utils.BulkImportReport{
+		RecordType: recordType,
+		Mode:       mode,
+		Rows:       make([]utils.BulkImportRowResult, len(rows))}
+	appDevs := make(map[string]bool)
+	creators := make(map[string]bool)
+
+	// Validate every row before writing anything
+	for i, row := range rows {
+		report.Rows[i].Row = i
+		switch recordType {
+		case utils.CUSTOMER_RECORD_KEY_PREFIX:
+			importer, err = prepareCustomerRow(stub, txn, row, appDevs, txTime)
+		case utils.CREATOR_RECORD_KEY_PREFIX:
+			importer, err = prepareCreatorRow(stub, txn)
+		default:
+			importer, err = prepareProductRow(stub, txn, row, creators)
+		}
+		if err != nil {
+			report.Rows[i].Error = err.Error()
+			report.Failed += 1
+		}
+		importers = append(importers, importer)
+	}
+
+	report.Committed = report.Failed == 0 || mode == transactions.BULK_IMPORT_PARTIAL
+	if report.Committed {
+		for i, importer := range importers {
+			if importer == nil {
+				continue
+			}
+			err = importer(&report.Rows[i])
+			if err != nil {
+				return utils.ErrorResponse(utils.WrapError(err, "Error importing row %d", i))
+			}
+			report.Succeeded += 1
+		}
+	}
+	fmt.Printf("Bulk import of %d %s rows: %d succeeded, %d failed\n", len(rows), recordType, report.Succeeded, report.Failed)
+
+	return utils.SuccessResponse(stub, txn, report)
+}
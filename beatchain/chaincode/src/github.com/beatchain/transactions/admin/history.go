@@ -0,0 +1,69 @@
+/*
+Handles change history queries on ledger records
+*/
+
+package admin
+
+import (
+	"github.com/beatchain/utils"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+func getHistoryKey(stub shim.ChaincodeStubInterface, recordType string, ids []string) (string, error) {
+	/*
+		Builds the ledger key of a record supporting history queries from its IDs
+	*/
+	switch recordType {
+	case utils.CONTRACT_KEY_PREFIX:
+		if len(ids) != 3 {
+			return "", utils.InvalidArgumentError("", "%s history takes 3 IDs: {CreatorID, AppDevID, ProductID}. Found %d", recordType, len(ids))
+		}
+	case utils.BANK_ACCOUNT_KEY_PREFIX, utils.PRODUCT_KEY_PREFIX, utils.CUSTOMER_RECORD_KEY_PREFIX:
+		if len(ids) != 1 {
+			return "", utils.InvalidArgumentError("", "%s history takes 1 ID. Found %d", recordType, len(ids))
+		}
+	default:
+		return "", utils.InvalidArgumentError("", "history is not available for record type %s", recordType)
+	}
+	return utils.GetRecordKey(stub, recordType, ids...)
+}
+
+func GetRecordHistory(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Lists every change made to a BankAccount, Contract, Product or CustomerRecord, oldest
+		first, with the txID and timestamp of the transaction that made it. Returned as JSON.
+
+		Args:
+			RecordType (string): "BankAccount", "Contract", "Product" or "CustomerRecord"
+			ID (string): ID of the record. Contracts take {CreatorID, AppDevID, ProductID}
+	*/
+	var history []utils.RecordHistoryEntry
+	var key string
+	var err error
+
+	// Access control: Only a Beatchain Admin can invoke this transaction
+	if !transaction.TestMode && !utils.AuthenticateBeatchainAdmin(transaction) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Beatchain Admin Org. Access denied."))
+	}
+	if len(transaction.Args) < 2 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting at least 2: {RecordType, ID}. Found %d", len(transaction.Args)))
+	}
+	recordType := transaction.Args[0]
+
+	key, err = getHistoryKey(stub, recordType, transaction.Args[1:])
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	history, err = utils.GetRecordHistory(stub, recordType, key)
+	if err != nil {
+		return utils.ErrorResponse(utils.WrapError(err, "Error accessing history of %s", key))
+	}
+
+	page, err := utils.GetPage(stub, len(history))
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.PageResponse(stub, transaction, history[page.Offset:page.Offset+page.Returned], page)
+}
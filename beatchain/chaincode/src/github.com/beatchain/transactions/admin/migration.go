@@ -0,0 +1,65 @@
+/*
+Handles schema migration queries on ledger records
+*/
+
+package admin
+
+import (
+	"github.com/beatchain/utils"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+func MigrationStatus(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Reports how many records of each versioned type are stored at each schema version,
+		how many still await migration, and the outcome of the last upgrade-time migration.
+		Records below the current version are migrated lazily when read.
+
+		Args:
+			None
+	*/
+	var status *utils.MigrationStatus
+	var err error
+
+	// Access control: Only a Beatchain Admin can invoke this transaction
+	if !transaction.TestMode && !utils.AuthenticateBeatchainAdmin(transaction) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Beatchain Admin Org. Access denied."))
+	}
+	if len(transaction.Args) != 0 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "MigrationStatus takes no arguments"))
+	}
+
+	status, err = utils.GetMigrationStatus(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.SuccessResponse(stub, transaction, status)
+}
+
+func RebuildIndexes(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Rebuilds the secondary indexes from the records stored on the ledger, filling in
+		entries for records stored before their index existed. Returns the number of
+		entries written per index as JSON.
+
+		Args:
+			None
+	*/
+	var rebuild *utils.IndexRebuild
+	var err error
+
+	// Access control: Only a Beatchain Admin can invoke this transaction
+	if !transaction.TestMode && !utils.AuthenticateBeatchainAdmin(transaction) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Beatchain Admin Org. Access denied."))
+	}
+	if len(transaction.Args) != 0 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "RebuildIndexes takes no arguments"))
+	}
+
+	rebuild, err = utils.RebuildIndexes(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.SuccessResponse(stub, transaction, rebuild)
+}
@@ -0,0 +1,519 @@
+/*
+Handles offboarding Customers, Creators and AppDevs
+*/
+
+package admin
+
+import (
+	"strings"
+
+	"github.com/beatchain/transactions"
+	"github.com/beatchain/transactions/banking"
+	"github.com/beatchain/utils"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+func validateClose(txn *utils.Transaction, recordType string) (string, string, error) {
+	/*
+		Validates the inputs common to the Close* functions
+	*/
+	if len(txn.Args) != 2 {
+		return "", "", utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 2: {%sID, Disposition}. Found %d",
+			recordType, len(txn.Args))
+	}
+	disposition := txn.Args[1]
+	if disposition != transactions.CLOSE_PAYOUT && disposition != transactions.CLOSE_SWEEP {
+		return "", "", utils.InvalidArgumentError("Disposition", "Disposition must be %s or %s. Given: %s",
+			transactions.CLOSE_PAYOUT, transactions.CLOSE_SWEEP, disposition)
+	}
+	return txn.Args[0], disposition, nil
+}
+
+func checkOpenDisputes(stub shim.ChaincodeStubInterface, creatorId string, appDevId string) error {
+	/*
+		Refuses to offboard a party to an open dispute, as its ruling may move funds to or
+		from the party's bank account
+	*/
+	disputes, err := utils.GetDisputes(stub, creatorId, appDevId)
+	if err != nil {
+		return err
+	}
+	for _, dispute := range disputes {
+		if dispute.Status == transactions.DISPUTE_OPEN {
+			return utils.ConflictError("dispute %s must be resolved first", dispute.Id)
+		}
+	}
+	return nil
+}
+
+func checkHeldStreams(stub shim.ChaincodeStubInterface, customerRecord *utils.CustomerRecord) error {
+	/*
+		Refuses to offboard a Customer while any of their streams through their AppDevs is
+		held for review, as the review decides whether the usage is paid
+	*/
+	for appDevId := range customerRecord.Subscriptions {
+		streams, err := utils.GetFlaggedStreams(stub, appDevId, "")
+		if err != nil {
+			return err
+		}
+		for _, stream := range streams {
+			if stream.CustomerId == customerRecord.Id && stream.Status == transactions.STREAM_HELD {
+				return utils.ConflictError("held stream %s must be reviewed first", stream.Id)
+			}
+		}
+	}
+	return nil
+}
+
+func checkPendingWithdrawals(stub shim.ChaincodeStubInterface, bankAccountId string) error {
+	/*
+		Refuses to offboard an entity while a withdrawal from its bank account awaits an
+		admin's decision, as a rejection returns the held funds to the account
+	*/
+	withdrawals, err := utils.GetWithdrawals(stub, bankAccountId)
+	if err != nil {
+		return err
+	}
+	for _, withdrawal := range withdrawals {
+		if withdrawal.Status == transactions.WITHDRAWAL_PENDING {
+			return utils.ConflictError("withdrawal %s must be approved or rejected first", withdrawal.Id)
+		}
+	}
+	return nil
+}
+
+func checkPendingProposals(stub shim.ChaincodeStubInterface, bankAccountId string) error {
+	/*
+		Refuses to offboard an entity while a transfer proposal for its bank account may still
+		be approved, as its execution would move funds in or out of the released account
+	*/
+	txTime, err := utils.GetTxTime(stub)
+	if err != nil {
+		return err
+	}
+	proposals, err := utils.GetTransferProposals(stub)
+	if err != nil {
+		return err
+	}
+	for _, proposal := range proposals {
+		if proposal.BankAccountId == bankAccountId && proposal.Status == transactions.PROPOSAL_PENDING &&
+			!txTime.After(proposal.ExpiresAt) {
+			return utils.ConflictError("transfer proposal %s must be executed or expire first", proposal.Id)
+		}
+	}
+	return nil
+}
+
+func releaseBankAccount(stub shim.ChaincodeStubInterface, txn *utils.Transaction, accounts banking.BankAccounts,
+	tombstone *utils.Tombstone) error {
+	/*
+		Empties a closed entity's bank account and releases it for reassignment to a new
+		entity. The remaining balance is either swept to the Beatchain admin account, or
+		held in a pending withdrawal subject to the withdrawal limits, which an admin
+		approves once it is paid off-chain to the entity.
+	*/
+	bankAccount, err := accounts.Get(stub, tombstone.BankAccountId)
+	if err != nil {
+		return err
+	}
+	tombstone.FinalBalance = bankAccount.Balance
+
+	if tombstone.FinalBalance > 0.0 && tombstone.Disposition == transactions.CLOSE_SWEEP {
+		adminBankAccount, err := accounts.Get(stub, utils.BEATCHAIN_ADMIN_BANK_ACCOUNT_ID)
+		if err != nil {
+			return err
+		}
+		adminBankAccount.Balance = utils.RoundCents(float64(adminBankAccount.Balance + tombstone.FinalBalance))
+		err = utils.SetBankAccount(stub, adminBankAccount)
+		if err != nil {
+			return err
+		}
+		bankAccount.Balance = 0.0
+	} else if tombstone.FinalBalance > 0.0 {
+		withdrawal := &utils.Withdrawal{
+			RequesterType: tombstone.RecordType,
+			RequesterId:   tombstone.Id,
+			Amount:        tombstone.FinalBalance,
+			Closing:       true}
+		err = banking.HoldWithdrawal(stub, txn, bankAccount, withdrawal)
+		if err != nil {
+			return err
+		}
+		tombstone.WithdrawalId = withdrawal.Id
+	}
+
+	bankAccount.InUse = false
+	return utils.SetBankAccount(stub, bankAccount)
+}
+
+func terminateContract(stub shim.ChaincodeStubInterface, txn *utils.Transaction, accounts banking.BankAccounts,
+	contract *utils.Contract) (float32, error) {
+	/*
+		Terminates a contract with an offboarded party. The AppDev pays the Creator any
+		shortfall against the minimum guarantee of an accepted contract, whether or not its
+		term has ended, recorded as a guarantee top-up in a settlement without streams.
+
+		Returns:
+			shortfall: Amount paid to meet the minimum guarantee
+			err: Error object. nil if no error occurred.
+	*/
+	var terms *utils.ContractTerms
+	var shortfall float32
+	var err error
+
+	if contract.Status == transactions.ACCEPTED {
+		terms, err = utils.GetVerifiedContractTerms(stub, contract)
+		if err != nil {
+			return 0.0, err
+		}
+		shortfall = utils.GuaranteeShortfall(terms)
+	}
+
+	if shortfall > 0.0 {
+		appDevRecord, err := utils.GetAppDevRecord(stub, contract.AppDevId)
+		if err != nil {
+			return 0.0, err
+		}
+		appDevBankAccount, err := accounts.Get(stub, appDevRecord.BankAccountId)
+		if err != nil {
+			return 0.0, err
+		}
+		creatorRecord, err := utils.GetCreatorRecord(stub, contract.CreatorId)
+		if err != nil {
+			return 0.0, err
+		}
+		creatorBankAccount, err := accounts.Get(stub, creatorRecord.BankAccountId)
+		if err != nil {
+			return 0.0, err
+		}
+		if appDevBankAccount.Balance < shortfall {
+			return 0.0, utils.InsufficientFundsError("AppDev ID: %s Insufficient Funds for minimum guarantee shortfall of $%.2f",
+				contract.AppDevId, shortfall)
+		}
+
+		appDevBankAccount.Balance = utils.RoundCents(float64(appDevBankAccount.Balance - shortfall))
+		creatorBankAccount.Balance = utils.RoundCents(float64(creatorBankAccount.Balance + shortfall))
+		err = utils.SetBankAccount(stub, appDevBankAccount)
+		if err != nil {
+			return 0.0, err
+		}
+		err = utils.SetBankAccount(stub, creatorBankAccount)
+		if err != nil {
+			return 0.0, err
+		}
+
+		utils.ApplyGuaranteeTopUp(terms, shortfall)
+		err = utils.SetContractTerms(stub, terms)
+		if err != nil {
+			return 0.0, err
+		}
+
+		settlementId, err := utils.GetUniqueId(stub, txn)
+		if err != nil {
+			return 0.0, err
+		}
+		settledAt, err := utils.GetTxTime(stub)
+		if err != nil {
+			return 0.0, err
+		}
+		err = utils.SetSettlement(stub, &utils.Settlement{
+			Id:              settlementId,
+			CreatorId:       contract.CreatorId,
+			AppDevId:        contract.AppDevId,
+			ProductId:       contract.ProductId,
+			GuaranteeAmount: shortfall,
+			NetAmount:       shortfall,
+			SettledAt:       settledAt,
+			TxId:            stub.GetTxID()})
+		if err != nil {
+			return 0.0, err
+		}
+	}
+
+	contract.Status = transactions.TERMINATED
+	err = utils.SetContract(stub, contract)
+	if err != nil {
+		return 0.0, err
+	}
+	return shortfall, nil
+}
+
+func terminateContracts(stub shim.ChaincodeStubInterface, txn *utils.Transaction, accounts banking.BankAccounts,
+	contracts []*utils.Contract, tombstone *utils.Tombstone) error {
+	for _, contract := range contracts {
+		if contract.Status == transactions.TERMINATED {
+			continue
+		}
+		shortfall, err := terminateContract(stub, txn, accounts, contract)
+		if err != nil {
+			return utils.WrapError(err, "Error terminating Contract %s/%s/%s", contract.CreatorId, contract.AppDevId, contract.ProductId)
+		}
+		tombstone.ShortfallPaid = utils.RoundCents(float64(tombstone.ShortfallPaid + shortfall))
+		tombstone.TerminatedContracts += 1
+	}
+	return nil
+}
+
+func buryRecord(stub shim.ChaincodeStubInterface, txn *utils.Transaction, accounts banking.BankAccounts, record utils.Record,
+	tombstone *utils.Tombstone) pb.Response {
+	/*
+		Releases the closed entity's bank account, deletes its record and leaves a tombstone
+		in its place. Returns the tombstone as JSON.
+	*/
+	var err error
+
+	err = releaseBankAccount(stub, txn, accounts, tombstone)
+	if err != nil {
+		return utils.ErrorResponse(utils.WrapError(err, "Error releasing BA with id %s", tombstone.BankAccountId))
+	}
+
+	err = utils.DeleteRecord(stub, record)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	tombstone.ClosedBy = txn.CreatorId
+	tombstone.TxId = stub.GetTxID()
+	tombstone.ClosedAt, err = utils.GetTxTime(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	err = utils.SetTombstone(stub, tombstone)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	return utils.SuccessResponse(stub, txn, tombstone)
+}
+
+func CloseCustomer(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Offboards a Customer once none of their streams is held for review. Nothing is
+		settled here: their recorded streams count towards the products they played, and
+		are paid to the Creators by their AppDevs at the next CollectPayment. Their remaining
+		balance is paid out or swept, their bank account is released and their record is
+		replaced by a tombstone. Invoked by a Beatchain admin or the Customer's AppDev.
+
+		Args:
+			CustomerID (string): ID of the Customer to close
+			Disposition (string): "payout" to request a withdrawal of the remaining balance or
+				"sweep" to move it to the Beatchain admin account
+	*/
+	var customerRecord *utils.CustomerRecord
+	var err error
+
+	customerId, disposition, err := validateClose(txn, "Customer")
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	customerRecord, err = utils.GetCustomerRecord(stub, customerId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	// Access control: Only a Beatchain Admin or one of the Customer's AppDevs can invoke this transaction
+	_, subscribed := customerRecord.Subscriptions[txn.CreatorId]
+	if !txn.TestMode && !utils.AuthenticateBeatchainAdmin(txn) &&
+		!(utils.AuthenticateAppDev(txn) && subscribed) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller is not a Beatchain Admin or the Customer's AppDev. Access denied."))
+	}
+
+	err = checkHeldStreams(stub, customerRecord)
+	if err == nil {
+		err = checkPendingWithdrawals(stub, customerRecord.BankAccountId)
+	}
+	if err == nil {
+		err = checkPendingProposals(stub, customerRecord.BankAccountId)
+	}
+	if err != nil {
+		return utils.ErrorResponse(utils.WrapError(err, "Cannot close Customer %s", customerId))
+	}
+
+	return buryRecord(stub, txn, banking.BankAccounts{}, customerRecord, &utils.Tombstone{
+		RecordType:    utils.CUSTOMER_RECORD_KEY_PREFIX,
+		Id:            customerId,
+		BankAccountId: customerRecord.BankAccountId,
+		Disposition:   disposition})
+}
+
+func CloseCreator(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Offboards a Creator. Outstanding usage of their products is settled, their contracts
+		are terminated with any minimum guarantee shortfalls paid, and their products are
+		deactivated. Their remaining balance is paid out or swept, their bank account is
+		released and their record is replaced by a tombstone. Invoked by a Beatchain admin or
+		the Creator.
+
+		Args:
+			CreatorID (string): ID of the Creator to close
+			Disposition (string): "payout" to request a withdrawal of the remaining balance or
+				"sweep" to move it to the Beatchain admin account
+	*/
+	var creatorRecord *utils.CreatorRecord
+	var contracts []*utils.Contract
+	var products []*utils.Product
+	var run *banking.PaymentRun
+	var err error
+
+	creatorId, disposition, err := validateClose(txn, "Creator")
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	// Access control: Only a Beatchain Admin or the Creator can invoke this transaction
+	if !txn.TestMode && !utils.AuthenticateBeatchainAdmin(txn) &&
+		!(utils.AuthenticateCreator(txn) && txn.CreatorId == creatorId) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller is not a Beatchain Admin or the Creator. Access denied."))
+	}
+
+	creatorRecord, err = utils.GetCreatorRecord(stub, creatorId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	err = checkOpenDisputes(stub, creatorId, "")
+	if err == nil {
+		err = checkPendingWithdrawals(stub, creatorRecord.BankAccountId)
+	}
+	if err == nil {
+		err = checkPendingProposals(stub, creatorRecord.BankAccountId)
+	}
+	if err != nil {
+		return utils.ErrorResponse(utils.WrapError(err, "Cannot close Creator %s", creatorId))
+	}
+
+	// Settle outstanding usage before terminating contracts
+	accounts := banking.BankAccounts{}
+	run, err = banking.SettlePayments(stub, txn, creatorId, "", accounts)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if run.Exceptions != 0 {
+		return utils.ErrorResponse(utils.InsufficientFundsError("Cannot close Creator %s: AppDevs found with insufficient funds\n%s",
+			creatorId, strings.Join(run.Details, "\n")))
+	}
+
+	tombstone := &utils.Tombstone{
+		RecordType:    utils.CREATOR_RECORD_KEY_PREFIX,
+		Id:            creatorId,
+		BankAccountId: creatorRecord.BankAccountId,
+		Disposition:   disposition,
+		ShortfallPaid: utils.RoundCents(float64(run.TotalGuarantee))}
+
+	contracts, err = utils.GetCreatorContracts(stub, creatorId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	err = terminateContracts(stub, txn, accounts, contracts, tombstone)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	products, err = utils.GetCreatorProducts(stub, creatorId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	for _, product := range products {
+		product.IsActive = false
+		err = utils.SetProduct(stub, product)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+	}
+
+	return buryRecord(stub, txn, accounts, creatorRecord, tombstone)
+}
+
+func CloseAppDev(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Offboards an AppDev whose Customers have all been closed or unsubscribed. Usage owed
+		to each Creator is settled and the AppDev's contracts are terminated with any minimum
+		guarantee shortfalls paid. Its remaining balance is paid out or swept, its bank account is
+		released and its record is replaced by a tombstone. Invoked by a Beatchain admin or
+		the AppDev.
+
+		Args:
+			AppDevID (string): ID of the AppDev to close
+			Disposition (string): "payout" to request a withdrawal of the remaining balance or
+				"sweep" to move it to the Beatchain admin account
+	*/
+	var appDevRecord *utils.AppDevRecord
+	var customers []*utils.CustomerRecord
+	var contracts []*utils.Contract
+	var run *banking.PaymentRun
+	var err error
+
+	appDevId, disposition, err := validateClose(txn, "AppDev")
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	// Access control: Only a Beatchain Admin or the AppDev can invoke this transaction
+	if !txn.TestMode && !utils.AuthenticateBeatchainAdmin(txn) &&
+		!(utils.AuthenticateAppDev(txn) && txn.CreatorId == appDevId) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller is not a Beatchain Admin or the AppDev. Access denied."))
+	}
+
+	appDevRecord, err = utils.GetAppDevRecord(stub, appDevId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	customers, err = utils.GetAppDevCustomers(stub, appDevId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if len(customers) != 0 {
+		return utils.ErrorResponse(utils.ConflictError("Cannot close AppDev %s: %d customers must be closed or unsubscribed first", appDevId, len(customers)))
+	}
+
+	err = checkOpenDisputes(stub, "", appDevId)
+	if err == nil {
+		err = checkPendingWithdrawals(stub, appDevRecord.BankAccountId)
+	}
+	if err == nil {
+		err = checkPendingProposals(stub, appDevRecord.BankAccountId)
+	}
+	if err != nil {
+		return utils.ErrorResponse(utils.WrapError(err, "Cannot close AppDev %s", appDevId))
+	}
+
+	contracts, err = utils.GetAppDevContracts(stub, appDevId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	tombstone := &utils.Tombstone{
+		RecordType:    utils.APPDEV_RECORD_KEY_PREFIX,
+		Id:            appDevId,
+		BankAccountId: appDevRecord.BankAccountId,
+		Disposition:   disposition}
+
+	// Settle the usage owed to each Creator before terminating contracts
+	accounts := banking.BankAccounts{}
+	settled := make(map[string]bool)
+	for _, contract := range contracts {
+		if contract.Status == transactions.TERMINATED || settled[contract.CreatorId] {
+			continue
+		}
+		settled[contract.CreatorId] = true
+		run, err = banking.SettlePayments(stub, txn, contract.CreatorId, appDevId, accounts)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+		if run.Exceptions != 0 {
+			return utils.ErrorResponse(utils.InsufficientFundsError("Cannot close AppDev %s: insufficient funds to pay Creator %s\n%s",
+				appDevId, contract.CreatorId, strings.Join(run.Details, "\n")))
+		}
+		tombstone.ShortfallPaid = utils.RoundCents(float64(tombstone.ShortfallPaid + run.TotalGuarantee))
+	}
+
+	err = terminateContracts(stub, txn, accounts, contracts, tombstone)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	return buryRecord(stub, txn, accounts, appDevRecord, tombstone)
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	"sort"
 	"strings"
 
 	"github.com/beatchain/utils"
@@ -17,13 +18,16 @@ import (
 
 func ListBankAccounts(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
 	/*
-		Lists all of the bank accounts and their balances from the ledger
+		Lists all of the bank accounts and their balances from the ledger. May be paged;
+		see utils.GetPage.
 
 		Args:
 			transaction: Creator's transaction info
 
 	*/
 	var currentBankAccount *utils.BankAccount
+	var bankAccounts []*utils.BankAccount
+	var page *utils.Page
 
 	var jsonOutput []string
 	var err error
@@ -32,55 +36,64 @@ func ListBankAccounts(stub shim.ChaincodeStubInterface, transaction *utils.Trans
 
 	// Validate an ID is given
 	if !transaction.TestMode && transaction.CreatorId == "" {
-		return shim.Error(fmt.Sprintf("calling user ID not found"))
+		return utils.ErrorResponse(utils.ForbiddenError("calling user ID not found"))
 	}
 	// Validate no other args are specified
 	if len(transaction.Args) != 0 {
-		return shim.Error(fmt.Sprintf("ListBankAccounts takes no arguments"))
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "ListBankAccounts takes no arguments"))
 	}
 
 	// Create an iterator for fetching bank account keys
 	keysIterator, err = stub.GetStateByPartialCompositeKey("object~id", []string{utils.BANK_ACCOUNT_KEY_PREFIX})
 	if err != nil {
 		fmt.Print("Key iterator error: ")
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 	defer keysIterator.Close()
 
-	// Loop through keys and print account balances
+	// Loop through keys and collect the bank accounts
+	bankAccounts = []*utils.BankAccount{}
 	for keysIterator.HasNext() {
 		result, err := keysIterator.Next()
 		if err != nil {
-			// Errors print the current listing prior to the error for debug purposes
-			jsonOutput = append(jsonOutput, fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
-			return shim.Error(strings.Join(jsonOutput, "\n"))
+			return utils.ErrorResponse(utils.WrapError(err, "keys operation failed. Error accessing state"))
 		}
 		_, keyComponents, err := stub.SplitCompositeKey(result.Key)
 		if err != nil {
-			return shim.Error(err.Error())
+			return utils.ErrorResponse(err)
 		}
 		currentBankAccount, err = utils.GetBankAccount(stub, keyComponents[1])
 		if err != nil {
-			// Errors print the current listing prior to the error for debug purposes
-			jsonOutput = append(jsonOutput, fmt.Sprintf("keys operation failed. Error accessing Bank Account: %s", err))
-			return shim.Error(strings.Join(jsonOutput, "\n"))
+			return utils.ErrorResponse(utils.WrapError(err, "keys operation failed. Error accessing Bank Account"))
 		}
+		bankAccounts = append(bankAccounts, currentBankAccount)
+	}
+
+	page, err = utils.GetPage(stub, len(bankAccounts))
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	bankAccounts = bankAccounts[page.Offset : page.Offset+page.Returned]
+	for _, currentBankAccount = range bankAccounts {
 		jsonOutput = append(jsonOutput, fmt.Sprintf("Bank Account ID: %s Balance: %.2f", currentBankAccount.Id, currentBankAccount.Balance))
 	}
 	resultMsg := strings.Join(jsonOutput, "\n")
-	return shim.Success([]byte(resultMsg))
+	return utils.TextPageResponse(stub, transaction, bankAccounts, page, resultMsg)
 }
 
 
 func ListAllCustomers(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
 	/*
-		Lists all of the customer records and their details from the ledger
+		Lists all of the customer records and their details from the ledger. May be paged;
+		see utils.GetPage.
 
 		Args:
 			transaction: Creator's transaction info
 
 	*/
 	var currentCustomerRecord *utils.CustomerRecord
+	var customerRecords []*utils.CustomerRecord
+	var page *utils.Page
 
 	var jsonOutput []string
 	var err error
@@ -89,117 +102,123 @@ func ListAllCustomers(stub shim.ChaincodeStubInterface, transaction *utils.Trans
 
 	// Validate an ID is given
 	if !transaction.TestMode && transaction.CreatorId == "" {
-		return shim.Error(fmt.Sprintf("calling user ID not found"))
+		return utils.ErrorResponse(utils.ForbiddenError("calling user ID not found"))
 	}
 	// Validate no other args are specified
 	if len(transaction.Args) != 0 {
-		return shim.Error(fmt.Sprintf("ListCustomers takes no arguments"))
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "ListCustomers takes no arguments"))
 	}
 
 	// Create an iterator for fetching keys
 	keysIterator, err = stub.GetStateByPartialCompositeKey("object~id", []string{utils.CUSTOMER_RECORD_KEY_PREFIX})
 	if err != nil {
 		fmt.Print("Key iterator error: ")
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 	defer keysIterator.Close()
 
-	// Loop through keys and print account balances
+	// Loop through keys and collect the customer records
+	customerRecords = []*utils.CustomerRecord{}
 	for keysIterator.HasNext() {
 		result, err := keysIterator.Next()
 		if err != nil {
-			// Errors print the current listing prior to the error for debug purposes
-			jsonOutput = append(jsonOutput, fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
-			return shim.Error(strings.Join(jsonOutput, "\n"))
+			return utils.ErrorResponse(utils.WrapError(err, "keys operation failed. Error accessing state"))
 		}
 		_, keyComponents, err := stub.SplitCompositeKey(result.Key)
 		if err != nil {
-			return shim.Error(err.Error())
+			return utils.ErrorResponse(err)
 		}
 		currentCustomerRecord, err = utils.GetCustomerRecord(stub, keyComponents[1])
 		if err != nil {
-			// Errors print the current listing prior to the error for debug purposes
-			jsonOutput = append(jsonOutput, fmt.Sprintf("keys operation failed. Error accessing Bank Account: %s", err))
-			return shim.Error(strings.Join(jsonOutput, "\n"))
+			return utils.ErrorResponse(utils.WrapError(err, "keys operation failed. Error accessing Customer record"))
 		}
+		customerRecords = append(customerRecords, currentCustomerRecord)
+	}
+
+	page, err = utils.GetPage(stub, len(customerRecords))
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	customerRecords = customerRecords[page.Offset : page.Offset+page.Returned]
+	for _, currentCustomerRecord = range customerRecords {
 		msg := fmt.Sprintf(
 			"Customer ID: %s \n" +
-				"\tAppDevId: %s\n" +
 				"\tBankAccountId: %s\n" +
-				"\tSubscriptionFee: %0.2f\n" +
-				"\tSubscriptionDueDate: %s\n" +
 				"\tQueuedSong: %s\n" +
 				"\tPreviousSong: %s",
 			currentCustomerRecord.Id,
-			currentCustomerRecord.AppDevId,
 			currentCustomerRecord.BankAccountId,
-			currentCustomerRecord.SubscriptionFee,
-			currentCustomerRecord.SubscriptionDueDate.String(),
 			currentCustomerRecord.QueuedSong,
 			currentCustomerRecord.PreviousSong)
 		jsonOutput = append(jsonOutput, msg)
+
+		// List subscriptions in AppDev order so the output is deterministic
+		appDevIds := make([]string, 0, len(currentCustomerRecord.Subscriptions))
+		for appDevId := range currentCustomerRecord.Subscriptions {
+			appDevIds = append(appDevIds, appDevId)
+		}
+		sort.Strings(appDevIds)
+		for _, appDevId := range appDevIds {
+			subscription := currentCustomerRecord.Subscriptions[appDevId]
+			jsonOutput = append(jsonOutput, fmt.Sprintf(
+				"\tSubscription AppDevId: %s, SubscriptionFee: %0.2f, SubscriptionDueDate: %s",
+				subscription.AppDevId,
+				subscription.SubscriptionFee,
+				subscription.SubscriptionDueDate.String()))
+		}
 	}
 	resultMsg := strings.Join(jsonOutput, "\n")
-	return shim.Success([]byte(resultMsg))
+	return utils.TextPageResponse(stub, transaction, customerRecords, page, resultMsg)
 }
 
 func ListAppCustomers(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
 	/*
-		Lists all of the customer records and their details from the ledger for a particular app dev eg: spotify
+		Lists the customers subscribed through a particular app dev eg: spotify along with
+		their subscription details. Reads the AppDev's subscription index rather than every
+		customer on the ledger. May be paged; see utils.GetPage.
 
 		Args:
 			transaction: Creator's transaction info, AppdevID
 
 	*/
-	var currentCustomerRecord *utils.CustomerRecord
+	var customerRecords []*utils.CustomerRecord
+	var page *utils.Page
 
 	var jsonOutput []string
 	var err error
-	var keysIterator shim.StateQueryIteratorInterface
 
 
 	// Validate an ID is given
 	if !transaction.TestMode && transaction.CreatorId == "" {
-		return shim.Error(fmt.Sprintf("calling user ID not found"))
+		return utils.ErrorResponse(utils.ForbiddenError("calling user ID not found"))
 	}
 	// Validate no other args are specified
 	if len(transaction.Args) != 1 {
-		return shim.Error(fmt.Sprintf("ListCustomers takes 1 argument : {AppdevId}"))
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "ListAppCustomers takes 1 argument : {AppdevId}"))
 	}
 
 	appDevId := transaction.Args[0]
 	// check for valid AppDev
 	_, err = utils.GetAppDevRecord(stub, appDevId)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
-	// Create an iterator for fetching keys
-	keysIterator, err = stub.GetStateByPartialCompositeKey("object~id", []string{utils.CUSTOMER_RECORD_KEY_PREFIX})
+	customerRecords, err = utils.GetAppDevCustomers(stub, appDevId)
 	if err != nil {
-		fmt.Print("Key iterator error: ")
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
-	defer keysIterator.Close()
+	if customerRecords == nil {
+		customerRecords = []*utils.CustomerRecord{}
+	}
+	page, err = utils.GetPage(stub, len(customerRecords))
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	customerRecords = customerRecords[page.Offset : page.Offset+page.Returned]
 
-	// Loop through keys and print account balances
-	for keysIterator.HasNext() {
-		result, err := keysIterator.Next()
-		if err != nil {
-			// Errors print the current listing prior to the error for debug purposes
-			jsonOutput = append(jsonOutput, fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
-			return shim.Error(strings.Join(jsonOutput, "\n"))
-		}
-		_, keyComponents, err := stub.SplitCompositeKey(result.Key)
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		currentCustomerRecord, err = utils.GetCustomerRecord(stub, keyComponents[1])
-		if err != nil {
-			// Errors print the current listing prior to the error for debug purposes
-			jsonOutput = append(jsonOutput, fmt.Sprintf("keys operation failed. Error accessing Bank Account: %s", err))
-			return shim.Error(strings.Join(jsonOutput, "\n"))
-		}
+	for _, currentCustomerRecord := range customerRecords {
+		subscription := currentCustomerRecord.Subscriptions[appDevId]
 		msg := fmt.Sprintf(
 			"Customer ID: %s \n" +
 				"\tAppDevId: %s\n" +
@@ -209,19 +228,126 @@ func ListAppCustomers(stub shim.ChaincodeStubInterface, transaction *utils.Trans
 				"\tQueuedSong: %s\n" +
 				"\tPreviousSong: %s",
 			currentCustomerRecord.Id,
-			currentCustomerRecord.AppDevId,
+			subscription.AppDevId,
 			currentCustomerRecord.BankAccountId,
-			currentCustomerRecord.SubscriptionFee,
-			currentCustomerRecord.SubscriptionDueDate.String(),
+			subscription.SubscriptionFee,
+			subscription.SubscriptionDueDate.String(),
 			currentCustomerRecord.QueuedSong,
 			currentCustomerRecord.PreviousSong)
-		
-		if appDevId == currentCustomerRecord.AppDevId {
-			jsonOutput = append(jsonOutput, msg)
-		}
-
-		
+		jsonOutput = append(jsonOutput, msg)
 	}
 	resultMsg := strings.Join(jsonOutput, "\n")
-	return shim.Success([]byte(resultMsg))
-}
\ No newline at end of file
+	return utils.TextPageResponse(stub, transaction, customerRecords, page, resultMsg)
+}
+
+func ListCreatorProducts(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Lists the products of a Creator as JSON using the Creator product index. May be
+		paged; see utils.GetPage.
+
+		Args:
+			CreatorID (string): ID of the Creator
+	*/
+	var products []*utils.Product
+	var err error
+
+	// Validate an ID is given
+	if !transaction.TestMode && transaction.CreatorId == "" {
+		return utils.ErrorResponse(utils.ForbiddenError("calling user ID not found"))
+	}
+	if len(transaction.Args) != 1 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "ListCreatorProducts takes 1 argument: {CreatorID}. Found %d", len(transaction.Args)))
+	}
+	_, err = utils.GetCreatorRecord(stub, transaction.Args[0])
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	products, err = utils.GetCreatorProducts(stub, transaction.Args[0])
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if products == nil {
+		products = []*utils.Product{}
+	}
+	page, err := utils.GetPage(stub, len(products))
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.PageResponse(stub, transaction, products[page.Offset:page.Offset+page.Returned], page)
+}
+
+func ListAppDevContracts(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Lists the contracts of an AppDev as JSON using the AppDev contract index. AppDevs
+		may only list their own contracts. May be paged; see utils.GetPage.
+
+		Args:
+			AppDevID (string): ID of the AppDev
+	*/
+	var contracts []*utils.Contract
+	var err error
+
+	if len(transaction.Args) != 1 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "ListAppDevContracts takes 1 argument: {AppDevID}. Found %d", len(transaction.Args)))
+	}
+	appDevId := transaction.Args[0]
+	// Access control: Only a Beatchain Admin or the AppDev can invoke this transaction
+	if !transaction.TestMode && !utils.AuthenticateBeatchainAdmin(transaction) &&
+		!(utils.AuthenticateAppDev(transaction) && transaction.CreatorId == appDevId) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller is not a Beatchain Admin or the AppDev. Access denied."))
+	}
+	_, err = utils.GetAppDevRecord(stub, appDevId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	contracts, err = utils.GetAppDevContracts(stub, appDevId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return contractPageResponse(stub, transaction, contracts)
+}
+
+func ListProductContracts(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Lists the contracts of a product as JSON using the product contract index. Creators
+		may only list the contracts of their own products. May be paged; see utils.GetPage.
+
+		Args:
+			ProductID (string): ID of the Product
+	*/
+	var product *utils.Product
+	var contracts []*utils.Contract
+	var err error
+
+	if len(transaction.Args) != 1 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "ListProductContracts takes 1 argument: {ProductID}. Found %d", len(transaction.Args)))
+	}
+	product, err = utils.GetProduct(stub, transaction.Args[0])
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	// Access control: Only a Beatchain Admin or the product's Creator can invoke this transaction
+	if !transaction.TestMode && !utils.AuthenticateBeatchainAdmin(transaction) &&
+		!(utils.AuthenticateCreator(transaction) && transaction.CreatorId == product.CreatorId) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller is not a Beatchain Admin or the product's Creator. Access denied."))
+	}
+
+	contracts, err = utils.GetProductContracts(stub, product.Id)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return contractPageResponse(stub, transaction, contracts)
+}
+
+func contractPageResponse(stub shim.ChaincodeStubInterface, transaction *utils.Transaction, contracts []*utils.Contract) pb.Response {
+	if contracts == nil {
+		contracts = []*utils.Contract{}
+	}
+	page, err := utils.GetPage(stub, len(contracts))
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.PageResponse(stub, transaction, contracts[page.Offset:page.Offset+page.Returned], page)
+}
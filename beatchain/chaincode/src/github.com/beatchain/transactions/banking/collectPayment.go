@@ -1,13 +1,14 @@
 package banking
 
 import (
-	"errors"
 	"fmt"
+	"github.com/beatchain/transactions"
 	"github.com/beatchain/utils"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 	"math"
 	"strings"
+	"time"
 )
 
 func validateCollectPayment(transaction *utils.Transaction) error {
@@ -16,69 +17,177 @@ func validateCollectPayment(transaction *utils.Transaction) error {
 	*/
 	// Access control: Only an Customer Org member can invoke this transaction
 	if !transaction.TestMode && !utils.AuthenticateCreator(transaction) {
-		return errors.New(fmt.Sprintf("caller not a member of Creator Org. Access denied"))
+		return utils.ForbiddenError("caller not a member of Creator Org. Access denied")
 	}
 	if transaction.TestMode {
 		transaction.CreatorId = utils.TEST_CREATOR_ID
 	}
 	// Validate an ID is given
 	if transaction.CreatorId == "" {
-		return errors.New(fmt.Sprintf("user ID not found"))
+		return utils.ForbiddenError("user ID not found")
 	}
 	// Validate no other args are specified
 	if len(transaction.Args) != 0 {
-		return errors.New(fmt.Sprintf("CollectPayment takes no arguments"))
+		return utils.InvalidArgumentError("", "CollectPayment takes no arguments")
 	}
 	return nil
 }
 
+type PaymentRun struct {
+	/*
+		Defines the outcome of settling a Creator's contracts
+	*/
+	Details        []string            `json:"-"`              // Description of each payment and exception
+	Settlements    []*utils.Settlement `json:"settlements"`    // Breakdown of each payment made
+	Shortfalls     []*PaymentShortfall `json:"shortfalls"`     // Payments skipped for insufficient AppDev funds
+	TotalPayment   float32             `json:"totalpayment"`   // Cash paid to the Creator
+	TotalRecouped  float32             `json:"totalrecouped"`  // Earnings recouped against advances
+	TotalGuarantee float32             `json:"totalguarantee"` // Portion of the cash paid to meet minimum guarantees
+	Exceptions     int32               `json:"exceptions"`     // Number of shortfalls
+}
+
+type PaymentShortfall struct {
+	/*
+		Defines a payment skipped as the AppDev could not cover it
+	*/
+	AppDevId    string  `json:"appdevid"`
+	ContractKey string  `json:"contractkey"`
+	Amount      float32 `json:"amount"`
+}
+
+// Bank accounts read by a transaction, keyed by ID
+type BankAccounts map[string]*utils.BankAccount
+
+func (accounts BankAccounts) Get(stub shim.ChaincodeStubInterface, bankAccountId string) (*utils.BankAccount, error) {
+	/*
+		Fetches a bank account once per transaction, so that each step of a multi-step
+		transaction such as offboarding works on the balances left by the steps before it
+	*/
+	bankAccount, ok := accounts[bankAccountId]
+	if ok {
+		return bankAccount, nil
+	}
+	bankAccount, err := utils.GetBankAccount(stub, bankAccountId)
+	if err != nil {
+		return nil, err
+	}
+	accounts[bankAccountId] = bankAccount
+	return bankAccount, nil
+}
+
 func CollectPayment(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
 	/*
 		Processes payment for a creator by accumulating all product streams and withdrawing payments from the
-		AppDev accounts from whom the product was streamed.
+		AppDev accounts from whom the product was streamed. See SettlePayments.
 
 		Args:
 			transaction: Creator's transaction info
 
 	*/
+	var run *PaymentRun
+	var err error
+
+	// Validate inputs
+	err = validateCollectPayment(transaction)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	run, err = SettlePayments(stub, transaction, transaction.CreatorId, "", BankAccounts{})
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	paymentDetails := run.Details
+
+	if run.TotalRecouped > 0 {
+		paymentDetails = append(paymentDetails, fmt.Sprintf("Total Recouped against Advances: %.2f", run.TotalRecouped))
+	}
+
+	if run.TotalPayment == 0 && run.TotalRecouped > 0 && run.Exceptions == 0 {
+		// Earnings went entirely towards recouping advances
+		paymentDetails = append(paymentDetails, "No cash payments made. All earnings recouped against advances.")
+	} else if run.TotalPayment == 0 && run.Exceptions == 0 {
+		// If there were no payments and no insufficient fund warnings, return with the message
+		paymentDetails = []string{"No payable opportunities found."}
+	} else if run.TotalPayment == 0 && run.Exceptions != 0 {
+		paymentDetails = append(paymentDetails, "No payments made. AppDevs found with insufficient funds")
+	} else {
+		// Return final details message to the Creator
+		paymentDetails = append(paymentDetails, fmt.Sprintf("Total Payment: %.2f", run.TotalPayment))
+		if run.Exceptions != 0 {
+			paymentDetails = append(paymentDetails, "WARNING: AppDevs found with insufficient funds")
+		}
+	}
+	return utils.TextResponse(stub, transaction, run, strings.Join(paymentDetails, "\n"))
+}
+
+func SettlePayments(stub shim.ChaincodeStubInterface, transaction *utils.Transaction, creatorId string, appDevId string,
+	accounts BankAccounts) (*PaymentRun, error) {
+	/*
+		Settles a creator's contracts by accumulating all product streams and withdrawing payments from the
+		AppDev accounts from whom the product was streamed. Earnings under a contract with an outstanding
+		advance are first recouped against the advance before any cash is withdrawn. If the AppDev has an
+		open invoice to the creator, the invoiced streams are settled at the invoiced rate and the invoice
+		is marked settled once all of its lines are paid. Engagement metrics are paid alongside streams
+		at the contract's per-metric rates. If the Creator has received less than the minimum guarantee
+		of an accepted contract whose guarantee term has ended, the shortfall is paid on top and
+		recouped from later earnings, so the Creator is paid the greater of their earnings and the
+		guarantee. Terminated contracts are skipped.
+
+		Args:
+			transaction: Caller's transaction info
+			creatorId: ID of the Creator being paid
+			appDevId: If given, only contracts with this AppDev are settled
+			accounts: Bank accounts already read by the transaction. Accounts the run reads
+				are added to it.
+
+		Returns:
+			run: Payments made and exceptions found
+			err: Error object. nil if no error occurred.
+	*/
 	var appDevRecord *utils.AppDevRecord
 	var creatorRecord *utils.CreatorRecord
 	var currentProduct *utils.Product
 	var currentContract *utils.Contract
+	var currentTerms *utils.ContractTerms
+	var currentInvoice *utils.Invoice
+	var currentInvoiceLine *utils.InvoiceLine
+	var openInvoices map[string]*utils.Invoice
 	var creatorBankAccount, appDevBankAccount *utils.BankAccount
 	var keysIterator shim.StateQueryIteratorInterface
-	var paymentExceptions int32
-	var payment, totalPayment float32
-	var currentAppDevId, currentProductId string
-	var paymentDetails []string
+	var invoiceChecked bool
+	var payment, payPerStream, metricsPayment, recouped, guaranteePayment, cashPayment float32
+	var metrics map[string]int64
+	var streams, settledMetrics int64
+	var currentAppDevId, currentProductId, settlementId, invoiceId string
+	var settledAt time.Time
 	var err error
 
-	// Validate inputs
-	err = validateCollectPayment(transaction)
+	// lookup Creator's record
+	creatorRecord, err = utils.GetCreatorRecord(stub, creatorId)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, utils.WrapError(err, "Error accessing creatorRecord with id %s", creatorId)
 	}
 
-	// lookup Creator's record
-	creatorRecord, err = utils.GetCreatorRecord(stub, transaction.CreatorId)
+	// lookup Creator's  Bank Account
+	creatorBankAccount, err = accounts.Get(stub, creatorRecord.BankAccountId)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Error accessing creatorRecord with id %s: %s", transaction.CreatorId, err.Error()))
+		return nil, utils.WrapError(err, "Error accessing creatorRecord BA with id %s", creatorRecord.BankAccountId)
 	}
 
-	// lookup Creator's  Bank Account
-	creatorBankAccount, err = utils.GetBankAccount(stub, transaction.CreatorId)
+	settledAt, err = utils.GetTxTime(stub)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Error accessing creatorRecord BA with id %s: %s", creatorRecord.BankAccountId, err.Error()))
+		return nil, err
 	}
 
-	totalPayment = 0.0
-	paymentExceptions = 0
+	run := &PaymentRun{Settlements: []*utils.Settlement{}, Shortfalls: []*PaymentShortfall{}}
+	openInvoices = make(map[string]*utils.Invoice)
 
 	// Create an iterator for fetching creator's contract keys
-	keysIterator, err = stub.GetStateByPartialCompositeKey(utils.KEY_OBJECT_FORMAT, []string{utils.CONTRACT_KEY_PREFIX, transaction.CreatorId})
+	keysIterator, err = stub.GetStateByPartialCompositeKey(utils.KEY_OBJECT_FORMAT, []string{utils.CONTRACT_KEY_PREFIX, creatorId})
 	if err != nil {
 		fmt.Print("Key iterator error: ")
-		return shim.Error(err.Error())
+		return nil, err
 	}
 	defer keysIterator.Close()
 
@@ -87,66 +196,134 @@ func CollectPayment(stub shim.ChaincodeStubInterface, transaction *utils.Transac
 		result, err := keysIterator.Next()
 		if err != nil {
 			// Errors print the current listing prior to the error for debug purposes
-			return shim.Error(fmt.Sprintf("Contract iteration operation failed: %s", err.Error()))
+			return nil, utils.WrapError(err, "Contract iteration operation failed")
 		}
 
 		// Split the key into appDevId and productId
 		_, currentAppDevId, currentProductId, err = utils.SplitContractKey(stub, result.Key)
 		if err != nil {
-			return shim.Error(err.Error())
+			return nil, err
+		}
+		if appDevId != "" && currentAppDevId != appDevId {
+			continue
 		}
 
 		// Fetch the contract
-		currentContract, err = utils.GetContract(stub, transaction.CreatorId, currentAppDevId, currentProductId)
+		currentContract, err = utils.GetContract(stub, creatorId, currentAppDevId, currentProductId)
 		if err != nil {
 			// Errors print the current listing prior to the error for debug purposes
-			return shim.Error(fmt.Sprintf("Error accessing Contract with key %s: %s", result.Key, err.Error()))
+			return nil, utils.WrapError(err, "Error accessing Contract with key %s", result.Key)
+		}
+		if currentContract.Status == transactions.TERMINATED {
+			// Terminated contracts were settled when the party was offboarded
+			continue
+		}
+		// Fetch the private terms, verifying the rate against the public hash
+		currentTerms, err = utils.GetVerifiedContractTerms(stub, currentContract)
+		if err != nil {
+			return nil, utils.WrapError(err, "Error accessing terms of Contract with key %s", result.Key)
 		}
 		// lookup AppDev record
 		appDevRecord, err = utils.GetAppDevRecord(stub, currentAppDevId)
 		if err != nil {
-			return shim.Error(fmt.Sprintf("Error accessing appDevRecord with id %s: %s", currentAppDevId, err.Error()))
+			return nil, utils.WrapError(err, "Error accessing appDevRecord with id %s", currentAppDevId)
 		}
 
 		// lookup AppDev Bank Account
-		appDevBankAccount, err = utils.GetBankAccount(stub, appDevRecord.BankAccountId)
+		appDevBankAccount, err = accounts.Get(stub, appDevRecord.BankAccountId)
 		if err != nil {
-			return shim.Error(fmt.Sprintf("Error accessing appDevRecord BA with id %s: %s", appDevRecord.BankAccountId, err.Error()))
+			return nil, utils.WrapError(err, "Error accessing appDevRecord BA with id %s", appDevRecord.BankAccountId)
 		}
 
 		// lookup product record
 		currentProduct, err = utils.GetProduct(stub, currentProductId)
 		if err != nil {
-			return shim.Error(fmt.Sprintf("Error accessing product with id %s: %s", currentProductId, err.Error()))
+			return nil, utils.WrapError(err, "Error accessing product with id %s", currentProductId)
 		}
 		if !currentProduct.IsActive {
 			// Skip "deleted" products
 			continue
 		}
 
+		// Settle the invoiced streams at the invoiced rate if the AppDev has an open invoice
+		streams = currentProduct.UnRenumeratedListens
+		payPerStream = currentTerms.CreatorPayPerStream
+		currentInvoice, invoiceChecked = openInvoices[currentAppDevId]
+		if !invoiceChecked {
+			currentInvoice, _, err = GetOpenInvoice(stub, creatorId, currentAppDevId)
+			if err != nil {
+				return nil, utils.WrapError(err, "Error accessing invoices for appDev with id %s", currentAppDevId)
+			}
+			openInvoices[currentAppDevId] = currentInvoice
+		}
+		currentInvoiceLine = nil
+		invoiceId = ""
+		if currentInvoice != nil {
+			for i := range currentInvoice.Lines {
+				if currentInvoice.Lines[i].ProductId == currentProductId && currentInvoice.Lines[i].SettlementId == "" {
+					currentInvoiceLine = &currentInvoice.Lines[i]
+				}
+			}
+		}
+		metrics = make(map[string]int64)
+		for metricType, count := range currentContract.UnRenumeratedMetricCounts {
+			metrics[metricType] = count
+		}
+		if currentInvoiceLine != nil {
+			if currentInvoiceLine.Streams < streams {
+				streams = currentInvoiceLine.Streams
+			}
+			payPerStream = currentInvoiceLine.PayPerStream
+			invoiceId = currentInvoice.Id
+			for metricType, count := range metrics {
+				if currentInvoiceLine.Metrics[metricType] < count {
+					metrics[metricType] = currentInvoiceLine.Metrics[metricType]
+				}
+			}
+		}
+
 		// Attempt to transfer funds
 		// Exchange funds, taking care that cents are appropriately handled
-		payment64 := float64(currentProduct.UnRenumeratedListens) * float64(currentContract.CreatorPayPerStream)
-		payment = float32(math.Round(payment64*100)/100)
+		payment64 := float64(streams) * float64(payPerStream)
+		metricsPayment = utils.PriceMetrics(currentTerms, metrics)
+		payment = utils.RoundCents(math.Round(payment64*100)/100 + float64(metricsPayment))
+
+		// Recoup any outstanding advance before paying out cash
+		recouped, cashPayment = utils.SplitRecoupment(currentTerms, payment)
 
-		if payment == 0.0 {
+		// Top the Creator's payments up to the minimum guarantee once its term has ended
+		guaranteePayment = 0.0
+		if currentContract.Status == transactions.ACCEPTED && utils.GuaranteeDue(currentTerms, settledAt) {
+			guaranteePayment = utils.GuaranteeTopUp(currentTerms, payment, recouped)
+			cashPayment = utils.RoundCents(float64(cashPayment + guaranteePayment))
+		}
+
+		if payment == 0.0 && guaranteePayment == 0.0 {
 			// No payment needed; skip processing
 			continue
 		}
 
-		if appDevBankAccount.Balance < payment {
+		if appDevBankAccount.Balance < cashPayment {
 			// AppDev has insufficient funds to pay the creator; Note the exception to the user and continue
-			paymentExceptions += 1
+			run.Exceptions += 1
+			run.Shortfalls = append(run.Shortfalls, &PaymentShortfall{
+				AppDevId: currentAppDevId,
+				ContractKey: result.Key,
+				Amount: cashPayment})
 			msg := fmt.Sprintf(
 				"WARNING! AppDev ID: %s Insufficient Funds for payment of %.2f in accordance with Contract %s",
-				currentAppDevId, payment, result.Key)
-			paymentDetails = append(paymentDetails, msg)
+				currentAppDevId, cashPayment, result.Key)
+			run.Details = append(run.Details, msg)
 			continue
 		}
 		// If appDev has the funds, go ahead and process payment
-		appDevBankAccount.Balance -= payment
-		creatorBankAccount.Balance += payment
-		totalPayment += payment
+		appDevBankAccount.Balance -= cashPayment
+		creatorBankAccount.Balance += cashPayment
+		run.TotalPayment += cashPayment
+		run.TotalRecouped += recouped
+		run.TotalGuarantee += guaranteePayment
+		utils.ApplyRecoupment(currentTerms, payment, recouped)
+		utils.ApplyGuaranteeTopUp(currentTerms, guaranteePayment)
 
 		// Print out the details for the payment
 		msg := fmt.Sprintf(
@@ -154,51 +331,103 @@ func CollectPayment(stub shim.ChaincodeStubInterface, transaction *utils.Transac
 				"\tAppDev ID: %s \n" +
 				"\tNum. Streams: %d\n" +
 				"\tPayment per Stream: $%.4f\n" +
+				"\tNum. Metrics: %d\n" +
+				"\tPayment for Metrics: $%.2f\n" +
+				"\tRecouped against Advance: $%.2f\n" +
+				"\tPaid towards Minimum Guarantee: $%.2f\n" +
+				"\tRemaining Advance Balance: $%.2f\n" +
 				"\tIn accordance with Contract: %s",
-			payment, currentAppDevId, currentProduct.UnRenumeratedListens, currentContract.CreatorPayPerStream, result.Key)
-		paymentDetails = append(paymentDetails, msg)
+			cashPayment, currentAppDevId, streams, payPerStream, utils.CountMetrics(metrics), metricsPayment,
+			recouped, guaranteePayment, currentTerms.RecoupBalance, result.Key)
+		run.Details = append(run.Details, msg)
+
+		// Persist the settlement for earnings statements
+		settlementId, err = utils.GetUniqueId(stub, transaction)
+		if err != nil {
+			return nil, err
+		}
+		settlement := &utils.Settlement{
+			Id: settlementId,
+			CreatorId: creatorId,
+			AppDevId: currentAppDevId,
+			ProductId: currentProductId,
+			Streams: streams,
+			PayPerStream: payPerStream,
+			GrossAmount: payment,
+			RecoupedAmount: recouped,
+			GuaranteeAmount: guaranteePayment,
+			Metrics: metrics,
+			MetricsAmount: metricsPayment,
+			NetAmount: cashPayment,
+			SettledAt: settledAt,
+			TxId: stub.GetTxID(),
+			InvoiceId: invoiceId}
+		err = utils.SetSettlement(stub, settlement)
+		if err != nil {
+			return nil, err
+		}
+		run.Settlements = append(run.Settlements, settlement)
+		if currentInvoiceLine != nil {
+			currentInvoiceLine.SettlementId = settlementId
+		}
 
-		// Reset product metrics
-		currentProduct.TotalListens += currentProduct.UnRenumeratedListens
-		currentProduct.TotalMetrics += currentProduct.UnRenumeratedMetrics
-		currentProduct.UnRenumeratedListens = 0
-		currentProduct.UnRenumeratedMetrics = 0
+		// Reset product and contract metrics
+		settledMetrics = utils.CountMetrics(metrics)
+		for metricType, count := range metrics {
+			currentContract.UnRenumeratedMetricCounts[metricType] -= count
+			if currentContract.UnRenumeratedMetricCounts[metricType] == 0 {
+				delete(currentContract.UnRenumeratedMetricCounts, metricType)
+			}
+		}
+		currentProduct.TotalListens += streams
+		currentProduct.TotalMetrics += settledMetrics
+		currentProduct.UnRenumeratedListens -= streams
+		currentProduct.UnRenumeratedMetrics -= settledMetrics
 
 		// Update changes ledger
 		err = utils.SetProduct(stub, currentProduct)
 		if err != nil {
-			return shim.Error(err.Error())
+			return nil, err
 		}
 		err = utils.SetBankAccount(stub, appDevBankAccount)
 		if err != nil {
-			return shim.Error(err.Error())
+			return nil, err
+		}
+		err = utils.SetContractTerms(stub, currentTerms)
+		if err != nil {
+			return nil, err
+		}
+		if settledMetrics > 0 {
+			err = utils.SetContract(stub, currentContract)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	if totalPayment == 0 && paymentExceptions == 0 {
-		// If there were no payments and no insufficient fund warnings, return with the message
-		resultMsg := "No payable opportunities found."
-		return shim.Success([]byte(resultMsg))
-	} else if totalPayment == 0 && paymentExceptions != 0 {
-		msg := fmt.Sprintf("No payments made. AppDevs found with insufficient funds")
-		paymentDetails = append(paymentDetails, msg)
-		resultMsg := strings.Join(paymentDetails, "\n")
-		return shim.Success([]byte(resultMsg))
-	} else {
-		// Submit final payment to the creator on the ledger
-		err = utils.SetBankAccount(stub, creatorBankAccount)
+	// Save settled invoice lines, closing out fully settled invoices
+	for _, currentInvoice = range openInvoices {
+		if currentInvoice == nil {
+			continue
+		}
+		currentInvoice.Status = transactions.INVOICE_SETTLED
+		for _, line := range currentInvoice.Lines {
+			if line.SettlementId == "" {
+				currentInvoice.Status = transactions.INVOICE_OPEN
+			}
+		}
+		err = utils.SetInvoice(stub, currentInvoice)
 		if err != nil {
-			return shim.Error(err.Error())
+			return nil, err
 		}
+	}
 
-		// Return final details message to the Creator
-		paymentDetails = append(paymentDetails, fmt.Sprintf("Total Payment: %.2f", totalPayment))
-		if paymentExceptions != 0 {
-			paymentDetails = append(paymentDetails, fmt.Sprintf("WARNING: AppDevs found with insufficient funds"))
+	if run.TotalPayment > 0 {
+		// Submit final payment to the creator on the ledger
+		err = utils.SetBankAccount(stub, creatorBankAccount)
+		if err != nil {
+			return nil, err
 		}
-		resultMsg := strings.Join(paymentDetails, "\n")
-		return shim.Success([]byte(resultMsg))
 	}
-
-
+	return run, nil
 }
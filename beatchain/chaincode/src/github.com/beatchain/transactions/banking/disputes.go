@@ -0,0 +1,396 @@
+/*
+Handles disputes between Creators and AppDevs over settlements, and their arbitration by
+admins
+*/
+package banking
+
+import (
+	"encoding/hex"
+	"math"
+	"strconv"
+
+	"github.com/beatchain/transactions"
+	"github.com/beatchain/utils"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+func validateOpenDispute(transaction *utils.Transaction) (string, float32, error) {
+	/*
+		Validates the inputs to the OpenDispute function
+
+		Returns:
+			claimant: CREATOR_RECORD_KEY_PREFIX or APPDEV_RECORD_KEY_PREFIX
+			amount: Amount in dispute
+			err: Error object. nil if no error occurred.
+	*/
+	var claimant string
+
+	if len(transaction.Args) != 6 {
+		return "", 0.0, utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 6: {CreatorID, AppDevID, ProductID, SettlementID, Amount, Reason}. Found %d", len(transaction.Args))
+	}
+
+	// Access control: Only the disputing Creator or AppDev can invoke this transaction
+	if transaction.TestMode {
+		claimant = utils.CREATOR_RECORD_KEY_PREFIX
+	} else if utils.AuthenticateCreator(transaction) && transaction.CreatorId == transaction.Args[0] {
+		claimant = utils.CREATOR_RECORD_KEY_PREFIX
+	} else if utils.AuthenticateAppDev(transaction) && transaction.CreatorId == transaction.Args[1] {
+		claimant = utils.APPDEV_RECORD_KEY_PREFIX
+	} else {
+		return "", 0.0, utils.ForbiddenError("caller is not the disputing Creator or AppDev. Access denied")
+	}
+
+	if transaction.Args[3] == "" {
+		return "", 0.0, utils.InvalidArgumentError("SettlementID", "a SettlementID must be given for the dispute")
+	}
+
+	amount, err := parseAmount(transaction.Args[4])
+	if err != nil {
+		return "", 0.0, err
+	}
+	if transaction.Args[5] == "" {
+		return "", 0.0, utils.InvalidArgumentError("Reason", "a Reason must be given for the dispute")
+	}
+	return claimant, amount, nil
+}
+
+func disputeParty(transaction *utils.Transaction, dispute *utils.Dispute) (string, error) {
+	/*
+		Identifies the calling party to a dispute
+
+		Returns:
+			party: CREATOR_RECORD_KEY_PREFIX or APPDEV_RECORD_KEY_PREFIX
+			err: Error object. nil if no error occurred.
+	*/
+	if transaction.TestMode {
+		return utils.CREATOR_RECORD_KEY_PREFIX, nil
+	}
+	if utils.AuthenticateCreator(transaction) && transaction.CreatorId == dispute.CreatorId {
+		return utils.CREATOR_RECORD_KEY_PREFIX, nil
+	}
+	if utils.AuthenticateAppDev(transaction) && transaction.CreatorId == dispute.AppDevId {
+		return utils.APPDEV_RECORD_KEY_PREFIX, nil
+	}
+	return "", utils.ForbiddenError("Caller is not a party to dispute %s. Access denied.", dispute.Id)
+}
+
+func partyBankAccountId(stub shim.ChaincodeStubInterface, dispute *utils.Dispute, party string) (string, error) {
+	/*
+		Looks up the bank account of a party to a dispute
+	*/
+	if party == utils.CREATOR_RECORD_KEY_PREFIX {
+		creatorRecord, err := utils.GetCreatorRecord(stub, dispute.CreatorId)
+		if err != nil {
+			return "", err
+		}
+		return creatorRecord.BankAccountId, nil
+	}
+	appDevRecord, err := utils.GetAppDevRecord(stub, dispute.AppDevId)
+	if err != nil {
+		return "", err
+	}
+	return appDevRecord.BankAccountId, nil
+}
+
+func OpenDispute(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Opens a dispute against a settlement paid by CollectPayment. A Creator claims the
+		streams it paid for were under-reported and the AppDev owes more; an AppDev claims the
+		settlement overpaid the Creator. The disputed amount is held out of the other party's
+		balance until an admin resolves the dispute. Each party may hold one open dispute per
+		settlement, and claims are capped at the settlement's net amount less the amounts held
+		by open disputes and awarded by resolved disputes over it. Returns the dispute as JSON.
+
+		Args:
+			CreatorID (string): ID of the contract's Creator
+			AppDevID (string): ID of the contract's AppDev
+			ProductID (string): ID of the contract's Product
+			SettlementID (string): ID of the disputed Settlement
+			Amount (string): Amount in dispute in $USD
+			Reason (string): Grounds for the dispute
+	*/
+	var claimant, respondent string
+	var settlement *utils.Settlement
+	var heldAccount *utils.BankAccount
+	var disputes []*utils.Dispute
+	var amount, claimed float32
+	var err error
+
+	claimant, amount, err = validateOpenDispute(transaction)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	dispute := &utils.Dispute{
+		CreatorId:    transaction.Args[0],
+		AppDevId:     transaction.Args[1],
+		ProductId:    transaction.Args[2],
+		SettlementId: transaction.Args[3],
+		Claimant:     claimant,
+		Amount:       amount,
+		Reason:       transaction.Args[5],
+		Evidence:     []utils.DisputeEvidence{},
+		Status:       transactions.DISPUTE_OPEN,
+		TxId:         stub.GetTxID()}
+
+	// Disputes are over a settlement paid under a contract between the two parties
+	settlement, err = utils.GetSettlement(stub, dispute.CreatorId, dispute.SettlementId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if settlement.AppDevId != dispute.AppDevId || settlement.ProductId != dispute.ProductId {
+		return utils.ErrorResponse(utils.InvalidArgumentError("SettlementID", "Settlement %s was not paid under the given contract", settlement.Id))
+	}
+
+	// Each party may hold one open dispute over a settlement, and claims over it are capped at
+	// what it paid less the amounts held or awarded by the other disputes over it
+	disputes, err = utils.GetDisputes(stub, dispute.CreatorId, dispute.AppDevId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	for _, other := range disputes {
+		if other.ProductId != dispute.ProductId || other.SettlementId != dispute.SettlementId {
+			continue
+		}
+		if other.Status != transactions.DISPUTE_OPEN {
+			claimed += other.AwardedAmount
+			continue
+		}
+		if other.Claimant == claimant {
+			return utils.ErrorResponse(utils.ConflictError("Settlement %s is already disputed by the %s in dispute %s", settlement.Id, claimant, other.Id))
+		}
+		claimed += other.Amount
+	}
+	if amount > utils.RoundCents(float64(settlement.NetAmount-claimed)) {
+		return utils.ErrorResponse(utils.InvalidArgumentError("Amount", "Disputed amount $%.2f exceeds the $%.2f paid by settlement %s less $%.2f already held or awarded",
+			amount, settlement.NetAmount, settlement.Id, claimed))
+	}
+
+	// Hold the disputed amount out of the respondent's balance
+	respondent = utils.APPDEV_RECORD_KEY_PREFIX
+	if claimant == utils.APPDEV_RECORD_KEY_PREFIX {
+		respondent = utils.CREATOR_RECORD_KEY_PREFIX
+	}
+	dispute.HeldBankAccountId, err = partyBankAccountId(stub, dispute, respondent)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	heldAccount, err = utils.GetBankAccount(stub, dispute.HeldBankAccountId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if heldAccount.Balance < amount {
+		return utils.ErrorResponse(utils.InsufficientFundsError("Bank Account Balance $%.2f of the %s insufficient to hold $%.2f",
+			heldAccount.Balance, respondent, amount))
+	}
+	heldAccount.Balance = utils.RoundCents(float64(heldAccount.Balance - amount))
+	err = utils.SetBankAccount(stub, heldAccount)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	dispute.Id, err = utils.GetUniqueId(stub, transaction)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	dispute.OpenedAt, err = utils.GetTxTime(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	err = utils.SetDispute(stub, dispute)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	return utils.SuccessResponse(stub, transaction, dispute)
+}
+
+func SubmitDisputeEvidence(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Records the hash of a piece of evidence held off-chain against an open dispute.
+		Either party may submit evidence.
+
+		Args:
+			DisputeID (string): ID of the Dispute
+			EvidenceHash (string): Hex encoded SHA-256 of the evidence
+			Description (string): Short description of the evidence
+	*/
+	var dispute *utils.Dispute
+	var evidence utils.DisputeEvidence
+	var err error
+
+	if len(transaction.Args) != 3 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 3: {DisputeID, EvidenceHash, Description}. Found %d", len(transaction.Args)))
+	}
+	hashBytes, err := hex.DecodeString(transaction.Args[1])
+	if err != nil || len(hashBytes) != 32 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("EvidenceHash", "EvidenceHash must be a hex encoded SHA-256 hash. Given: %s", transaction.Args[1]))
+	}
+
+	dispute, err = utils.GetDispute(stub, transaction.Args[0])
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	evidence.SubmittedBy, err = disputeParty(transaction, dispute)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if dispute.Status != transactions.DISPUTE_OPEN {
+		return utils.ErrorResponse(utils.ConflictError("Dispute %s is %s, not %s", dispute.Id, dispute.Status, transactions.DISPUTE_OPEN))
+	}
+	if len(dispute.Evidence) >= utils.MAX_DISPUTE_EVIDENCE {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Disputes are limited to %d pieces of evidence", utils.MAX_DISPUTE_EVIDENCE))
+	}
+
+	evidence.Hash = hex.EncodeToString(hashBytes)
+	for _, submitted := range dispute.Evidence {
+		if submitted.Hash == evidence.Hash {
+			return utils.ErrorResponse(utils.ConflictError("Evidence %s has already been submitted to dispute %s", evidence.Hash, dispute.Id))
+		}
+	}
+	evidence.Description = transaction.Args[2]
+	evidence.TxId = stub.GetTxID()
+	evidence.SubmittedAt, err = utils.GetTxTime(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	dispute.Evidence = append(dispute.Evidence, evidence)
+	err = utils.SetDispute(stub, dispute)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.TextResponse(stub, transaction, dispute, "SUCCESS")
+}
+
+func ResolveDispute(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Rules on an open dispute as its arbitrator. The awarded portion of the held amount is
+		paid to the claimant and the remainder is returned to the respondent.
+
+		Args:
+			DisputeID (string): ID of the Dispute
+			AwardedAmount (string): Amount paid to the claimant in $USD, from 0 up to the
+				disputed amount
+			Ruling (string): The arbitrator's reasons
+	*/
+	var dispute *utils.Dispute
+	var claimantAccount, heldAccount *utils.BankAccount
+	var claimantAccountId string
+	var err error
+
+	// Access control: Only a Beatchain Admin can invoke this transaction
+	if !transaction.TestMode && !utils.AuthenticateBeatchainAdmin(transaction) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Beatchain Admin Org. Access denied."))
+	}
+	if len(transaction.Args) != 3 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 3: {DisputeID, AwardedAmount, Ruling}. Found %d", len(transaction.Args)))
+	}
+	award64, err := strconv.ParseFloat(transaction.Args[1], 64)
+	if err != nil || award64 < 0 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("AwardedAmount", "AwardedAmount must be a non-negative amount. Given: %s", transaction.Args[1]))
+	}
+	award := float32(math.Round(award64*100) / 100)
+	if transaction.Args[2] == "" {
+		return utils.ErrorResponse(utils.InvalidArgumentError("Ruling", "A Ruling must be given"))
+	}
+
+	dispute, err = utils.GetDispute(stub, transaction.Args[0])
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if dispute.Status != transactions.DISPUTE_OPEN {
+		return utils.ErrorResponse(utils.ConflictError("Dispute %s is %s, not %s", dispute.Id, dispute.Status, transactions.DISPUTE_OPEN))
+	}
+	if award > dispute.Amount {
+		return utils.ErrorResponse(utils.InvalidArgumentError("AwardedAmount", "AwardedAmount $%.2f exceeds the disputed amount of $%.2f", award, dispute.Amount))
+	}
+
+	// Release the held funds between the parties
+	claimantAccountId, err = partyBankAccountId(stub, dispute, dispute.Claimant)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	claimantAccount, err = utils.GetBankAccount(stub, claimantAccountId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	claimantAccount.Balance = utils.RoundCents(float64(claimantAccount.Balance + award))
+	err = utils.SetBankAccount(stub, claimantAccount)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	heldAccount, err = utils.GetBankAccount(stub, dispute.HeldBankAccountId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	heldAccount.Balance = utils.RoundCents(float64(heldAccount.Balance + dispute.Amount - award))
+	err = utils.SetBankAccount(stub, heldAccount)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	dispute.Status = transactions.DISPUTE_RESOLVED
+	dispute.AwardedAmount = award
+	dispute.Ruling = transaction.Args[2]
+	dispute.ResolvedBy = transaction.CreatorId
+	dispute.ResolvedAt, err = utils.GetTxTime(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	err = utils.SetDispute(stub, dispute)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	return utils.SuccessResponse(stub, transaction, dispute)
+}
+
+func ListDisputes(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Lists disputes as JSON. Creators and AppDevs may only list the disputes they are a
+		party to.
+
+		Args:
+			CreatorID (string): ID of the Creator party. Empty for all Creators.
+			AppDevID (string): ID of the AppDev party. Empty for all AppDevs.
+			Status (string, optional): OPEN or RESOLVED
+	*/
+	var disputes, matching []*utils.Dispute
+	var status string
+	var err error
+
+	if len(transaction.Args) != 2 && len(transaction.Args) != 3 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 2 or 3: {CreatorID, AppDevID, Status}. Found %d", len(transaction.Args)))
+	}
+	creatorId := transaction.Args[0]
+	appDevId := transaction.Args[1]
+	if len(transaction.Args) == 3 {
+		status = transaction.Args[2]
+	}
+
+	// Access control: Only a Beatchain Admin or a party to the disputes can invoke this transaction
+	if !transaction.TestMode && !utils.AuthenticateBeatchainAdmin(transaction) &&
+		!(utils.AuthenticateCreator(transaction) && transaction.CreatorId == creatorId) &&
+		!(utils.AuthenticateAppDev(transaction) && transaction.CreatorId == appDevId) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller may only list the disputes they are a party to. Access denied."))
+	}
+
+	disputes, err = utils.GetDisputes(stub, creatorId, appDevId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	matching = []*utils.Dispute{}
+	for _, dispute := range disputes {
+		if status == "" || dispute.Status == status {
+			matching = append(matching, dispute)
+		}
+	}
+
+	page, err := utils.GetPage(stub, len(matching))
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.PageResponse(stub, transaction, matching[page.Offset:page.Offset+page.Returned], page)
+}
@@ -0,0 +1,280 @@
+/*
+Handles AppDev payable forecasts and invoices frozen for settlement
+*/
+package banking
+
+import (
+	"time"
+
+	"github.com/beatchain/transactions"
+	"github.com/beatchain/utils"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+func validateAppDevCaller(transaction *utils.Transaction) error {
+	/*
+		Validates the caller of an AppDev billing function
+	*/
+	// Access control: Only an AppDev Org member can invoke this transaction
+	if !transaction.TestMode && !utils.AuthenticateAppDev(transaction) {
+		return utils.ForbiddenError("caller not a member of AppDev Org. Access denied")
+	}
+	if transaction.TestMode {
+		transaction.CreatorId = utils.TEST_APPDEV_ID
+	}
+	// Validate an ID is given
+	if transaction.CreatorId == "" {
+		return utils.ForbiddenError("user ID not found")
+	}
+	return nil
+}
+
+func projectContractPayment(stub shim.ChaincodeStubInterface, contract *utils.Contract) (*utils.PayableContract, error) {
+	/*
+		Projects the payment owed under a contract from the product's current unremunerated
+		streams and engagement metrics and any minimum guarantee shortfall, as CollectPayment
+		would compute it. Returns nil if nothing is payable.
+	*/
+	var product *utils.Product
+	var terms *utils.ContractTerms
+	var txTime time.Time
+	var err error
+
+	if contract.Status == transactions.REJECTED || contract.Status == transactions.TERMINATED {
+		return nil, nil
+	}
+
+	product, err = utils.GetProduct(stub, contract.ProductId)
+	if err != nil {
+		return nil, err
+	}
+	if !product.IsActive {
+		return nil, nil
+	}
+
+	terms, err = utils.GetVerifiedContractTerms(stub, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	metricsAmount := utils.PriceMetrics(terms, contract.UnRenumeratedMetricCounts)
+	gross := utils.RoundCents(float64(utils.RoundCents(float64(product.UnRenumeratedListens)*float64(terms.CreatorPayPerStream)) + metricsAmount))
+	recouped, cash := utils.SplitRecoupment(terms, gross)
+	guarantee := float32(0.0)
+	txTime, err = utils.GetTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	if contract.Status == transactions.ACCEPTED && utils.GuaranteeDue(terms, txTime) {
+		guarantee = utils.GuaranteeTopUp(terms, gross, recouped)
+	}
+	if gross == 0.0 && guarantee == 0.0 {
+		return nil, nil
+	}
+
+	return &utils.PayableContract{
+		CreatorId:       contract.CreatorId,
+		ProductId:       contract.ProductId,
+		Streams:         product.UnRenumeratedListens,
+		PayPerStream:    terms.CreatorPayPerStream,
+		GrossAmount:     gross,
+		Metrics:         contract.UnRenumeratedMetricCounts,
+		MetricsAmount:   metricsAmount,
+		RecoupedAmount:  recouped,
+		GuaranteeAmount: guarantee,
+		AmountDue:       utils.RoundCents(float64(cash + guarantee)),
+		Covered:         true}, nil
+}
+
+func GetAppDevPayables(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Projects the amount the calling AppDev owes each Creator from current unremunerated
+		usage and contract rates, flagging payments its bank account balance cannot cover.
+		Returned as JSON.
+
+		Args:
+			None
+	*/
+	var appDevRecord *utils.AppDevRecord
+	var bankAccount *utils.BankAccount
+	var contracts []*utils.Contract
+	var payable *utils.PayableContract
+	var remaining float32
+	var err error
+
+	err = validateAppDevCaller(transaction)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if len(transaction.Args) != 0 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "GetAppDevPayables takes no arguments"))
+	}
+
+	appDevRecord, err = utils.GetAppDevRecord(stub, transaction.CreatorId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	bankAccount, err = utils.GetBankAccount(stub, appDevRecord.BankAccountId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	contracts, err = utils.GetAppDevContracts(stub, appDevRecord.Id)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	payables := &utils.AppDevPayables{
+		AppDevId:      appDevRecord.Id,
+		Balance:       bankAccount.Balance,
+		CreatorTotals: make(map[string]float32),
+		Contracts:     []utils.PayableContract{}}
+	remaining = bankAccount.Balance
+
+	// Payments are covered in the order CollectPayment would encounter them
+	for _, contract := range contracts {
+		payable, err = projectContractPayment(stub, contract)
+		if err != nil {
+			return utils.ErrorResponse(utils.WrapError(err, "Error projecting payment for product %s", contract.ProductId))
+		}
+		if payable == nil {
+			continue
+		}
+		if payable.AmountDue > remaining {
+			payable.Covered = false
+			payables.Shortfall = utils.RoundCents(float64(payables.Shortfall + payable.AmountDue))
+		} else {
+			remaining -= payable.AmountDue
+		}
+		payables.CreatorTotals[payable.CreatorId] = utils.RoundCents(float64(payables.CreatorTotals[payable.CreatorId] + payable.AmountDue))
+		payables.TotalDue = utils.RoundCents(float64(payables.TotalDue + payable.AmountDue))
+		payables.Contracts = append(payables.Contracts, *payable)
+	}
+
+	return utils.SuccessResponse(stub, transaction, payables)
+}
+
+func GetOpenInvoice(stub shim.ChaincodeStubInterface, creatorId string, appDevId string) (*utils.Invoice, time.Time, error) {
+	/*
+		Finds the open invoice between a Creator and an AppDev, if any, along with the end
+		of the most recently invoiced period.
+
+		Returns:
+			invoice: The open Invoice, or nil if none exists
+			lastPeriodEnd: End of the latest invoice's period; zero if never invoiced
+			err: Error object. nil if no error occurred.
+	*/
+	var openInvoice *utils.Invoice
+	var lastPeriodEnd time.Time
+
+	invoices, err := utils.GetInvoices(stub, creatorId, appDevId)
+	if err != nil {
+		return nil, lastPeriodEnd, err
+	}
+	for _, invoice := range invoices {
+		if invoice.Status == transactions.INVOICE_OPEN {
+			openInvoice = invoice
+		}
+		if invoice.PeriodEnd.After(lastPeriodEnd) {
+			lastPeriodEnd = invoice.PeriodEnd
+		}
+	}
+	return openInvoice, lastPeriodEnd, nil
+}
+
+func IssueInvoice(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Freezes the calling AppDev's unremunerated usage of a Creator's products into an
+		invoice covering the period since the last invoice. CollectPayment settles the
+		invoiced streams at the invoiced rates and references the invoice. Only one invoice
+		between an AppDev and a Creator may be open at a time. Returns the invoice as JSON.
+
+		Args:
+			CreatorID (string): ID of the Creator being invoiced for
+	*/
+	var contracts []*utils.Contract
+	var openInvoice *utils.Invoice
+	var payable *utils.PayableContract
+	var lastPeriodEnd, periodEnd time.Time
+	var err error
+
+	err = validateAppDevCaller(transaction)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if len(transaction.Args) != 1 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 1: {CreatorID}. Found %d", len(transaction.Args)))
+	}
+	creatorId := transaction.Args[0]
+
+	_, err = utils.GetCreatorRecord(stub, creatorId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	openInvoice, lastPeriodEnd, err = GetOpenInvoice(stub, creatorId, transaction.CreatorId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if openInvoice != nil {
+		return utils.ErrorResponse(utils.ConflictError("Invoice %s to creator %s is still open", openInvoice.Id, creatorId))
+	}
+
+	periodEnd, err = utils.GetTxTime(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	invoice := &utils.Invoice{
+		CreatorId:   creatorId,
+		AppDevId:    transaction.CreatorId,
+		PeriodStart: lastPeriodEnd,
+		PeriodEnd:   periodEnd,
+		Lines:       []utils.InvoiceLine{},
+		Status:      transactions.INVOICE_OPEN,
+		TxId:        stub.GetTxID()}
+
+	contracts, err = utils.GetAppDevContracts(stub, transaction.CreatorId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	for _, contract := range contracts {
+		if contract.CreatorId != creatorId {
+			continue
+		}
+		payable, err = projectContractPayment(stub, contract)
+		if err != nil {
+			return utils.ErrorResponse(utils.WrapError(err, "Error projecting payment for product %s", contract.ProductId))
+		}
+		if payable == nil || payable.GrossAmount == 0.0 {
+			continue
+		}
+		invoice.Lines = append(invoice.Lines, utils.InvoiceLine{
+			ProductId:       payable.ProductId,
+			Streams:         payable.Streams,
+			PayPerStream:    payable.PayPerStream,
+			GrossAmount:     payable.GrossAmount,
+			Metrics:         payable.Metrics,
+			MetricsAmount:   payable.MetricsAmount,
+			RecoupedAmount:  payable.RecoupedAmount,
+			GuaranteeAmount: payable.GuaranteeAmount,
+			AmountDue:       payable.AmountDue})
+		invoice.AmountDue = utils.RoundCents(float64(invoice.AmountDue + payable.AmountDue))
+	}
+
+	if len(invoice.Lines) == 0 {
+		return utils.ErrorResponse(utils.ConflictError("No unremunerated usage to invoice for creator %s", creatorId))
+	}
+
+	invoice.Id, err = utils.GetUniqueId(stub, transaction)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	err = utils.SetInvoice(stub, invoice)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	return utils.SuccessResponse(stub, transaction, invoice)
+}
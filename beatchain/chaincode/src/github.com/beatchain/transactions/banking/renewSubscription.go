@@ -6,15 +6,23 @@ package banking
 
 import (
 	"github.com/beatchain/utils"
-	"errors"
-	"fmt"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 	"math"
 	"time"
 )
 
-
+type SubscriptionPayment struct {
+	/*
+		Defines the breakdown of a subscription fee paid by RenewSubscription
+	*/
+	CustomerId          string    `json:"customerid"`
+	AppDevId            string    `json:"appdevid"`
+	SubscriptionFee     float32   `json:"subscriptionfee"`
+	AppDevShare         float32   `json:"appdevshare"` // Paid to the AppDev
+	AdminShare          float32   `json:"adminshare"`  // Paid to the Beatchain Admin
+	SubscriptionDueDate time.Time `json:"subscriptionduedate"`
+}
 
 func validateRenewSubscription(transaction *utils.Transaction) error {
 	/*
@@ -22,18 +30,18 @@ func validateRenewSubscription(transaction *utils.Transaction) error {
 	 */
 	// Access control: Only an Customer Org member can invoke this transaction
 	if !transaction.TestMode && !utils.AuthenticateCustomer(transaction) {
-		return errors.New(fmt.Sprintf("caller not a member of Customer Org. Access denied"))
+		return utils.ForbiddenError("caller not a member of Customer Org. Access denied")
 	}
 	if transaction.TestMode {
 		transaction.CreatorId = utils.TEST_CUSTOMER_ID
 	}
 	// Validate an ID is given
 	if !transaction.TestMode && transaction.CreatorId == "" {
-		return errors.New(fmt.Sprintf("customer ID not found"))
+		return utils.ForbiddenError("customer ID not found")
 	}
-	// Validate no other args are specified
-	if len(transaction.Args) != 0 {
-		return errors.New(fmt.Sprintf("renewSubscription takes no arguments"))
+	// Validate at most the AppDev is specified
+	if len(transaction.Args) > 1 {
+		return utils.InvalidArgumentError("", "renewSubscription takes at most 1 argument: {AppDevID}")
 	}
 
 
@@ -42,95 +50,120 @@ func validateRenewSubscription(transaction *utils.Transaction) error {
 
 func RenewSubscription(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
 	/*
-	Renews the Customer's subscription for a month. Transfers money from the Customer's
-	bank account to the AppDev's account and extends the subscription due date by a month.
+	Renews the Customer's subscription through an AppDev for a month. Transfers money from
+	the Customer's bank account to the AppDev's account and extends the subscription due
+	date by a month.
 
 	Args:
 		transaction: Creator's transaction info
+		AppDevID (string, optional): ID of the AppDev subscribed through. Required if the
+			Customer subscribes through more than one AppDev.
 
 	 */
 	var customerRecord *utils.CustomerRecord
+	var subscription *utils.Subscription
+	var appDevId string
 	var customerBankAccount, appDevBankAccount, beatchainAdminBankAccount *utils.BankAccount
 	var appDevRecord *utils.AppDevRecord
 	var appDevShare float64
+	var txTime time.Time
 	var err error
 
 	// Validate inputs
 	err = validateRenewSubscription(transaction)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	// lookup customer record
 	customerRecord, err = utils.GetCustomerRecord(stub, transaction.CreatorId)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
+	}
+
+	// lookup the subscription being renewed
+	if len(transaction.Args) == 1 {
+		appDevId = transaction.Args[0]
+	}
+	subscription, err = utils.GetSubscription(customerRecord, appDevId)
+	if err != nil {
+		return utils.ErrorResponse(err)
 	}
 
 	// lookup customer bank account balance
 	customerBankAccount, err = utils.GetBankAccount(stub, customerRecord.BankAccountId)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	// lookup AppDev record
-	appDevRecord, err = utils.GetAppDevRecord(stub, customerRecord.AppDevId)
+	appDevRecord, err = utils.GetAppDevRecord(stub, subscription.AppDevId)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	// lookup AppDev Bank Account
 	appDevBankAccount, err = utils.GetBankAccount(stub, appDevRecord.BankAccountId)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	// lookup Beatchain Admin Bank Account
 	beatchainAdminBankAccount, err = utils.GetBankAccount(stub, utils.BEATCHAIN_ADMIN_BANK_ACCOUNT_ID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	// Validate the user can pay for the subscription
-	if customerBankAccount.Balance < customerRecord.SubscriptionFee {
-		err = errors.New(fmt.Sprintf("Bank Account Balance $%.2f insufficient for fee of $%.2f",
-			customerBankAccount.Balance, customerRecord.SubscriptionFee))
-		return shim.Error(err.Error())
+	if customerBankAccount.Balance < subscription.SubscriptionFee {
+		err = utils.InsufficientFundsError("Bank Account Balance $%.2f insufficient for fee of $%.2f",
+			customerBankAccount.Balance, subscription.SubscriptionFee)
+		return utils.ErrorResponse(err)
 	}
 
 	// Exchange funds, taking care that cents are appropriately handled
-	customerBankAccount.Balance -= customerRecord.SubscriptionFee
-	appDevShare = float64(customerRecord.SubscriptionFee * (1. - appDevRecord.AdminFeeFrac))
+	customerBankAccount.Balance -= subscription.SubscriptionFee
+	appDevShare = float64(subscription.SubscriptionFee * (1. - appDevRecord.AdminFeeFrac))
 	appDevShare = math.Round(appDevShare*100)/100
 	appDevBankAccount.Balance += float32(appDevShare)
-	beatchainAdminBankAccount.Balance += customerRecord.SubscriptionFee - float32(appDevShare)
+	beatchainAdminBankAccount.Balance += subscription.SubscriptionFee - float32(appDevShare)
 
 	// Increment subscription time
-	if customerRecord.SubscriptionDueDate.Before(time.Now()){
+	txTime, err = utils.GetTxTime(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if subscription.SubscriptionDueDate.Before(txTime){
 		// If subscription lapsed, add 30 days from now
-		customerRecord.SubscriptionDueDate = time.Now().Add(time.Hour * 24 * 30)
+		subscription.SubscriptionDueDate = txTime.Add(time.Hour * 24 * 30)
 	} else {
 		// if due date hasn't passed, add 30 days to the existing due date
-		customerRecord.SubscriptionDueDate = customerRecord.SubscriptionDueDate.Add(time.Hour * 24 * 30)
+		subscription.SubscriptionDueDate = subscription.SubscriptionDueDate.Add(time.Hour * 24 * 30)
 	}
 
 	// Save the changes to the ledger
 	err = utils.SetCustomerRecord(stub, customerRecord)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 	err = utils.SetBankAccount(stub, customerBankAccount)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 	err = utils.SetBankAccount(stub, appDevBankAccount)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 	err = utils.SetBankAccount(stub, beatchainAdminBankAccount)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
-	return shim.Success([]byte("SUCCESS"))
+	return utils.TextResponse(stub, transaction, &SubscriptionPayment{
+		CustomerId: customerRecord.Id,
+		AppDevId: subscription.AppDevId,
+		SubscriptionFee: subscription.SubscriptionFee,
+		AppDevShare: float32(appDevShare),
+		AdminShare: subscription.SubscriptionFee - float32(appDevShare),
+		SubscriptionDueDate: subscription.SubscriptionDueDate}, "SUCCESS")
 }
\ No newline at end of file
@@ -0,0 +1,167 @@
+/*
+Handles earnings statement queries built from persisted settlements
+*/
+package banking
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/beatchain/utils"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	statementDateLayout = "2006-01-02"
+)
+
+func validateGetCreatorStatement(transaction *utils.Transaction) (time.Time, time.Time, string, error) {
+	/*
+		Validates the inputs to the GetCreatorStatement function
+	*/
+	var from, to time.Time
+	var err error
+
+	// Access control: Only a Creator Org member can invoke this transaction
+	if !transaction.TestMode && !utils.AuthenticateCreator(transaction) {
+		return from, to, "", utils.ForbiddenError("caller not a member of Creator Org. Access denied")
+	}
+	if transaction.TestMode {
+		transaction.CreatorId = utils.TEST_CREATOR_ID
+	}
+	// Validate an ID is given
+	if transaction.CreatorId == "" {
+		return from, to, "", utils.ForbiddenError("user ID not found")
+	}
+	if len(transaction.Args) != 3 {
+		return from, to, "", utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 3: {From, To, GroupBy}. Found %d", len(transaction.Args))
+	}
+
+	from, err = time.Parse(statementDateLayout, transaction.Args[0])
+	if err != nil {
+		return from, to, "", utils.InvalidArgumentError("From", "Cannot parse given From to date in form YYYY-MM-DD: %s", transaction.Args[0])
+	}
+	to, err = time.Parse(statementDateLayout, transaction.Args[1])
+	if err != nil {
+		return from, to, "", utils.InvalidArgumentError("To", "Cannot parse given To to date in form YYYY-MM-DD: %s", transaction.Args[1])
+	}
+	if to.Before(from) {
+		return from, to, "", utils.InvalidArgumentError("To", "To date %s is before From date %s", transaction.Args[1], transaction.Args[0])
+	}
+
+	switch groupBy := transaction.Args[2]; groupBy {
+	case utils.STATEMENT_GROUP_PRODUCT, utils.STATEMENT_GROUP_APPDEV, utils.STATEMENT_GROUP_CONTRACT:
+		return from, to, groupBy, nil
+	default:
+		return from, to, "", utils.InvalidArgumentError("GroupBy", "GroupBy must be one of %s, %s or %s. Given: %s",
+			utils.STATEMENT_GROUP_PRODUCT, utils.STATEMENT_GROUP_APPDEV, utils.STATEMENT_GROUP_CONTRACT, groupBy)
+	}
+}
+
+func addToStatementLine(line *utils.StatementLine, settlement *utils.Settlement) {
+	line.Settlements += 1
+	line.Streams += settlement.Streams
+	line.GrossAmount = utils.RoundCents(float64(line.GrossAmount + settlement.GrossAmount))
+	line.MetricsAmount = utils.RoundCents(float64(line.MetricsAmount + settlement.MetricsAmount))
+	line.RecoupedAmount = utils.RoundCents(float64(line.RecoupedAmount + settlement.RecoupedAmount))
+	line.NetAmount = utils.RoundCents(float64(line.NetAmount + settlement.NetAmount))
+	if line.Streams > 0 {
+		line.PayPerStream = float32(math.Round(float64(line.GrossAmount-line.MetricsAmount)/float64(line.Streams)*10000) / 10000)
+	}
+}
+
+func BuildCreatorStatement(settlements []*utils.Settlement, creatorId string, from time.Time, to time.Time, groupBy string) *utils.CreatorStatement {
+	/*
+		Aggregates a Creator's settlements paid within [from, to] into statement lines
+
+		Args:
+			settlements: Settlements paid to the Creator
+			creatorId: ID of the Creator
+			from: First day of the period
+			to: Last day of the period, inclusive
+			groupBy: One of the STATEMENT_GROUP_* options
+
+		Returns:
+			statement: CreatorStatement with one line per group, sorted by group key
+	*/
+	var groupKey string
+	var groupKeys []string
+
+	statement := &utils.CreatorStatement{
+		CreatorId: creatorId,
+		From:      from,
+		To:        to,
+		GroupBy:   groupBy,
+		Lines:     []utils.StatementLine{}}
+	lines := make(map[string]*utils.StatementLine)
+	end := to.Add(time.Hour * 24)
+
+	for _, settlement := range settlements {
+		if settlement.SettledAt.Before(from) || !settlement.SettledAt.Before(end) {
+			continue
+		}
+
+		switch groupBy {
+		case utils.STATEMENT_GROUP_PRODUCT:
+			groupKey = settlement.ProductId
+		case utils.STATEMENT_GROUP_APPDEV:
+			groupKey = settlement.AppDevId
+		default:
+			groupKey = settlement.ProductId + "/" + settlement.AppDevId
+		}
+
+		line, ok := lines[groupKey]
+		if !ok {
+			line = &utils.StatementLine{}
+			if groupBy != utils.STATEMENT_GROUP_APPDEV {
+				line.ProductId = settlement.ProductId
+			}
+			if groupBy != utils.STATEMENT_GROUP_PRODUCT {
+				line.AppDevId = settlement.AppDevId
+			}
+			lines[groupKey] = line
+			groupKeys = append(groupKeys, groupKey)
+		}
+		addToStatementLine(line, settlement)
+		addToStatementLine(&statement.Total, settlement)
+	}
+
+	sort.Strings(groupKeys)
+	for _, groupKey = range groupKeys {
+		statement.Lines = append(statement.Lines, *lines[groupKey])
+	}
+	return statement
+}
+
+func GetCreatorStatement(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Reports a Creator's streams, rate, gross and net earnings over a period from the
+		settlements persisted by CollectPayment. Returned as JSON.
+
+		Args:
+			From (string): First day of the period in form YYYY-MM-DD
+			To (string): Last day of the period, inclusive, in form YYYY-MM-DD
+			GroupBy (string): "product", "appdev" or "contract" (product and AppDev)
+	*/
+	var settlements []*utils.Settlement
+	var from, to time.Time
+	var groupBy string
+	var err error
+
+	// Validate inputs
+	from, to, groupBy, err = validateGetCreatorStatement(transaction)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	settlements, err = utils.GetCreatorSettlements(stub, transaction.CreatorId)
+	if err != nil {
+		return utils.ErrorResponse(utils.WrapError(err, "Error accessing settlements for creator with id %s", transaction.CreatorId))
+	}
+
+	statement := BuildCreatorStatement(settlements, transaction.CreatorId, from, to, groupBy)
+
+	return utils.SuccessResponse(stub, transaction, statement)
+}
@@ -1,86 +1,403 @@
 package banking
 
 import (
+	"encoding/json"
+	"github.com/beatchain/transactions"
 	"github.com/beatchain/utils"
-	"fmt"
-	"errors"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 	"math"
 	"strconv"
+	"time"
 )
 
-func validateTransfer(transaction *utils.Transaction) (float32, error) {
+func validateTransfer(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) (float32, *utils.TransferPolicy, error) {
 	/*
 		Validates the inputs to the TransferFunds function
 	*/
-	var amount float32
+	var amount, dailyTotal float32
 	var amount64 float64
+	var limits *utils.WithdrawalLimits
+	var policy *utils.TransferPolicy
+	var txTime time.Time
 	var err error
 
 	// Access control: Only a Beatchain Admin Org member can invoke this transaction
 	if !transaction.TestMode && !utils.AuthenticateBeatchainAdmin(transaction) {
-		return amount, errors.New(fmt.Sprintf("caller not a member of Beatchain Admin Org. Access denied"))
+		return amount, nil, utils.ForbiddenError("caller not a member of Beatchain Admin Org. Access denied")
 	}
 	// Validate no other args are specified
 	if len(transaction.Args) != 2 {
-		return amount, errors.New(fmt.Sprintf("Incorrect number of arguments. Expecting 1: {BankAccountId, amount}. Found %d", len(transaction.Args)))
+		return amount, nil, utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 1: {BankAccountId, amount}. Found %d", len(transaction.Args))
 	}
 
 	// Parse and validate amount
 	amount64, err = strconv.ParseFloat(transaction.Args[1], 64)
 	if err != nil {
-		return amount, errors.New(fmt.Sprintf("Cannot parse amount to float64: %s", transaction.Args[1]))
+		return amount, nil, utils.InvalidArgumentError("Amount", "Cannot parse amount to float64: %s", transaction.Args[1])
 	}
 	amount64 = math.Round(amount64*100)/100
 	if math.Abs(amount64) == 0.00 {
-		return amount, errors.New(fmt.Sprintf("Cannot transfer amount of $0.00 (rounded)"))
+		return amount, nil, utils.InvalidArgumentError("Amount", "Cannot transfer amount of $0.00 (rounded)")
+	}
+
+	// Transfers over the multi-signature threshold or the per-transaction limit are
+	// proposed rather than executed, so no amount is left without a way through
+	policy, err = utils.GetTransferPolicy(stub)
+	if err != nil {
+		return amount, nil, err
+	}
+	limits, err = utils.GetWithdrawalLimits(stub)
+	if err != nil {
+		return amount, nil, err
+	}
+	if math.Abs(amount64) > float64(policy.Threshold) || math.Abs(amount64) > float64(limits.PerTransaction) {
+		return float32(amount64), policy, nil
+	}
+
+	// Withdrawals count towards the daily limit of the account alongside requested ones
+	if amount64 < 0.0 {
+		txTime, err = utils.GetTxTime(stub)
+		if err != nil {
+			return amount, nil, err
+		}
+		dailyTotal, err = withdrawnToday(stub, transaction.Args[0], txTime)
+		if err != nil {
+			return amount, nil, err
+		}
+		if float64(dailyTotal)-amount64 > float64(limits.Daily) {
+			return float32(amount64), policy, nil
+		}
+	}
+
+	return float32(amount64), nil, nil
+}
+
+func applyTransfer(stub shim.ChaincodeStubInterface, transaction *utils.Transaction, bankAccountId string,
+	amount float32) (*utils.BankAccount, error) {
+	/*
+		Adds an amount to a bank account's balance, failing if the account would be overdrawn.
+		A negative amount is recorded as an approved withdrawal, so that it counts towards the
+		account's daily withdrawal limit.
+	*/
+	bankAccount, err := utils.GetBankAccount(stub, bankAccountId)
+	if err != nil {
+		return nil, utils.WrapError(err, "Error accessing BA with id %s", bankAccountId)
+	}
+
+	// Transfer and validate solvency
+	bankAccount.Balance += amount
+	if bankAccount.Balance < 0.00 {
+		return nil, utils.InsufficientFundsError("BA ID: %s Insufficient Funds for payment of %.2f", bankAccountId, amount)
 	}
-    // Limit the total amount in each txn
-	if math.Abs(amount64) > 1000.0 {
-		return amount, errors.New(fmt.Sprintf("Cannot transfer over $1000.00 in a single txn. Given: %.2f", amount64))
+
+	if amount < 0.0 {
+		txTime, err := utils.GetTxTime(stub)
+		if err != nil {
+			return nil, err
+		}
+		withdrawal := &utils.Withdrawal{
+			BankAccountId: bankAccountId,
+			RequesterType: utils.BEATCHAIN_ADMIN_MSP,
+			RequesterId:   transaction.CreatorIdentity,
+			Amount:        -amount,
+			Status:        transactions.WITHDRAWAL_APPROVED,
+			RequestedAt:   txTime,
+			DecidedAt:     txTime,
+			DecidedBy:     transaction.CreatorIdentity,
+			TxId:          stub.GetTxID()}
+		withdrawal.Id, err = utils.GetUniqueId(stub, transaction)
+		if err != nil {
+			return nil, err
+		}
+		err = utils.SetWithdrawal(stub, withdrawal)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return float32(amount64), nil
+	// Set change in ledger
+	return bankAccount, utils.SetBankAccount(stub, bankAccount)
+}
+
+func validateApprover(transaction *utils.Transaction, policy *utils.TransferPolicy) error {
+	/*
+		Validates that the caller may approve transfer proposals under the policy
+	*/
+	if transaction.CreatorIdentity == "" {
+		return utils.ForbiddenError("caller identity not found")
+	}
+	if len(policy.Admins) == 0 {
+		return nil
+	}
+	for _, admin := range policy.Admins {
+		if admin == transaction.CreatorIdentity {
+			return nil
+		}
+	}
+	return utils.ForbiddenError("%s is not an approver of admin transfers", transaction.CreatorIdentity)
+}
+
+func proposeTransfer(stub shim.ChaincodeStubInterface, transaction *utils.Transaction, policy *utils.TransferPolicy,
+	bankAccountId string, amount float32) (*utils.TransferProposal, error) {
+	/*
+		Creates a pending proposal for a transfer over the multi-signature threshold. The
+		proposer's approval counts towards those required.
+	*/
+	var txTime time.Time
+	var err error
+
+	err = validateApprover(transaction, policy)
+	if err != nil {
+		return nil, err
+	}
+	_, err = utils.GetBankAccount(stub, bankAccountId)
+	if err != nil {
+		return nil, utils.WrapError(err, "Error accessing BA with id %s", bankAccountId)
+	}
+	txTime, err = utils.GetTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	proposal := &utils.TransferProposal{
+		BankAccountId: bankAccountId,
+		Amount: amount,
+		ProposedBy: transaction.CreatorIdentity,
+		ProposedAt: txTime,
+		ExpiresAt: txTime.Add(time.Hour * time.Duration(policy.ExpiryHours)),
+		RequiredApprovals: policy.RequiredApprovals,
+		Approvals: []utils.TransferApproval{{
+			Identity: transaction.CreatorIdentity,
+			ApprovedAt: txTime,
+			TxId: stub.GetTxID()}},
+		Status: transactions.PROPOSAL_PENDING,
+		TxId: stub.GetTxID()}
+	proposal.Id, err = utils.GetUniqueId(stub, transaction)
+	if err != nil {
+		return nil, err
+	}
+	return proposal, utils.SetTransferProposal(stub, proposal)
 }
 
 func TransferFunds(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
 	/*
 		Credits monies transferred off-chain bank accounts to those on-chain (i.e. deposits and withdrawals)
-	    Used by administrators to manually move funds in the ledger.
+	    Used by administrators to manually move funds in the ledger. Transfers over the
+		multi-signature threshold or the per-transaction withdrawal limit, and withdrawals
+		over the account's daily limit, create a pending TransferProposal, returned as JSON,
+		which executes once enough distinct admin identities approve it with ApproveTransfer.
 
 		Args:
 			bankAccountId (string): ID of the BankAccount whose balance will be altered
+			amount (string): Amount in $USD. Negative for withdrawals, which are recorded
+				as approved Withdrawals.
 	*/
 	var bankAccountId string
-	var bankAccount *utils.BankAccount
+	var policy *utils.TransferPolicy
+	var proposal *utils.TransferProposal
 	var amount float32
 	var err error
 
 	// Validate inputs
-	amount, err = validateTransfer(transaction)
+	amount, policy, err = validateTransfer(stub, transaction)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 	bankAccountId = transaction.Args[0]
 
-	// lookup Bank Account
-	bankAccount, err = utils.GetBankAccount(stub, bankAccountId)
+	if policy != nil {
+		proposal, err = proposeTransfer(stub, transaction, policy, bankAccountId, amount)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+		return utils.SuccessResponse(stub, transaction, proposal)
+	}
+
+	bankAccount, err := applyTransfer(stub, transaction, bankAccountId, amount)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Error accessing BA with id %s: %s", bankAccountId, err.Error()))
+		return utils.ErrorResponse(err)
 	}
 
-	// Transfer and validate solvency
-	bankAccount.Balance += amount
-	if bankAccount.Balance < 0.00 {
-		return shim.Error(fmt.Sprintf("BA ID: %s Insufficient Funds for payment of %.2f", bankAccountId, amount))
+	return utils.TextResponse(stub, transaction, bankAccount, "SUCCESS")
+}
+
+func ApproveTransfer(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Records the calling admin identity's approval of a pending transfer proposal, executing
+		the transfer once the required number of distinct identities have approved it. Expired
+		proposals cannot be approved. Returns the proposal as JSON.
+
+		Args:
+			ProposalID (string): ID of the TransferProposal
+	*/
+	var proposal *utils.TransferProposal
+	var policy *utils.TransferPolicy
+	var txTime time.Time
+	var err error
+
+	// Access control: Only a Beatchain Admin can invoke this transaction
+	if !transaction.TestMode && !utils.AuthenticateBeatchainAdmin(transaction) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Beatchain Admin Org. Access denied."))
+	}
+	if len(transaction.Args) != 1 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 1: {ProposalID}. Found %d", len(transaction.Args)))
 	}
 
-	// Set change in ledger
-	err = utils.SetBankAccount(stub, bankAccount)
+	policy, err = utils.GetTransferPolicy(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	err = validateApprover(transaction, policy)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	proposal, err = utils.GetTransferProposal(stub, transaction.Args[0])
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	txTime, err = utils.GetTxTime(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if proposal.Status == transactions.PROPOSAL_PENDING && txTime.After(proposal.ExpiresAt) {
+		return utils.ErrorResponse(utils.ConflictError("Proposal %s expired at %s", proposal.Id, proposal.ExpiresAt.Format(time.RFC3339)))
+	}
+	if proposal.Status != transactions.PROPOSAL_PENDING {
+		return utils.ErrorResponse(utils.ConflictError("Proposal %s is %s, not %s", proposal.Id, proposal.Status, transactions.PROPOSAL_PENDING))
+	}
+	for _, approval := range proposal.Approvals {
+		if approval.Identity == transaction.CreatorIdentity {
+			return utils.ErrorResponse(utils.ConflictError("%s has already approved proposal %s", transaction.CreatorIdentity, proposal.Id))
+		}
+	}
+
+	proposal.Approvals = append(proposal.Approvals, utils.TransferApproval{
+		Identity: transaction.CreatorIdentity,
+		ApprovedAt: txTime,
+		TxId: stub.GetTxID()})
+
+	// Execute once enough distinct identities have approved
+	if len(proposal.Approvals) >= proposal.RequiredApprovals {
+		_, err = applyTransfer(stub, transaction, proposal.BankAccountId, proposal.Amount)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+		proposal.Status = transactions.PROPOSAL_EXECUTED
+		proposal.ExecutedAt = txTime
+	}
+
+	err = utils.SetTransferProposal(stub, proposal)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
+	}
+	return utils.SuccessResponse(stub, transaction, proposal)
+}
+
+func ListTransferProposals(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Lists transfer proposals as JSON, optionally only those with a given status. Pending
+		proposals past their expiry are reported as EXPIRED.
+
+		Args:
+			Status (string, optional): PENDING, EXECUTED or EXPIRED
+	*/
+	var proposals, matching []*utils.TransferProposal
+	var txTime time.Time
+	var status string
+	var err error
+
+	// Access control: Only a Beatchain Admin can invoke this transaction
+	if !transaction.TestMode && !utils.AuthenticateBeatchainAdmin(transaction) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Beatchain Admin Org. Access denied."))
+	}
+	if len(transaction.Args) > 1 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting at most 1: {Status}. Found %d", len(transaction.Args)))
+	}
+	if len(transaction.Args) == 1 {
+		status = transaction.Args[0]
 	}
 
-	return shim.Success([]byte("SUCCESS"))
-}
\ No newline at end of file
+	txTime, err = utils.GetTxTime(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	proposals, err = utils.GetTransferProposals(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	matching = []*utils.TransferProposal{}
+	for _, proposal := range proposals {
+		if proposal.Status == transactions.PROPOSAL_PENDING && txTime.After(proposal.ExpiresAt) {
+			proposal.Status = transactions.PROPOSAL_EXPIRED
+		}
+		if status == "" || proposal.Status == status {
+			matching = append(matching, proposal)
+		}
+	}
+
+	page, err := utils.GetPage(stub, len(matching))
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.PageResponse(stub, transaction, matching[page.Offset:page.Offset+page.Returned], page)
+}
+
+func SetTransferPolicy(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Sets when admin transfers need approval from multiple admin identities
+
+		Args:
+			Threshold (string): Transfers above this amount in $USD require approval
+			RequiredApprovals (string): Number of distinct admin identities which must approve
+			ExpiryHours (string): Hours a proposal may wait for approval
+			Admins (string, optional): JSON array of the identities which may approve. Any
+				admin identity may approve if not given.
+	*/
+	var policy utils.TransferPolicy
+	var threshold float32
+	var err error
+
+	// Access control: Only a Beatchain Admin can invoke this transaction
+	if !transaction.TestMode && !utils.AuthenticateBeatchainAdmin(transaction) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Beatchain Admin Org. Access denied."))
+	}
+	if len(transaction.Args) != 3 && len(transaction.Args) != 4 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 3 or 4: {Threshold, RequiredApprovals, ExpiryHours, Admins}. Found %d", len(transaction.Args)))
+	}
+
+	threshold, err = parseAmount(transaction.Args[0])
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	policy.Threshold = threshold
+	policy.RequiredApprovals, err = strconv.Atoi(transaction.Args[1])
+	if err != nil || policy.RequiredApprovals < 2 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("RequiredApprovals", "RequiredApprovals must be an integer of at least 2. Given: %s", transaction.Args[1]))
+	}
+	policy.ExpiryHours, err = strconv.Atoi(transaction.Args[2])
+	if err != nil || policy.ExpiryHours <= 0 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("ExpiryHours", "ExpiryHours must be a positive integer. Given: %s", transaction.Args[2]))
+	}
+	policy.Admins = []string{}
+	if len(transaction.Args) == 4 {
+		err = json.Unmarshal([]byte(transaction.Args[3]), &policy.Admins)
+		if err != nil {
+			return utils.ErrorResponse(utils.InvalidArgumentError("Admins", "Cannot parse Admins as a JSON array: %s", err.Error()))
+		}
+		distinct := make(map[string]bool)
+		for _, admin := range policy.Admins {
+			distinct[admin] = true
+		}
+		if len(policy.Admins) > 0 && len(distinct) < policy.RequiredApprovals {
+			return utils.ErrorResponse(utils.InvalidArgumentError("RequiredApprovals", "RequiredApprovals %d exceeds the %d distinct Admins", policy.RequiredApprovals, len(distinct)))
+		}
+	}
+
+	err = utils.SetTransferPolicy(stub, &policy)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.TextResponse(stub, transaction, &policy, "SUCCESS")
+}
@@ -0,0 +1,371 @@
+/*
+Handles Creator and AppDev requests to withdraw funds off-chain, and their approval by admins
+*/
+package banking
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/beatchain/transactions"
+	"github.com/beatchain/utils"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+func parseAmount(amountStr string) (float32, error) {
+	/*
+		Parses a positive $USD amount, rounded to the cent
+	*/
+	amount64, err := strconv.ParseFloat(amountStr, 64)
+	if err != nil {
+		return 0.0, utils.InvalidArgumentError("Amount", "Cannot parse amount to float64: %s", amountStr)
+	}
+	amount64 = math.Round(amount64*100) / 100
+	if amount64 <= 0.00 {
+		return 0.0, utils.InvalidArgumentError("Amount", "Amount must be positive (rounded). Given: %s", amountStr)
+	}
+	return float32(amount64), nil
+}
+
+func validateRequestWithdrawal(transaction *utils.Transaction) (string, float32, error) {
+	/*
+		Validates the inputs to the RequestWithdrawal function
+
+		Returns:
+			requesterType: CREATOR_RECORD_KEY_PREFIX or APPDEV_RECORD_KEY_PREFIX
+			amount: Amount to withdraw
+			err: Error object. nil if no error occurred.
+	*/
+	var requesterType string
+
+	// Access control: Only a Creator or AppDev Org member can invoke this transaction
+	if transaction.TestMode {
+		requesterType = utils.CREATOR_RECORD_KEY_PREFIX
+		transaction.CreatorId = utils.TEST_CREATOR_ID
+	} else if utils.AuthenticateCreator(transaction) {
+		requesterType = utils.CREATOR_RECORD_KEY_PREFIX
+	} else if utils.AuthenticateAppDev(transaction) {
+		requesterType = utils.APPDEV_RECORD_KEY_PREFIX
+	} else {
+		return "", 0.0, utils.ForbiddenError("caller not a member of Creator or AppDev Org. Access denied")
+	}
+	if transaction.CreatorId == "" {
+		return "", 0.0, utils.ForbiddenError("user ID not found")
+	}
+	if len(transaction.Args) != 1 {
+		return "", 0.0, utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 1: {Amount}. Found %d", len(transaction.Args))
+	}
+
+	amount, err := parseAmount(transaction.Args[0])
+	if err != nil {
+		return "", 0.0, err
+	}
+	return requesterType, amount, nil
+}
+
+func withdrawnToday(stub shim.ChaincodeStubInterface, bankAccountId string, txTime time.Time) (float32, error) {
+	/*
+		Sums the pending and approved withdrawals from a bank account requested on the
+		UTC day of the transaction
+	*/
+	var total float32
+
+	withdrawals, err := utils.GetDailyWithdrawals(stub, bankAccountId, txTime)
+	if err != nil {
+		return 0.0, err
+	}
+	for _, withdrawal := range withdrawals {
+		if withdrawal.Status != transactions.WITHDRAWAL_REJECTED {
+			total = utils.RoundCents(float64(total + withdrawal.Amount))
+		}
+	}
+	return total, nil
+}
+
+func HoldWithdrawal(stub shim.ChaincodeStubInterface, transaction *utils.Transaction, bankAccount *utils.BankAccount,
+	withdrawal *utils.Withdrawal) error {
+	/*
+		Checks a withdrawal against the per-transaction and daily withdrawal limits, holds its
+		amount out of the bank account's balance and records it as pending admin approval
+
+		Args:
+			bankAccount: BankAccount withdrawn from
+			withdrawal: Withdrawal giving the requester and amount. Its ID, status and
+				request time are set here.
+
+		Returns:
+			err: Error object. nil if no error occurred.
+	*/
+	var limits *utils.WithdrawalLimits
+	var dailyTotal float32
+	var err error
+
+	// Enforce the withdrawal limits
+	limits, err = utils.GetWithdrawalLimits(stub)
+	if err != nil {
+		return err
+	}
+	if withdrawal.Amount > limits.PerTransaction {
+		return utils.InvalidArgumentError("Amount", "Cannot withdraw over $%.2f in a single txn. Given: %.2f", limits.PerTransaction, withdrawal.Amount)
+	}
+	withdrawal.RequestedAt, err = utils.GetTxTime(stub)
+	if err != nil {
+		return err
+	}
+	dailyTotal, err = withdrawnToday(stub, bankAccount.Id, withdrawal.RequestedAt)
+	if err != nil {
+		return err
+	}
+	if dailyTotal+withdrawal.Amount > limits.Daily {
+		return utils.ConflictError("Withdrawal of %.2f exceeds the daily limit of $%.2f. Already withdrawn today: %.2f",
+			withdrawal.Amount, limits.Daily, dailyTotal)
+	}
+
+	// Hold the funds
+	if bankAccount.Balance < withdrawal.Amount {
+		return utils.InsufficientFundsError("BA ID: %s Insufficient Funds for withdrawal of %.2f", bankAccount.Id, withdrawal.Amount)
+	}
+	bankAccount.Balance = utils.RoundCents(float64(bankAccount.Balance - withdrawal.Amount))
+	err = utils.SetBankAccount(stub, bankAccount)
+	if err != nil {
+		return err
+	}
+
+	withdrawal.BankAccountId = bankAccount.Id
+	withdrawal.Status = transactions.WITHDRAWAL_PENDING
+	withdrawal.TxId = stub.GetTxID()
+	withdrawal.Id, err = utils.GetUniqueId(stub, transaction)
+	if err != nil {
+		return err
+	}
+	return utils.SetWithdrawal(stub, withdrawal)
+}
+
+func RequestWithdrawal(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Requests a withdrawal off-chain from the calling Creator's or AppDev's bank account.
+		The amount is held out of the account's balance until an admin approves or rejects
+		the request. Requests are subject to the per-transaction and daily withdrawal limits.
+		Returns the withdrawal as JSON.
+
+		Args:
+			Amount (string): Amount to withdraw in $USD
+	*/
+	var requesterType, bankAccountId string
+	var bankAccount *utils.BankAccount
+	var amount float32
+	var err error
+
+	requesterType, amount, err = validateRequestWithdrawal(transaction)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	// Lookup the requester's bank account
+	if requesterType == utils.CREATOR_RECORD_KEY_PREFIX {
+		creatorRecord, err := utils.GetCreatorRecord(stub, transaction.CreatorId)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+		bankAccountId = creatorRecord.BankAccountId
+	} else {
+		appDevRecord, err := utils.GetAppDevRecord(stub, transaction.CreatorId)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+		bankAccountId = appDevRecord.BankAccountId
+	}
+	bankAccount, err = utils.GetBankAccount(stub, bankAccountId)
+	if err != nil {
+		return utils.ErrorResponse(utils.WrapError(err, "Error accessing BA with id %s", bankAccountId))
+	}
+
+	withdrawal := &utils.Withdrawal{
+		RequesterType: requesterType,
+		RequesterId:   transaction.CreatorId,
+		Amount:        amount}
+	err = HoldWithdrawal(stub, transaction, bankAccount, withdrawal)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	return utils.SuccessResponse(stub, transaction, withdrawal)
+}
+
+func decidePendingWithdrawal(stub shim.ChaincodeStubInterface, transaction *utils.Transaction, argName string) (*utils.Withdrawal, error) {
+	/*
+		Validates the inputs to the ApproveWithdrawal and RejectWithdrawal functions,
+		returning the pending withdrawal stamped with the decision
+	*/
+	// Access control: Only a Beatchain Admin can invoke this transaction
+	if !transaction.TestMode && !utils.AuthenticateBeatchainAdmin(transaction) {
+		return nil, utils.ForbiddenError("Caller not a member of Beatchain Admin Org. Access denied.")
+	}
+	if len(transaction.Args) != 2 {
+		return nil, utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 2: {WithdrawalID, %s}. Found %d", argName, len(transaction.Args))
+	}
+	if transaction.Args[1] == "" {
+		return nil, utils.InvalidArgumentError("", "%s is required", argName)
+	}
+
+	withdrawal, err := utils.GetWithdrawal(stub, transaction.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	if withdrawal.Status != transactions.WITHDRAWAL_PENDING {
+		return nil, utils.ConflictError("Withdrawal %s is %s, not %s", withdrawal.Id, withdrawal.Status, transactions.WITHDRAWAL_PENDING)
+	}
+
+	withdrawal.DecidedAt, err = utils.GetTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	withdrawal.DecidedBy = transaction.CreatorId
+	return withdrawal, nil
+}
+
+func ApproveWithdrawal(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Approves a pending withdrawal once its funds have been paid off-chain. The held funds
+		leave the ledger.
+
+		Args:
+			WithdrawalID (string): ID of the pending Withdrawal
+			PaymentReference (string): Reference to the off-chain payment
+	*/
+	withdrawal, err := decidePendingWithdrawal(stub, transaction, "PaymentReference")
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	withdrawal.Status = transactions.WITHDRAWAL_APPROVED
+	withdrawal.PaymentReference = transaction.Args[1]
+	err = utils.SetWithdrawal(stub, withdrawal)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	return utils.TextResponse(stub, transaction, withdrawal, "SUCCESS")
+}
+
+func RejectWithdrawal(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Rejects a pending withdrawal, returning the held funds to the bank account. The
+		final balance of an offboarded entity, whose account has been released, is returned
+		to the Beatchain admin account instead.
+
+		Args:
+			WithdrawalID (string): ID of the pending Withdrawal
+			Reason (string): Reason for the rejection
+	*/
+	var bankAccount *utils.BankAccount
+
+	withdrawal, err := decidePendingWithdrawal(stub, transaction, "Reason")
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	refundBankAccountId := withdrawal.BankAccountId
+	if withdrawal.Closing {
+		refundBankAccountId = utils.BEATCHAIN_ADMIN_BANK_ACCOUNT_ID
+	}
+	bankAccount, err = utils.GetBankAccount(stub, refundBankAccountId)
+	if err != nil {
+		return utils.ErrorResponse(utils.WrapError(err, "Error accessing BA with id %s", refundBankAccountId))
+	}
+	bankAccount.Balance = utils.RoundCents(float64(bankAccount.Balance + withdrawal.Amount))
+	err = utils.SetBankAccount(stub, bankAccount)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	withdrawal.Status = transactions.WITHDRAWAL_REJECTED
+	withdrawal.Reason = transaction.Args[1]
+	err = utils.SetWithdrawal(stub, withdrawal)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	return utils.TextResponse(stub, transaction, withdrawal, "SUCCESS")
+}
+
+func ListWithdrawals(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Lists withdrawals as JSON, optionally only those with a given status
+
+		Args:
+			Status (string, optional): PENDING, APPROVED or REJECTED
+	*/
+	var withdrawals, matching []*utils.Withdrawal
+	var status string
+	var err error
+
+	// Access control: Only a Beatchain Admin can invoke this transaction
+	if !transaction.TestMode && !utils.AuthenticateBeatchainAdmin(transaction) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Beatchain Admin Org. Access denied."))
+	}
+	if len(transaction.Args) > 1 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting at most 1: {Status}. Found %d", len(transaction.Args)))
+	}
+	if len(transaction.Args) == 1 {
+		status = transaction.Args[0]
+	}
+
+	withdrawals, err = utils.GetWithdrawals(stub, "")
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	matching = []*utils.Withdrawal{}
+	for _, withdrawal := range withdrawals {
+		if status == "" || withdrawal.Status == status {
+			matching = append(matching, withdrawal)
+		}
+	}
+
+	page, err := utils.GetPage(stub, len(matching))
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.PageResponse(stub, transaction, matching[page.Offset:page.Offset+page.Returned], page)
+}
+
+func SetWithdrawalLimits(stub shim.ChaincodeStubInterface, transaction *utils.Transaction) pb.Response {
+	/*
+		Sets the limits on moving funds off-chain. TransferFunds over the per-transaction
+		limit need multi-signature approval, as those over the transfer policy threshold do.
+
+		Args:
+			PerTransaction (string): Maximum amount of a single transfer or withdrawal in $USD
+			Daily (string): Maximum withdrawn from a bank account per UTC day in $USD
+	*/
+	var perTransaction, daily float32
+	var err error
+
+	// Access control: Only a Beatchain Admin can invoke this transaction
+	if !transaction.TestMode && !utils.AuthenticateBeatchainAdmin(transaction) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Beatchain Admin Org. Access denied."))
+	}
+	if len(transaction.Args) != 2 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 2: {PerTransaction, Daily}. Found %d", len(transaction.Args)))
+	}
+	perTransaction, err = parseAmount(transaction.Args[0])
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	daily, err = parseAmount(transaction.Args[1])
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if perTransaction > daily {
+		return utils.ErrorResponse(utils.InvalidArgumentError("PerTransaction", "PerTransaction limit %.2f exceeds Daily limit %.2f", perTransaction, daily))
+	}
+
+	limits := &utils.WithdrawalLimits{PerTransaction: perTransaction, Daily: daily}
+	err = utils.SetWithdrawalLimits(stub, limits)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.TextResponse(stub, transaction, limits, "SUCCESS")
+}
@@ -10,4 +10,70 @@ const (
 	REQUESTED	= "REQUESTED"
 	ACCEPTED	= "ACCEPTED"
 	REJECTED	= "REJECTED"
-)
\ No newline at end of file
+	TERMINATED	= "TERMINATED"	// A party to the contract was offboarded
+)
+
+// Invoice state values
+const (
+	INVOICE_OPEN	= "OPEN"
+	INVOICE_SETTLED	= "SETTLED"
+)
+
+// Bulk import settings
+const (
+	BULK_IMPORT_MAX_ROWS	= 500
+	BULK_IMPORT_ATOMIC		= "atomic"	// Nothing is imported unless every row is valid
+	BULK_IMPORT_PARTIAL		= "partial"	// Valid rows are imported; invalid rows are reported
+)
+
+// Offboarding balance dispositions
+const (
+	CLOSE_PAYOUT	= "payout"	// Remaining balance is held in a withdrawal to the closed entity for admin approval
+	CLOSE_SWEEP		= "sweep"	// Remaining balance is swept to the Beatchain admin account
+)
+
+// Withdrawal state values
+const (
+	WITHDRAWAL_PENDING	= "PENDING"
+	WITHDRAWAL_APPROVED	= "APPROVED"
+	WITHDRAWAL_REJECTED	= "REJECTED"
+)
+
+// Transfer proposal state values
+const (
+	PROPOSAL_PENDING	= "PENDING"
+	PROPOSAL_EXECUTED	= "EXECUTED"
+	PROPOSAL_EXPIRED	= "EXPIRED"
+)
+
+// Flagged stream state values
+const (
+	STREAM_HELD	= "HELD"
+	STREAM_RELEASED	= "RELEASED"
+	STREAM_REJECTED	= "REJECTED"
+)
+
+// Dispute state values
+const (
+	DISPUTE_OPEN		= "OPEN"
+	DISPUTE_RESOLVED	= "RESOLVED"
+)
+
+// Engagement metric types, optionally priced by contracts
+const (
+	METRIC_FULL_PLAY	= "fullplay"
+	METRIC_SKIP			= "skip"
+	METRIC_LIKE			= "like"
+	METRIC_PLAYLIST_ADD	= "playlistadd"
+)
+
+var METRIC_TYPES = []string{METRIC_FULL_PLAY, METRIC_SKIP, METRIC_LIKE, METRIC_PLAYLIST_ADD}
+
+func IsMetricType(metricType string) bool {
+	for _, known := range METRIC_TYPES {
+		if metricType == known {
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,11 @@
 package streaming
 
 import (
-	"errors"
-	"fmt"
+	"encoding/json"
+	"math"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/beatchain/transactions"
 	"github.com/beatchain/utils"
@@ -14,11 +16,118 @@ import (
 
 var ContractVariable = "contractVariable"
 
+func parseOfferedTerms(stub shim.ChaincodeStubInterface, txn *utils.Transaction) (*utils.ContractTerms, error) {
+	/*
+		Parses the pricing and advance terms of a contract offer. Terms are read from the
+		transient map under CONTRACT_TERMS_TRANSIENT_KEY so that they are not recorded in the
+		transaction proposal. Positional arguments would publish the terms, so they are only
+		accepted in test mode.
+	*/
+	var transientMap map[string][]byte
+	var creatorPayPerStream, advance, minimumGuarantee float64
+	var guaranteeEnd, salt string
+	var err error
+
+	terms := &utils.ContractTerms{}
+
+	transientMap, err = stub.GetTransient()
+	if err != nil {
+		return nil, err
+	}
+
+	if termsBytes, ok := transientMap[utils.CONTRACT_TERMS_TRANSIENT_KEY]; ok {
+		if len(txn.Args) != 3 {
+			return nil, utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 3 with transient terms: {AppDevID, CreatorID, ProductID}. Found %d", len(txn.Args))
+		}
+		err = json.Unmarshal(termsBytes, terms)
+		if err != nil {
+			return nil, utils.InvalidArgumentError(utils.CONTRACT_TERMS_TRANSIENT_KEY, "Cannot parse transient contract terms: %s", err.Error())
+		}
+		creatorPayPerStream = float64(terms.CreatorPayPerStream)
+		advance = float64(terms.Advance)
+		minimumGuarantee = float64(terms.MinimumGuarantee)
+		guaranteeEnd = terms.GuaranteeEnd
+	} else if !txn.TestMode {
+		return nil, utils.InvalidArgumentError(utils.CONTRACT_TERMS_TRANSIENT_KEY, "Contract terms must be given in the transient map under %s", utils.CONTRACT_TERMS_TRANSIENT_KEY)
+	} else {
+		if len(txn.Args) < 4 || len(txn.Args) > 7 {
+			return nil, utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 4 to 7: {AppDevID, CreatorID, ProductID, CreatorPayPerStream, [Advance], [MinimumGuarantee], [GuaranteeEnd]}. Found %d", len(txn.Args))
+		}
+		creatorPayPerStream, err = strconv.ParseFloat(txn.Args[3], 32)
+		if err != nil {
+			return nil, utils.InvalidArgumentError("CreatorPayPerStream", "Cannot parse given CreatorPayPerStream to float32: %s", txn.Args[3])
+		}
+		// Parse the optional advance terms
+		if len(txn.Args) > 4 {
+			advance, err = strconv.ParseFloat(txn.Args[4], 32)
+			if err != nil {
+				return nil, utils.InvalidArgumentError("Advance", "Cannot parse given Advance to float32: %s", txn.Args[4])
+			}
+		}
+		if len(txn.Args) > 5 {
+			minimumGuarantee, err = strconv.ParseFloat(txn.Args[5], 32)
+			if err != nil {
+				return nil, utils.InvalidArgumentError("MinimumGuarantee", "Cannot parse given MinimumGuarantee to float32: %s", txn.Args[5])
+			}
+		}
+		if len(txn.Args) > 6 {
+			guaranteeEnd = txn.Args[6]
+		}
+	}
+
+	advance = math.Round(advance*100) / 100
+	minimumGuarantee = math.Round(minimumGuarantee*100) / 100
+	if creatorPayPerStream < 0.0 || advance < 0.0 || minimumGuarantee < 0.0 {
+		return nil, utils.InvalidArgumentError("", "CreatorPayPerStream, Advance and MinimumGuarantee must be >= $0.00")
+	}
+	if minimumGuarantee > 0.0 && minimumGuarantee < advance {
+		return nil, utils.InvalidArgumentError("MinimumGuarantee", "MinimumGuarantee $%.2f cannot be less than the Advance $%.2f", minimumGuarantee, advance)
+	}
+	// A minimum guarantee is owed at the end of its term, not as a second advance
+	if minimumGuarantee > 0.0 {
+		_, err = time.Parse(utils.DATE_LAYOUT, guaranteeEnd)
+		if err != nil {
+			return nil, utils.InvalidArgumentError("GuaranteeEnd", "A GuaranteeEnd day in form YYYY-MM-DD must be given with a MinimumGuarantee. Given: %s", guaranteeEnd)
+		}
+	} else {
+		guaranteeEnd = ""
+	}
+	for metricType, rate := range terms.MetricRates {
+		if !transactions.IsMetricType(metricType) {
+			return nil, utils.InvalidArgumentError("MetricRates", "Unknown metric type %s. Expecting one of %s", metricType, strings.Join(transactions.METRIC_TYPES, ", "))
+		}
+		if rate < 0.0 {
+			return nil, utils.InvalidArgumentError("MetricRates", "Rate for metric %s must be >= $0.00", metricType)
+		}
+	}
+	if len(terms.MetricRates) == 0 {
+		terms.MetricRates = nil
+	}
+	salt, err = utils.DeriveContractSalt(stub, txn.TestMode, txn.Args[1], txn.Args[0], txn.Args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return &utils.ContractTerms{
+		CreatorId: txn.Args[1],
+		AppDevId: txn.Args[0],
+		ProductId: txn.Args[2],
+		CreatorPayPerStream: float32(creatorPayPerStream),
+		Advance: float32(advance),
+		MinimumGuarantee: float32(minimumGuarantee),
+		GuaranteeEnd: guaranteeEnd,
+		Salt: salt,
+		RecoupBalance: 0.0,
+		TotalEarned: 0.0,
+		MetricRates: terms.MetricRates}, nil
+}
+
 // THESE FUNCTIONS DO NOT CHECK TO SEE IF THE CALLER IS THE ACTUAL ORG MAKING/ACCEPTING/DENYING CONTRACT
 func OfferContract(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
 	/*
 	Offers a contract with a given payment per stream in $USD to a Creator for the rights
-	to stream content.
+	to stream content. The pricing and advance terms are stored in the contract terms private
+	data collection; only their hash is recorded on the public Contract.
 
 	Args:
 		AppDevID (string): ID of the AppDev Submitting the offer
@@ -26,69 +135,112 @@ func OfferContract(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.
 		CreatorID (string): ID of the Creator to which the contract is offered
 		ProductID (string): ID of the Product under consideration of the contract
 			Note: Each Product has a separate contract in this draft
-		CreatorPayPerStream (float32): Payment in $USD per stream of the product
+		CreatorPayPerStream (float32): Test mode only. Payment in $USD per stream of the product
+		Advance (float32): Test mode only. Optional. Advance in $USD paid to the Creator upon
+			acceptance and recouped from subsequent stream earnings. Defaults to 0.
+		MinimumGuarantee (float32): Test mode only. Optional. Minimum total in $USD guaranteed
+			to the Creator over the guarantee term. Must be 0 or no less than the Advance.
+			Defaults to 0.
+		GuaranteeEnd (string): Test mode only. Required with a MinimumGuarantee. UTC day in
+			form YYYY-MM-DD ending the guarantee term. Any shortfall against the guarantee is
+			paid by the first settlement from that day, or when the contract is terminated.
+
+	Transient:
+		contractterms (JSON): {creatorpayperstream, advance, minimumguarantee, guaranteeend, metricrates}.
+			Required outside test mode, in which case only the first 3 arguments are expected.
+			metricrates optionally prices engagement metrics in $USD per metric by type, e.g.
+			{"like": 0.002}. Metrics can only be priced through the transient terms.
+		contractsalt (bytes): Random seed of at least 16 bytes from which the salt hashed
+			with the terms is derived. Required outside test mode.
+
+	Note: the hash only hides the terms themselves. The public balance transfers made at
+	acceptance and settlement, and the product's public stream counters, still let an
+	observer estimate the rate and advance of a contract.
 	*/
 
 	var creator *utils.CreatorRecord
 	var product *utils.Product
+	var existingContract *utils.Contract
+	var terms *utils.ContractTerms
 	var err error
 
 	// Access control: Only an AppDev Org member can invoke this transaction
 	//if !utils.AuthenticateAppDev(txn) {
-	//	return shim.Error("Caller not a member of AppDev Org. Access denied.")
+	//	return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of AppDev Org. Access denied."))
 	//}
 
-	args := txn.Args
-	if len(args) != 4 {
-		err := errors.New(fmt.Sprintf("Incorrect number of arguments. Expecting 4: {AppDevID, CreatorID, ProductID, CreatorPayPerStream}. Found %d", len(args)))
-		return shim.Error(err.Error())
+	if len(txn.Args) < 3 {
+		err := utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting at least 3: {AppDevID, CreatorID, ProductID}. Found %d", len(txn.Args))
+		return utils.ErrorResponse(err)
 	}
 
 	appDevId := txn.Args[0]
 	creatorId := txn.Args[1]
 	productId := txn.Args[2]
 
-	creatorPayPerStream, err := strconv.ParseFloat(txn.Args[3], 32)
+	terms, err = parseOfferedTerms(stub, txn)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	// check for valid AppDev
-	_, err = utils.GetAppDevRecord(stub, txn.Args[0])
+	_, err = utils.GetAppDevRecord(stub, appDevId)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	// check for valid Creator
-	creator, err = utils.GetCreatorRecord(stub, txn.Args[1])
+	creator, err = utils.GetCreatorRecord(stub, creatorId)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	// check for valid Product and verify Creator owns Product
-	product, err = utils.GetProduct(stub, txn.Args[2])
+	product, err = utils.GetProduct(stub, productId)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
 	if creator.Id != product.CreatorId {
-		err = errors.New(fmt.Sprintf("Creator does not match Product. Creator: "  + creator.Id + " Product's Creator: " + product.CreatorId) + " productID: " + product.Id)
-		return shim.Error(err.Error())
+		err = utils.InvalidArgumentError("CreatorID", "Creator does not match Product. Creator: %s Product's Creator: %s productID: %s", creator.Id, product.CreatorId, product.Id)
+		return utils.ErrorResponse(err)
+	}
+
+	// Do not allow a new offer to wipe out the advance, earnings and unpaid usage of an accepted contract
+	existingContract, err = utils.GetContract(stub, creatorId, appDevId, productId)
+	if err == nil && existingContract.Status == transactions.ACCEPTED {
+		return utils.ErrorResponse(utils.ConflictError("Contract is already %s; it must be terminated before a new offer", existingContract.Status))
+	}
+
+	termsHash, err := utils.HashContractTerms(terms)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	err = utils.SetContractTerms(stub, terms)
+	if err != nil {
+		return utils.ErrorResponse(err)
 	}
 
-	raw_contract := &utils.Contract{CreatorId: creatorId, AppDevId: appDevId, ProductId: productId, CreatorPayPerStream: float32(creatorPayPerStream), Status: transactions.REQUESTED}
+	raw_contract := &utils.Contract{
+		CreatorId: creatorId,
+		AppDevId: appDevId,
+		ProductId: productId,
+		Status: transactions.REQUESTED,
+		TermsHash: termsHash}
 	err = utils.SetContract(stub, raw_contract)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
-	return shim.Success([]byte("SUCCESS"))
+	return utils.TextResponse(stub, txn, raw_contract, "SUCCESS")
 }
 
 func AcceptContract(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
 	/*
 		Accepts an offered contract for a given payment per stream in $USD by a Creator for the rights
-		to stream content.
+		to stream content. If the contract carries an advance, it is transferred from the AppDev's
+		bank account to the Creator's and becomes the contract's recoupment balance.
 
 		Args:
 			CreatorID (string): ID of the Creator to which the contract is offered
@@ -98,17 +250,20 @@ func AcceptContract(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb
 			AppDevID (string): ID of the AppDev Submitting the offer
 	*/
 
+	var creatorRecord *utils.CreatorRecord
+	var appDevRecord *utils.AppDevRecord
+	var creatorBankAccount, appDevBankAccount *utils.BankAccount
 	var err error
 
 	// Access control: Only an Creator Org member can invoke this transaction
-	if !utils.AuthenticateCreator(txn) {
-		return shim.Error("Caller not a member of Creator Org. Access denied.")
+	if !txn.TestMode && !utils.AuthenticateCreator(txn) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Creator Org. Access denied."))
 	}
 
 	args := txn.Args
 	if len(args) != 3 {
-		err := errors.New(fmt.Sprintf("Incorrect number of arguments. Expecting 3: {CreatorID, ProductID, AppDevID}. Found %d", len(args)))
-		return shim.Error(err.Error())
+		err := utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 3: {CreatorID, ProductID, AppDevID}. Found %d", len(args))
+		return utils.ErrorResponse(err)
 	}
 
 	creatorId := txn.Args[0]
@@ -117,17 +272,66 @@ func AcceptContract(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb
 
 	contract, err := utils.GetContract(stub, creatorId, appDevId, productId)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
+	}
+
+	if contract.Status != transactions.REQUESTED {
+		return utils.ErrorResponse(utils.ConflictError("Contract status is %s; only %s contracts can be accepted", contract.Status, transactions.REQUESTED))
+	}
+
+	terms, err := utils.GetVerifiedContractTerms(stub, contract)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	if terms.Advance > 0.0 {
+		// Pay the advance from the AppDev to the Creator
+		appDevRecord, err = utils.GetAppDevRecord(stub, appDevId)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+		appDevBankAccount, err = utils.GetBankAccount(stub, appDevRecord.BankAccountId)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+		creatorRecord, err = utils.GetCreatorRecord(stub, creatorId)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+		creatorBankAccount, err = utils.GetBankAccount(stub, creatorRecord.BankAccountId)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+
+		if appDevBankAccount.Balance < terms.Advance {
+			return utils.ErrorResponse(utils.InsufficientFundsError("AppDev ID: %s Insufficient Funds for advance of $%.2f", appDevId, terms.Advance))
+		}
+		appDevBankAccount.Balance -= terms.Advance
+		creatorBankAccount.Balance += terms.Advance
+
+		err = utils.SetBankAccount(stub, appDevBankAccount)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+		err = utils.SetBankAccount(stub, creatorBankAccount)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
 	}
 
 	contract.Status = transactions.ACCEPTED
+	terms.RecoupBalance = terms.Advance
 
 	err = utils.SetContract(stub, contract)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
+	}
+	err = utils.SetContractTerms(stub, terms)
+	if err != nil {
+		return utils.ErrorResponse(err)
 	}
 
-	return shim.Success([]byte("SUCCESS"))
+	return utils.TextResponse(stub, txn, contract, "SUCCESS")
 }
 
 func RejectContract(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
@@ -144,14 +348,14 @@ func RejectContract(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb
 	var err error
 
 	// Access control: Only an Creator Org member can invoke this transaction
-	if !utils.AuthenticateCreator(txn) {
-		return shim.Error("Caller not a member of Creator Org. Access denied.")
+	if !txn.TestMode && !utils.AuthenticateCreator(txn) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Creator Org. Access denied."))
 	}
 
 	args := txn.Args
 	if len(args) != 3 {
-		err := errors.New(fmt.Sprintf("Incorrect number of arguments. Expecting 3: {CreatorID, ProductID, AppDevID}. Found %d", len(args)))
-		return shim.Error(err.Error())
+		err := utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 3: {CreatorID, ProductID, AppDevID}. Found %d", len(args))
+		return utils.ErrorResponse(err)
 	}
 
 	creatorId := txn.Args[0]
@@ -160,15 +364,72 @@ func RejectContract(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb
 
 	contract, err := utils.GetContract(stub, creatorId, appDevId, productId)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
+	}
+
+	if contract.Status != transactions.REQUESTED {
+		return utils.ErrorResponse(utils.ConflictError("Contract status is %s; only %s contracts can be rejected", contract.Status, transactions.REQUESTED))
 	}
 
 	contract.Status = transactions.REJECTED
 
 	err = utils.SetContract(stub, contract)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
+	}
+
+	return utils.TextResponse(stub, txn, contract, "SUCCESS")
+}
+
+func GetContractTerms(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Returns the terms of a contract along with its advance recoupment and minimum guarantee
+		standing as JSON. Only the Creator and the AppDev party to the contract may view it, as the
+		terms are read from the contract terms private data collection.
+
+		Args:
+			CreatorID (string): ID of the Creator party to the contract
+			ProductID (string): ID of the Product under contract
+			AppDevID (string): ID of the AppDev party to the contract
+	*/
+	var contract *utils.Contract
+	var terms *utils.ContractTerms
+	var err error
+
+	// Access control: Only the Creator and AppDev orgs can invoke this transaction
+	if !txn.TestMode && !(utils.AuthenticateCreator(txn) || utils.AuthenticateAppDev(txn)) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Creator or AppDev Org. Access denied."))
+	}
+
+	args := txn.Args
+	if len(args) != 3 {
+		err := utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 3: {CreatorID, ProductID, AppDevID}. Found %d", len(args))
+		return utils.ErrorResponse(err)
+	}
+
+	creatorId := txn.Args[0]
+	productId := txn.Args[1]
+	appDevId := txn.Args[2]
+
+	// Only the parties to the contract may view its terms
+	if !txn.TestMode && txn.CreatorId != creatorId && txn.CreatorId != appDevId {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller is not a party to the contract. Access denied."))
+	}
+
+	contract, err = utils.GetContract(stub, creatorId, appDevId, productId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	terms, err = utils.GetVerifiedContractTerms(stub, contract)
+	if err != nil {
+		return utils.ErrorResponse(err)
 	}
 
-	return shim.Success([]byte("SUCCESS"))
+	return utils.SuccessResponse(stub, txn, struct {
+		*utils.ContractTerms
+		Status             string  `json:"contractstatus"`
+		TermsHash          string  `json:"termshash"`
+		GuaranteeShortfall float32 `json:"guaranteeshortfall"`
+	}{terms, contract.Status, contract.TermsHash, utils.GuaranteeShortfall(terms)})
 }
@@ -0,0 +1,312 @@
+package streaming
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/beatchain/transactions"
+	"github.com/beatchain/utils"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+func checkFraudRules(rules *utils.FraudRules, playLog *utils.PlayLog, productId string, metricType string,
+	playedAt time.Time) []string {
+	/*
+		Evaluates a play against the fraud rules and the customer's plays over the last day.
+		Streams and each type of engagement are counted separately. Plays older than a day
+		are dropped from the log. A rule set to 0 is not enforced.
+
+		Returns:
+			reasons: Descriptions of the rules the play breaks. Empty if the play is payable.
+	*/
+	var recent []utils.Play
+	var windowPlays, dailyPlays int
+	var lastPlay time.Time
+	reasons := []string{}
+
+	windowStart := playedAt.Add(-time.Minute * time.Duration(rules.VelocityWindowMinutes))
+	year, month, day := playedAt.UTC().Date()
+
+	for _, play := range playLog.Plays {
+		if playedAt.Sub(play.PlayedAt) >= time.Hour*24 {
+			continue
+		}
+		recent = append(recent, play)
+		if play.MetricType != metricType {
+			continue
+		}
+
+		if play.PlayedAt.After(windowStart) {
+			windowPlays += 1
+		}
+		if play.ProductId != productId {
+			continue
+		}
+		if play.PlayedAt.After(lastPlay) {
+			lastPlay = play.PlayedAt
+		}
+		pYear, pMonth, pDay := play.PlayedAt.UTC().Date()
+		if pYear == year && pMonth == month && pDay == day {
+			dailyPlays += 1
+		}
+	}
+	playLog.Plays = recent
+
+	if rules.MaxPlaysPerWindow > 0 && windowPlays >= rules.MaxPlaysPerWindow {
+		reasons = append(reasons, fmt.Sprintf("customer exceeded %d plays in %d minutes",
+			rules.MaxPlaysPerWindow, rules.VelocityWindowMinutes))
+	}
+	if rules.MinRepeatGapSeconds > 0 && !lastPlay.IsZero() &&
+		playedAt.Sub(lastPlay) < time.Second*time.Duration(rules.MinRepeatGapSeconds) {
+		reasons = append(reasons, fmt.Sprintf("product repeated within %d seconds", rules.MinRepeatGapSeconds))
+	}
+	if rules.MaxDailyProductPlays > 0 && dailyPlays >= rules.MaxDailyProductPlays {
+		reasons = append(reasons, fmt.Sprintf("customer exceeded %d plays of product today", rules.MaxDailyProductPlays))
+	}
+	return reasons
+}
+
+func SetFraudRules(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Sets the rules a stream must pass to become payable. A rule set to 0 is not enforced.
+
+		Args:
+			MaxPlaysPerWindow (string): Plays allowed per customer within the velocity window
+			VelocityWindowMinutes (string): Length of the velocity window, at most 1440
+			MinRepeatGapSeconds (string): Minimum time between a customer's plays of a product
+			MaxDailyProductPlays (string): Plays of a product allowed per customer per UTC day
+	*/
+	var values [4]int
+	var err error
+
+	// Access control: Only a Beatchain Admin can invoke this transaction
+	if !txn.TestMode && !utils.AuthenticateBeatchainAdmin(txn) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Beatchain Admin Org. Access denied."))
+	}
+	if len(txn.Args) != 4 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 4: {MaxPlaysPerWindow, VelocityWindowMinutes, MinRepeatGapSeconds, MaxDailyProductPlays}. Found %d", len(txn.Args)))
+	}
+	for i, arg := range txn.Args {
+		values[i], err = strconv.Atoi(arg)
+		if err != nil || values[i] < 0 {
+			return utils.ErrorResponse(utils.InvalidArgumentError("", "Fraud rules must be non-negative integers. Given: %s", arg))
+		}
+	}
+	if values[1] > 24*60 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("VelocityWindowMinutes", "VelocityWindowMinutes cannot exceed a day. Given: %d", values[1]))
+	}
+
+	rules := &utils.FraudRules{
+		MaxPlaysPerWindow:     values[0],
+		VelocityWindowMinutes: values[1],
+		MinRepeatGapSeconds:   values[2],
+		MaxDailyProductPlays:  values[3]}
+	err = utils.SetFraudRules(stub, rules)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.TextResponse(stub, txn, rules, "SUCCESS")
+}
+
+func GetFlaggedStreams(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Lists streams flagged by the fraud rules as JSON. AppDevs may only list streams made
+		through their own platform.
+
+		Args:
+			AppDevID (string): ID of the AppDev streamed through. Empty for all AppDevs.
+			ProductID (string): ID of the Product streamed. Empty for all products.
+			Status (string, optional): HELD, RELEASED or REJECTED
+	*/
+	var streams, matching []*utils.FlaggedStream
+	var status string
+	var err error
+
+	// Access control: Only a Beatchain Admin or AppDev Org member can invoke this transaction
+	isAppDev := !txn.TestMode && utils.AuthenticateAppDev(txn)
+	if !txn.TestMode && !isAppDev && !utils.AuthenticateBeatchainAdmin(txn) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Beatchain Admin or AppDev Org. Access denied."))
+	}
+	if len(txn.Args) != 2 && len(txn.Args) != 3 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 2 or 3: {AppDevID, ProductID, Status}. Found %d", len(txn.Args)))
+	}
+	appDevId := txn.Args[0]
+	productId := txn.Args[1]
+	if len(txn.Args) == 3 {
+		status = txn.Args[2]
+	}
+	if isAppDev && appDevId != txn.CreatorId {
+		return utils.ErrorResponse(utils.ForbiddenError("AppDevs may only list flagged streams made through their own platform"))
+	}
+
+	streams, err = utils.GetFlaggedStreams(stub, appDevId, productId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	matching = []*utils.FlaggedStream{}
+	for _, stream := range streams {
+		if status == "" || stream.Status == status {
+			matching = append(matching, stream)
+		}
+	}
+
+	page, err := utils.GetPage(stub, len(matching))
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.PageResponse(stub, txn, matching[page.Offset:page.Offset+page.Returned], page)
+}
+
+func ReviewFlaggedStream(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Settles an admin's review of a held stream. Released streams become payable to the
+		product's Creator; rejected streams are never paid.
+
+		Args:
+			StreamID (string): ID of the held FlaggedStream
+			Decision (string): RELEASED or REJECTED
+	*/
+	var stream *utils.FlaggedStream
+	var product *utils.Product
+	var err error
+
+	// Access control: Only a Beatchain Admin can invoke this transaction
+	if !txn.TestMode && !utils.AuthenticateBeatchainAdmin(txn) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Beatchain Admin Org. Access denied."))
+	}
+	if len(txn.Args) != 2 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 2: {StreamID, Decision}. Found %d", len(txn.Args)))
+	}
+	decision := txn.Args[1]
+	if decision != transactions.STREAM_RELEASED && decision != transactions.STREAM_REJECTED {
+		return utils.ErrorResponse(utils.InvalidArgumentError("Decision", "Decision must be %s or %s. Given: %s",
+			transactions.STREAM_RELEASED, transactions.STREAM_REJECTED, decision))
+	}
+
+	stream, err = utils.GetFlaggedStream(stub, txn.Args[0])
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if stream.Status != transactions.STREAM_HELD {
+		return utils.ErrorResponse(utils.ConflictError("Stream %s is %s, not %s", stream.Id, stream.Status, transactions.STREAM_HELD))
+	}
+
+	if decision == transactions.STREAM_RELEASED {
+		product, err = utils.GetProduct(stub, stream.ProductId)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+		err = creditPlay(stub, stream.AppDevId, product, stream.MetricType)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+	}
+
+	stream.Status = decision
+	stream.ReviewedBy = txn.CreatorId
+	stream.ReviewedAt, err = utils.GetTxTime(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	err = utils.SetFlaggedStream(stub, stream)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.TextResponse(stub, txn, stream, "SUCCESS")
+}
+
+func creditPlay(stub shim.ChaincodeStubInterface, appDevId string, product *utils.Product, metricType string) error {
+	/*
+		Makes a play payable to the product's Creator. Streams are counted on the product;
+		engagement is counted on the AppDev's contract, whose rates it is paid at.
+	*/
+	if metricType == "" {
+		product.UnRenumeratedListens += 1
+		return utils.SetProduct(stub, product)
+	}
+
+	contract, err := utils.GetContract(stub, product.CreatorId, appDevId, product.Id)
+	if err != nil {
+		return err
+	}
+	if contract.UnRenumeratedMetricCounts == nil {
+		contract.UnRenumeratedMetricCounts = make(map[string]int64)
+	}
+	contract.UnRenumeratedMetricCounts[metricType] += 1
+	err = utils.SetContract(stub, contract)
+	if err != nil {
+		return err
+	}
+	product.UnRenumeratedMetrics += 1
+	return utils.SetProduct(stub, product)
+}
+
+func recordPlay(stub shim.ChaincodeStubInterface, txn *utils.Transaction, customer *utils.CustomerRecord,
+	appDevId string, product *utils.Product, metricType string) (*utils.FlaggedStream, error) {
+	/*
+		Records a customer's play of a product through an AppDev: a stream, or engagement of
+		the given metric type. Plays passing the fraud rules are payable to the product's
+		Creator; others are held as a FlaggedStream pending admin review.
+
+		Returns:
+			stream: The held FlaggedStream, or nil if the play is payable
+			err: Error object. nil if no error occurred.
+	*/
+	var rules *utils.FraudRules
+	var playLog *utils.PlayLog
+	var stream *utils.FlaggedStream
+	var playedAt time.Time
+	var err error
+
+	playedAt, err = utils.GetTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	rules, err = utils.GetFraudRules(stub)
+	if err != nil {
+		return nil, err
+	}
+	playLog, err = utils.GetPlayLog(stub, customer.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	reasons := checkFraudRules(rules, playLog, product.Id, metricType, playedAt)
+	if len(reasons) == 0 {
+		err = creditPlay(stub, appDevId, product, metricType)
+	} else {
+		stream = &utils.FlaggedStream{
+			CustomerId: customer.Id,
+			AppDevId:   appDevId,
+			CreatorId:  product.CreatorId,
+			ProductId:  product.Id,
+			MetricType: metricType,
+			PlayedAt:   playedAt,
+			Reasons:    reasons,
+			Status:     transactions.STREAM_HELD,
+			TxId:       stub.GetTxID()}
+		stream.Id, err = utils.GetUniqueId(stub, txn)
+		if err != nil {
+			return nil, err
+		}
+		err = utils.SetFlaggedStream(stub, stream)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Streams, held or not, allow the customer to record engagement with the product
+	if metricType == "" {
+		err = utils.SetStreamedProduct(stub, customer.Id, appDevId, product.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Flagged plays are logged too so that continued abuse stays flagged
+	playLog.Plays = append(playLog.Plays, utils.Play{ProductId: product.Id, MetricType: metricType, PlayedAt: playedAt})
+	return stream, utils.SetPlayLog(stub, playLog)
+}
@@ -0,0 +1,429 @@
+package streaming
+
+import (
+	"encoding/json"
+
+	"github.com/beatchain/utils"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+func validateCustomerCaller(txn *utils.Transaction) error {
+	/*
+		Validates the caller of a playlist or play queue function
+	*/
+	// Access control: Only an Customer Org member can invoke this transaction
+	if !txn.TestMode && !utils.AuthenticateCustomer(txn) {
+		return utils.ForbiddenError("Caller not a member of Customer Org. Access denied.")
+	}
+	if txn.TestMode {
+		txn.CreatorId = utils.TEST_CUSTOMER_ID
+	}
+	if txn.CreatorId == "" {
+		return utils.ForbiddenError("Transaction invoker Customer ID not found in ecert attributes")
+	}
+	return nil
+}
+
+func parsePlaylistProducts(stub shim.ChaincodeStubInterface, productIdsJson string) ([]string, error) {
+	/*
+		Parses a JSON array of product IDs, validating that each product exists and is active
+	*/
+	var productIds []string
+
+	err := json.Unmarshal([]byte(productIdsJson), &productIds)
+	if err != nil {
+		return nil, utils.InvalidArgumentError("ProductIDs", "Cannot parse ProductIDs as a JSON array: %s", err.Error())
+	}
+	if len(productIds) > utils.MAX_PLAYLIST_ENTRIES {
+		return nil, utils.InvalidArgumentError("ProductIDs", "Playlists are limited to %d entries. Given: %d", utils.MAX_PLAYLIST_ENTRIES, len(productIds))
+	}
+	for _, productId := range productIds {
+		product, err := utils.GetProduct(stub, productId)
+		if err != nil {
+			return nil, err
+		}
+		if !product.IsActive {
+			return nil, utils.ConflictError("Product %s is no longer available", productId)
+		}
+	}
+	if productIds == nil {
+		productIds = []string{}
+	}
+	return productIds, nil
+}
+
+func getOwnedPlaylist(stub shim.ChaincodeStubInterface, txn *utils.Transaction, playlistId string) (*utils.Playlist, error) {
+	/*
+		Fetches a playlist, validating that the caller owns it
+	*/
+	playlist, err := utils.GetPlaylist(stub, playlistId)
+	if err != nil {
+		return nil, err
+	}
+	if playlist.OwnerId != txn.CreatorId {
+		return nil, utils.ForbiddenError("Playlist %s is not owned by customer %s", playlistId, txn.CreatorId)
+	}
+	return playlist, nil
+}
+
+func CreatePlaylist(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Creates a playlist owned by the calling Customer. Returns the playlist as JSON.
+
+		Args:
+			Name (string): Name of the playlist
+			ProductIDs (string): JSON array of the IDs of the products in play order
+	*/
+	var productIds []string
+	var err error
+
+	err = validateCustomerCaller(txn)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if len(txn.Args) != 2 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 2: {Name, ProductIDs}. Found %d", len(txn.Args)))
+	}
+	if txn.Args[0] == "" {
+		return utils.ErrorResponse(utils.InvalidArgumentError("Name", "Name is required"))
+	}
+	productIds, err = parsePlaylistProducts(stub, txn.Args[1])
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	playlist := &utils.Playlist{
+		OwnerId:    txn.CreatorId,
+		Name:       txn.Args[0],
+		ProductIds: productIds,
+		SharedWith: []string{}}
+	playlist.CreatedAt, err = utils.GetTxTime(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	playlist.UpdatedAt = playlist.CreatedAt
+	playlist.Id, err = utils.GetUniqueId(stub, txn)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	err = utils.SetPlaylist(stub, playlist)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.SuccessResponse(stub, txn, playlist)
+}
+
+func UpdatePlaylist(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Replaces the products of a playlist owned by the calling Customer, e.g. to reorder,
+		add or remove products. Returns the playlist as JSON.
+
+		Args:
+			PlaylistID (string): ID of the Playlist
+			ProductIDs (string): JSON array of the IDs of the products in play order
+	*/
+	var playlist *utils.Playlist
+	var err error
+
+	err = validateCustomerCaller(txn)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if len(txn.Args) != 2 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 2: {PlaylistID, ProductIDs}. Found %d", len(txn.Args)))
+	}
+	playlist, err = getOwnedPlaylist(stub, txn, txn.Args[0])
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	playlist.ProductIds, err = parsePlaylistProducts(stub, txn.Args[1])
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	playlist.UpdatedAt, err = utils.GetTxTime(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	err = utils.SetPlaylist(stub, playlist)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.SuccessResponse(stub, txn, playlist)
+}
+
+func SharePlaylist(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Shares a playlist owned by the calling Customer with another customer, who may then
+		view and enqueue it
+
+		Args:
+			PlaylistID (string): ID of the Playlist
+			CustomerID (string): ID of the customer to share the playlist with
+	*/
+	var playlist *utils.Playlist
+	var err error
+
+	err = validateCustomerCaller(txn)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if len(txn.Args) != 2 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 2: {PlaylistID, CustomerID}. Found %d", len(txn.Args)))
+	}
+	playlist, err = getOwnedPlaylist(stub, txn, txn.Args[0])
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	customerId := txn.Args[1]
+	if utils.CanViewPlaylist(playlist, customerId) {
+		return utils.ErrorResponse(utils.ConflictError("Playlist %s is already visible to customer %s", playlist.Id, customerId))
+	}
+	_, err = utils.GetCustomerRecord(stub, customerId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	playlist.SharedWith = append(playlist.SharedWith, customerId)
+	playlist.UpdatedAt, err = utils.GetTxTime(stub)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	err = utils.SetPlaylist(stub, playlist)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.TextResponse(stub, txn, playlist, "SUCCESS")
+}
+
+func GetPlaylists(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Lists the playlists the calling Customer owns or has been shared as JSON
+
+		Args:
+			None
+	*/
+	err := validateCustomerCaller(txn)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if len(txn.Args) != 0 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "GetPlaylists takes no arguments"))
+	}
+
+	playlists, err := utils.GetCustomerPlaylists(stub, txn.CreatorId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if playlists == nil {
+		playlists = []*utils.Playlist{}
+	}
+	page, err := utils.GetPage(stub, len(playlists))
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.PageResponse(stub, txn, playlists[page.Offset:page.Offset+page.Returned], page)
+}
+
+func enqueue(stub shim.ChaincodeStubInterface, txn *utils.Transaction, appDevId string, productIds []string) pb.Response {
+	/*
+		Appends products to the calling Customer's play queue, validating that each may be
+		streamed under the contracts of the given AppDev. Returns the queue as JSON.
+	*/
+	for _, productId := range productIds {
+		_, _, _, err := validateCustomerStream(stub, txn, appDevId, productId)
+		if err != nil {
+			return utils.ErrorResponse(utils.WrapError(err, "Cannot enqueue product %s", productId))
+		}
+	}
+
+	playQueue, err := utils.GetPlayQueue(stub, txn.CreatorId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if len(playQueue.ProductIds)+len(productIds) > utils.MAX_PLAY_QUEUE_ENTRIES {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Play queues are limited to %d entries", utils.MAX_PLAY_QUEUE_ENTRIES))
+	}
+	playQueue.ProductIds = append(playQueue.ProductIds, productIds...)
+	err = utils.SetPlayQueue(stub, playQueue)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.SuccessResponse(stub, txn, playQueue)
+}
+
+func EnqueueSongs(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Appends products to the end of the calling Customer's play queue. Every product must
+		be covered by a contract with the customer's AppDev. Returns the queue as JSON.
+
+		Args:
+			ProductIDs (string): JSON array of the IDs of the products in play order
+			AppDevID (string, optional): ID of the AppDev to stream through. Required if the
+				Customer subscribes through more than one AppDev.
+	*/
+	var productIds []string
+
+	err := validateCustomerCaller(txn)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if len(txn.Args) != 1 && len(txn.Args) != 2 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 1 or 2: {ProductIDs, AppDevID}. Found %d", len(txn.Args)))
+	}
+	err = json.Unmarshal([]byte(txn.Args[0]), &productIds)
+	if err != nil {
+		return utils.ErrorResponse(utils.InvalidArgumentError("ProductIDs", "Cannot parse ProductIDs as a JSON array: %s", err.Error()))
+	}
+	if len(productIds) == 0 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("ProductIDs", "ProductIDs is empty"))
+	}
+	return enqueue(stub, txn, optionalAppDevId(txn, 1), productIds)
+}
+
+func EnqueuePlaylist(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Appends the products of a playlist the calling Customer owns or has been shared to the
+		end of their play queue. Every product must be covered by a contract with the
+		customer's AppDev. Returns the queue as JSON.
+
+		Args:
+			PlaylistID (string): ID of the Playlist
+			AppDevID (string, optional): ID of the AppDev to stream through. Required if the
+				Customer subscribes through more than one AppDev.
+	*/
+	err := validateCustomerCaller(txn)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if len(txn.Args) != 1 && len(txn.Args) != 2 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 1 or 2: {PlaylistID, AppDevID}. Found %d", len(txn.Args)))
+	}
+	playlist, err := utils.GetPlaylist(stub, txn.Args[0])
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if !utils.CanViewPlaylist(playlist, txn.CreatorId) {
+		return utils.ErrorResponse(utils.ForbiddenError("Playlist %s has not been shared with customer %s", playlist.Id, txn.CreatorId))
+	}
+	if len(playlist.ProductIds) == 0 {
+		return utils.ErrorResponse(utils.ConflictError("Playlist %s is empty", playlist.Id))
+	}
+	return enqueue(stub, txn, optionalAppDevId(txn, 1), playlist.ProductIds)
+}
+
+func GetPlayQueue(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Returns the calling Customer's play queue as JSON
+
+		Args:
+			None
+	*/
+	err := validateCustomerCaller(txn)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if len(txn.Args) != 0 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "GetPlayQueue takes no arguments"))
+	}
+	playQueue, err := utils.GetPlayQueue(stub, txn.CreatorId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.SuccessResponse(stub, txn, playQueue)
+}
+
+func ClearPlayQueue(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Removes every entry from the calling Customer's play queue
+
+		Args:
+			None
+	*/
+	err := validateCustomerCaller(txn)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if len(txn.Args) != 0 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "ClearPlayQueue takes no arguments"))
+	}
+	playQueue := &utils.PlayQueue{CustomerId: txn.CreatorId, ProductIds: []string{}}
+	err = utils.SetPlayQueue(stub, playQueue)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	return utils.TextResponse(stub, txn, playQueue, "SUCCESS")
+}
+
+func NextSong(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Pops the next product off the calling Customer's play queue and streams it as
+		RequestSong would, recording the play. The product is revalidated as AppDev
+		contracts may have changed since it was queued; entries which can no longer be
+		streamed are dropped until a valid one is found or the queue is empty, and are
+		listed in the result. Returns a NextSongResult as JSON, with no product if every
+		remaining entry was dropped.
+
+		Args:
+			AppDevID (string, optional): ID of the AppDev to stream through. Required if the
+				Customer subscribes through more than one AppDev.
+	*/
+	var playQueue *utils.PlayQueue
+	var product *utils.Product
+	var flagged *utils.FlaggedStream
+	var skipped []string
+	var productId string
+	var err error
+
+	err = validateCustomerCaller(txn)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if len(txn.Args) > 1 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 0 or 1: {AppDevID}. Found %d", len(txn.Args)))
+	}
+
+	playQueue, err = utils.GetPlayQueue(stub, txn.CreatorId)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if len(playQueue.ProductIds) == 0 {
+		return utils.ErrorResponse(utils.ConflictError("Play queue of customer %s is empty", txn.CreatorId))
+	}
+	customer, subscription, err := validateCustomerSubscription(stub, txn, optionalAppDevId(txn, 0))
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	// Drop entries whose product is gone or inactive, or whose contract is not accepted, until one can be streamed
+	for product == nil && len(playQueue.ProductIds) > 0 {
+		productId = playQueue.ProductIds[0]
+		playQueue.ProductIds = playQueue.ProductIds[1:]
+		product, err = validateStreamedProduct(stub, subscription, productId)
+		if err != nil {
+			code := utils.ErrorCode(err)
+			if code != utils.ERROR_NOT_FOUND && code != utils.ERROR_CONFLICT {
+				return utils.ErrorResponse(err)
+			}
+			skipped = append(skipped, productId)
+			productId = ""
+		}
+	}
+	if product != nil {
+		flagged, err = streamSong(stub, txn, customer, subscription, product)
+		if err != nil {
+			return utils.ErrorResponse(err)
+		}
+	}
+	err = utils.SetPlayQueue(stub, playQueue)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+
+	return utils.SuccessResponse(stub, txn, &utils.NextSongResult{
+		ProductId: productId,
+		Remaining: len(playQueue.ProductIds),
+		Skipped:   skipped,
+		Flagged:   flagged})
+}
@@ -1,10 +1,9 @@
 package streaming
 
 import (
-	"errors"
-	"fmt"
-	"time"
+	"strings"
 
+	"github.com/beatchain/transactions"
 	"github.com/beatchain/utils"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
@@ -12,72 +11,228 @@ import (
 )
 
 
+func optionalAppDevId(txn *utils.Transaction, index int) string {
+	/*
+		Returns the optional AppDevID argument at the given index, or an empty string if it
+		was not given
+	*/
+	if len(txn.Args) > index {
+		return txn.Args[index]
+	}
+	return ""
+}
+
+func validateCustomerStream(stub shim.ChaincodeStubInterface, txn *utils.Transaction, appDevId string,
+	productId string) (*utils.CustomerRecord, *utils.Subscription, *utils.Product, error) {
+	/*
+		Validates that the calling Customer may stream a product through an AppDev: their
+		subscription through the AppDev must be active and the AppDev must hold an accepted
+		contract for the product. If no AppDev is given the customer's only subscription is used.
+	*/
+	customer, subscription, err := validateCustomerSubscription(stub, txn, appDevId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	product, err := validateStreamedProduct(stub, subscription, productId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return customer, subscription, product, nil
+}
+
+func validateCustomerSubscription(stub shim.ChaincodeStubInterface, txn *utils.Transaction,
+	appDevId string) (*utils.CustomerRecord, *utils.Subscription, error) {
+	/*
+		Validates that the calling Customer's subscription through an AppDev is active. If no
+		AppDev is given the customer's only subscription is used.
+	*/
+	customer, err := utils.GetCustomerRecord(stub, txn.CreatorId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	subscription, err := utils.GetSubscription(customer, appDevId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = utils.GetAppDevRecord(stub, subscription.AppDevId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	txTime, err := utils.GetTxTime(stub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !subscription.SubscriptionDueDate.After(txTime) {
+		return nil, nil, utils.ConflictError("Invalid combination of parameters or subscription no longer active/valid.")
+	}
+	return customer, subscription, nil
+}
+
+func validateStreamedProduct(stub shim.ChaincodeStubInterface, subscription *utils.Subscription,
+	productId string) (*utils.Product, error) {
+	/*
+		Validates that a product is active and that the AppDev of a subscription holds an
+		accepted contract for it
+	*/
+	product, err := utils.GetProduct(stub, productId)
+	if err != nil {
+		return nil, err
+	}
+	if !product.IsActive {
+		return nil, utils.ConflictError("Product %s is no longer active", productId)
+	}
+
+	creator, err := utils.GetCreatorRecord(stub, product.CreatorId)
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := utils.GetContract(stub, creator.Id, subscription.AppDevId, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	if contract.CreatorId != product.CreatorId {
+		return nil, utils.ConflictError("Invalid combination of parameters or subscription no longer active/valid.")
+	}
+	if contract.Status != transactions.ACCEPTED {
+		return nil, utils.ConflictError("Contract for product %s through AppDev %s is %s, not %s", productId,
+			subscription.AppDevId, contract.Status, transactions.ACCEPTED)
+	}
+	return product, nil
+}
+
+func streamSong(stub shim.ChaincodeStubInterface, txn *utils.Transaction, customer *utils.CustomerRecord,
+	subscription *utils.Subscription, product *utils.Product) (*utils.FlaggedStream, error) {
+	/*
+		Streams a validated product to a customer through the AppDev of their subscription,
+		recording the play. Returns the held FlaggedStream if the play broke a fraud rule.
+	*/
+	customer.PreviousSong = customer.QueuedSong
+	customer.QueuedSong = product.Id
+
+	err := utils.SetCustomerRecord(stub, customer)
+	if err != nil {
+		return nil, err
+	}
+	return recordPlay(stub, txn, customer, subscription.AppDevId, product, "")
+}
+
 func RequestSong(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
 	/*
-		Queues a song for streaming by a Customer from an AppDev
+		Queues a song for streaming by a Customer from an AppDev. The stream becomes payable
+		to the product's Creator unless it breaks a fraud rule, in which case it is held for
+		admin review and returned as JSON.
 
 		Args:
 			ProductID (string): ID of the Product to stream
+			AppDevID (string, optional): ID of the AppDev streamed through. Required if the
+				Customer subscribes through more than one AppDev.
 	*/
 	// Access control: Only an Customer Org member can invoke this transaction
 	if !txn.TestMode && !(utils.AuthenticateCustomer(txn) || utils.AuthenticateBeatchainAdmin(txn)) {
-		return shim.Error("Caller not a member of Customer Org. Access denied.")
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Customer Org. Access denied."))
+	}
+	if txn.TestMode {
+		txn.CreatorId = utils.TEST_CUSTOMER_ID
 	}
 
 	if txn.CreatorId == "" {
-		return shim.Error("Transaction invoker Customer ID not found in ecert attributes")
+		return utils.ErrorResponse(utils.ForbiddenError("Transaction invoker Customer ID not found in ecert attributes"))
 	}
 
 	args := txn.Args
 	//if len(args) != 4 {
-	//	err := errors.New(fmt.Sprintf("Incorrect number of arguments. Expecting 4: {CustomerId, AppDevId, CreatorId, ProductID}. Found %d", len(args)))
-	//	return shim.Error(err.Error())
+	//	err := utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 4: {CustomerId, AppDevId, CreatorId, ProductID}. Found %d", len(args))
+	//	return utils.ErrorResponse(err)
 	//}
 
-	if len(args) != 1 {
-		err := errors.New(fmt.Sprintf("Incorrect number of arguments. Expecting 1: {ProductID}. Found %d", len(args)))
-		return shim.Error(err.Error())
+	if len(args) != 1 && len(args) != 2 {
+		err := utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 1 or 2: {ProductID, AppDevID}. Found %d", len(args))
+		return utils.ErrorResponse(err)
 	}
 	productId := txn.Args[0]
 
-	customer, err := utils.GetCustomerRecord(stub, txn.CreatorId)
+	customer, subscription, product, err := validateCustomerStream(stub, txn, optionalAppDevId(txn, 1), productId)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
 
-	product, err := utils.GetProduct(stub, productId)
+	flagged, err := streamSong(stub, txn, customer, subscription, product)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
+	}
+	if flagged != nil && txn.ResponseFormat == utils.RESPONSE_FORMAT_TEXT {
+		return utils.SuccessResponse(stub, txn, flagged)
 	}
 
-	appdev, err := utils.GetAppDevRecord(stub, customer.AppDevId)
-	if err != nil {
-		return shim.Error(err.Error())
+	return utils.TextResponse(stub, txn, &utils.StreamResult{
+		ProductId: product.Id,
+		AppDevId: subscription.AppDevId,
+		Flagged: flagged}, "SUCCESS")
+}
+
+func RecordEngagement(stub shim.ChaincodeStubInterface, txn *utils.Transaction) pb.Response {
+	/*
+		Records a Customer's engagement with a product they have streamed through the
+		AppDev, such as liking it or adding it to a playlist. Engagement is checked against
+		the fraud rules as streams are, and if payable is paid to the product's Creator at
+		the per-metric rates of the AppDev's contract, if it prices them, when the Creator
+		next collects payment. Held engagement is returned as JSON.
+
+		Args:
+			ProductID (string): ID of the Product engaged with
+			MetricType (string): One of fullplay, skip, like or playlistadd
+			AppDevID (string, optional): ID of the AppDev streamed through. Required if the
+				Customer subscribes through more than one AppDev.
+	*/
+	// Access control: Only an Customer Org member can invoke this transaction
+	if !txn.TestMode && !(utils.AuthenticateCustomer(txn) || utils.AuthenticateBeatchainAdmin(txn)) {
+		return utils.ErrorResponse(utils.ForbiddenError("Caller not a member of Customer Org. Access denied."))
+	}
+	if txn.TestMode {
+		txn.CreatorId = utils.TEST_CUSTOMER_ID
+	}
+	if txn.CreatorId == "" {
+		return utils.ErrorResponse(utils.ForbiddenError("Transaction invoker Customer ID not found in ecert attributes"))
+	}
+	if len(txn.Args) != 2 && len(txn.Args) != 3 {
+		return utils.ErrorResponse(utils.InvalidArgumentError("", "Incorrect number of arguments. Expecting 2 or 3: {ProductID, MetricType, AppDevID}. Found %d", len(txn.Args)))
+	}
+	productId := txn.Args[0]
+	metricType := txn.Args[1]
+	if !transactions.IsMetricType(metricType) {
+		return utils.ErrorResponse(utils.InvalidArgumentError("MetricType", "MetricType must be one of %s. Given: %s", strings.Join(transactions.METRIC_TYPES, ", "), metricType))
 	}
 
-	creator, err := utils.GetCreatorRecord(stub, product.CreatorId)
+	customer, subscription, product, err := validateCustomerStream(stub, txn, optionalAppDevId(txn, 2), productId)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
 	}
-
-	contract, err := utils.GetContract(stub, creator.Id, appdev.Id, productId)
+	streamed, err := utils.HasStreamedProduct(stub, customer.Id, subscription.AppDevId, product.Id)
 	if err != nil {
-		return shim.Error(err.Error())
+		return utils.ErrorResponse(err)
+	}
+	if !streamed {
+		return utils.ErrorResponse(utils.ConflictError("Customer %s has not streamed product %s through AppDev %s",
+			customer.Id, product.Id, subscription.AppDevId))
 	}
 
-	if customer.SubscriptionDueDate.After(time.Now()) && customer.AppDevId == appdev.Id && contract.CreatorId == product.CreatorId {
-
-		customer.PreviousSong = customer.QueuedSong
-		customer.QueuedSong = productId
-
-		err = utils.SetProduct(stub, product)
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-	} else {
-		err := errors.New(fmt.Sprintf("Invalid combination of parameters or subscription no longer active/valid."))
-		return shim.Error(err.Error())
+	flagged, err := recordPlay(stub, txn, customer, subscription.AppDevId, product, metricType)
+	if err != nil {
+		return utils.ErrorResponse(err)
+	}
+	if flagged != nil && txn.ResponseFormat == utils.RESPONSE_FORMAT_TEXT {
+		return utils.SuccessResponse(stub, txn, flagged)
 	}
 
-	return shim.Success([]byte("SUCCESS"))
+	return utils.TextResponse(stub, txn, &utils.StreamResult{
+		ProductId: product.Id,
+		AppDevId: subscription.AppDevId,
+		MetricType: metricType,
+		Flagged: flagged}, "SUCCESS")
 }
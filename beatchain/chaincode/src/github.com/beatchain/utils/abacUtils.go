@@ -61,6 +61,7 @@ func GetTxInfo(stub shim.ChaincodeStubInterface, testMode bool) (*Transaction, e
 		}
 		txn.CreatorOrg = creatorSerializedId.Mspid
 		txn.CreatorCertIssuer = cert.Issuer.CommonName
+		txn.CreatorIdentity = fmt.Sprintf("%s::x509::%s::%s", creatorSerializedId.Mspid, cert.Subject.CommonName, cert.Issuer.CommonName)
 
 		// Access Attributes here
 		attribute, found, err = cid.GetAttributeValue(stub, "id")
@@ -84,6 +85,14 @@ func GetTxInfo(stub shim.ChaincodeStubInterface, testMode bool) (*Transaction, e
 		txn.CreatorOrg = "test"
 		txn.CreatorCertIssuer = "test"
 		txn.CreatorAdmin = true
+
+		// Test stubs may act as a named identity
+		creator, _ := stub.GetCreator()
+		if len(creator) > 0 {
+			txn.CreatorIdentity = string(creator)
+		} else {
+			txn.CreatorIdentity = "test"
+		}
 	}
 
 	// Fetch the function call info
@@ -10,7 +10,6 @@ const UNIQUE_STARTING_ID = "100000000"
 const BEATCHAIN_ADMIN_MSP = "BeatchainMSP"
 const BEATCHAIN_ADMIN_CA = "ca.admin.beatchain.com"
 const BEATCHAIN_ADMIN_BANK_ACCOUNT_ID = "1"
-// to be done:  need to factor in changes for bank account inUse bool - Arun
 // Authorization constants
 const CUSTOMER_MSP = "CustomerMSP"
 const CUSTOMER_CA = "ca.customerorg.beatchain.com"
@@ -27,6 +26,73 @@ const CONTRACT_KEY_PREFIX = "Contract"
 const BANK_ACCOUNT_KEY_PREFIX = "BankAccount"
 const APPDEV_RECORD_KEY_PREFIX = "AppDevRecord"
 const PRODUCT_KEY_PREFIX = "Product"
+const CONTRACT_TERMS_KEY_PREFIX = "ContractTerms"
+const DATE_LAYOUT = "2006-01-02" // Layout of the UTC days in index keys and contract terms
+const SETTLEMENT_KEY_PREFIX = "Settlement"
+const INVOICE_KEY_PREFIX = "Invoice"
+const TOMBSTONE_KEY_PREFIX = "Tombstone"
+const WITHDRAWAL_KEY_PREFIX = "Withdrawal"
+const ACCOUNT_WITHDRAWAL_KEY_PREFIX = "AccountWithdrawal" // Index of withdrawals by bank account and day; see Withdrawal.RecordIndexes
+const WITHDRAWAL_LIMITS_KEY = "WITHDRAWAL_LIMITS"
+const TRANSFER_PROPOSAL_KEY_PREFIX = "TransferProposal"
+const TRANSFER_POLICY_KEY = "TRANSFER_POLICY"
+const PLAY_LOG_KEY_PREFIX = "PlayLog"
+const STREAMED_PRODUCT_KEY_PREFIX = "StreamedProduct" // Marks that a customer has streamed a product through an AppDev
+const FLAGGED_STREAM_KEY_PREFIX = "FlaggedStream"
+const APPDEV_FLAGGED_STREAM_KEY_PREFIX = "AppDevFlaggedStream" // Index of flagged streams by AppDev and Creator; see FlaggedStream.RecordIndexes
+const PRODUCT_FLAGGED_STREAM_KEY_PREFIX = "ProductFlaggedStream" // Index of flagged streams by product and AppDev; see FlaggedStream.RecordIndexes
+const FRAUD_RULES_KEY = "FRAUD_RULES"
+const PLAYLIST_KEY_PREFIX = "Playlist"
+const CUSTOMER_PLAYLIST_KEY_PREFIX = "CustomerPlaylist" // Index of playlists by owner and shared customers; see Playlist.RecordIndexes
+const PLAY_QUEUE_KEY_PREFIX = "PlayQueue"
+const SUBSCRIPTION_KEY_PREFIX = "Subscription" // Index of customers by AppDev; see CustomerRecord.RecordIndexes
+const CREATOR_PRODUCT_KEY_PREFIX = "CreatorProduct" // Index of products by Creator; see Product.RecordIndexes
+const APPDEV_CONTRACT_KEY_PREFIX = "AppDevContract" // Index of contracts by AppDev; see Contract.RecordIndexes
+const PRODUCT_CONTRACT_KEY_PREFIX = "ProductContract" // Index of contracts by product; see Contract.RecordIndexes
+const FREE_BANK_ACCOUNT_KEY_PREFIX = "FreeBankAccount" // Index of bank accounts not assigned to an entity; see BankAccount.RecordIndexes
+const CREATOR_DISPUTE_KEY_PREFIX = "CreatorDispute" // Index of disputes by Creator and AppDev; see Dispute.RecordIndexes
+const APPDEV_DISPUTE_KEY_PREFIX = "AppDevDispute" // Index of disputes by AppDev; see Dispute.RecordIndexes
+const DISPUTE_KEY_PREFIX = "Dispute"
+const MIGRATION_STATUS_KEY = "SCHEMA_MIGRATION_STATUS"
+
+// Private data constants
+const CONTRACT_TERMS_COLLECTION = "collectionContractTerms"
+const CONTRACT_TERMS_TRANSIENT_KEY = "contractterms"
+const CONTRACT_SALT_TRANSIENT_KEY = "contractsalt" // Random seed of the salts hashed with contract terms; see DeriveContractSalt
+const CONTRACT_SALT_MIN_BYTES = 16
+
+// Transient map key of the page of a listing requested by the caller; see GetPage
+const PAGE_TRANSIENT_KEY = "page"
+
+// Transient map key of the response format requested by the caller; see GetResponseFormat
+const RESPONSE_FORMAT_TRANSIENT_KEY = "responseformat"
+
+// Default withdrawal limits in $USD, used until an admin sets them
+const DEFAULT_WITHDRAWAL_TXN_LIMIT = 1000.0
+const DEFAULT_WITHDRAWAL_DAILY_LIMIT = 5000.0
+
+// Default policy for admin transfers requiring multiple approvals
+const DEFAULT_MULTISIG_THRESHOLD = 1000.0
+const DEFAULT_MULTISIG_APPROVALS = 2
+const DEFAULT_MULTISIG_EXPIRY_HOURS = 72
+
+// Default anti-fraud rules for streams
+const DEFAULT_FRAUD_MAX_PLAYS_PER_WINDOW = 30
+const DEFAULT_FRAUD_VELOCITY_WINDOW_MINUTES = 60
+const DEFAULT_FRAUD_MIN_REPEAT_GAP_SECONDS = 60
+const DEFAULT_FRAUD_MAX_DAILY_PRODUCT_PLAYS = 20
+
+// Maximum entries in a playlist or play queue
+const MAX_PLAYLIST_ENTRIES = 500
+const MAX_PLAY_QUEUE_ENTRIES = 200
+
+// Dispute constants
+const MAX_DISPUTE_EVIDENCE = 20
+
+// Statement grouping options
+const STATEMENT_GROUP_PRODUCT = "product"
+const STATEMENT_GROUP_APPDEV = "appdev"
+const STATEMENT_GROUP_CONTRACT = "contract"
 
 // Test constants
 const BEATCHAIN_ADMIN_BALANCE = "1000"
@@ -56,7 +122,7 @@ const TEST_PRODUCT_ADD_METRICS = "0"
 const TEST_PRODUCT_ACTIVE = "true"
 
 const TEST_CONTRACT_PPS = "0.01"
-const TEST_CONTRACT_STATUS = "true"
+const TEST_CONTRACT_STATUS = "ACCEPTED"
 
 type Transaction struct {
 	/*
@@ -68,22 +134,32 @@ type Transaction struct {
 	CreatorOrg        string
 	CreatorCertIssuer string
 	CreatorAdmin      bool
+	CreatorIdentity   string // Identity of the caller as "MSPID::x509::CN::IssuerCN", distinct per enrolled user across orgs
 	Args              []string
 	TestMode		  bool
 	LastUniqueId	  int64
+	ResponseFormat    string // RESPONSE_FORMAT_JSON or RESPONSE_FORMAT_TEXT, as requested by the caller
 }
 
 type CustomerRecord struct {
 	/*
-		Defines a single customer for a single AppDev on the ledger
+		Defines a single customer on the ledger, who may subscribe through several AppDevs
+	*/
+	Id            string                   `json:"id"`
+	BankAccountId string                   `json:"bankaccountid"`
+	Subscriptions map[string]*Subscription `json:"subscriptions"` // AppDev ID -> Subscription
+	QueuedSong    string                   `json:"queuedsong"`
+	PreviousSong  string                   `json:"previoussong"`
+	SchemaVersion int                      `json:"schemaversion"`
+}
+
+type Subscription struct {
+	/*
+		Defines a customer's subscription through a single AppDev
 	*/
-	Id                  string    `json:"id"`
 	AppDevId            string    `json:"appdevid"`
-	BankAccountId       string    `json:"bankaccountid"`
 	SubscriptionFee     float32   `json:"subscriptionfee"`
 	SubscriptionDueDate time.Time `json:"subscriptionduedate"`
-	QueuedSong          string    `json:"queuedsong"`
-	PreviousSong        string    `json:"previoussong"`
 }
 
 type CreatorRecord struct {
@@ -92,6 +168,7 @@ type CreatorRecord struct {
 	*/
 	Id                  string    `json:"id"`
 	BankAccountId       string    `json:"bankaccountid"`
+	SchemaVersion       int       `json:"schemaversion"`
 }
 
 type BankAccount struct {
@@ -101,6 +178,7 @@ type BankAccount struct {
 	Id      string  `json:"id"`
 	Balance float32 `json:"balance"`
 	InUse	bool 	`json:"inUse"` //if true cant be assigned to a new entity. can be assigned to only one person
+	SchemaVersion int `json:"schemaversion"`
 }
 
 type AppDevRecord struct {
@@ -110,17 +188,39 @@ type AppDevRecord struct {
 	Id            string  `json:"id"`
 	BankAccountId string  `json:"bankaccountid"`
 	AdminFeeFrac  float32 `json:"adminfeefrac"`
+	SchemaVersion int     `json:"schemaversion"`
 }
 
 type Contract struct {
 	/*
 		Defines a Contract record on the ledger
 	*/
-	CreatorId           string  `json:"creatorid"`
-	AppDevId            string  `json:"appdevid"`
-	ProductId           string  `json:"productid"`
-	CreatorPayPerStream float32 `json:"creatorpayperstream"`
-	Status              string  `json:"contractstatus"`
+	CreatorId                 string           `json:"creatorid"`
+	AppDevId                  string           `json:"appdevid"`
+	ProductId                 string           `json:"productid"`
+	Status                    string           `json:"contractstatus"`
+	TermsHash                 string           `json:"termshash"` // SHA-256 of the private ContractTerms
+	UnRenumeratedMetricCounts map[string]int64 `json:"unRenumeratedMetricCounts,omitempty"` // Unremunerated metrics through the AppDev by type
+	SchemaVersion             int              `json:"schemaversion"`
+}
+
+type ContractTerms struct {
+	/*
+		Defines the confidential terms of a Contract. Stored in the contract terms
+		private data collection, visible only to the Creator and AppDev orgs and the Beatchain
+		admins.
+	*/
+	CreatorId           string             `json:"creatorid"`
+	AppDevId            string             `json:"appdevid"`
+	ProductId           string             `json:"productid"`
+	CreatorPayPerStream float32            `json:"creatorpayperstream"`
+	Advance             float32            `json:"advance"`                // Paid by the AppDev to the Creator upon acceptance
+	MinimumGuarantee    float32            `json:"minimumguarantee"`       // Minimum total owed to the Creator over the contract
+	GuaranteeEnd        string             `json:"guaranteeend,omitempty"` // UTC day, as DATE_LAYOUT, from which any guarantee shortfall is paid
+	Salt                string             `json:"salt"`                   // Prevents guessing the terms from the public hash
+	RecoupBalance       float32            `json:"recoupbalance"`          // Portion of the advance not yet recouped from streams
+	TotalEarned         float32            `json:"totalearned"`            // Gross stream earnings accrued under the contract
+	MetricRates         map[string]float32 `json:"metricrates,omitempty"`  // Payment in $USD per engagement metric by type
 }
 
 type Product struct {
@@ -134,7 +234,396 @@ type Product struct {
 	TotalListens         int64  `json:"totalListens"`
 	UnRenumeratedListens int64  `json:"unRenumeratedListens"`
 	TotalMetrics         int64  `json:"totalMetrics"`
-	UnRenumeratedMetrics int64  `json:"unRenumeratedMetrics"`
+	UnRenumeratedMetrics int64  `json:"unRenumeratedMetrics"` // Across AppDevs; see Contract.UnRenumeratedMetricCounts
 	AdditionalMetrics    int64  `json:"additionalMetrics"`
 	IsActive             bool   `json:"isActive"`
+	SchemaVersion        int    `json:"schemaversion"`
+}
+
+type Settlement struct {
+	/*
+		Defines a single payment made under a contract by CollectPayment. Stored in the
+		contract terms private data collection as it reveals the contract's rate.
+	*/
+	Id              string           `json:"id"`
+	CreatorId       string           `json:"creatorid"`
+	AppDevId        string           `json:"appdevid"`
+	ProductId       string           `json:"productid"`
+	Streams         int64            `json:"streams"`
+	PayPerStream    float32          `json:"payperstream"`
+	GrossAmount     float32          `json:"grossamount"`       // Stream and metric earnings before recoupment
+	RecoupedAmount  float32          `json:"recoupedamount"`    // Portion applied to the contract's advance
+	Metrics         map[string]int64 `json:"metrics,omitempty"` // Engagement metrics settled by type
+	MetricsAmount   float32          `json:"metricsamount"`     // Portion of the gross amount paid for metrics
+	GuaranteeAmount float32          `json:"guaranteeamount"`   // Paid to meet the minimum guarantee; recouped from later earnings
+	NetAmount       float32          `json:"netamount"`         // Cash paid to the Creator
+	SettledAt       time.Time        `json:"settledat"`
+	TxId            string           `json:"txid"`
+	InvoiceId       string           `json:"invoiceid"` // Invoice the settlement paid, if any
+}
+
+type StatementLine struct {
+	/*
+		Defines the earnings for a single group of settlements within a CreatorStatement
+	*/
+	ProductId      string  `json:"productid,omitempty"`
+	AppDevId       string  `json:"appdevid,omitempty"`
+	Settlements    int     `json:"settlements"`
+	Streams        int64   `json:"streams"`
+	PayPerStream   float32 `json:"payperstream"` // Effective rate: gross stream amount over streams
+	GrossAmount    float32 `json:"grossamount"`
+	MetricsAmount  float32 `json:"metricsamount"`
+	RecoupedAmount float32 `json:"recoupedamount"`
+	NetAmount      float32 `json:"netamount"`
+}
+
+type CreatorStatement struct {
+	/*
+		Defines a Creator's earnings over a period, built from persisted Settlements
+	*/
+	CreatorId string          `json:"creatorid"`
+	From      time.Time       `json:"from"`
+	To        time.Time       `json:"to"`
+	GroupBy   string          `json:"groupby"`
+	Lines     []StatementLine `json:"lines"`
+	Total     StatementLine   `json:"total"`
+}
+
+type InvoiceLine struct {
+	/*
+		Defines the usage of a single product frozen onto an Invoice
+	*/
+	ProductId       string           `json:"productid"`
+	Streams         int64            `json:"streams"`
+	PayPerStream    float32          `json:"payperstream"`
+	GrossAmount     float32          `json:"grossamount"`
+	Metrics         map[string]int64 `json:"metrics,omitempty"` // Engagement metrics invoiced by type
+	MetricsAmount   float32          `json:"metricsamount"`
+	RecoupedAmount  float32          `json:"recoupedamount"`  // Expected recoupment at time of issue
+	GuaranteeAmount float32          `json:"guaranteeamount"` // Expected minimum guarantee top-up at time of issue
+	AmountDue       float32          `json:"amountdue"`
+	SettlementId    string           `json:"settlementid"` // Set once the line is paid by CollectPayment
+}
+
+type Invoice struct {
+	/*
+		Defines the amount owed by an AppDev to a Creator for a billing period. The lines are
+		frozen when issued; CollectPayment settles each line and references the invoice.
+		Stored in the contract terms private data collection.
+	*/
+	Id          string        `json:"id"`
+	CreatorId   string        `json:"creatorid"`
+	AppDevId    string        `json:"appdevid"`
+	PeriodStart time.Time     `json:"periodstart"`
+	PeriodEnd   time.Time     `json:"periodend"`
+	Lines       []InvoiceLine `json:"lines"`
+	AmountDue   float32       `json:"amountdue"`
+	Status      string        `json:"status"`
+	TxId        string        `json:"txid"`
+}
+
+type PayableContract struct {
+	/*
+		Defines the projected payment owed under a single contract
+	*/
+	CreatorId       string           `json:"creatorid"`
+	ProductId       string           `json:"productid"`
+	Streams         int64            `json:"streams"`
+	PayPerStream    float32          `json:"payperstream"`
+	GrossAmount     float32          `json:"grossamount"`
+	Metrics         map[string]int64 `json:"metrics,omitempty"`
+	MetricsAmount   float32          `json:"metricsamount"`
+	RecoupedAmount  float32          `json:"recoupedamount"`
+	GuaranteeAmount float32          `json:"guaranteeamount"` // Owed to meet the minimum guarantee
+	AmountDue       float32          `json:"amountdue"`
+	Covered         bool             `json:"covered"` // false if the AppDev's balance cannot cover this payment
+}
+
+type AppDevPayables struct {
+	/*
+		Defines an AppDev's projected payments to Creators from current unremunerated usage
+	*/
+	AppDevId      string             `json:"appdevid"`
+	Balance       float32            `json:"balance"`
+	CreatorTotals map[string]float32 `json:"creatortotals"`
+	Contracts     []PayableContract  `json:"contracts"`
+	TotalDue      float32            `json:"totaldue"`
+	Shortfall     float32            `json:"shortfall"`
+}
+
+type RecordHistoryEntry struct {
+	/*
+		Defines a single change to a ledger record as returned by GetRecordHistory
+	*/
+	TxId      string      `json:"txid"`
+	Timestamp time.Time   `json:"timestamp"`
+	IsDelete  bool        `json:"isdelete"`
+	Record    interface{} `json:"record"` // nil if the record was deleted
+}
+
+type MigrationRun struct {
+	/*
+		Defines the outcome of the last ledger-wide schema migration
+	*/
+	TxId       string         `json:"txid"`
+	MigratedAt time.Time      `json:"migratedat"`
+	Migrated   map[string]int `json:"migrated"` // Record type -> records rewritten
+}
+
+type IndexRebuild struct {
+	/*
+		Defines the outcome of rebuilding the secondary indexes, as returned by RebuildIndexes
+	*/
+	TxId      string         `json:"txid"`
+	RebuiltAt time.Time      `json:"rebuiltat"`
+	Removed   int            `json:"removed"` // Entries deleted before rebuilding
+	Entries   map[string]int `json:"entries"` // Index name -> entries written
+}
+
+type RecordTypeMigrationStatus struct {
+	/*
+		Defines how many records of a type are stored at each schema version
+	*/
+	RecordType     string      `json:"recordtype"`
+	CurrentVersion int         `json:"currentversion"`
+	VersionCounts  map[int]int `json:"versioncounts"` // Stored schema version -> record count
+	Pending        int         `json:"pending"`       // Records below the current version
+}
+
+type MigrationStatus struct {
+	/*
+		Defines the schema migration state of the ledger as returned by MigrationStatus
+	*/
+	RecordTypes []RecordTypeMigrationStatus `json:"recordtypes"`
+	LastRun     *MigrationRun               `json:"lastrun"` // nil if the ledger was never migrated
+}
+
+type BulkImportRowResult struct {
+	/*
+		Defines the outcome of importing a single row of a BulkImport batch
+	*/
+	Row           int    `json:"row"` // Index of the row within the batch
+	Id            string `json:"id,omitempty"`
+	BankAccountId string `json:"bankaccountid,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+type BulkImportReport struct {
+	/*
+		Defines the per-row report returned by BulkImport
+	*/
+	RecordType string                `json:"recordtype"`
+	Mode       string                `json:"mode"`
+	Committed  bool                  `json:"committed"` // false if an atomic batch was rejected
+	Succeeded  int                   `json:"succeeded"`
+	Failed     int                   `json:"failed"`
+	Rows       []BulkImportRowResult `json:"rows"`
+}
+
+type Tombstone struct {
+	/*
+		Defines the record left on the ledger in place of an offboarded Customer, Creator
+		or AppDev
+	*/
+	RecordType          string    `json:"recordtype"`
+	Id                  string    `json:"id"`
+	BankAccountId       string    `json:"bankaccountid"` // Released for reassignment
+	Disposition         string    `json:"disposition"`   // How the final balance was handled: payout or sweep
+	FinalBalance        float32   `json:"finalbalance"`
+	WithdrawalId        string    `json:"withdrawalid,omitempty"` // Withdrawal paying out the final balance
+	ShortfallPaid       float32   `json:"shortfallpaid"`          // Minimum guarantee shortfalls settled on closing
+	TerminatedContracts int       `json:"terminatedcontracts"`
+	ClosedBy            string    `json:"closedby"`
+	ClosedAt            time.Time `json:"closedat"`
+	TxId                string    `json:"txid"`
+}
+
+type WithdrawalLimits struct {
+	/*
+		Defines the configurable limits on moving funds off-chain
+	*/
+	PerTransaction float32 `json:"pertransaction"` // Maximum amount of a single transfer or withdrawal
+	Daily          float32 `json:"daily"`          // Maximum withdrawn from an account per UTC day
+}
+
+type Withdrawal struct {
+	/*
+		Defines a request by a Creator or AppDev to withdraw funds off-chain, the payout of a
+		closed entity's final balance, or a withdrawal made by an admin with TransferFunds.
+		The amount is held out of the account's balance while the request is pending.
+	*/
+	Id               string    `json:"id"`
+	BankAccountId    string    `json:"bankaccountid"`
+	RequesterType    string    `json:"requestertype"` // Record type of the Creator, AppDev or closed entity, or BEATCHAIN_ADMIN_MSP for TransferFunds
+	RequesterId      string    `json:"requesterid"`
+	Amount           float32   `json:"amount"`
+	Status           string    `json:"status"`
+	Closing          bool      `json:"closing"` // Pays out the final balance of an offboarded entity
+	RequestedAt      time.Time `json:"requestedat"`
+	DecidedAt        time.Time `json:"decidedat"`
+	DecidedBy        string    `json:"decidedby"`
+	PaymentReference string    `json:"paymentreference"` // Reference to the off-chain payment, if approved
+	Reason           string    `json:"reason"`           // Reason given for rejection
+	TxId             string    `json:"txid"`
+}
+
+type TransferPolicy struct {
+	/*
+		Defines when admin transfers need approval from multiple admin identities
+	*/
+	Threshold         float32  `json:"threshold"`         // Transfers above this amount in $USD require approval
+	RequiredApprovals int      `json:"requiredapprovals"` // M: distinct admin approvals needed to execute
+	Admins            []string `json:"admins"`            // N: identities which may approve. Empty allows any admin.
+	ExpiryHours       int      `json:"expiryhours"`       // Hours a proposal may wait for approval
+}
+
+type TransferApproval struct {
+	/*
+		Defines an admin's approval of a transfer proposal
+	*/
+	Identity   string    `json:"identity"`
+	ApprovedAt time.Time `json:"approvedat"`
+	TxId       string    `json:"txid"`
+}
+
+type TransferProposal struct {
+	/*
+		Defines an admin transfer awaiting approval by multiple admin identities
+	*/
+	Id                string             `json:"id"`
+	BankAccountId     string             `json:"bankaccountid"`
+	Amount            float32            `json:"amount"` // Negative for withdrawals
+	ProposedBy        string             `json:"proposedby"`
+	ProposedAt        time.Time          `json:"proposedat"`
+	ExpiresAt         time.Time          `json:"expiresat"`
+	RequiredApprovals int                `json:"requiredapprovals"`
+	Approvals         []TransferApproval `json:"approvals"`
+	Status            string             `json:"status"`
+	ExecutedAt        time.Time          `json:"executedat"`
+	TxId              string             `json:"txid"`
+}
+
+type FraudRules struct {
+	/*
+		Defines the rules a stream must pass to become payable. Streams breaking a rule are
+		held for admin review.
+	*/
+	MaxPlaysPerWindow     int `json:"maxplaysperwindow"`     // Plays allowed per customer within the velocity window
+	VelocityWindowMinutes int `json:"velocitywindowminutes"` // Length of the velocity window, at most a day
+	MinRepeatGapSeconds   int `json:"minrepeatgapseconds"`   // Minimum time between a customer's plays of a product
+	MaxDailyProductPlays  int `json:"maxdailyproductplays"`  // Plays of a product allowed per customer per UTC day
+}
+
+type Play struct {
+	/*
+		Defines a single stream of, or engagement with, a product by a customer
+	*/
+	ProductId  string    `json:"productid"`
+	MetricType string    `json:"metrictype,omitempty"` // Engagement metric type. Empty for a stream.
+	PlayedAt   time.Time `json:"playedat"`
+}
+
+type PlayLog struct {
+	/*
+		Defines a customer's plays over the last day, used to evaluate the fraud rules
+	*/
+	CustomerId string `json:"customerid"`
+	Plays      []Play `json:"plays"` // Oldest first
+}
+
+type FlaggedStream struct {
+	/*
+		Defines a stream or engagement which broke a fraud rule. Held streams are not payable
+		until an admin releases them.
+	*/
+	Id         string    `json:"id"`
+	CustomerId string    `json:"customerid"`
+	AppDevId   string    `json:"appdevid"`
+	CreatorId  string    `json:"creatorid"`
+	ProductId  string    `json:"productid"`
+	MetricType string    `json:"metrictype,omitempty"` // Engagement metric type. Empty for a stream.
+	PlayedAt   time.Time `json:"playedat"`
+	Reasons    []string  `json:"reasons"`
+	Status     string    `json:"status"`
+	ReviewedBy string    `json:"reviewedby"`
+	ReviewedAt time.Time `json:"reviewedat"`
+	TxId       string    `json:"txid"`
+}
+
+type Playlist struct {
+	/*
+		Defines an ordered list of products curated by a customer. Shared playlists can be
+		viewed and enqueued by the customers they are shared with.
+	*/
+	Id         string    `json:"id"`
+	OwnerId    string    `json:"ownerid"` // ID of the CustomerRecord who created the playlist
+	Name       string    `json:"name"`
+	ProductIds []string  `json:"productids"`
+	SharedWith []string  `json:"sharedwith"` // IDs of customers the playlist is shared with
+	CreatedAt  time.Time `json:"createdat"`
+	UpdatedAt  time.Time `json:"updatedat"`
+}
+
+type PlayQueue struct {
+	/*
+		Defines the products a customer has queued to stream next, in play order
+	*/
+	CustomerId string   `json:"customerid"`
+	ProductIds []string `json:"productids"`
+}
+
+type StreamResult struct {
+	/*
+		Defines the outcome of a customer's song request or engagement
+	*/
+	ProductId  string         `json:"productid"`
+	AppDevId   string         `json:"appdevid"`
+	MetricType string         `json:"metrictype,omitempty"` // Engagement metric type. Empty for a stream.
+	Flagged    *FlaggedStream `json:"flagged,omitempty"`    // Set if the stream was held by the fraud rules
+}
+
+type NextSongResult struct {
+	/*
+		Defines the outcome of streaming the next song in a customer's play queue
+	*/
+	ProductId string         `json:"productid"`         // Empty if no queued product could be streamed
+	Remaining int            `json:"remaining"`         // Entries left in the queue
+	Skipped   []string       `json:"skipped,omitempty"` // Queued products dropped as they can no longer be streamed
+	Flagged   *FlaggedStream `json:"flagged,omitempty"` // Set if the stream was held by the fraud rules
+}
+
+type DisputeEvidence struct {
+	/*
+		Defines a piece of evidence submitted to a dispute. The evidence itself is held
+		off-chain; the ledger records its hash so that it cannot be altered later.
+	*/
+	SubmittedBy string    `json:"submittedby"` // CREATOR_RECORD_KEY_PREFIX or APPDEV_RECORD_KEY_PREFIX
+	Hash        string    `json:"hash"`        // Hex encoded SHA-256 of the evidence
+	Description string    `json:"description"`
+	SubmittedAt time.Time `json:"submittedat"`
+	TxId        string    `json:"txid"`
+}
+
+type Dispute struct {
+	/*
+		Defines a dispute between a Creator and an AppDev over a settlement. The disputed amount
+		is held out of the respondent's balance until an admin arbitrator rules on it.
+	*/
+	Id                string            `json:"id"`
+	CreatorId         string            `json:"creatorid"`
+	AppDevId          string            `json:"appdevid"`
+	ProductId         string            `json:"productid"`
+	SettlementId      string            `json:"settlementid"`
+	Claimant          string            `json:"claimant"` // CREATOR_RECORD_KEY_PREFIX or APPDEV_RECORD_KEY_PREFIX
+	HeldBankAccountId string            `json:"heldbankaccountid"`
+	Amount            float32           `json:"amount"`
+	Reason            string            `json:"reason"`
+	Evidence          []DisputeEvidence `json:"evidence"`
+	Status            string            `json:"status"`
+	AwardedAmount     float32           `json:"awardedamount"` // Portion of the held amount paid to the claimant
+	Ruling            string            `json:"ruling"`
+	OpenedAt          time.Time         `json:"openedat"`
+	ResolvedAt        time.Time         `json:"resolvedat"`
+	ResolvedBy        string            `json:"resolvedby"`
+	TxId              string            `json:"txid"`
 }
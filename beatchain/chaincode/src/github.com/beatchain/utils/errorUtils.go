@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Stable codes identifying the cause of a failed transaction
+const (
+	ERROR_NOT_FOUND          = "NOT_FOUND"
+	ERROR_FORBIDDEN          = "FORBIDDEN"
+	ERROR_INVALID_ARGUMENT   = "INVALID_ARGUMENT"
+	ERROR_INSUFFICIENT_FUNDS = "INSUFFICIENT_FUNDS"
+	ERROR_CONFLICT           = "CONFLICT"
+	ERROR_INTERNAL           = "INTERNAL" // Any error not raised with one of the codes above
+)
+
+type ChaincodeError struct {
+	/*
+		Defines the JSON body returned as the message of a failed transaction
+	*/
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"` // Argument name -> problem with its value
+}
+
+func (chaincodeError *ChaincodeError) Error() string {
+	return chaincodeError.Message
+}
+
+func newChaincodeError(code string, format string, args []interface{}) *ChaincodeError {
+	return &ChaincodeError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func NotFoundError(format string, args ...interface{}) *ChaincodeError {
+	return newChaincodeError(ERROR_NOT_FOUND, format, args)
+}
+
+func ForbiddenError(format string, args ...interface{}) *ChaincodeError {
+	return newChaincodeError(ERROR_FORBIDDEN, format, args)
+}
+
+func InsufficientFundsError(format string, args ...interface{}) *ChaincodeError {
+	return newChaincodeError(ERROR_INSUFFICIENT_FUNDS, format, args)
+}
+
+func ConflictError(format string, args ...interface{}) *ChaincodeError {
+	return newChaincodeError(ERROR_CONFLICT, format, args)
+}
+
+func InvalidArgumentError(field string, format string, args ...interface{}) *ChaincodeError {
+	/*
+		Creates an INVALID_ARGUMENT error. If the problem lies with a single argument its name
+		is given as the field, and the message is reported against it in the error's fields.
+	*/
+	chaincodeError := newChaincodeError(ERROR_INVALID_ARGUMENT, format, args)
+	if field != "" {
+		chaincodeError.Fields = map[string]string{field: chaincodeError.Message}
+	}
+	return chaincodeError
+}
+
+func ErrorCode(err error) string {
+	/*
+		Returns the code of an error, or ERROR_INTERNAL if it was not raised with one
+	*/
+	if chaincodeError, ok := err.(*ChaincodeError); ok {
+		return chaincodeError.Code
+	}
+	return ERROR_INTERNAL
+}
+
+func WrapError(err error, format string, args ...interface{}) error {
+	/*
+		Prefixes an error's message with context, keeping its code and fields
+	*/
+	prefix := fmt.Sprintf(format, args...)
+	if chaincodeError, ok := err.(*ChaincodeError); ok {
+		return &ChaincodeError{
+			Code:    chaincodeError.Code,
+			Message: prefix + ": " + chaincodeError.Message,
+			Fields:  chaincodeError.Fields}
+	}
+	return &ChaincodeError{Code: ERROR_INTERNAL, Message: prefix + ": " + err.Error()}
+}
+
+func ErrorResponse(err error) pb.Response {
+	/*
+		Fails a transaction with the error's JSON body as the response message. Errors
+		without a code are reported as ERROR_INTERNAL.
+
+		Args:
+			err: Error raised by the transaction
+
+		Returns:
+			response: shim.Error response carrying the JSON encoded ChaincodeError
+	*/
+	var body bytes.Buffer
+
+	chaincodeError, ok := err.(*ChaincodeError)
+	if !ok {
+		chaincodeError = &ChaincodeError{Code: ERROR_INTERNAL, Message: err.Error()}
+	}
+
+	// Messages quote user input, so they are not HTML escaped
+	encoder := json.NewEncoder(&body)
+	encoder.SetEscapeHTML(false)
+	if encodeErr := encoder.Encode(chaincodeError); encodeErr != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Error(string(bytes.TrimRight(body.Bytes(), "\n")))
+}
+
+func ParseErrorResponse(message string) *ChaincodeError {
+	/*
+		Decodes the message of a failed transaction. Messages which are not a JSON error
+		body, such as those raised by the peer itself, are reported as ERROR_INTERNAL.
+	*/
+	var chaincodeError ChaincodeError
+
+	err := json.Unmarshal([]byte(message), &chaincodeError)
+	if err != nil || chaincodeError.Code == "" {
+		return &ChaincodeError{Code: ERROR_INTERNAL, Message: message}
+	}
+	return &chaincodeError
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+)
+
+func NewRecordOfType(recordType string) (interface{}, error) {
+	/*
+		Returns an empty record for the given key prefix into which history values are decoded
+
+		Args:
+			recordType: One of the *_KEY_PREFIX record types supporting history queries
+
+		Returns:
+			record: Pointer to an empty record struct
+			err: Error object. nil if no error occurred.
+	*/
+	switch recordType {
+	case BANK_ACCOUNT_KEY_PREFIX:
+		return &BankAccount{}, nil
+	case CONTRACT_KEY_PREFIX:
+		return &Contract{}, nil
+	case PRODUCT_KEY_PREFIX:
+		return &Product{}, nil
+	case CUSTOMER_RECORD_KEY_PREFIX:
+		return &CustomerRecord{}, nil
+	default:
+		return nil, InvalidArgumentError("", "history is not available for record type %s", recordType)
+	}
+}
+
+func GetRecordHistory(stub shim.ChaincodeStubInterface, recordType string, key string) ([]RecordHistoryEntry, error) {
+	/*
+		Fetches every committed change to a ledger record, oldest first, decoding each
+		value into the record's struct
+
+		Args:
+			stub: HF shim interface
+			recordType: Key prefix of the record, e.g. BANK_ACCOUNT_KEY_PREFIX
+			key: Composite key of the record
+
+		Returns:
+			history: One RecordHistoryEntry per change
+			err: Error object. nil if no error occurred.
+	*/
+	var historyIterator shim.HistoryQueryIteratorInterface
+	var modification *queryresult.KeyModification
+	var history []RecordHistoryEntry
+	var record interface{}
+	var err error
+
+	historyIterator, err = stub.GetHistoryForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer historyIterator.Close()
+
+	history = []RecordHistoryEntry{}
+	for historyIterator.HasNext() {
+		modification, err = historyIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		entry := RecordHistoryEntry{
+			TxId:     modification.TxId,
+			IsDelete: modification.IsDelete}
+		if modification.Timestamp != nil {
+			entry.Timestamp = time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos)).UTC()
+		}
+
+		if !modification.IsDelete {
+			record, err = NewRecordOfType(recordType)
+			if err != nil {
+				return nil, err
+			}
+			err = UnmarshalRecord(recordType, modification.Value, record)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("error unmarshaling %s history for key %s in tx %s",
+					recordType, key, modification.TxId))
+			}
+			entry.Record = record
+		}
+		history = append(history, entry)
+	}
+
+	return history, nil
+}
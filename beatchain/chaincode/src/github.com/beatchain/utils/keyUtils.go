@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"strconv"
+	"time"
 )
 
 
@@ -67,12 +68,42 @@ func GetUniqueId(stub shim.ChaincodeStubInterface, txn *Transaction) (string, er
 	return strId, nil
 }
 
+func GetTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	/*
+		Returns the transaction's client timestamp. Unlike time.Now(), this is the
+		same on every endorsing peer.
+	*/
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC(), nil
+}
+
 /*
 The following are helper functions used create composite keys
 */
 
-func GetCustomerRecordKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
-	key, err := stub.CreateCompositeKey(KEY_OBJECT_FORMAT, []string{CUSTOMER_RECORD_KEY_PREFIX, id})
+func GetContractTermsKey(stub shim.ChaincodeStubInterface, creatorId string, appDevId string, productId string) (string, error) {
+	key, err := stub.CreateCompositeKey(KEY_OBJECT_FORMAT, []string{CONTRACT_TERMS_KEY_PREFIX, creatorId, appDevId, productId})
+	if err != nil {
+		return "", err
+	} else {
+		return key, nil
+	}
+}
+
+func GetSettlementKey(stub shim.ChaincodeStubInterface, creatorId string, settlementId string) (string, error) {
+	key, err := stub.CreateCompositeKey(KEY_OBJECT_FORMAT, []string{SETTLEMENT_KEY_PREFIX, creatorId, settlementId})
+	if err != nil {
+		return "", err
+	} else {
+		return key, nil
+	}
+}
+
+func GetInvoiceKey(stub shim.ChaincodeStubInterface, creatorId string, appDevId string, invoiceId string) (string, error) {
+	key, err := stub.CreateCompositeKey(KEY_OBJECT_FORMAT, []string{INVOICE_KEY_PREFIX, creatorId, appDevId, invoiceId})
 	if err != nil {
 		return "", err
 	} else {
@@ -80,8 +111,8 @@ func GetCustomerRecordKey(stub shim.ChaincodeStubInterface, id string) (string,
 	}
 }
 
-func GetCreatorRecordKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
-	key, err := stub.CreateCompositeKey(KEY_OBJECT_FORMAT, []string{CREATOR_RECORD_KEY_PREFIX, id})
+func GetTombstoneKey(stub shim.ChaincodeStubInterface, recordType string, id string) (string, error) {
+	key, err := stub.CreateCompositeKey(KEY_OBJECT_FORMAT, []string{TOMBSTONE_KEY_PREFIX, recordType, id})
 	if err != nil {
 		return "", err
 	} else {
@@ -89,8 +120,8 @@ func GetCreatorRecordKey(stub shim.ChaincodeStubInterface, id string) (string, e
 	}
 }
 
-func GetContractKey(stub shim.ChaincodeStubInterface, creatorId string, appDevId string, productId string,) (string, error) {
-	key, err := stub.CreateCompositeKey(KEY_OBJECT_FORMAT, []string{CONTRACT_KEY_PREFIX, creatorId, appDevId, productId})
+func GetTransferProposalKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	key, err := stub.CreateCompositeKey(KEY_OBJECT_FORMAT, []string{TRANSFER_PROPOSAL_KEY_PREFIX, id})
 	if err != nil {
 		return "", err
 	} else {
@@ -98,8 +129,8 @@ func GetContractKey(stub shim.ChaincodeStubInterface, creatorId string, appDevId
 	}
 }
 
-func GetBankAccountKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
-	key, err := stub.CreateCompositeKey(KEY_OBJECT_FORMAT, []string{BANK_ACCOUNT_KEY_PREFIX, id})
+func GetStreamedProductKey(stub shim.ChaincodeStubInterface, customerId string, appDevId string, productId string) (string, error) {
+	key, err := stub.CreateCompositeKey(KEY_OBJECT_FORMAT, []string{STREAMED_PRODUCT_KEY_PREFIX, customerId, appDevId, productId})
 	if err != nil {
 		return "", err
 	} else {
@@ -107,8 +138,8 @@ func GetBankAccountKey(stub shim.ChaincodeStubInterface, id string) (string, err
 	}
 }
 
-func GetAppDevRecordKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
-	key, err := stub.CreateCompositeKey(KEY_OBJECT_FORMAT, []string{APPDEV_RECORD_KEY_PREFIX, id})
+func GetPlayLogKey(stub shim.ChaincodeStubInterface, customerId string) (string, error) {
+	key, err := stub.CreateCompositeKey(KEY_OBJECT_FORMAT, []string{PLAY_LOG_KEY_PREFIX, customerId})
 	if err != nil {
 		return "", err
 	} else {
@@ -116,8 +147,8 @@ func GetAppDevRecordKey(stub shim.ChaincodeStubInterface, id string) (string, er
 	}
 }
 
-func GetProductKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
-	key, err := stub.CreateCompositeKey(KEY_OBJECT_FORMAT, []string{PRODUCT_KEY_PREFIX, id})
+func GetPlayQueueKey(stub shim.ChaincodeStubInterface, customerId string) (string, error) {
+	key, err := stub.CreateCompositeKey(KEY_OBJECT_FORMAT, []string{PLAY_QUEUE_KEY_PREFIX, customerId})
 	if err != nil {
 		return "", err
 	} else {
@@ -0,0 +1,281 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+type Migration func(record map[string]interface{}) error
+
+// Record types whose JSON is versioned, in the order they are migrated
+var VersionedRecordTypes = []string{
+	BANK_ACCOUNT_KEY_PREFIX,
+	CUSTOMER_RECORD_KEY_PREFIX,
+	APPDEV_RECORD_KEY_PREFIX,
+	CREATOR_RECORD_KEY_PREFIX,
+	PRODUCT_KEY_PREFIX,
+	CONTRACT_KEY_PREFIX,
+}
+
+// Record type -> migrations, where migration i upgrades schema version i to i+1
+var schemaMigrations = make(map[string][]Migration)
+
+func init() {
+	// Version 1: records stored before schema versioning need only be stamped
+	for _, recordType := range VersionedRecordTypes {
+		RegisterMigration(recordType, func(record map[string]interface{}) error { return nil })
+	}
+
+	// CustomerRecord version 2: a customer's single AppDev subscription becomes one of many
+	RegisterMigration(CUSTOMER_RECORD_KEY_PREFIX, migrateCustomerSubscriptions)
+}
+
+func migrateCustomerSubscriptions(record map[string]interface{}) error {
+	/*
+		Moves the AppDev ID, fee and due date of a version 1 CustomerRecord into its
+		subscriptions, keyed by the AppDev ID
+	*/
+	subscriptions := make(map[string]interface{})
+	if appDevId, ok := record["appdevid"].(string); ok && appDevId != "" {
+		subscriptions[appDevId] = map[string]interface{}{
+			"appdevid":            appDevId,
+			"subscriptionfee":     record["subscriptionfee"],
+			"subscriptionduedate": record["subscriptionduedate"]}
+	}
+	delete(record, "appdevid")
+	delete(record, "subscriptionfee")
+	delete(record, "subscriptionduedate")
+	record["subscriptions"] = subscriptions
+	return nil
+}
+
+func RegisterMigration(recordType string, migration Migration) {
+	/*
+		Registers the migration from the current schema version of a record type to the
+		next. Migrations operate on the decoded JSON of the record so that they can read
+		fields which no longer exist on the record's struct.
+	*/
+	schemaMigrations[recordType] = append(schemaMigrations[recordType], migration)
+}
+
+func CurrentSchemaVersion(recordType string) int {
+	return len(schemaMigrations[recordType])
+}
+
+func MigrateRecordBytes(recordType string, recordBytes []byte) ([]byte, bool, error) {
+	/*
+		Applies the registered migrations to a stored record's JSON, bringing it up to the
+		current schema version of its type
+
+		Args:
+			recordType: Key prefix of the record, e.g. PRODUCT_KEY_PREFIX
+			recordBytes: JSON of the record as stored on the ledger
+
+		Returns:
+			migratedBytes: JSON of the record at the current schema version
+			migrated: true if any migration was applied
+			err: Error object. nil if no error occurred.
+	*/
+	var record map[string]interface{}
+	var version int64
+	var err error
+
+	// Decode numbers as json.Number so amounts survive the round trip unchanged
+	decoder := json.NewDecoder(bytes.NewReader(recordBytes))
+	decoder.UseNumber()
+	err = decoder.Decode(&record)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Records stored before versioning have no schema version
+	if storedVersion, ok := record["schemaversion"].(json.Number); ok {
+		version, err = storedVersion.Int64()
+		if err != nil {
+			return nil, false, errors.New(fmt.Sprintf("invalid schema version %s on %s record", storedVersion, recordType))
+		}
+	}
+
+	migrations := schemaMigrations[recordType]
+	if int(version) > len(migrations) {
+		return nil, false, errors.New(fmt.Sprintf("%s record has schema version %d, newer than this chaincode's version %d",
+			recordType, version, len(migrations)))
+	}
+	if int(version) == len(migrations) {
+		return recordBytes, false, nil
+	}
+
+	for ; int(version) < len(migrations); version++ {
+		err = migrations[version](record)
+		if err != nil {
+			return nil, false, errors.New(fmt.Sprintf("error migrating %s record from schema version %d: %s",
+				recordType, version, err.Error()))
+		}
+	}
+	record["schemaversion"] = version
+
+	recordBytes, err = json.Marshal(record)
+	if err != nil {
+		return nil, false, err
+	}
+	return recordBytes, true, nil
+}
+
+func UnmarshalRecord(recordType string, recordBytes []byte, record interface{}) error {
+	/*
+		Unmarshals a stored record, lazily migrating it to the current schema version
+	*/
+	recordBytes, _, err := MigrateRecordBytes(recordType, recordBytes)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(recordBytes, record)
+}
+
+func MigrateLedger(stub shim.ChaincodeStubInterface) (*MigrationRun, error) {
+	/*
+		Rewrites every versioned record stored below its type's current schema version.
+		Called when the chaincode is upgraded.
+
+		Args:
+			stub: HF shim interface
+
+		Returns:
+			run: Number of records migrated per type. Also stored under MIGRATION_STATUS_KEY.
+			err: Error object. nil if no error occurred.
+	*/
+	var keysIterator shim.StateQueryIteratorInterface
+	var migratedBytes, runBytes []byte
+	var migrated bool
+	var err error
+
+	run := &MigrationRun{
+		TxId:     stub.GetTxID(),
+		Migrated: make(map[string]int)}
+	run.MigratedAt, err = GetTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, recordType := range VersionedRecordTypes {
+		pending := make(map[string][]byte)
+
+		// Collect the migrated records before writing any of them back
+		keysIterator, err = stub.GetStateByPartialCompositeKey(KEY_OBJECT_FORMAT, []string{recordType})
+		if err != nil {
+			return nil, err
+		}
+		for keysIterator.HasNext() {
+			result, err := keysIterator.Next()
+			if err != nil {
+				keysIterator.Close()
+				return nil, err
+			}
+			migratedBytes, migrated, err = MigrateRecordBytes(recordType, result.Value)
+			if err != nil {
+				keysIterator.Close()
+				return nil, errors.New(fmt.Sprintf("error migrating record with key %s: %s", result.Key, err.Error()))
+			}
+			if migrated {
+				pending[result.Key] = migratedBytes
+			}
+		}
+		keysIterator.Close()
+
+		for key, value := range pending {
+			err = stub.PutState(key, value)
+			if err != nil {
+				return nil, err
+			}
+			// Migrations cannot write index entries, so those the record declares are written as PutRecord would
+			record, err := decodeRecord(recordType, key, value)
+			if err != nil {
+				return nil, err
+			}
+			err = updateIndexEntries(stub, nil, record)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("error indexing record with key %s: %s", key, err.Error()))
+			}
+		}
+		run.Migrated[recordType] = len(pending)
+	}
+
+	runBytes, err = json.Marshal(run)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(MIGRATION_STATUS_KEY, runBytes)
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func GetMigrationStatus(stub shim.ChaincodeStubInterface) (*MigrationStatus, error) {
+	/*
+		Counts the stored records of each versioned type by schema version
+
+		Args:
+			stub: HF shim interface
+
+		Returns:
+			status: Counts per type along with the last ledger-wide migration run
+			err: Error object. nil if no error occurred.
+	*/
+	var keysIterator shim.StateQueryIteratorInterface
+	var runBytes []byte
+	var err error
+
+	status := &MigrationStatus{RecordTypes: []RecordTypeMigrationStatus{}}
+
+	for _, recordType := range VersionedRecordTypes {
+		typeStatus := RecordTypeMigrationStatus{
+			RecordType:     recordType,
+			CurrentVersion: CurrentSchemaVersion(recordType),
+			VersionCounts:  make(map[int]int)}
+
+		keysIterator, err = stub.GetStateByPartialCompositeKey(KEY_OBJECT_FORMAT, []string{recordType})
+		if err != nil {
+			return nil, err
+		}
+		for keysIterator.HasNext() {
+			var stored struct {
+				SchemaVersion int `json:"schemaversion"`
+			}
+
+			result, err := keysIterator.Next()
+			if err != nil {
+				keysIterator.Close()
+				return nil, err
+			}
+			err = json.Unmarshal(result.Value, &stored)
+			if err != nil {
+				keysIterator.Close()
+				return nil, errors.New(fmt.Sprintf("error unmarshaling record with key %s", result.Key))
+			}
+			typeStatus.VersionCounts[stored.SchemaVersion] += 1
+			if stored.SchemaVersion < typeStatus.CurrentVersion {
+				typeStatus.Pending += 1
+			}
+		}
+		keysIterator.Close()
+
+		status.RecordTypes = append(status.RecordTypes, typeStatus)
+	}
+
+	runBytes, err = stub.GetState(MIGRATION_STATUS_KEY)
+	if err != nil {
+		return nil, err
+	}
+	if len(runBytes) != 0 {
+		err = json.Unmarshal(runBytes, &status.LastRun)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"math"
+	"time"
+)
+
+func RoundCents(amount float64) float32 {
+	/*
+		Rounds a $USD amount to the nearest cent
+	*/
+	return float32(math.Round(amount*100) / 100)
+}
+
+func SplitRecoupment(terms *ContractTerms, earnings float32) (float32, float32) {
+	/*
+		Splits stream earnings under a contract into the portion recouped against the
+		contract's outstanding advance and the portion payable in cash. The terms are
+		not modified.
+
+		Args:
+			terms: ContractTerms under which the earnings accrued
+			earnings: Gross stream earnings in $USD
+
+		Returns:
+			recouped: Portion of the earnings applied to the advance
+			cash: Portion of the earnings owed to the Creator in cash
+	*/
+	var recouped float32
+
+	recouped = earnings
+	if terms.RecoupBalance < recouped {
+		recouped = terms.RecoupBalance
+	}
+	if recouped < 0.0 {
+		recouped = 0.0
+	}
+	return recouped, RoundCents(float64(earnings - recouped))
+}
+
+func ApplyRecoupment(terms *ContractTerms, earnings float32, recouped float32) {
+	/*
+		Records settled stream earnings against the contract terms, reducing the outstanding
+		advance by the recouped amount.
+	*/
+	terms.RecoupBalance = RoundCents(float64(terms.RecoupBalance - recouped))
+	terms.TotalEarned = RoundCents(float64(terms.TotalEarned + earnings))
+}
+
+func GuaranteeShortfall(terms *ContractTerms) float32 {
+	/*
+		Returns the amount still owed to the Creator to meet the contract's minimum
+		guarantee. The Creator has received the advance plus all cash payouts, which
+		equals the total earned plus any advance not yet recouped.
+	*/
+	return GuaranteeTopUp(terms, 0.0, 0.0)
+}
+
+func GuaranteeDue(terms *ContractTerms, asOf time.Time) bool {
+	/*
+		Reports whether the guarantee term of a contract has ended, so that settlements
+		from then on top the Creator's payments up to the minimum guarantee
+	*/
+	if terms.MinimumGuarantee <= 0.0 {
+		return false
+	}
+	guaranteeEnd, err := time.Parse(DATE_LAYOUT, terms.GuaranteeEnd)
+	return err == nil && !asOf.Before(guaranteeEnd)
+}
+
+func GuaranteeTopUp(terms *ContractTerms, earnings float32, recouped float32) float32 {
+	/*
+		Returns the amount to pay on top of settled earnings so that the Creator has received
+		at least the contract's minimum guarantee. The terms are not modified.
+
+		Args:
+			terms: ContractTerms under which the earnings accrued
+			earnings: Gross earnings being settled in $USD
+			recouped: Portion of the earnings applied to the advance
+
+		Returns:
+			topUp: Amount owed to meet the minimum guarantee
+	*/
+	var received, topUp float32
+
+	received = terms.TotalEarned + earnings + terms.RecoupBalance - recouped
+	topUp = RoundCents(float64(terms.MinimumGuarantee - received))
+	if topUp < 0.0 {
+		return 0.0
+	}
+	return topUp
+}
+
+func ApplyGuaranteeTopUp(terms *ContractTerms, topUp float32) {
+	/*
+		Records a minimum guarantee top-up against the contract terms. Like an advance, the
+		top-up is recouped from later earnings before further cash is paid.
+	*/
+	terms.RecoupBalance = RoundCents(float64(terms.RecoupBalance + topUp))
+}
+
+func CountMetrics(metrics map[string]int64) int64 {
+	/*
+		Totals engagement metric counts across types
+	*/
+	var total int64
+	for _, count := range metrics {
+		total += count
+	}
+	return total
+}
+
+func PriceMetrics(terms *ContractTerms, metrics map[string]int64) float32 {
+	/*
+		Prices engagement metrics at a contract's per-metric rates. Metric types the
+		contract does not price earn nothing.
+
+		Args:
+			terms: ContractTerms under which the metrics accrued
+			metrics: Metric counts by type
+
+		Returns:
+			amount: Earnings in $USD, rounded to the cent
+	*/
+	var amount float64
+	for metricType, count := range metrics {
+		amount += float64(count) * float64(terms.MetricRates[metricType])
+	}
+	return RoundCents(amount)
+}
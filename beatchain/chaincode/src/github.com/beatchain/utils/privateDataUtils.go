@@ -0,0 +1,452 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+type PrivateDataStub interface {
+	/*
+		Subset of the shim interface used to access private data collections. The vendored
+		shim only exposes these methods on ChaincodeStubInterface when built with the
+		"experimental" tag, so they are accessed through a type assertion instead.
+	*/
+	GetPrivateData(collection string, key string) ([]byte, error)
+	PutPrivateData(collection string, key string, value []byte) error
+	DelPrivateData(collection string, key string) error
+	GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error)
+}
+
+func getPrivateDataStub(stub shim.ChaincodeStubInterface) (PrivateDataStub, error) {
+	privateStub, ok := stub.(PrivateDataStub)
+	if !ok {
+		return nil, errors.New("private data collections are not supported by this chaincode stub; build the chaincode with -tags experimental")
+	}
+	return privateStub, nil
+}
+
+func DeriveContractSalt(stub shim.ChaincodeStubInterface, testMode bool, creatorId string, appDevId string, productId string) (string, error) {
+	/*
+		Derives the salt hashed with a contract's terms from the random seed the caller
+		passes in the transient map under CONTRACT_SALT_TRANSIENT_KEY. The seed never reaches
+		the public ledger and the salt is only stored with the private terms, so the terms
+		cannot be guessed from the public hash. Each contract gets its own salt so that one
+		seed can serve every contract written by a transaction.
+
+		In test mode the transaction ID stands in for a missing seed.
+
+		Returns:
+			salt (string): Hex-encoded HMAC-SHA256 of the contract's key under the seed
+			err: Error object. nil if no error occurred.
+	*/
+	var transientMap map[string][]byte
+	var seed []byte
+	var err error
+
+	transientMap, err = stub.GetTransient()
+	if err != nil {
+		return "", err
+	}
+	seed = transientMap[CONTRACT_SALT_TRANSIENT_KEY]
+	if len(seed) == 0 && testMode {
+		seed = []byte(stub.GetTxID())
+	} else if len(seed) < CONTRACT_SALT_MIN_BYTES {
+		return "", InvalidArgumentError(CONTRACT_SALT_TRANSIENT_KEY, "A random salt of at least %d bytes must be given in the transient map under %s", CONTRACT_SALT_MIN_BYTES, CONTRACT_SALT_TRANSIENT_KEY)
+	}
+
+	mac := hmac.New(sha256.New, seed)
+	_, err = fmt.Fprintf(mac, "%s\x00%s\x00%s", creatorId, appDevId, productId)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func HashContractTerms(terms *ContractTerms) (string, error) {
+	/*
+		Computes the hash of the agreed-upon contract terms stored publicly on the Contract.
+		Only the fields fixed at offer time are hashed; the recoupment balance and total
+		earned change with each settlement. Metric rates and the guarantee term are omitted
+		when not given so that the hashes of earlier contracts are unchanged.
+
+		Returns:
+			hash (string): Hex-encoded SHA-256 of the terms
+			err: Error object. nil if no error occurred.
+	*/
+	var termsBytes []byte
+	var digest [sha256.Size]byte
+	var err error
+
+	termsBytes, err = json.Marshal(struct {
+		CreatorId           string             `json:"creatorid"`
+		AppDevId            string             `json:"appdevid"`
+		ProductId           string             `json:"productid"`
+		CreatorPayPerStream float32            `json:"creatorpayperstream"`
+		Advance             float32            `json:"advance"`
+		MinimumGuarantee    float32            `json:"minimumguarantee"`
+		Salt                string             `json:"salt"`
+		MetricRates         map[string]float32 `json:"metricrates,omitempty"`
+		GuaranteeEnd        string             `json:"guaranteeend,omitempty"`
+	}{terms.CreatorId, terms.AppDevId, terms.ProductId, terms.CreatorPayPerStream,
+		terms.Advance, terms.MinimumGuarantee, terms.Salt, terms.MetricRates, terms.GuaranteeEnd})
+	if err != nil {
+		return "", err
+	}
+
+	digest = sha256.Sum256(termsBytes)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+func VerifyContractTerms(contract *Contract, terms *ContractTerms) error {
+	/*
+		Verifies the private terms match the hash recorded on the public Contract
+
+		Returns:
+			err: Error object. nil if the terms match.
+	*/
+	hash, err := HashContractTerms(terms)
+	if err != nil {
+		return err
+	}
+	if hash != contract.TermsHash {
+		return ConflictError("private terms do not match the public hash for Contract %s/%s/%s",
+			contract.CreatorId, contract.AppDevId, contract.ProductId)
+	}
+	return nil
+}
+
+func GetContractTerms(stub shim.ChaincodeStubInterface, creatorId string, appDevId string, productId string) (*ContractTerms, error) {
+	/*
+		Fetches a ContractTerms object from the contract terms private data collection
+
+		Args:
+			stub: HF shim interface
+			creatorId, appDevId, productId: Primary Key of the Contract
+
+		Returns:
+			terms: ContractTerms struct obj for the requested contract
+			err: Error object. nil if no error occurred.
+	*/
+	var privateStub PrivateDataStub
+	var termsBytes []byte
+	var terms *ContractTerms
+	var termsKey string
+	var err error
+
+	privateStub, err = getPrivateDataStub(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the record key
+	termsKey, err = GetContractTermsKey(stub, creatorId, appDevId, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reuse the record if it was decoded earlier in the transaction
+	if cached, ok := CachedRecord(stub, privateCacheKey(CONTRACT_TERMS_COLLECTION, termsKey)).(*ContractTerms); ok {
+		return cached, nil
+	}
+
+	// Pull the record bytes from the collection
+	termsBytes, err = privateStub.GetPrivateData(CONTRACT_TERMS_COLLECTION, termsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(termsBytes) == 0 {
+		err = NotFoundError("No private terms found for termsKey %s", termsKey)
+		return nil, err
+	}
+
+	// Unmarshal the JSON
+	err = json.Unmarshal(termsBytes, &terms)
+	if err != nil {
+		return nil, err
+	}
+	CacheRecord(stub, privateCacheKey(CONTRACT_TERMS_COLLECTION, termsKey), terms)
+
+	return terms, nil
+}
+
+func SetContractTerms(stub shim.ChaincodeStubInterface, terms *ContractTerms) error {
+	/*
+		Sets a ContractTerms object within the contract terms private data collection
+
+		Args:
+			stub: HF shim interface
+			terms: ContractTerms object to be set in the collection
+
+		Returns:
+			err: Error object. nil if no error occurred.
+	*/
+	var privateStub PrivateDataStub
+	var termsBytes []byte
+	var termsKey string
+	var err error
+
+	privateStub, err = getPrivateDataStub(stub)
+	if err != nil {
+		return err
+	}
+
+	// Create the record key
+	termsKey, err = GetContractTermsKey(stub, terms.CreatorId, terms.AppDevId, terms.ProductId)
+	if err != nil {
+		return err
+	}
+
+	// marshal the struct to JSON
+	termsBytes, err = json.Marshal(terms)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error marshaling ContractTerms record with termsKey %s", termsKey))
+	}
+
+	// Push the record to the collection
+	err = privateStub.PutPrivateData(CONTRACT_TERMS_COLLECTION, termsKey, termsBytes)
+	if err != nil {
+		return err
+	}
+	CacheRecord(stub, privateCacheKey(CONTRACT_TERMS_COLLECTION, termsKey), terms)
+	return nil
+}
+
+func GetVerifiedContractTerms(stub shim.ChaincodeStubInterface, contract *Contract) (*ContractTerms, error) {
+	/*
+		Fetches the private terms of a Contract and verifies them against its public hash
+	*/
+	terms, err := GetContractTerms(stub, contract.CreatorId, contract.AppDevId, contract.ProductId)
+	if err != nil {
+		return nil, err
+	}
+	err = VerifyContractTerms(contract, terms)
+	if err != nil {
+		return nil, err
+	}
+	return terms, nil
+}
+
+func GetSettlement(stub shim.ChaincodeStubInterface, creatorId string, settlementId string) (*Settlement, error) {
+	/*
+		Fetches a Settlement object from the contract terms private data collection
+
+		Args:
+			stub: HF shim interface
+			creatorId, settlementId: Primary Key of the Settlement
+
+		Returns:
+			settlement: Settlement struct obj for the requested record
+			err: Error object. nil if no error occurred.
+	*/
+	var privateStub PrivateDataStub
+	var settlementBytes []byte
+	var settlement *Settlement
+	var settlementKey string
+	var err error
+
+	privateStub, err = getPrivateDataStub(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the record key
+	settlementKey, err = GetSettlementKey(stub, creatorId, settlementId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pull the record bytes from the collection
+	settlementBytes, err = privateStub.GetPrivateData(CONTRACT_TERMS_COLLECTION, settlementKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(settlementBytes) == 0 {
+		err = NotFoundError("No record found for Settlement.ID %s of Creator %s", settlementId, creatorId)
+		return nil, err
+	}
+
+	// Unmarshal the JSON
+	err = json.Unmarshal(settlementBytes, &settlement)
+	if err != nil {
+		return nil, err
+	}
+
+	return settlement, nil
+}
+
+func SetSettlement(stub shim.ChaincodeStubInterface, settlement *Settlement) error {
+	/*
+		Sets a Settlement object within the contract terms private data collection
+
+		Args:
+			stub: HF shim interface
+			settlement: Settlement object to be set in the collection
+
+		Returns:
+			err: Error object. nil if no error occurred.
+	*/
+	var privateStub PrivateDataStub
+	var settlementBytes []byte
+	var settlementKey string
+	var err error
+
+	privateStub, err = getPrivateDataStub(stub)
+	if err != nil {
+		return err
+	}
+
+	// Create the record key
+	settlementKey, err = GetSettlementKey(stub, settlement.CreatorId, settlement.Id)
+	if err != nil {
+		return err
+	}
+
+	// marshal the struct to JSON
+	settlementBytes, err = json.Marshal(settlement)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error marshaling Settlement record with Settlement.ID %s", settlement.Id))
+	}
+
+	// Push the record to the collection
+	return privateStub.PutPrivateData(CONTRACT_TERMS_COLLECTION, settlementKey, settlementBytes)
+}
+
+func GetCreatorSettlements(stub shim.ChaincodeStubInterface, creatorId string) ([]*Settlement, error) {
+	/*
+		Fetches all Settlement objects paid to a Creator from the contract terms private
+		data collection
+
+		Args:
+			stub: HF shim interface
+			creatorId: ID of the Creator
+
+		Returns:
+			settlements: Settlement struct objs in key order
+			err: Error object. nil if no error occurred.
+	*/
+	var privateStub PrivateDataStub
+	var keysIterator shim.StateQueryIteratorInterface
+	var settlements []*Settlement
+	var err error
+
+	privateStub, err = getPrivateDataStub(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	keysIterator, err = privateStub.GetPrivateDataByPartialCompositeKey(CONTRACT_TERMS_COLLECTION, KEY_OBJECT_FORMAT,
+		[]string{SETTLEMENT_KEY_PREFIX, creatorId})
+	if err != nil {
+		return nil, err
+	}
+	defer keysIterator.Close()
+
+	for keysIterator.HasNext() {
+		var settlement *Settlement
+
+		result, err := keysIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(result.Value, &settlement)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("error unmarshaling Settlement record with key %s", result.Key))
+		}
+		settlements = append(settlements, settlement)
+	}
+
+	return settlements, nil
+}
+
+func SetInvoice(stub shim.ChaincodeStubInterface, invoice *Invoice) error {
+	/*
+		Sets an Invoice object within the contract terms private data collection
+
+		Args:
+			stub: HF shim interface
+			invoice: Invoice object to be set in the collection
+
+		Returns:
+			err: Error object. nil if no error occurred.
+	*/
+	var privateStub PrivateDataStub
+	var invoiceBytes []byte
+	var invoiceKey string
+	var err error
+
+	privateStub, err = getPrivateDataStub(stub)
+	if err != nil {
+		return err
+	}
+
+	// Create the record key
+	invoiceKey, err = GetInvoiceKey(stub, invoice.CreatorId, invoice.AppDevId, invoice.Id)
+	if err != nil {
+		return err
+	}
+
+	// marshal the struct to JSON
+	invoiceBytes, err = json.Marshal(invoice)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error marshaling Invoice record with Invoice.ID %s", invoice.Id))
+	}
+
+	// Push the record to the collection
+	return privateStub.PutPrivateData(CONTRACT_TERMS_COLLECTION, invoiceKey, invoiceBytes)
+}
+
+func GetInvoices(stub shim.ChaincodeStubInterface, creatorId string, appDevId string) ([]*Invoice, error) {
+	/*
+		Fetches all Invoice objects issued by an AppDev to a Creator from the contract
+		terms private data collection
+
+		Args:
+			stub: HF shim interface
+			creatorId: ID of the Creator
+			appDevId: ID of the AppDev
+
+		Returns:
+			invoices: Invoice struct objs in key order
+			err: Error object. nil if no error occurred.
+	*/
+	var privateStub PrivateDataStub
+	var keysIterator shim.StateQueryIteratorInterface
+	var invoices []*Invoice
+	var err error
+
+	privateStub, err = getPrivateDataStub(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	keysIterator, err = privateStub.GetPrivateDataByPartialCompositeKey(CONTRACT_TERMS_COLLECTION, KEY_OBJECT_FORMAT,
+		[]string{INVOICE_KEY_PREFIX, creatorId, appDevId})
+	if err != nil {
+		return nil, err
+	}
+	defer keysIterator.Close()
+
+	for keysIterator.HasNext() {
+		var invoice *Invoice
+
+		result, err := keysIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(result.Value, &invoice)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("error unmarshaling Invoice record with key %s", result.Key))
+		}
+		invoices = append(invoices, invoice)
+	}
+
+	return invoices, nil
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 )
@@ -21,496 +22,1015 @@ func GetCustomerRecord(stub shim.ChaincodeStubInterface, customerId string) (*Cu
 			err: Error object. nil if no error occurred.
 
 	*/
-	var customerRecordBytes []byte
-	var customerRecord *CustomerRecord
-	var customerKey string
-	var err error
-
-	// Create the record key
-	customerKey, err = GetCustomerRecordKey(stub, customerId)
+	record, err := GetRecord(stub, CUSTOMER_RECORD_KEY_PREFIX, customerId)
 	if err != nil {
-		return customerRecord, err
+		return nil, err
 	}
+	return record.(*CustomerRecord), nil
+}
 
-	// Pull the record bytes from the ledger
-	customerRecordBytes, err = stub.GetState(customerKey)
-	if err != nil {
-		return customerRecord, err
-	}
+func SetCustomerRecord(stub shim.ChaincodeStubInterface, customerRecord *CustomerRecord) error {
+	/*
+		Sets a CustomerRecord object within the ledger, updating the subscription index
+		entries of the AppDevs it subscribes through
 
-	if len(customerRecordBytes) == 0 {
-		err = errors.New(fmt.Sprintf("No record found for Customer.ID %s", customerId))
-		return customerRecord, err
-	}
+		Args:
+			stub: HF shim interface
+			customerRecord: CustomerRecord object to be set in the ledger
 
-	// Unmarshal the JSON
-	err = json.Unmarshal(customerRecordBytes, &customerRecord)
-	if err != nil {
-		return customerRecord, err
+		Returns:
+			err: Error object. nil if no error occurred.
+
+	*/
+	return PutRecord(stub, customerRecord)
+}
+
+func GetSubscription(customerRecord *CustomerRecord, appDevId string) (*Subscription, error) {
+	/*
+		Fetches a customer's subscription through an AppDev
+
+		Args:
+			customerRecord: CustomerRecord of the subscriber
+			appDevId: ID of the AppDev. If empty, the customer's only subscription is returned.
+
+		Returns:
+			subscription: Subscription of the customer through the AppDev
+			err: Error object. nil if no error occurred.
+	*/
+	if appDevId == "" {
+		if len(customerRecord.Subscriptions) != 1 {
+			return nil, InvalidArgumentError("", "Customer %s has %d subscriptions. An AppDev ID must be given.",
+				customerRecord.Id, len(customerRecord.Subscriptions))
+		}
+		for _, subscription := range customerRecord.Subscriptions {
+			return subscription, nil
+		}
 	}
 
-	return customerRecord, nil
+	subscription, ok := customerRecord.Subscriptions[appDevId]
+	if !ok {
+		return nil, ConflictError("Customer %s is not subscribed through AppDev %s", customerRecord.Id, appDevId)
+	}
+	return subscription, nil
 }
 
-func SetCustomerRecord(stub shim.ChaincodeStubInterface, customerRecord *CustomerRecord) error {
+
+func GetBankAccount(stub shim.ChaincodeStubInterface, bankAccountId string) (*BankAccount, error) {
 	/*
-		Sets a CustomerRecord object within the ledger
+		Fetches a BankAccount object from off the ledger
 
 		Args:
 			stub: HF shim interface
-			customerRecord: CustomerRecord object to be set in the ledger
+			bankAccountId: Primary Key of the BankAccount record
 
 		Returns:
+			bankAccount: BankAccount struct obj for the requested record
 			err: Error object. nil if no error occurred.
 
 	*/
-	var customerRecordBytes []byte
-	var customerKey string
-	var err error
-
-	// Create the record key
-	customerKey, err = GetCustomerRecordKey(stub, customerRecord.Id)
+	record, err := GetRecord(stub, BANK_ACCOUNT_KEY_PREFIX, bankAccountId)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return record.(*BankAccount), nil
+}
 
-	// marshal the struct to JSON
-	customerRecordBytes, err = json.Marshal(customerRecord)
+func SetBankAccount(stub shim.ChaincodeStubInterface, bankAccount *BankAccount) error {
+	/*
+		Sets a BankAccount object within the ledger
+
+		Args:
+			stub: HF shim interface
+			bankAccount: BankAccount object to be set in the ledger
+
+		Returns:
+			err: Error object. INSUFFICIENT_FUNDS if the balance is negative.
+
+	*/
+	return PutRecord(stub, bankAccount)
+}
+
+func GetFreeBankAccounts(stub shim.ChaincodeStubInterface) ([]*BankAccount, error) {
+	/*
+		Fetches the BankAccount objects not assigned to an entity using the free bank
+		account index
+
+		Returns:
+			bankAccounts: BankAccount struct objs in key order
+			err: Error object. nil if no error occurred.
+	*/
+	var bankAccounts []*BankAccount
+
+	records, err := ListIndexedRecords(stub, BANK_ACCOUNT_KEY_PREFIX, FREE_BANK_ACCOUNT_KEY_PREFIX)
 	if err != nil {
-		return errors.New(fmt.Sprintf("error marshaling Customer record with CustomerRecord.ID %s",
-			customerRecord.Id))
+		return nil, err
+	}
+	for _, record := range records {
+		bankAccounts = append(bankAccounts, record.(*BankAccount))
 	}
+	return bankAccounts, nil
+}
 
-	// Push the record back to the ledger
-	err = stub.PutState(customerKey, customerRecordBytes)
+func GetAppDevRecord(stub shim.ChaincodeStubInterface, appDevId string) (*AppDevRecord, error) {
+	/*
+		Fetches a AppDevRecord object from off the ledger
+
+		Args:
+			stub: HF shim interface
+			appDevId: Primary Key of the AppDevRecord object
+
+		Returns:
+			appDevRecord: AppDevRecord struct obj for the requested record
+			err: Error object. nil if no error occurred.
+
+	*/
+	record, err := GetRecord(stub, APPDEV_RECORD_KEY_PREFIX, appDevId)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	return nil
+	return record.(*AppDevRecord), nil
 }
 
-func GetBankAccount(stub shim.ChaincodeStubInterface, bankAccountId string) (*BankAccount, error) {
+func SetAppDevRecord(stub shim.ChaincodeStubInterface, appDevRecord *AppDevRecord) error {
 	/*
-		Fetches a BankAccount object from off the ledger
+		Sets an AppDevRecord object within the ledger
 
 		Args:
 			stub: HF shim interface
-			bankAccountId: Primary Key of the BankAccount record
+			appDevRecord: AppDevRecord object to be set in the ledger
 
 		Returns:
-			bankAccount: BankAccount struct obj for the requested record
 			err: Error object. nil if no error occurred.
 
 	*/
-	var bankAccountBytes []byte
-	var bankAccount *BankAccount
-	var bankAccountKey string
-	var err error
+	return PutRecord(stub, appDevRecord)
+}
 
-	// Create the record key
-	bankAccountKey, err = GetBankAccountKey(stub, bankAccountId)
+func GetProduct(stub shim.ChaincodeStubInterface, productId string) (*Product, error) {
+	/*
+		Fetches a Product object from off the ledger
+
+		Args:
+			stub: HF shim interface
+			productId: Primary Key of the Product
+
+		Returns:
+			product: Product struct obj for the requested record
+			err: Error object. nil if no error occurred.
+
+	*/
+	record, err := GetRecord(stub, PRODUCT_KEY_PREFIX, productId)
 	if err != nil {
-		return bankAccount, err
+		return nil, err
 	}
+	return record.(*Product), nil
+}
 
-	// Pull the record bytes from the ledger
-	bankAccountBytes, err = stub.GetState(bankAccountKey)
+func SetProduct(stub shim.ChaincodeStubInterface, product *Product) error {
+	/*
+		Sets a Product object within the ledger
+
+		Args:
+			stub: HF shim interface
+			product: Product object to be set in the ledger
+
+		Returns:
+			err: Error object. nil if no error occurred.
+
+	*/
+	return PutRecord(stub, product)
+}
+
+func GetCreatorRecord(stub shim.ChaincodeStubInterface, creatorId string) (*CreatorRecord, error) {
+	/*
+		Fetches a CreatorRecord object from off the ledger
+
+		Args:
+			stub: HF shim interface
+			creatorId: Primary Key of the Creator
+
+		Returns:
+			creatorRecord: CreatorRecord struct obj for the requested record
+			err: Error object. nil if no error occurred.
+
+	*/
+	record, err := GetRecord(stub, CREATOR_RECORD_KEY_PREFIX, creatorId)
 	if err != nil {
-		return bankAccount, err
+		return nil, err
 	}
+	return record.(*CreatorRecord), nil
+}
 
-	if len(bankAccountBytes) == 0 {
-		err = errors.New(fmt.Sprintf("No record found for BankAccount.ID %s", bankAccountId))
-		return bankAccount, err
-	}
+func SetCreatorRecord(stub shim.ChaincodeStubInterface, creatorRecord *CreatorRecord) error {
+	/*
+		Sets a CreatorRecord object within the ledger
 
-	// Unmarshal the JSON
-	err = json.Unmarshal(bankAccountBytes, &bankAccount)
+		Args:
+			stub: HF shim interface
+			creatorRecord: CreatorRecord object to be set in the ledger
+
+		Returns:
+			err: Error object. nil if no error occurred.
+
+	*/
+	return PutRecord(stub, creatorRecord)
+}
+
+func GetContract(stub shim.ChaincodeStubInterface, creatorId string, appDevId string, productId string) (*Contract, error) {
+	/*
+		Fetches a Contract object from off the ledger
+
+		Args:
+			stub: HF shim interface
+			creatorId, appDevId, productId: IDs of the parties to and product of the Contract
+
+		Returns:
+			contract: Contract struct obj for the requested record
+			err: Error object. nil if no error occurred.
+
+	*/
+	record, err := GetRecord(stub, CONTRACT_KEY_PREFIX, creatorId, appDevId, productId)
 	if err != nil {
-		return bankAccount, err
+		return nil, err
 	}
+	return record.(*Contract), nil
+}
 
-	return bankAccount, nil
+func SetContract(stub shim.ChaincodeStubInterface, contract *Contract) error {
+	/*
+		Sets a Contract object within the ledger
+
+		Args:
+			stub: HF shim interface
+			contract: Contract object to be set in the ledger
+
+		Returns:
+			err: Error object. nil if no error occurred.
 
+	*/
+	return PutRecord(stub, contract)
 }
 
-func SetBankAccount(stub shim.ChaincodeStubInterface, bankAccount *BankAccount) error {
+func GetAppDevContracts(stub shim.ChaincodeStubInterface, appDevId string) ([]*Contract, error) {
 	/*
-		Sets a BankAccount object within the ledger
+		Fetches all Contract objects to which an AppDev is a party using the AppDev contract
+		index
 
 		Args:
 			stub: HF shim interface
-			bankAccount: BankAccount object to be set in the ledger
+			appDevId: ID of the AppDev
 
 		Returns:
+			contracts: Contract struct objs in key order
 			err: Error object. nil if no error occurred.
+	*/
+	return listIndexedContracts(stub, APPDEV_CONTRACT_KEY_PREFIX, appDevId)
+}
 
+func GetProductContracts(stub shim.ChaincodeStubInterface, productId string) ([]*Contract, error) {
+	/*
+		Fetches all Contract objects for a product using the product contract index
+
+		Args:
+			stub: HF shim interface
+			productId: ID of the Product
+
+		Returns:
+			contracts: Contract struct objs in key order
+			err: Error object. nil if no error occurred.
 	*/
-	var bankAccountBytes []byte
-	var bankAccountKey string
-	var err error
+	return listIndexedContracts(stub, PRODUCT_CONTRACT_KEY_PREFIX, productId)
+}
 
-	// Create the record key
-	bankAccountKey, err = GetBankAccountKey(stub, bankAccount.Id)
+func listIndexedContracts(stub shim.ChaincodeStubInterface, index string, id string) ([]*Contract, error) {
+	var contracts []*Contract
+
+	records, err := ListIndexedRecords(stub, CONTRACT_KEY_PREFIX, index, id)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	// Validate balance
-	if bankAccount.Balance < 0.0 {
-		return errors.New(fmt.Sprintf("cannot update Bank Account balance of $%.2f; Balance must be >= $0.0",
-			bankAccount.Balance))
+	for _, record := range records {
+		contracts = append(contracts, record.(*Contract))
 	}
+	return contracts, nil
+}
 
-	// marshal the struct to JSON
-	bankAccountBytes, err = json.Marshal(bankAccount)
+func GetCreatorContracts(stub shim.ChaincodeStubInterface, creatorId string) ([]*Contract, error) {
+	/*
+		Fetches all Contract objects to which a Creator is a party
+
+		Args:
+			stub: HF shim interface
+			creatorId: ID of the Creator
+
+		Returns:
+			contracts: Contract struct objs in key order
+			err: Error object. nil if no error occurred.
+	*/
+	var contracts []*Contract
+
+	records, err := ListRecords(stub, CONTRACT_KEY_PREFIX, creatorId)
 	if err != nil {
-		return errors.New(fmt.Sprintf("error marshaling BankAccount record with BankAccount.ID %s",
-			bankAccount.Id))
+		return nil, err
+	}
+	for _, record := range records {
+		contracts = append(contracts, record.(*Contract))
 	}
+	return contracts, nil
+}
 
-	// Push the record back to the ledger
-	err = stub.PutState(bankAccountKey, bankAccountBytes)
+func GetCreatorProducts(stub shim.ChaincodeStubInterface, creatorId string) ([]*Product, error) {
+	/*
+		Fetches all Product objects owned by a Creator using the Creator product index
+
+		Args:
+			stub: HF shim interface
+			creatorId: ID of the Creator
+
+		Returns:
+			products: Product struct objs in key order
+			err: Error object. nil if no error occurred.
+	*/
+	var products []*Product
+
+	records, err := ListIndexedRecords(stub, PRODUCT_KEY_PREFIX, CREATOR_PRODUCT_KEY_PREFIX, creatorId)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	return nil
+	for _, record := range records {
+		products = append(products, record.(*Product))
+	}
+	return products, nil
 }
 
-func GetAppDevRecord(stub shim.ChaincodeStubInterface, appDevId string) (*AppDevRecord, error) {
+func GetAppDevCustomers(stub shim.ChaincodeStubInterface, appDevId string) ([]*CustomerRecord, error) {
 	/*
-		Fetches a AppDevRecord object from off the ledger
+		Fetches all CustomerRecord objects subscribed through an AppDev using the
+		subscription index
 
 		Args:
 			stub: HF shim interface
-			appDevId: Primary Key of the AppDevRecord object
+			appDevId: ID of the AppDev
 
 		Returns:
-			appDevRecord: AppDevRecord struct obj for the requested record
+			customers: CustomerRecord struct objs in customer ID order
 			err: Error object. nil if no error occurred.
+	*/
+	var customers []*CustomerRecord
+
+	records, err := ListIndexedRecords(stub, CUSTOMER_RECORD_KEY_PREFIX, SUBSCRIPTION_KEY_PREFIX, appDevId)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		customers = append(customers, record.(*CustomerRecord))
+	}
+	return customers, nil
+}
 
+func SetTombstone(stub shim.ChaincodeStubInterface, tombstone *Tombstone) error {
+	/*
+		Sets a Tombstone object within the ledger
+
+		Args:
+			stub: HF shim interface
+			tombstone: Tombstone object to be set in the ledger
+
+		Returns:
+			err: Error object. nil if no error occurred.
 	*/
-	var appDevRecordBytes []byte
-	var appDevRecord *AppDevRecord
-	var appDevRecordKey string
+	var tombstoneBytes []byte
+	var tombstoneKey string
 	var err error
 
 	// Create the record key
-	appDevRecordKey, err = GetAppDevRecordKey(stub, appDevId)
+	tombstoneKey, err = GetTombstoneKey(stub, tombstone.RecordType, tombstone.Id)
 	if err != nil {
-		return appDevRecord, err
+		return err
 	}
 
-	// Pull the record bytes from the ledger
-	appDevRecordBytes, err = stub.GetState(appDevRecordKey)
+	// marshal the struct to JSON
+	tombstoneBytes, err = json.Marshal(tombstone)
 	if err != nil {
-		return appDevRecord, err
+		return errors.New(fmt.Sprintf("error marshaling Tombstone record for %s %s", tombstone.RecordType, tombstone.Id))
 	}
 
-	if len(appDevRecordBytes) == 0 {
-		err = errors.New(fmt.Sprintf("No Bank Account record found for BankAccount.ID %s", appDevId))
-		return appDevRecord, err
-	}
+	// Push the record to the ledger
+	return stub.PutState(tombstoneKey, tombstoneBytes)
+}
 
-	// Unmarshal the JSON
-	err = json.Unmarshal(appDevRecordBytes, &appDevRecord)
+func GetWithdrawal(stub shim.ChaincodeStubInterface, withdrawalId string) (*Withdrawal, error) {
+	/*
+		Fetches a Withdrawal object from off the ledger
+
+		Args:
+			stub: HF shim interface
+			withdrawalId: Primary Key of the Withdrawal
+
+		Returns:
+			withdrawal: Withdrawal struct obj for the requested record
+			err: Error object. nil if no error occurred.
+	*/
+	record, err := GetRecord(stub, WITHDRAWAL_KEY_PREFIX, withdrawalId)
 	if err != nil {
-		return appDevRecord, err
+		return nil, err
 	}
+	return record.(*Withdrawal), nil
+}
 
-	return appDevRecord, nil
+func SetWithdrawal(stub shim.ChaincodeStubInterface, withdrawal *Withdrawal) error {
+	/*
+		Sets a Withdrawal object within the ledger
+
+		Args:
+			stub: HF shim interface
+			withdrawal: Withdrawal object to be set in the ledger
 
+		Returns:
+			err: Error object. nil if no error occurred.
+	*/
+	return PutRecord(stub, withdrawal)
 }
 
-func SetAppDevRecord(stub shim.ChaincodeStubInterface, appDevRecord *AppDevRecord) error {
+func GetWithdrawals(stub shim.ChaincodeStubInterface, bankAccountId string) ([]*Withdrawal, error) {
 	/*
-		Sets an AppDevRecord object within the ledger
+		Fetches all Withdrawal objects, or only those from a bank account if one is given
+		using the bank account withdrawal index
 
 		Args:
 			stub: HF shim interface
-			appDevRecord: AppDevRecord object to be set in the ledger
+			bankAccountId: ID of the BankAccount withdrawn from. Empty for all accounts.
 
 		Returns:
+			withdrawals: Withdrawal struct objs in key order
 			err: Error object. nil if no error occurred.
-
 	*/
-	var appDevRecordBytes []byte
-	var appDevKey string
+	var records []Record
+	var withdrawals []*Withdrawal
 	var err error
 
-	// Create the record key
-	appDevKey, err = GetAppDevRecordKey(stub, appDevRecord.Id)
+	if bankAccountId == "" {
+		records, err = ListRecords(stub, WITHDRAWAL_KEY_PREFIX)
+	} else {
+		records, err = ListIndexedRecords(stub, WITHDRAWAL_KEY_PREFIX, ACCOUNT_WITHDRAWAL_KEY_PREFIX, bankAccountId)
+	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for _, record := range records {
+		withdrawals = append(withdrawals, record.(*Withdrawal))
 	}
+	return withdrawals, nil
+}
 
-	// marshal the struct to JSON
-	appDevRecordBytes, err = json.Marshal(appDevRecord)
+func GetDailyWithdrawals(stub shim.ChaincodeStubInterface, bankAccountId string, day time.Time) ([]*Withdrawal, error) {
+	/*
+		Fetches the Withdrawal objects from a bank account requested on the UTC day of the
+		given time using the bank account withdrawal index
+
+		Args:
+			stub: HF shim interface
+			bankAccountId: ID of the BankAccount withdrawn from
+			day: Any time within the UTC day
+
+		Returns:
+			withdrawals: Withdrawal struct objs in key order
+			err: Error object. nil if no error occurred.
+	*/
+	var withdrawals []*Withdrawal
+
+	records, err := ListIndexedRecords(stub, WITHDRAWAL_KEY_PREFIX, ACCOUNT_WITHDRAWAL_KEY_PREFIX, bankAccountId,
+		day.UTC().Format(DATE_LAYOUT))
 	if err != nil {
-		return errors.New(fmt.Sprintf("error marshaling AppDev record with AppDevRecord.ID %s",
-			appDevRecord.Id))
+		return nil, err
+	}
+	for _, record := range records {
+		withdrawals = append(withdrawals, record.(*Withdrawal))
 	}
+	return withdrawals, nil
+}
 
-	// Push the record back to the ledger
-	err = stub.PutState(appDevKey, appDevRecordBytes)
+func GetWithdrawalLimits(stub shim.ChaincodeStubInterface) (*WithdrawalLimits, error) {
+	/*
+		Fetches the withdrawal limits set by an admin, or the defaults if none were set
+	*/
+	var limits *WithdrawalLimits
+
+	limitsBytes, err := stub.GetState(WITHDRAWAL_LIMITS_KEY)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if len(limitsBytes) == 0 {
+		return &WithdrawalLimits{
+			PerTransaction: DEFAULT_WITHDRAWAL_TXN_LIMIT,
+			Daily: DEFAULT_WITHDRAWAL_DAILY_LIMIT}, nil
+	}
+
+	err = json.Unmarshal(limitsBytes, &limits)
+	if err != nil {
+		return nil, err
 	}
+	return limits, nil
+}
 
-	return nil
+func SetWithdrawalLimits(stub shim.ChaincodeStubInterface, limits *WithdrawalLimits) error {
+	/*
+		Sets the withdrawal limits within the ledger
+	*/
+	limitsBytes, err := json.Marshal(limits)
+	if err != nil {
+		return errors.New("error marshaling WithdrawalLimits")
+	}
+	return stub.PutState(WITHDRAWAL_LIMITS_KEY, limitsBytes)
 }
 
-func GetProduct(stub shim.ChaincodeStubInterface, productId string) (*Product, error) {
+func GetTransferProposal(stub shim.ChaincodeStubInterface, proposalId string) (*TransferProposal, error) {
 	/*
-		Fetches a product object from off the ledger
+		Fetches a TransferProposal object from off the ledger
 
 		Args:
 			stub: HF shim interface
-			productId: Primary Key of the product record
+			proposalId: Primary Key of the TransferProposal
 
 		Returns:
-			product: product struct obj for the requested record
+			proposal: TransferProposal struct obj for the requested record
 			err: Error object. nil if no error occurred.
-
 	*/
-	var productBytes []byte
-	var product *Product
-	var productKey string
+	var proposalBytes []byte
+	var proposal *TransferProposal
+	var proposalKey string
 	var err error
 
 	// Create the record key
-	productKey, err = GetProductKey(stub, productId)
+	proposalKey, err = GetTransferProposalKey(stub, proposalId)
 	if err != nil {
 		return nil, err
 	}
 
 	// Pull the record bytes from the ledger
-	productBytes, err = stub.GetState(productKey)
+	proposalBytes, err = stub.GetState(proposalKey)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(productBytes) == 0 {
-		err = errors.New(fmt.Sprintf("No record found for product.ID %s", productId))
+	if len(proposalBytes) == 0 {
+		err = NotFoundError("No record found for TransferProposal.ID %s", proposalId)
 		return nil, err
 	}
 
 	// Unmarshal the JSON
-	err = json.Unmarshal(productBytes, &product)
+	err = json.Unmarshal(proposalBytes, &proposal)
 	if err != nil {
-		return product, err
+		return nil, err
 	}
 
-	return product, nil
-
+	return proposal, nil
 }
 
-func SetProduct(stub shim.ChaincodeStubInterface, product *Product) error {
+func SetTransferProposal(stub shim.ChaincodeStubInterface, proposal *TransferProposal) error {
 	/*
-		Sets a product object within the ledger
+		Sets a TransferProposal object within the ledger
 
 		Args:
 			stub: HF shim interface
-			product: product object to be set in the ledger
+			proposal: TransferProposal object to be set in the ledger
 
 		Returns:
 			err: Error object. nil if no error occurred.
-
 	*/
-	var productBytes []byte
-	var productKey string
+	var proposalBytes []byte
+	var proposalKey string
 	var err error
 
 	// Create the record key
-	productKey, err = GetProductKey(stub, product.Id)
+	proposalKey, err = GetTransferProposalKey(stub, proposal.Id)
 	if err != nil {
 		return err
 	}
 
 	// marshal the struct to JSON
-	productBytes, err = json.Marshal(product)
+	proposalBytes, err = json.Marshal(proposal)
 	if err != nil {
-		return errors.New(fmt.Sprintf("error marshaling product record with product.ID %s",
-			product.Id))
+		return errors.New(fmt.Sprintf("error marshaling TransferProposal record with TransferProposal.ID %s", proposal.Id))
 	}
 
 	// Push the record back to the ledger
-	err = stub.PutState(productKey, productBytes)
+	return stub.PutState(proposalKey, proposalBytes)
+}
+
+func GetTransferProposals(stub shim.ChaincodeStubInterface) ([]*TransferProposal, error) {
+	/*
+		Fetches all TransferProposal objects in key order
+	*/
+	var keysIterator shim.StateQueryIteratorInterface
+	var proposals []*TransferProposal
+	var err error
+
+	keysIterator, err = stub.GetStateByPartialCompositeKey(KEY_OBJECT_FORMAT, []string{TRANSFER_PROPOSAL_KEY_PREFIX})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer keysIterator.Close()
+
+	for keysIterator.HasNext() {
+		var proposal *TransferProposal
+
+		result, err := keysIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(result.Value, &proposal)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("error unmarshaling TransferProposal record with key %s", result.Key))
+		}
+		proposals = append(proposals, proposal)
 	}
 
-	return nil
+	return proposals, nil
 }
 
-
-func GetCreatorRecord(stub shim.ChaincodeStubInterface, creatorId string) (*CreatorRecord, error) {
+func GetTransferPolicy(stub shim.ChaincodeStubInterface) (*TransferPolicy, error) {
 	/*
-		Fetches a CreatorRecord object from off the ledger
+		Fetches the admin transfer approval policy, or the default policy if none was set
+	*/
+	var policy *TransferPolicy
 
-		Args:
-			stub: HF shim interface
-			creatorId: Primary Key of the Creator
+	policyBytes, err := stub.GetState(TRANSFER_POLICY_KEY)
+	if err != nil {
+		return nil, err
+	}
+	if len(policyBytes) == 0 {
+		return &TransferPolicy{
+			Threshold: DEFAULT_MULTISIG_THRESHOLD,
+			RequiredApprovals: DEFAULT_MULTISIG_APPROVALS,
+			Admins: []string{},
+			ExpiryHours: DEFAULT_MULTISIG_EXPIRY_HOURS}, nil
+	}
 
-		Returns:
-			creatorRecord: CreatorRecord struct obj for the requested record
-			err: Error object. nil if no error occurred.
+	err = json.Unmarshal(policyBytes, &policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
 
+func SetTransferPolicy(stub shim.ChaincodeStubInterface, policy *TransferPolicy) error {
+	/*
+		Sets the admin transfer approval policy within the ledger
 	*/
-	var creatorRecordBytes []byte
-	var creatorRecord *CreatorRecord
-	var creatorKey string
-	var err error
+	policyBytes, err := json.Marshal(policy)
+	if err != nil {
+		return errors.New("error marshaling TransferPolicy")
+	}
+	return stub.PutState(TRANSFER_POLICY_KEY, policyBytes)
+}
 
-	// Create the record key
-	creatorKey, err = GetCreatorRecordKey(stub, creatorId)
+func GetPlayLog(stub shim.ChaincodeStubInterface, customerId string) (*PlayLog, error) {
+	/*
+		Fetches a customer's PlayLog from off the ledger. Customers who have not played
+		anything have an empty log.
+	*/
+	var playLog *PlayLog
+
+	playLogKey, err := GetPlayLogKey(stub, customerId)
 	if err != nil {
-		return creatorRecord, err
+		return nil, err
+	}
+	playLogBytes, err := stub.GetState(playLogKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(playLogBytes) == 0 {
+		return &PlayLog{CustomerId: customerId, Plays: []Play{}}, nil
 	}
 
-	// Pull the record bytes from the ledger
-	creatorRecordBytes, err = stub.GetState(creatorKey)
+	err = json.Unmarshal(playLogBytes, &playLog)
 	if err != nil {
-		return creatorRecord, err
+		return nil, err
 	}
+	return playLog, nil
+}
 
-	if len(creatorRecordBytes) == 0 {
-		err = errors.New(fmt.Sprintf("No record found for Creator.ID %s", creatorId))
-		return creatorRecord, err
+func SetPlayLog(stub shim.ChaincodeStubInterface, playLog *PlayLog) error {
+	/*
+		Sets a customer's PlayLog within the ledger
+	*/
+	playLogKey, err := GetPlayLogKey(stub, playLog.CustomerId)
+	if err != nil {
+		return err
+	}
+	playLogBytes, err := json.Marshal(playLog)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error marshaling PlayLog record with CustomerID %s", playLog.CustomerId))
 	}
+	return stub.PutState(playLogKey, playLogBytes)
+}
 
-	// Unmarshal the JSON
-	err = json.Unmarshal(creatorRecordBytes, &creatorRecord)
+func HasStreamedProduct(stub shim.ChaincodeStubInterface, customerId string, appDevId string, productId string) (bool, error) {
+	/*
+		Reports whether a customer has streamed a product through an AppDev
+	*/
+	streamedKey, err := GetStreamedProductKey(stub, customerId, appDevId, productId)
 	if err != nil {
-		return creatorRecord, err
+		return false, err
 	}
+	streamedBytes, err := stub.GetState(streamedKey)
+	if err != nil {
+		return false, err
+	}
+	return len(streamedBytes) != 0, nil
+}
 
-	return creatorRecord, nil
+func SetStreamedProduct(stub shim.ChaincodeStubInterface, customerId string, appDevId string, productId string) error {
+	/*
+		Marks that a customer has streamed a product through an AppDev
+	*/
+	streamedKey, err := GetStreamedProductKey(stub, customerId, appDevId, productId)
+	if err != nil {
+		return err
+	}
+	// The mark carries no value, but an empty value would delete the key
+	return stub.PutState(streamedKey, []byte{0x00})
 }
 
-func SetCreatorRecord(stub shim.ChaincodeStubInterface, creatorRecord *CreatorRecord) error {
+func GetFlaggedStream(stub shim.ChaincodeStubInterface, streamId string) (*FlaggedStream, error) {
 	/*
-		Sets a CreatorRecord object within the ledger
+		Fetches a FlaggedStream object from off the ledger
 
 		Args:
 			stub: HF shim interface
-			CreatorRecord: CreatorRecord object to be set in the ledger
+			streamId: Primary Key of the FlaggedStream
 
 		Returns:
+			stream: FlaggedStream struct obj for the requested record
 			err: Error object. nil if no error occurred.
+	*/
+	record, err := GetRecord(stub, FLAGGED_STREAM_KEY_PREFIX, streamId)
+	if err != nil {
+		return nil, err
+	}
+	return record.(*FlaggedStream), nil
+}
+
+func SetFlaggedStream(stub shim.ChaincodeStubInterface, stream *FlaggedStream) error {
+	/*
+		Sets a FlaggedStream object within the ledger
+
+		Args:
+			stub: HF shim interface
+			stream: FlaggedStream object to be set in the ledger
 
+		Returns:
+			err: Error object. nil if no error occurred.
 	*/
-	var creatorRecordBytes []byte
-	var creatorKey string
+	return PutRecord(stub, stream)
+}
+
+func GetFlaggedStreams(stub shim.ChaincodeStubInterface, appDevId string, productId string) ([]*FlaggedStream, error) {
+	/*
+		Fetches FlaggedStream objects, filtered by AppDev and product when either is given
+		using the flagged stream indexes
+
+		Args:
+			stub: HF shim interface
+			appDevId: ID of the AppDev streamed through. Empty for all AppDevs.
+			productId: ID of the Product streamed. Empty for all products.
+
+		Returns:
+			streams: FlaggedStream struct objs in key order
+			err: Error object. nil if no error occurred.
+	*/
+	var records []Record
+	var streams []*FlaggedStream
 	var err error
 
-	// Create the record key
-	creatorKey, err = GetCreatorRecordKey(stub, creatorRecord.Id)
-	if err != nil {
-		return err
+	switch {
+	case productId != "" && appDevId != "":
+		records, err = ListIndexedRecords(stub, FLAGGED_STREAM_KEY_PREFIX, PRODUCT_FLAGGED_STREAM_KEY_PREFIX, productId, appDevId)
+	case productId != "":
+		records, err = ListIndexedRecords(stub, FLAGGED_STREAM_KEY_PREFIX, PRODUCT_FLAGGED_STREAM_KEY_PREFIX, productId)
+	case appDevId != "":
+		records, err = ListIndexedRecords(stub, FLAGGED_STREAM_KEY_PREFIX, APPDEV_FLAGGED_STREAM_KEY_PREFIX, appDevId)
+	default:
+		records, err = ListRecords(stub, FLAGGED_STREAM_KEY_PREFIX)
 	}
-
-	// marshal the struct to JSON
-	creatorRecordBytes, err = json.Marshal(creatorRecord)
 	if err != nil {
-		return errors.New(fmt.Sprintf("error marshaling Customer record with CustomerRecord.ID %s",
-			creatorRecord.Id))
+		return nil, err
+	}
+	for _, record := range records {
+		streams = append(streams, record.(*FlaggedStream))
 	}
+	return streams, nil
+}
 
-	// Push the record back to the ledger
-	err = stub.PutState(creatorKey, creatorRecordBytes)
+func GetDispute(stub shim.ChaincodeStubInterface, disputeId string) (*Dispute, error) {
+	/*
+		Fetches a Dispute object from off the ledger
+
+		Args:
+			stub: HF shim interface
+			disputeId: Primary Key of the Dispute
+
+		Returns:
+			dispute: Dispute struct obj for the requested record
+			err: Error object. nil if no error occurred.
+	*/
+	record, err := GetRecord(stub, DISPUTE_KEY_PREFIX, disputeId)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	return nil
+	return record.(*Dispute), nil
 }
 
-
-func GetContract(stub shim.ChaincodeStubInterface, creatorId string, appDevId string, productId string) (*Contract, error) {
+func SetDispute(stub shim.ChaincodeStubInterface, dispute *Dispute) error {
 	/*
-		Fetches a Contract object from off the ledger
+		Sets a Dispute object within the ledger
 
 		Args:
 			stub: HF shim interface
-			contractKey: Primary Key of the Contract
+			dispute: Dispute object to be set in the ledger
 
 		Returns:
-			creatorRecord: CreatorRecord struct obj for the requested record
 			err: Error object. nil if no error occurred.
+	*/
+	return PutRecord(stub, dispute)
+}
 
+func GetDisputes(stub shim.ChaincodeStubInterface, creatorId string, appDevId string) ([]*Dispute, error) {
+	/*
+		Fetches Dispute objects, filtered by Creator and AppDev when either is given using
+		the dispute indexes
+
+		Args:
+			stub: HF shim interface
+			creatorId: ID of the disputing Creator. Empty for all Creators.
+			appDevId: ID of the disputing AppDev. Empty for all AppDevs.
+
+		Returns:
+			disputes: Dispute struct objs in key order
+			err: Error object. nil if no error occurred.
 	*/
-	var contractBytes []byte
-	var contract *Contract
-	var contractKey string
+	var records []Record
+	var disputes []*Dispute
 	var err error
 
-	// Fetch the record key
-	contractKey, err = GetContractKey(stub, creatorId, appDevId, productId)
+	switch {
+	case creatorId != "" && appDevId != "":
+		records, err = ListIndexedRecords(stub, DISPUTE_KEY_PREFIX, CREATOR_DISPUTE_KEY_PREFIX, creatorId, appDevId)
+	case creatorId != "":
+		records, err = ListIndexedRecords(stub, DISPUTE_KEY_PREFIX, CREATOR_DISPUTE_KEY_PREFIX, creatorId)
+	case appDevId != "":
+		records, err = ListIndexedRecords(stub, DISPUTE_KEY_PREFIX, APPDEV_DISPUTE_KEY_PREFIX, appDevId)
+	default:
+		records, err = ListRecords(stub, DISPUTE_KEY_PREFIX)
+	}
 	if err != nil {
-		return contract, err
+		return nil, err
+	}
+	for _, record := range records {
+		disputes = append(disputes, record.(*Dispute))
 	}
+	return disputes, nil
+}
 
-	fmt.Println("GetContractKey:" + contractKey)
+func GetFraudRules(stub shim.ChaincodeStubInterface) (*FraudRules, error) {
+	/*
+		Fetches the stream fraud rules set by an admin, or the defaults if none were set
+	*/
+	var rules *FraudRules
 
-	// Pull the record bytes from the ledger
-	contractBytes, err = stub.GetState(contractKey)
+	rulesBytes, err := stub.GetState(FRAUD_RULES_KEY)
 	if err != nil {
-		return contract, err
+		return nil, err
+	}
+	if len(rulesBytes) == 0 {
+		return &FraudRules{
+			MaxPlaysPerWindow: DEFAULT_FRAUD_MAX_PLAYS_PER_WINDOW,
+			VelocityWindowMinutes: DEFAULT_FRAUD_VELOCITY_WINDOW_MINUTES,
+			MinRepeatGapSeconds: DEFAULT_FRAUD_MIN_REPEAT_GAP_SECONDS,
+			MaxDailyProductPlays: DEFAULT_FRAUD_MAX_DAILY_PRODUCT_PLAYS}, nil
 	}
 
-	if len(contractBytes) == 0 {
-		err = errors.New(fmt.Sprintf("No record found for contractKey %s", contractKey))
-		return contract, err
+	err = json.Unmarshal(rulesBytes, &rules)
+	if err != nil {
+		return nil, err
 	}
+	return rules, nil
+}
 
-	// Unmarshal the JSON
-	err = json.Unmarshal(contractBytes, &contract)
+func SetFraudRules(stub shim.ChaincodeStubInterface, rules *FraudRules) error {
+	/*
+		Sets the stream fraud rules within the ledger
+	*/
+	rulesBytes, err := json.Marshal(rules)
 	if err != nil {
-		return contract, err
+		return errors.New("error marshaling FraudRules")
 	}
+	return stub.PutState(FRAUD_RULES_KEY, rulesBytes)
+}
+
+func GetPlaylist(stub shim.ChaincodeStubInterface, playlistId string) (*Playlist, error) {
+	/*
+		Fetches a Playlist object from off the ledger
+
+		Args:
+			stub: HF shim interface
+			playlistId: Primary Key of the Playlist
 
-	return contract, nil
+		Returns:
+			playlist: Playlist struct obj for the requested record
+			err: Error object. nil if no error occurred.
+	*/
+	record, err := GetRecord(stub, PLAYLIST_KEY_PREFIX, playlistId)
+	if err != nil {
+		return nil, err
+	}
+	return record.(*Playlist), nil
 }
 
-func SetContract(stub shim.ChaincodeStubInterface, contract *Contract) error {
+func SetPlaylist(stub shim.ChaincodeStubInterface, playlist *Playlist) error {
 	/*
-		Sets a Contract object within the ledger
+		Sets a Playlist object within the ledger
 
 		Args:
 			stub: HF shim interface
-			CreatorRecord: CreatorRecord object to be set in the ledger
+			playlist: Playlist object to be set in the ledger
 
 		Returns:
 			err: Error object. nil if no error occurred.
+	*/
+	return PutRecord(stub, playlist)
+}
 
+func GetCustomerPlaylists(stub shim.ChaincodeStubInterface, customerId string) ([]*Playlist, error) {
+	/*
+		Fetches the Playlists a customer owns or has been shared using the customer playlist
+		index
 	*/
-	var contractBytes []byte
-	var contractKey string
-	var err error
+	var playlists []*Playlist
 
-	// Create the record key
-	contractKey, err = GetContractKey(stub, contract.CreatorId, contract.AppDevId, contract.ProductId)
+	records, err := ListIndexedRecords(stub, PLAYLIST_KEY_PREFIX, CUSTOMER_PLAYLIST_KEY_PREFIX, customerId)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	for _, record := range records {
+		playlists = append(playlists, record.(*Playlist))
+	}
+	return playlists, nil
+}
 
-	fmt.Println("SetContractKey:" + contractKey)
+func CanViewPlaylist(playlist *Playlist, customerId string) bool {
+	/*
+		Returns true if the customer owns the playlist or it has been shared with them
+	*/
+	if playlist.OwnerId == customerId {
+		return true
+	}
+	for _, sharedId := range playlist.SharedWith {
+		if sharedId == customerId {
+			return true
+		}
+	}
+	return false
+}
 
-	// marshal the struct to JSON
-	contractBytes, err = json.Marshal(contract)
+func GetPlayQueue(stub shim.ChaincodeStubInterface, customerId string) (*PlayQueue, error) {
+	/*
+		Fetches a customer's PlayQueue from off the ledger. Customers who have not queued
+		anything have an empty queue.
+	*/
+	var playQueue *PlayQueue
+
+	playQueueKey, err := GetPlayQueueKey(stub, customerId)
+	if err != nil {
+		return nil, err
+	}
+	playQueueBytes, err := stub.GetState(playQueueKey)
 	if err != nil {
-		return errors.New(fmt.Sprintf("error marshaling Contract record with contractKey %s",
-			contractKey))
+		return nil, err
+	}
+	if len(playQueueBytes) == 0 {
+		return &PlayQueue{CustomerId: customerId, ProductIds: []string{}}, nil
 	}
 
-	// Push the record back to the ledger
-	err = stub.PutState(contractKey, contractBytes)
+	err = json.Unmarshal(playQueueBytes, &playQueue)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return playQueue, nil
+}
 
-	return nil
-}
\ No newline at end of file
+func SetPlayQueue(stub shim.ChaincodeStubInterface, playQueue *PlayQueue) error {
+	/*
+		Sets a customer's PlayQueue within the ledger
+	*/
+	playQueueKey, err := GetPlayQueueKey(stub, playQueue.CustomerId)
+	if err != nil {
+		return err
+	}
+	playQueueBytes, err := json.Marshal(playQueue)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error marshaling PlayQueue record with CustomerID %s", playQueue.CustomerId))
+	}
+	return stub.PutState(playQueueKey, playQueueBytes)
+}
@@ -0,0 +1,629 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+type Record interface {
+	/*
+		Implemented by the records stored through the repository. A record is stored under
+		the composite key of its type followed by its IDs, e.g.
+		{CONTRACT_KEY_PREFIX, CreatorId, AppDevId, ProductId}.
+	*/
+	RecordType() string // Key prefix of the record's type, e.g. PRODUCT_KEY_PREFIX
+	RecordId() []string // IDs following the key prefix
+}
+
+type VersionedRecord interface {
+	/*
+		Implemented by records of the VersionedRecordTypes, which PutRecord stamps with the
+		schema version they are written in
+	*/
+	Record
+	SetSchemaVersion(version int)
+}
+
+type ValidatedRecord interface {
+	/*
+		Implemented by records which must be checked before they are put
+	*/
+	Record
+	Validate() error
+}
+
+type IndexedRecord interface {
+	/*
+		Implemented by records declaring secondary indexes. Each entry is the name of an
+		index followed by the indexed attributes, e.g. {SUBSCRIPTION_KEY_PREFIX, appDevId}.
+		The repository stores the entry under the composite key of its attributes followed
+		by the record's IDs, with no value, and keeps the entries in step with the record.
+	*/
+	Record
+	RecordIndexes() [][]string
+}
+
+// Record type -> constructor of an empty record of the type
+var recordTypes = make(map[string]func() Record)
+
+// Record type -> names of the indexes its records declare entries in
+var recordIndexes = make(map[string][]string)
+
+func init() {
+	RegisterRecordType(BANK_ACCOUNT_KEY_PREFIX, func() Record { return &BankAccount{} })
+	RegisterRecordType(CUSTOMER_RECORD_KEY_PREFIX, func() Record { return &CustomerRecord{} })
+	RegisterRecordType(APPDEV_RECORD_KEY_PREFIX, func() Record { return &AppDevRecord{} })
+	RegisterRecordType(CREATOR_RECORD_KEY_PREFIX, func() Record { return &CreatorRecord{} })
+	RegisterRecordType(PRODUCT_KEY_PREFIX, func() Record { return &Product{} })
+	RegisterRecordType(CONTRACT_KEY_PREFIX, func() Record { return &Contract{} })
+	RegisterRecordType(WITHDRAWAL_KEY_PREFIX, func() Record { return &Withdrawal{} })
+	RegisterRecordType(FLAGGED_STREAM_KEY_PREFIX, func() Record { return &FlaggedStream{} })
+	RegisterRecordType(PLAYLIST_KEY_PREFIX, func() Record { return &Playlist{} })
+	RegisterRecordType(DISPUTE_KEY_PREFIX, func() Record { return &Dispute{} })
+
+	RegisterIndex(BANK_ACCOUNT_KEY_PREFIX, FREE_BANK_ACCOUNT_KEY_PREFIX)
+	RegisterIndex(CUSTOMER_RECORD_KEY_PREFIX, SUBSCRIPTION_KEY_PREFIX)
+	RegisterIndex(PRODUCT_KEY_PREFIX, CREATOR_PRODUCT_KEY_PREFIX)
+	RegisterIndex(CONTRACT_KEY_PREFIX, APPDEV_CONTRACT_KEY_PREFIX)
+	RegisterIndex(CONTRACT_KEY_PREFIX, PRODUCT_CONTRACT_KEY_PREFIX)
+	RegisterIndex(WITHDRAWAL_KEY_PREFIX, ACCOUNT_WITHDRAWAL_KEY_PREFIX)
+	RegisterIndex(FLAGGED_STREAM_KEY_PREFIX, APPDEV_FLAGGED_STREAM_KEY_PREFIX)
+	RegisterIndex(FLAGGED_STREAM_KEY_PREFIX, PRODUCT_FLAGGED_STREAM_KEY_PREFIX)
+	RegisterIndex(PLAYLIST_KEY_PREFIX, CUSTOMER_PLAYLIST_KEY_PREFIX)
+	RegisterIndex(DISPUTE_KEY_PREFIX, CREATOR_DISPUTE_KEY_PREFIX)
+	RegisterIndex(DISPUTE_KEY_PREFIX, APPDEV_DISPUTE_KEY_PREFIX)
+}
+
+func (customerRecord *CustomerRecord) RecordType() string { return CUSTOMER_RECORD_KEY_PREFIX }
+func (customerRecord *CustomerRecord) RecordId() []string { return []string{customerRecord.Id} }
+func (customerRecord *CustomerRecord) SetSchemaVersion(version int) {
+	customerRecord.SchemaVersion = version
+}
+
+func (customerRecord *CustomerRecord) RecordIndexes() [][]string {
+	// AppDev ID -> subscribed customers, so that an AppDev's customers are found without scanning
+	var indexes [][]string
+	for appDevId := range customerRecord.Subscriptions {
+		indexes = append(indexes, []string{SUBSCRIPTION_KEY_PREFIX, appDevId})
+	}
+	return indexes
+}
+
+func (creatorRecord *CreatorRecord) RecordType() string { return CREATOR_RECORD_KEY_PREFIX }
+func (creatorRecord *CreatorRecord) RecordId() []string { return []string{creatorRecord.Id} }
+func (creatorRecord *CreatorRecord) SetSchemaVersion(version int) {
+	creatorRecord.SchemaVersion = version
+}
+
+func (bankAccount *BankAccount) RecordType() string           { return BANK_ACCOUNT_KEY_PREFIX }
+func (bankAccount *BankAccount) RecordId() []string           { return []string{bankAccount.Id} }
+func (bankAccount *BankAccount) SetSchemaVersion(version int) { bankAccount.SchemaVersion = version }
+
+func (bankAccount *BankAccount) Validate() error {
+	if bankAccount.Balance < 0.0 {
+		return InsufficientFundsError("cannot update Bank Account balance of $%.2f; Balance must be >= $0.0",
+			bankAccount.Balance)
+	}
+	return nil
+}
+
+func (bankAccount *BankAccount) RecordIndexes() [][]string {
+	// Accounts released by offboarding, or never assigned, are reassigned to new entities
+	if bankAccount.InUse {
+		return nil
+	}
+	return [][]string{{FREE_BANK_ACCOUNT_KEY_PREFIX}}
+}
+
+func (appDevRecord *AppDevRecord) RecordType() string           { return APPDEV_RECORD_KEY_PREFIX }
+func (appDevRecord *AppDevRecord) RecordId() []string           { return []string{appDevRecord.Id} }
+func (appDevRecord *AppDevRecord) SetSchemaVersion(version int) { appDevRecord.SchemaVersion = version }
+
+func (product *Product) RecordType() string           { return PRODUCT_KEY_PREFIX }
+func (product *Product) RecordId() []string           { return []string{product.Id} }
+func (product *Product) SetSchemaVersion(version int) { product.SchemaVersion = version }
+
+func (product *Product) RecordIndexes() [][]string {
+	return [][]string{{CREATOR_PRODUCT_KEY_PREFIX, product.CreatorId}}
+}
+
+func (contract *Contract) RecordType() string { return CONTRACT_KEY_PREFIX }
+func (contract *Contract) RecordId() []string {
+	return []string{contract.CreatorId, contract.AppDevId, contract.ProductId}
+}
+func (contract *Contract) SetSchemaVersion(version int) { contract.SchemaVersion = version }
+
+func (contract *Contract) RecordIndexes() [][]string {
+	return [][]string{
+		{APPDEV_CONTRACT_KEY_PREFIX, contract.AppDevId},
+		{PRODUCT_CONTRACT_KEY_PREFIX, contract.ProductId}}
+}
+
+func (withdrawal *Withdrawal) RecordType() string { return WITHDRAWAL_KEY_PREFIX }
+func (withdrawal *Withdrawal) RecordId() []string { return []string{withdrawal.Id} }
+
+func (withdrawal *Withdrawal) RecordIndexes() [][]string {
+	// Bank account ID and UTC day requested -> withdrawals, so that daily limits are checked without scanning
+	return [][]string{{ACCOUNT_WITHDRAWAL_KEY_PREFIX, withdrawal.BankAccountId, withdrawal.RequestedAt.UTC().Format(DATE_LAYOUT)}}
+}
+
+func (stream *FlaggedStream) RecordType() string { return FLAGGED_STREAM_KEY_PREFIX }
+func (stream *FlaggedStream) RecordId() []string { return []string{stream.Id} }
+
+func (stream *FlaggedStream) RecordIndexes() [][]string {
+	// AppDev and Creator, or product and AppDev -> flagged streams, so that reviews are listed without scanning
+	return [][]string{
+		{APPDEV_FLAGGED_STREAM_KEY_PREFIX, stream.AppDevId, stream.CreatorId},
+		{PRODUCT_FLAGGED_STREAM_KEY_PREFIX, stream.ProductId, stream.AppDevId}}
+}
+
+func (playlist *Playlist) RecordType() string { return PLAYLIST_KEY_PREFIX }
+func (playlist *Playlist) RecordId() []string { return []string{playlist.Id} }
+
+func (playlist *Playlist) RecordIndexes() [][]string {
+	// Owner and shared customer IDs -> playlists, so that a customer's playlists are found without scanning
+	indexes := [][]string{{CUSTOMER_PLAYLIST_KEY_PREFIX, playlist.OwnerId}}
+	for _, customerId := range playlist.SharedWith {
+		if customerId != playlist.OwnerId {
+			indexes = append(indexes, []string{CUSTOMER_PLAYLIST_KEY_PREFIX, customerId})
+		}
+	}
+	return indexes
+}
+
+func (dispute *Dispute) RecordType() string { return DISPUTE_KEY_PREFIX }
+func (dispute *Dispute) RecordId() []string { return []string{dispute.Id} }
+
+func (dispute *Dispute) RecordIndexes() [][]string {
+	// Creator and AppDev, or AppDev alone -> disputes, so that a party's disputes are found without scanning
+	return [][]string{
+		{CREATOR_DISPUTE_KEY_PREFIX, dispute.CreatorId, dispute.AppDevId},
+		{APPDEV_DISPUTE_KEY_PREFIX, dispute.AppDevId}}
+}
+
+func RegisterRecordType(recordType string, newRecord func() Record) {
+	/*
+		Registers the constructor the repository decodes stored records of a type into
+	*/
+	recordTypes[recordType] = newRecord
+}
+
+func RegisterIndex(recordType string, index string) {
+	/*
+		Registers the name of an index the records of a type declare entries in, so that
+		RebuildIndexes can clear it
+	*/
+	recordIndexes[recordType] = append(recordIndexes[recordType], index)
+}
+
+func newRecord(recordType string) (Record, error) {
+	newRecord, ok := recordTypes[recordType]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("record type %s is not registered with the repository", recordType))
+	}
+	return newRecord(), nil
+}
+
+func GetRecordKey(stub shim.ChaincodeStubInterface, recordType string, ids ...string) (string, error) {
+	/*
+		Builds the ledger key of a record from its type and IDs
+	*/
+	return stub.CreateCompositeKey(KEY_OBJECT_FORMAT, append([]string{recordType}, ids...))
+}
+
+func GetRecord(stub shim.ChaincodeStubInterface, recordType string, ids ...string) (Record, error) {
+	/*
+		Fetches a record from off the ledger, reusing the record if it was decoded earlier in
+		the transaction
+
+		Args:
+			stub: HF shim interface
+			recordType: Key prefix of the record, e.g. PRODUCT_KEY_PREFIX
+			ids: IDs of the record following the key prefix
+
+		Returns:
+			record: The record, of the type registered for recordType
+			err: Error object. NOT_FOUND if no record is stored under the IDs.
+	*/
+	var recordBytes []byte
+	var record Record
+	var key string
+	var err error
+
+	key, err = GetRecordKey(stub, recordType, ids...)
+	if err != nil {
+		return nil, err
+	}
+	if cached, ok := CachedRecord(stub, key).(Record); ok {
+		return cached, nil
+	}
+
+	recordBytes, err = stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(recordBytes) == 0 {
+		return nil, NotFoundError("No record found for %s.ID %s", recordType, strings.Join(ids, ", "))
+	}
+
+	record, err = decodeRecord(recordType, key, recordBytes)
+	if err != nil {
+		return nil, err
+	}
+	CacheRecord(stub, key, record)
+
+	return record, nil
+}
+
+func decodeRecord(recordType string, key string, recordBytes []byte) (Record, error) {
+	/*
+		Decodes a stored record, lazily migrating it to the current schema version
+	*/
+	record, err := newRecord(recordType)
+	if err != nil {
+		return nil, err
+	}
+	err = UnmarshalRecord(recordType, recordBytes, record)
+	if err != nil {
+		return nil, WrapError(err, "error unmarshaling %s record with key %s", recordType, key)
+	}
+	return record, nil
+}
+
+func RecordExists(stub shim.ChaincodeStubInterface, recordType string, ids ...string) (bool, error) {
+	/*
+		Reports whether a record is stored under the IDs without decoding it
+	*/
+	if _, ok := recordTypes[recordType]; !ok {
+		return false, errors.New(fmt.Sprintf("record type %s is not registered with the repository", recordType))
+	}
+	key, err := GetRecordKey(stub, recordType, ids...)
+	if err != nil {
+		return false, err
+	}
+	if CachedRecord(stub, key) != nil {
+		return true, nil
+	}
+	recordBytes, err := stub.GetState(key)
+	if err != nil {
+		return false, err
+	}
+	return len(recordBytes) != 0, nil
+}
+
+func PutRecord(stub shim.ChaincodeStubInterface, record Record) error {
+	/*
+		Sets a record within the ledger, stamping it with its schema version and updating
+		the entries of its declared indexes
+
+		Args:
+			stub: HF shim interface
+			record: Record to be set in the ledger
+
+		Returns:
+			err: Error object. nil if no error occurred.
+	*/
+	var recordBytes []byte
+	var previous Record
+	var key string
+	var err error
+
+	key, err = GetRecordKey(stub, record.RecordType(), record.RecordId()...)
+	if err != nil {
+		return err
+	}
+
+	if validated, ok := record.(ValidatedRecord); ok {
+		err = validated.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if versioned, ok := record.(VersionedRecord); ok {
+		versioned.SetSchemaVersion(CurrentSchemaVersion(record.RecordType()))
+	}
+
+	// Index entries are compared to the record as stored, not as changed in memory
+	if _, ok := record.(IndexedRecord); ok {
+		recordBytes, err = stub.GetState(key)
+		if err != nil {
+			return err
+		}
+		if len(recordBytes) != 0 {
+			previous, err = decodeRecord(record.RecordType(), key, recordBytes)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	recordBytes, err = json.Marshal(record)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error marshaling %s record with key %s", record.RecordType(), key))
+	}
+	err = stub.PutState(key, recordBytes)
+	if err != nil {
+		return err
+	}
+	CacheRecord(stub, key, record)
+
+	return updateIndexEntries(stub, previous, record)
+}
+
+func DeleteRecord(stub shim.ChaincodeStubInterface, record Record) error {
+	/*
+		Deletes a record from the ledger along with the entries of its declared indexes
+	*/
+	var recordBytes []byte
+	var previous Record
+	var key string
+	var err error
+
+	key, err = GetRecordKey(stub, record.RecordType(), record.RecordId()...)
+	if err != nil {
+		return err
+	}
+
+	// Entries are removed for the record as stored, not as changed in memory
+	recordBytes, err = stub.GetState(key)
+	if err != nil {
+		return err
+	}
+	if len(recordBytes) == 0 {
+		return NotFoundError("No record found for %s.ID %s", record.RecordType(), strings.Join(record.RecordId(), ", "))
+	}
+	previous, err = decodeRecord(record.RecordType(), key, recordBytes)
+	if err != nil {
+		return err
+	}
+
+	err = stub.DelState(key)
+	if err != nil {
+		return err
+	}
+	return updateIndexEntries(stub, previous, nil)
+}
+
+func ListRecords(stub shim.ChaincodeStubInterface, recordType string, ids ...string) ([]Record, error) {
+	/*
+		Fetches the records of a type whose leading IDs match, in key order. Through a
+		StateCache the records put or deleted earlier in the transaction are included or
+		left out accordingly; those already decoded in the transaction are reused.
+
+		Args:
+			stub: HF shim interface
+			recordType: Key prefix of the records, e.g. CONTRACT_KEY_PREFIX
+			ids: Leading IDs of the records. None for every record of the type.
+
+		Returns:
+			records: Records of the type registered for recordType
+			err: Error object. nil if no error occurred.
+	*/
+	var keysIterator shim.StateQueryIteratorInterface
+	var records []Record
+	var err error
+
+	keysIterator, err = stub.GetStateByPartialCompositeKey(KEY_OBJECT_FORMAT, append([]string{recordType}, ids...))
+	if err != nil {
+		return nil, err
+	}
+	defer keysIterator.Close()
+
+	for keysIterator.HasNext() {
+		result, err := keysIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if cached, ok := CachedRecord(stub, result.Key).(Record); ok {
+			records = append(records, cached)
+			continue
+		}
+		record, err := decodeRecord(recordType, result.Key, result.Value)
+		if err != nil {
+			return nil, err
+		}
+		CacheRecord(stub, result.Key, record)
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func ListIndexedRecords(stub shim.ChaincodeStubInterface, recordType string, index ...string) ([]Record, error) {
+	/*
+		Fetches the records of a type through one of their declared indexes. Through a
+		StateCache the index entries written earlier in the transaction are followed.
+
+		Args:
+			stub: HF shim interface
+			recordType: Key prefix of the records, e.g. CUSTOMER_RECORD_KEY_PREFIX
+			index: Name of the index followed by leading indexed attributes, e.g.
+				{SUBSCRIPTION_KEY_PREFIX, appDevId}
+
+		Returns:
+			records: Records of the type registered for recordType, in index key order
+			err: Error object. nil if no error occurred.
+	*/
+	var keysIterator shim.StateQueryIteratorInterface
+	var records []Record
+	var template Record
+	var err error
+
+	template, err = newRecord(recordType)
+	if err != nil {
+		return nil, err
+	}
+	idCount := len(template.RecordId())
+
+	keysIterator, err = stub.GetStateByPartialCompositeKey(KEY_OBJECT_FORMAT, index)
+	if err != nil {
+		return nil, err
+	}
+	defer keysIterator.Close()
+
+	for keysIterator.HasNext() {
+		result, err := keysIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyComponents, err := stub.SplitCompositeKey(result.Key)
+		if err != nil {
+			return nil, err
+		}
+		// Entries end with the IDs of the indexed record
+		record, err := GetRecord(stub, recordType, keyComponents[len(keyComponents)-idCount:]...)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func updateIndexEntries(stub shim.ChaincodeStubInterface, previous Record, record Record) error {
+	/*
+		Deletes the index entries of the previous version of a record which the new version
+		no longer declares and writes those it newly declares. Either may be nil.
+	*/
+	previousKeys, err := indexEntryKeys(stub, previous)
+	if err != nil {
+		return err
+	}
+	keys, err := indexEntryKeys(stub, record)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range previousKeys {
+		if !containsString(keys, key) {
+			err = stub.DelState(key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range keys {
+		if !containsString(previousKeys, key) {
+			// Index entries carry no value, but an empty value would delete the key
+			err = stub.PutState(key, []byte{0x00})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func indexEntryKeys(stub shim.ChaincodeStubInterface, record Record) ([]string, error) {
+	/*
+		Returns the keys of the index entries a record declares, sorted so that every peer
+		writes them in the same order
+	*/
+	var keys []string
+
+	indexed, ok := record.(IndexedRecord)
+	if !ok {
+		return nil, nil
+	}
+	for _, index := range indexed.RecordIndexes() {
+		key, err := stub.CreateCompositeKey(KEY_OBJECT_FORMAT, append(append([]string{}, index...), record.RecordId()...))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func indexedRecordTypes() []string {
+	/*
+		Returns the record types with registered indexes, sorted so that every peer rebuilds
+		them in the same order
+	*/
+	var types []string
+	for recordType := range recordIndexes {
+		types = append(types, recordType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func RebuildIndexes(stub shim.ChaincodeStubInterface) (*IndexRebuild, error) {
+	/*
+		Clears every registered index and writes the entries declared by each stored record.
+		Indexes added after records were stored are filled in, and stale entries removed.
+
+		Args:
+			stub: HF shim interface
+
+		Returns:
+			rebuild: Number of entries removed, and written per index
+			err: Error object. nil if no error occurred.
+	*/
+	var keysIterator shim.StateQueryIteratorInterface
+	var records []Record
+	var err error
+
+	rebuild := &IndexRebuild{
+		TxId:    stub.GetTxID(),
+		Entries: make(map[string]int)}
+	rebuild.RebuiltAt, err = GetTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, recordType := range indexedRecordTypes() {
+		for _, index := range recordIndexes[recordType] {
+			keysIterator, err = stub.GetStateByPartialCompositeKey(KEY_OBJECT_FORMAT, []string{index})
+			if err != nil {
+				return nil, err
+			}
+			for keysIterator.HasNext() {
+				result, err := keysIterator.Next()
+				if err != nil {
+					keysIterator.Close()
+					return nil, err
+				}
+				err = stub.DelState(result.Key)
+				if err != nil {
+					keysIterator.Close()
+					return nil, err
+				}
+				rebuild.Removed += 1
+			}
+			keysIterator.Close()
+			rebuild.Entries[index] = 0
+		}
+
+		records, err = ListRecords(stub, recordType)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			err = updateIndexEntries(stub, nil, record)
+			if err != nil {
+				return nil, err
+			}
+			for _, index := range record.(IndexedRecord).RecordIndexes() {
+				rebuild.Entries[index[0]] += 1
+			}
+		}
+	}
+	return rebuild, nil
+}
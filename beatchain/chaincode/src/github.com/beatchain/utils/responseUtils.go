@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Formats of successful transaction responses
+const (
+	RESPONSE_FORMAT_JSON = "JSON" // Response envelope carrying a typed JSON payload
+	RESPONSE_FORMAT_TEXT = "TEXT" // Pre-envelope responses, kept while clients migrate
+)
+
+type Response struct {
+	/*
+		Defines the envelope returned as the payload of a successful transaction
+	*/
+	Function string      `json:"function"`
+	TxId     string      `json:"txid"`
+	Data     interface{} `json:"data"`
+	Page     *Page       `json:"page,omitempty"` // Set on listings only
+}
+
+type Page struct {
+	/*
+		Defines the slice of a listing returned in a response
+	*/
+	Offset   int `json:"offset"`
+	Limit    int `json:"limit"` // 0 if no limit was requested
+	Returned int `json:"returned"`
+	Total    int `json:"total"`
+}
+
+func GetResponseFormat(stub shim.ChaincodeStubInterface) (string, error) {
+	/*
+		Reads the response format requested under RESPONSE_FORMAT_TRANSIENT_KEY in the
+		transient map, e.g. TEXT. RESPONSE_FORMAT_JSON is used if no format is requested.
+
+		Args:
+			stub: HF shim interface
+
+		Returns:
+			format: RESPONSE_FORMAT_JSON or RESPONSE_FORMAT_TEXT
+			err: Error object. nil if no error occurred.
+	*/
+	transientMap, err := stub.GetTransient()
+	if err != nil {
+		return "", err
+	}
+	formatBytes, ok := transientMap[RESPONSE_FORMAT_TRANSIENT_KEY]
+	if !ok {
+		return RESPONSE_FORMAT_JSON, nil
+	}
+
+	format := string(formatBytes)
+	if format != RESPONSE_FORMAT_JSON && format != RESPONSE_FORMAT_TEXT {
+		return "", InvalidArgumentError(RESPONSE_FORMAT_TRANSIENT_KEY, "Response format must be %s or %s. Given: %s",
+			RESPONSE_FORMAT_JSON, RESPONSE_FORMAT_TEXT, format)
+	}
+	return format, nil
+}
+
+func GetPage(stub shim.ChaincodeStubInterface, total int) (*Page, error) {
+	/*
+		Reads the page of a listing requested under PAGE_TRANSIENT_KEY in the transient map,
+		e.g. {"offset": 20, "limit": 10}. The whole listing is returned if no page is requested.
+
+		Args:
+			stub: HF shim interface
+			total: Number of entries in the full listing
+
+		Returns:
+			page: Page of the listing to return. Entries [Offset, Offset+Returned) are returned.
+			err: Error object. nil if no error occurred.
+	*/
+	var transientMap map[string][]byte
+	var err error
+
+	page := &Page{Total: total}
+	transientMap, err = stub.GetTransient()
+	if err != nil {
+		return nil, err
+	}
+	if pageBytes, ok := transientMap[PAGE_TRANSIENT_KEY]; ok {
+		err = json.Unmarshal(pageBytes, page)
+		if err != nil {
+			return nil, InvalidArgumentError(PAGE_TRANSIENT_KEY, "Cannot parse requested page: %s", err.Error())
+		}
+		page.Total = total
+	}
+	if page.Offset < 0 || page.Limit < 0 {
+		return nil, InvalidArgumentError(PAGE_TRANSIENT_KEY, "Page offset and limit must be >= 0. Given: %d, %d",
+			page.Offset, page.Limit)
+	}
+
+	if page.Offset > total {
+		page.Offset = total
+	}
+	page.Returned = total - page.Offset
+	if page.Limit > 0 && page.Limit < page.Returned {
+		page.Returned = page.Limit
+	}
+	return page, nil
+}
+
+func SuccessResponse(stub shim.ChaincodeStubInterface, txn *Transaction, data interface{}) pb.Response {
+	/*
+		Completes a transaction with data as the payload of the response envelope. Under
+		RESPONSE_FORMAT_TEXT the data is returned bare as it was before the envelope.
+	*/
+	var dataBytes []byte
+	var err error
+
+	if txn.ResponseFormat != RESPONSE_FORMAT_TEXT {
+		return envelopeResponse(stub, txn, data, nil)
+	}
+	dataBytes, err = json.Marshal(data)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	return shim.Success(dataBytes)
+}
+
+func TextResponse(stub shim.ChaincodeStubInterface, txn *Transaction, data interface{}, text string) pb.Response {
+	/*
+		Completes a transaction with data as the payload of the response envelope. Under
+		RESPONSE_FORMAT_TEXT the text formerly returned by the transaction is returned instead.
+	*/
+	if txn.ResponseFormat != RESPONSE_FORMAT_TEXT {
+		return envelopeResponse(stub, txn, data, nil)
+	}
+	return shim.Success([]byte(text))
+}
+
+func PageResponse(stub shim.ChaincodeStubInterface, txn *Transaction, data interface{}, page *Page) pb.Response {
+	/*
+		Completes a listing with a page of entries as the payload of the response envelope.
+		Under RESPONSE_FORMAT_TEXT the entries of the page are returned bare.
+	*/
+	if txn.ResponseFormat != RESPONSE_FORMAT_TEXT {
+		return envelopeResponse(stub, txn, data, page)
+	}
+	return SuccessResponse(stub, txn, data)
+}
+
+func TextPageResponse(stub shim.ChaincodeStubInterface, txn *Transaction, data interface{}, page *Page, text string) pb.Response {
+	/*
+		Completes a listing with a page of entries as the payload of the response envelope.
+		Under RESPONSE_FORMAT_TEXT the text listing of the page is returned instead.
+	*/
+	if txn.ResponseFormat != RESPONSE_FORMAT_TEXT {
+		return envelopeResponse(stub, txn, data, page)
+	}
+	return shim.Success([]byte(text))
+}
+
+func envelopeResponse(stub shim.ChaincodeStubInterface, txn *Transaction, data interface{}, page *Page) pb.Response {
+	responseBytes, err := json.Marshal(&Response{
+		Function: txn.CalledFunction,
+		TxId:     stub.GetTxID(),
+		Data:     data,
+		Page:     page})
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	return shim.Success(responseBytes)
+}
+
+func UnmarshalResponse(payload []byte, data interface{}) (*Response, error) {
+	/*
+		Decodes the payload of a successful transaction, unmarshaling its data into the given
+		pointer
+
+		Returns:
+			response: The response envelope, with Data set to the given pointer
+			err: Error object. nil if no error occurred.
+	*/
+	response := &Response{Data: data}
+	err := json.Unmarshal(payload, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
@@ -0,0 +1,355 @@
+package utils
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+)
+
+type StateCache struct {
+	/*
+		Unit of work for a single transaction. The peer does not show a transaction its own
+		writes, so a record read again after being set within the same transaction comes back
+		stale. StateCache wraps the shim so that reads see the transaction's earlier writes,
+		holds the writes until Flush puts each changed key once, and keeps the records decoded
+		by the Get functions so that each is decoded at most once per transaction.
+
+		Partial composite key queries of the world state and of private data collections,
+		which list records and index entries, merge the transaction's unflushed writes into
+		the committed results. Other
+		range queries and key history are passed to the shim and, as on the peer, only
+		return committed state.
+	*/
+	shim.ChaincodeStubInterface
+	state   map[string]*cachedValue            // key -> value read or written
+	private map[string]map[string]*cachedValue // collection -> key -> value read or written
+	records map[string]interface{}             // key -> decoded record
+	writes  []cachedWrite                      // Keys written, in the order first written
+}
+
+type cachedValue struct {
+	value []byte
+	dirty bool // Written but not yet flushed
+}
+
+type cachedWrite struct {
+	collection string // Empty for the world state
+	key        string
+}
+
+func NewStateCache(stub shim.ChaincodeStubInterface) *StateCache {
+	return &StateCache{
+		ChaincodeStubInterface: stub,
+		state:                  make(map[string]*cachedValue),
+		private:                make(map[string]map[string]*cachedValue),
+		records:                make(map[string]interface{}),
+	}
+}
+
+func (cache *StateCache) GetState(key string) ([]byte, error) {
+	if cached, ok := cache.state[key]; ok {
+		return cached.value, nil
+	}
+	value, err := cache.ChaincodeStubInterface.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	cache.state[key] = &cachedValue{value: value}
+	return value, nil
+}
+
+func (cache *StateCache) PutState(key string, value []byte) error {
+	if key == "" {
+		return InvalidArgumentError("key", "Key must not be empty")
+	}
+	cached, ok := cache.state[key]
+	if !ok {
+		cached = &cachedValue{}
+		cache.state[key] = cached
+	}
+	if !cached.dirty {
+		cache.writes = append(cache.writes, cachedWrite{key: key})
+	}
+	cached.value = value
+	cached.dirty = true
+	// The decoded record no longer matches the value; setters cache it again
+	delete(cache.records, key)
+	return nil
+}
+
+func (cache *StateCache) DelState(key string) error {
+	return cache.PutState(key, nil)
+}
+
+func (cache *StateCache) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	/*
+		Queries the committed keys under a partial composite key, as the shim does, then
+		applies the transaction's unflushed writes under it: keys deleted within the
+		transaction are dropped, keys set are returned with their new value, and keys
+		created are added. Results are in key order.
+	*/
+	prefix, err := cache.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	committed, err := cache.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer committed.Close()
+	return cache.mergeWrites("", prefix, committed)
+}
+
+func (cache *StateCache) GetPrivateData(collection string, key string) ([]byte, error) {
+	if cached, ok := cache.private[collection][key]; ok {
+		return cached.value, nil
+	}
+	privateStub, err := getPrivateDataStub(cache.ChaincodeStubInterface)
+	if err != nil {
+		return nil, err
+	}
+	value, err := privateStub.GetPrivateData(collection, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := cache.private[collection]; !ok {
+		cache.private[collection] = make(map[string]*cachedValue)
+	}
+	cache.private[collection][key] = &cachedValue{value: value}
+	return value, nil
+}
+
+func (cache *StateCache) PutPrivateData(collection string, key string, value []byte) error {
+	if _, ok := cache.private[collection]; !ok {
+		cache.private[collection] = make(map[string]*cachedValue)
+	}
+	cached, ok := cache.private[collection][key]
+	if !ok {
+		cached = &cachedValue{}
+		cache.private[collection][key] = cached
+	}
+	if !cached.dirty {
+		cache.writes = append(cache.writes, cachedWrite{collection: collection, key: key})
+	}
+	cached.value = value
+	cached.dirty = true
+	delete(cache.records, privateCacheKey(collection, key))
+	return nil
+}
+
+func (cache *StateCache) DelPrivateData(collection string, key string) error {
+	return cache.PutPrivateData(collection, key, nil)
+}
+
+func (cache *StateCache) GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	/*
+		Queries the collection's committed keys under a partial composite key and applies
+		the transaction's unflushed writes to the collection, as GetStateByPartialCompositeKey
+		does for the world state
+	*/
+	prefix, err := cache.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	privateStub, err := getPrivateDataStub(cache.ChaincodeStubInterface)
+	if err != nil {
+		return nil, err
+	}
+	committed, err := privateStub.GetPrivateDataByPartialCompositeKey(collection, objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer committed.Close()
+	return cache.mergeWrites(collection, prefix, committed)
+}
+
+func (cache *StateCache) mergeWrites(collection string, prefix string, committed shim.StateQueryIteratorInterface) (shim.StateQueryIteratorInterface, error) {
+	/*
+		Applies the unflushed writes to a collection, or to the world state if the collection
+		is empty, under a key prefix to committed query results. Returns the results in key
+		order.
+	*/
+	var cached *cachedValue
+
+	values := make(map[string][]byte)
+	for committed.HasNext() {
+		result, err := committed.Next()
+		if err != nil {
+			return nil, err
+		}
+		values[result.Key] = result.Value
+	}
+	for _, write := range cache.writes {
+		if write.collection != collection || !strings.HasPrefix(write.key, prefix) {
+			continue
+		}
+		if collection == "" {
+			cached = cache.state[write.key]
+		} else {
+			cached = cache.private[collection][write.key]
+		}
+		if !cached.dirty {
+			continue
+		}
+		if cached.value == nil {
+			delete(values, write.key)
+		} else {
+			values[write.key] = cached.value
+		}
+	}
+
+	results := make([]*queryresult.KV, 0, len(values))
+	for key, value := range values {
+		results = append(results, &queryresult.KV{Key: key, Value: value})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Key < results[j].Key })
+	return &cachedIterator{results: results}, nil
+}
+
+func (cache *StateCache) Flush() error {
+	/*
+		Writes each key changed within the transaction to the shim once, in the order the
+		keys were first written. Deleted keys are deleted.
+	*/
+	var privateStub PrivateDataStub
+	var cached *cachedValue
+	var err error
+
+	for _, write := range cache.writes {
+		if write.collection == "" {
+			cached = cache.state[write.key]
+			if cached.value == nil {
+				err = cache.ChaincodeStubInterface.DelState(write.key)
+			} else {
+				err = cache.ChaincodeStubInterface.PutState(write.key, cached.value)
+			}
+		} else {
+			cached = cache.private[write.collection][write.key]
+			privateStub, err = getPrivateDataStub(cache.ChaincodeStubInterface)
+			if err == nil && cached.value == nil {
+				err = privateStub.DelPrivateData(write.collection, write.key)
+			} else if err == nil {
+				err = privateStub.PutPrivateData(write.collection, write.key, cached.value)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		cached.dirty = false
+	}
+	cache.writes = nil
+	return nil
+}
+
+func CachedRecord(stub shim.ChaincodeStubInterface, key string) interface{} {
+	/*
+		Returns the record decoded from the given key earlier in the transaction, or nil if
+		it has not been decoded or the stub is not a StateCache. Private data records are
+		keyed by privateCacheKey.
+
+		A deep copy of the cached record is returned, so changes the caller makes to it are
+		not seen by later Gets of the key unless the record is set.
+	*/
+	cache, ok := stub.(*StateCache)
+	if !ok {
+		return nil
+	}
+	record, ok := cache.records[key]
+	if !ok {
+		return nil
+	}
+	return cloneValue(reflect.ValueOf(record)).Interface()
+}
+
+func CacheRecord(stub shim.ChaincodeStubInterface, key string, record interface{}) {
+	/*
+		Keeps a deep copy of a record decoded from, or set on, the given key for the rest of
+		the transaction, so later changes the caller makes to the record are not cached
+	*/
+	cache, ok := stub.(*StateCache)
+	if ok {
+		cache.records[key] = cloneValue(reflect.ValueOf(record)).Interface()
+	}
+}
+
+func cloneValue(value reflect.Value) reflect.Value {
+	/*
+		Deep copies a decoded record, following pointers, slices, maps and interfaces.
+		Unexported struct fields, such as those of time.Time, are copied as they are.
+	*/
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return value
+		}
+		clone := reflect.New(value.Type().Elem())
+		clone.Elem().Set(cloneValue(value.Elem()))
+		return clone
+	case reflect.Interface:
+		if value.IsNil() {
+			return value
+		}
+		clone := reflect.New(value.Type()).Elem()
+		clone.Set(cloneValue(value.Elem()))
+		return clone
+	case reflect.Struct:
+		clone := reflect.New(value.Type()).Elem()
+		clone.Set(value)
+		for i := 0; i < value.NumField(); i++ {
+			if clone.Field(i).CanSet() {
+				clone.Field(i).Set(cloneValue(value.Field(i)))
+			}
+		}
+		return clone
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		clone := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			clone.Index(i).Set(cloneValue(value.Index(i)))
+		}
+		return clone
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		clone := reflect.MakeMapWithSize(value.Type(), value.Len())
+		for _, key := range value.MapKeys() {
+			clone.SetMapIndex(key, cloneValue(value.MapIndex(key)))
+		}
+		return clone
+	}
+	return value
+}
+
+func privateCacheKey(collection string, key string) string {
+	return collection + "/" + key
+}
+
+type cachedIterator struct {
+	/*
+		Iterates over query results already merged in memory
+	*/
+	results []*queryresult.KV
+}
+
+func (iterator *cachedIterator) HasNext() bool {
+	return len(iterator.results) > 0
+}
+
+func (iterator *cachedIterator) Next() (*queryresult.KV, error) {
+	if len(iterator.results) == 0 {
+		return nil, NotFoundError("No more query results")
+	}
+	result := iterator.results[0]
+	iterator.results = iterator.results[1:]
+	return result, nil
+}
+
+func (iterator *cachedIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/attrmgr"
+	"github.com/hyperledger/fabric/protos/msp"
+)
+
+type TestCA struct {
+	/*
+		Issues x509 certificates for an org's members the way its Fabric CA would, so that
+		tests can exercise the access control GetTxInfo skips in test mode
+	*/
+	MspId  string
+	Name   string // Common name of the CA, e.g. CUSTOMER_CA
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	serial int64
+}
+
+type TestIdentity struct {
+	/*
+		Defines a member enrolled with a TestCA. Creator holds the serialized identity the
+		peer would pass to the chaincode as the transaction creator.
+	*/
+	MspId      string
+	Name       string
+	Attributes map[string]string
+	Creator    []byte
+}
+
+func NewTestCA(mspId string, name string) (*TestCA, error) {
+	/*
+		Creates a self-signed CA for the given MSP
+
+		Args:
+			mspId: ID of the MSP the CA's members belong to, e.g. CUSTOMER_MSP
+			name: Common name of the CA, e.g. CUSTOMER_CA
+
+		Returns:
+			ca: The new CA
+			err: Error object. nil if no error occurred.
+	*/
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name, Organization: []string{mspId}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24 * 365 * 10),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &TestCA{MspId: mspId, Name: name, cert: cert, key: key, serial: 1}, nil
+}
+
+func (ca *TestCA) Enroll(name string, attributes map[string]string) (*TestIdentity, error) {
+	/*
+		Issues a certificate for a member of the CA's MSP carrying the given attributes,
+		e.g. {"id": "2222", "role": "client"}, as Fabric CA enrollment certificates do
+
+		Args:
+			name: Common name of the member
+			attributes: Attributes readable through the cid library. nil for none.
+
+		Returns:
+			identity: The enrolled member
+			err: Error object. nil if no error occurred.
+	*/
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	ca.serial += 1
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{ca.MspId}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour * 24 * 365),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if attributes != nil {
+		attrBytes, err := json.Marshal(&attrmgr.Attributes{Attrs: attributes})
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: attrmgr.AttrOID, Value: attrBytes}}
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   ca.MspId,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})})
+	if err != nil {
+		return nil, err
+	}
+	return &TestIdentity{MspId: ca.MspId, Name: name, Attributes: attributes, Creator: creator}, nil
+}
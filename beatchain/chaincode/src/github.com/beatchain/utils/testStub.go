@@ -0,0 +1,295 @@
+package utils
+
+import (
+	"container/list"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+type TestStub struct {
+	/*
+		Wraps the vendored MockStub to fill in the parts of the shim interface it leaves
+		unimplemented. The MockStub invokes chaincode with itself as the stub, so TestStub
+		keeps its own chaincode and arguments and drives the transaction directly.
+	*/
+	*shim.MockStub
+	cc           shim.Chaincode
+	args         [][]byte
+	txSeq        int
+	PrivateState map[string]map[string][]byte      // collection -> key -> value
+	Transient    map[string][]byte                 // Transient map passed to the next invocation
+	History      map[string][]testStubHistoryEntry // key -> changes, oldest first
+	Identity     string                            // Identity of the caller in test mode
+	Creator      []byte                            // Serialized identity of the caller outside test mode
+	TimeOffset   time.Duration                     // Added to the timestamp of each transaction
+}
+
+type testStubSnapshot struct {
+	/*
+		Captures the world state, private data and key history before a transaction so that
+		its writes can be discarded if it fails
+	*/
+	state        map[string][]byte
+	keys         *list.List
+	privateState map[string]map[string][]byte
+	history      map[string][]testStubHistoryEntry
+}
+
+type testStubHistoryEntry struct {
+	/*
+		Records a change to a key along with the transaction that made it
+	*/
+	txSeq        int
+	modification *queryresult.KeyModification
+}
+
+func NewTestStub(name string, cc shim.Chaincode) *TestStub {
+	return &TestStub{
+		MockStub:     shim.NewMockStub(name, cc),
+		cc:           cc,
+		PrivateState: make(map[string]map[string][]byte),
+		Transient:    make(map[string][]byte),
+		History:      make(map[string][]testStubHistoryEntry),
+	}
+}
+
+func (stub *TestStub) MockTransactionStart(txid string) {
+	stub.MockStub.MockTransactionStart(txid)
+	stub.TxTimestamp.Seconds += int64(stub.TimeOffset / time.Second)
+	stub.txSeq += 1
+}
+
+func (stub *TestStub) MockInit(uuid string, args [][]byte) pb.Response {
+	stub.args = args
+	stub.MockTransactionStart(uuid)
+	res := stub.cc.Init(stub)
+	stub.MockTransactionEnd(uuid)
+	stub.Transient = make(map[string][]byte)
+	return res
+}
+
+func (stub *TestStub) MockInvoke(uuid string, args [][]byte) pb.Response {
+	stub.args = args
+	snapshot := stub.snapshot()
+	stub.MockTransactionStart(uuid)
+	res := stub.cc.Invoke(stub)
+	stub.MockTransactionEnd(uuid)
+	// Like the peer, the writes of a failed transaction are never committed
+	if res.Status != shim.OK {
+		stub.restore(snapshot)
+	}
+	// Transient data only applies to a single proposal
+	stub.Transient = make(map[string][]byte)
+	return res
+}
+
+func (stub *TestStub) MockQuery(uuid string, args [][]byte) pb.Response {
+	// Like a peer answering a query, the writes of the transaction are never committed
+	snapshot := stub.snapshot()
+	res := stub.MockInvoke(uuid, args)
+	stub.restore(snapshot)
+	return res
+}
+
+func (stub *TestStub) MockInvokeAs(uuid string, identity *TestIdentity, args [][]byte) pb.Response {
+	// The creator only applies to a single proposal, like the transient map
+	stub.Creator = identity.Creator
+	res := stub.MockInvoke(uuid, args)
+	stub.Creator = nil
+	return res
+}
+
+func (stub *TestStub) snapshot() *testStubSnapshot {
+	snapshot := &testStubSnapshot{
+		state:        make(map[string][]byte, len(stub.State)),
+		keys:         list.New(),
+		privateState: make(map[string]map[string][]byte, len(stub.PrivateState)),
+		history:      make(map[string][]testStubHistoryEntry, len(stub.History)),
+	}
+	for key, value := range stub.State {
+		snapshot.state[key] = value
+	}
+	snapshot.keys.PushBackList(stub.Keys)
+	for collection, values := range stub.PrivateState {
+		snapshot.privateState[collection] = make(map[string][]byte, len(values))
+		for key, value := range values {
+			snapshot.privateState[collection][key] = value
+		}
+	}
+	for key, entries := range stub.History {
+		snapshot.history[key] = append([]testStubHistoryEntry(nil), entries...)
+	}
+	return snapshot
+}
+
+func (stub *TestStub) restore(snapshot *testStubSnapshot) {
+	stub.State = snapshot.state
+	stub.Keys = snapshot.keys
+	stub.PrivateState = snapshot.privateState
+	stub.History = snapshot.history
+}
+
+func (stub *TestStub) GetArgs() [][]byte {
+	return stub.args
+}
+
+func (stub *TestStub) GetStringArgs() []string {
+	strargs := make([]string, 0, len(stub.args))
+	for _, barg := range stub.args {
+		strargs = append(strargs, string(barg))
+	}
+	return strargs
+}
+
+func (stub *TestStub) GetFunctionAndParameters() (string, []string) {
+	allargs := stub.GetStringArgs()
+	if len(allargs) == 0 {
+		return "", []string{}
+	}
+	return allargs[0], allargs[1:]
+}
+
+func (stub *TestStub) GetCreator() ([]byte, error) {
+	if stub.Creator != nil {
+		return stub.Creator, nil
+	}
+	return []byte(stub.Identity), nil
+}
+
+func (stub *TestStub) GetTransient() (map[string][]byte, error) {
+	return stub.Transient, nil
+}
+
+func (stub *TestStub) recordHistory(key string, value []byte, isDelete bool) {
+	// Like the peer, only the last write to a key within a transaction is kept
+	modification := &queryresult.KeyModification{
+		TxId:      stub.TxID,
+		Value:     value,
+		Timestamp: stub.TxTimestamp,
+		IsDelete:  isDelete,
+	}
+	history := stub.History[key]
+	if len(history) > 0 && history[len(history)-1].txSeq == stub.txSeq {
+		history[len(history)-1].modification = modification
+		return
+	}
+	stub.History[key] = append(history, testStubHistoryEntry{txSeq: stub.txSeq, modification: modification})
+}
+
+func (stub *TestStub) PutState(key string, value []byte) error {
+	err := stub.MockStub.PutState(key, value)
+	if err != nil {
+		return err
+	}
+	stub.recordHistory(key, value, false)
+	return nil
+}
+
+func (stub *TestStub) DelState(key string) error {
+	err := stub.MockStub.DelState(key)
+	if err != nil {
+		return err
+	}
+	stub.recordHistory(key, nil, true)
+	return nil
+}
+
+func (stub *TestStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	var modifications []*queryresult.KeyModification
+	for _, entry := range stub.History[key] {
+		modifications = append(modifications, entry.modification)
+	}
+	return &testStubHistoryIterator{modifications: modifications}, nil
+}
+
+func (stub *TestStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	return stub.PrivateState[collection][key], nil
+}
+
+func (stub *TestStub) PutPrivateData(collection string, key string, value []byte) error {
+	if stub.TxID == "" {
+		return errors.New("cannot PutPrivateData without a transactions - call stub.MockTransactionStart()?")
+	}
+	if _, ok := stub.PrivateState[collection]; !ok {
+		stub.PrivateState[collection] = make(map[string][]byte)
+	}
+	stub.PrivateState[collection][key] = value
+	return nil
+}
+
+func (stub *TestStub) DelPrivateData(collection string, key string) error {
+	delete(stub.PrivateState[collection], key)
+	return nil
+}
+
+func (stub *TestStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	var results []*queryresult.KV
+
+	prefix, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range stub.PrivateState[collection] {
+		if strings.HasPrefix(key, prefix) {
+			results = append(results, &queryresult.KV{Namespace: stub.Name, Key: key, Value: value})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Key < results[j].Key })
+	return &testStubQueryIterator{results: results}, nil
+}
+
+type testStubQueryIterator struct {
+	/*
+		Iterates over a snapshot of query results
+	*/
+	results []*queryresult.KV
+	index   int
+}
+
+func (iter *testStubQueryIterator) HasNext() bool {
+	return iter.index < len(iter.results)
+}
+
+func (iter *testStubQueryIterator) Next() (*queryresult.KV, error) {
+	if !iter.HasNext() {
+		return nil, errors.New("Next() called when no results remain")
+	}
+	iter.index += 1
+	return iter.results[iter.index-1], nil
+}
+
+func (iter *testStubQueryIterator) Close() error {
+	iter.results = nil
+	return nil
+}
+
+type testStubHistoryIterator struct {
+	/*
+		Iterates over a snapshot of a key's history
+	*/
+	modifications []*queryresult.KeyModification
+	index         int
+}
+
+func (iter *testStubHistoryIterator) HasNext() bool {
+	return iter.index < len(iter.modifications)
+}
+
+func (iter *testStubHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	if !iter.HasNext() {
+		return nil, errors.New("Next() called when no history remains")
+	}
+	iter.index += 1
+	return iter.modifications[iter.index-1], nil
+}
+
+func (iter *testStubHistoryIterator) Close() error {
+	iter.modifications = nil
+	return nil
+}
@@ -8,7 +8,7 @@ import (
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 )
 
-func CheckBankAccount(t *testing.T, stub *shim.MockStub, id string, value float32) {
+func CheckBankAccount(t *testing.T, stub *TestStub, id string, value float32) {
 	var recordBytes []byte
 	var record *BankAccount
 	var key string
@@ -41,7 +41,7 @@ func CheckBankAccount(t *testing.T, stub *shim.MockStub, id string, value float3
 
 
 
-func CheckProduct(t *testing.T, stub *shim.MockStub, id string, value float32) {
+func CheckProduct(t *testing.T, stub *TestStub, id string, value float32) {
 	var recordBytes []byte
 	var record *Product
 	var key string
@@ -67,7 +67,7 @@ func CheckProduct(t *testing.T, stub *shim.MockStub, id string, value float32) {
 
 }
 
-func ExecQuery(t *testing.T, stub *shim.MockStub, function string) {
+func ExecQuery(t *testing.T, stub *TestStub, function string) {
 	fmt.Println("Executing Query function:", function)
 	res := stub.MockInvoke("1", [][]byte{[]byte(function)})
 	if res.Status != shim.OK {
@@ -82,7 +82,7 @@ func ExecQuery(t *testing.T, stub *shim.MockStub, function string) {
 	fmt.Println(payload)
 }
 
-func ExecInvoke(t *testing.T, stub *shim.MockStub, function string, args []string) *string {
+func ExecInvoke(t *testing.T, stub *TestStub, function string, args []string) *string {
 	fmt.Println("Executing invoke function:", function)
 
 	var byteArgs [][]byte
@@ -107,7 +107,45 @@ func ExecInvoke(t *testing.T, stub *shim.MockStub, function string, args []strin
 
 }
 
-func checkQuery(t *testing.T, stub *shim.MockStub, function string, name string, value string) {
+func ExecInvokeAs(t *testing.T, stub *TestStub, identity *TestIdentity, function string, args []string) *string {
+	fmt.Println("Executing invoke function:", function, "as", identity.Name)
+
+	byteArgs := [][]byte{[]byte(function)}
+	for _, s := range args {
+		byteArgs = append(byteArgs, []byte(s))
+	}
+
+	res := stub.MockInvokeAs("1", identity, byteArgs)
+	if res.Status != shim.OK {
+		fmt.Println("Invoke", function, "as", identity.Name, "failed", string(res.Message))
+		t.FailNow()
+	}
+
+	if res.Payload != nil {
+		payload := string(res.Payload)
+		return &payload
+	}
+	return nil
+}
+
+func CheckForbidden(t *testing.T, stub *TestStub, identity *TestIdentity, function string, args []string) {
+	byteArgs := [][]byte{[]byte(function)}
+	for _, s := range args {
+		byteArgs = append(byteArgs, []byte(s))
+	}
+
+	res := stub.MockInvokeAs("1", identity, byteArgs)
+	if res.Status == shim.OK {
+		fmt.Println("Invoke", function, "as", identity.Name, "succeeded but should have been denied")
+		t.FailNow()
+	}
+	if ParseErrorResponse(res.Message).Code != ERROR_FORBIDDEN {
+		fmt.Println("Invoke", function, "as", identity.Name, "failed without being denied:", res.Message)
+		t.FailNow()
+	}
+}
+
+func checkQuery(t *testing.T, stub *TestStub, function string, name string, value string) {
 	res := stub.MockInvoke("1", [][]byte{[]byte(function), []byte(name)})
 	if res.Status != shim.OK {
 		fmt.Println("Query", name, "failed", string(res.Message))
@@ -125,7 +163,7 @@ func checkQuery(t *testing.T, stub *shim.MockStub, function string, name string,
 }
 
 
-func FetchTestBankAccount(t *testing.T, stub *shim.MockStub, id string,) *BankAccount {
+func FetchTestBankAccount(t *testing.T, stub *TestStub, id string,) *BankAccount {
 	var recordBytes []byte
 	var record *BankAccount
 	var key string
@@ -153,7 +191,7 @@ func FetchTestBankAccount(t *testing.T, stub *shim.MockStub, id string,) *BankAc
 }
 
 
-func FetchTestAppdevRecord(t *testing.T, stub *shim.MockStub, id string,) *AppDevRecord {
+func FetchTestAppdevRecord(t *testing.T, stub *TestStub, id string,) *AppDevRecord {
 	var recordBytes []byte
 	var record *AppDevRecord
 	var key string
@@ -181,7 +219,7 @@ func FetchTestAppdevRecord(t *testing.T, stub *shim.MockStub, id string,) *AppDe
 }
 
 
-func FetchTestCustomerRecord(t *testing.T, stub *shim.MockStub, id string,) *CustomerRecord {
+func FetchTestCustomerRecord(t *testing.T, stub *TestStub, id string,) *CustomerRecord {
 	var recordBytes []byte
 	var record *CustomerRecord
 	var key string
@@ -208,7 +246,7 @@ func FetchTestCustomerRecord(t *testing.T, stub *shim.MockStub, id string,) *Cus
 	return record
 }
 
-func FetchTestCreatorRecord(t *testing.T, stub *shim.MockStub, id string,) *CreatorRecord {
+func FetchTestCreatorRecord(t *testing.T, stub *TestStub, id string,) *CreatorRecord {
 	var recordBytes []byte
 	var record *CreatorRecord
 	var key string
@@ -236,7 +274,7 @@ func FetchTestCreatorRecord(t *testing.T, stub *shim.MockStub, id string,) *Crea
 }
 
 
-func FetchTestProductRecord(t *testing.T, stub *shim.MockStub, id string,) *Product {
+func FetchTestProductRecord(t *testing.T, stub *TestStub, id string,) *Product {
 	var recordBytes []byte
 	var record *Product
 	var key string
@@ -263,7 +301,7 @@ func FetchTestProductRecord(t *testing.T, stub *shim.MockStub, id string,) *Prod
 	return record
 }
 
-func FetchTestContractRecord(t *testing.T, stub *shim.MockStub, creatorId string, appDevId string, productId string) *Contract {
+func FetchTestContractRecord(t *testing.T, stub *TestStub, creatorId string, appDevId string, productId string) *Contract {
 	var recordBytes []byte
 	var record *Contract
 	var key string
@@ -288,4 +326,73 @@ func FetchTestContractRecord(t *testing.T, stub *shim.MockStub, creatorId string
 	}
 
 	return record
-}
\ No newline at end of file
+}
+func FetchTestContractTerms(t *testing.T, stub *TestStub, creatorId string, appDevId string, productId string) *ContractTerms {
+	var recordBytes []byte
+	var record *ContractTerms
+	var key string
+	var err error
+
+	key, err = stub.CreateCompositeKey(KEY_OBJECT_FORMAT, []string{CONTRACT_TERMS_KEY_PREFIX, creatorId, appDevId, productId})
+	if err != nil {
+		fmt.Println("Cannot create key from id: ", creatorId, appDevId, productId)
+		t.FailNow()
+	}
+
+	recordBytes, err = stub.GetPrivateData(CONTRACT_TERMS_COLLECTION, key)
+	if err != nil || len(recordBytes) == 0 {
+		fmt.Println("Cannot find private record for key: ", key)
+		t.FailNow()
+	}
+
+	err = json.Unmarshal(recordBytes, &record)
+	if err != nil {
+		fmt.Println("Cannot unmarshal record for key: ", key)
+		t.FailNow()
+	}
+
+	return record
+}
+
+func ShrinkSequence(length int, fails func(steps []int) bool) []int {
+	/*
+		Shrinks a failing sequence of steps to a minimal failing subsequence. Chunks of steps
+		are removed while the sequence still fails, halving the chunk size whenever no chunk
+		can be removed, until no single step can be removed.
+
+		Args:
+			length: Number of steps in the failing sequence
+			fails: Replays the steps at the given indices, reporting whether they still fail
+
+		Returns:
+			steps: Indices of the steps of the minimal failing subsequence, in order
+	*/
+	steps := make([]int, length)
+	for i := range steps {
+		steps[i] = i
+	}
+
+	chunk := len(steps) / 2
+	for chunk >= 1 {
+		removed := false
+		for start := 0; start < len(steps); {
+			end := start + chunk
+			if end > len(steps) {
+				end = len(steps)
+			}
+			candidate := append(append([]int{}, steps[:start]...), steps[end:]...)
+			if len(candidate) > 0 && fails(candidate) {
+				steps = candidate
+				removed = true
+			} else {
+				start = end
+			}
+		}
+		if !removed {
+			chunk /= 2
+		} else if chunk > len(steps)/2 {
+			chunk = len(steps) / 2
+		}
+	}
+	return steps
+}
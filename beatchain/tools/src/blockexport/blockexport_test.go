@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beatchain/utils"
+	"github.com/golang/protobuf/proto"
+)
+
+var update = flag.Bool("update", false, "rewrite the fixture blocks in testdata")
+
+// Blocks 0-2 as stored in a peer's ledger files, and block 3 as fetched from the channel
+var ledgerFixture = filepath.Join("testdata", "ledger")
+var fetchedFixture = filepath.Join("testdata", "fetched")
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if *update {
+		err := writeFixtures()
+		if err != nil {
+			fmt.Println("Cannot write fixtures:", err.Error())
+			os.Exit(1)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+type fixtureTx struct {
+	txId      string
+	valid     bool
+	namespace string
+	writes    []*KVWrite
+}
+
+func fixtureKey(recordType string, ids ...string) string {
+	return "\x00" + strings.Join(append([]string{utils.KEY_OBJECT_FORMAT, recordType}, ids...), "\x00") + "\x00"
+}
+
+func fixtureValue(record interface{}) []byte {
+	recordBytes, _ := json.Marshal(record)
+	return recordBytes
+}
+
+func fixtureBlock(number uint64, txs []fixtureTx) (*Block, error) {
+	/*
+		Builds a block of endorser transactions, each writing to a single namespace. Block 0
+		holds a config transaction instead, as the genesis block of a channel does.
+	*/
+	var envelopes [][]byte
+	var validationCodes []byte
+
+	txTime := time.Date(2020, 5, 1, 12, 0, int(number), 0, time.UTC)
+	if number == 0 {
+		txs = []fixtureTx{{txId: "", valid: true}}
+	}
+	for _, tx := range txs {
+		headerType := HEADER_TYPE_ENDORSER_TRANSACTION
+		if number == 0 {
+			headerType = HEADER_TYPE_CONFIG
+		}
+		channelHeader, err := proto.Marshal(&ChannelHeader{
+			Type:      headerType,
+			ChannelId: "fullchannel",
+			TxId:      tx.txId,
+			Timestamp: &Timestamp{Seconds: txTime.Unix()}})
+		if err != nil {
+			return nil, err
+		}
+
+		var data []byte
+		if number != 0 {
+			kvRwset, err := proto.Marshal(&KVRWSet{Writes: tx.writes})
+			if err != nil {
+				return nil, err
+			}
+			results, err := proto.Marshal(&TxReadWriteSet{NsRwset: []*NsReadWriteSet{{Namespace: tx.namespace, Rwset: kvRwset}}})
+			if err != nil {
+				return nil, err
+			}
+			extension, err := proto.Marshal(&ChaincodeAction{
+				Results:     results,
+				ChaincodeId: &ChaincodeID{Name: tx.namespace}})
+			if err != nil {
+				return nil, err
+			}
+			responsePayload, err := proto.Marshal(&ProposalResponsePayload{Extension: extension})
+			if err != nil {
+				return nil, err
+			}
+			actionPayload, err := proto.Marshal(&ChaincodeActionPayload{
+				Action: &ChaincodeEndorsedAction{ProposalResponsePayload: responsePayload}})
+			if err != nil {
+				return nil, err
+			}
+			data, err = proto.Marshal(&Transaction{Actions: []*TransactionAction{{Payload: actionPayload}}})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		payload, err := proto.Marshal(&Payload{Header: &Header{ChannelHeader: channelHeader}, Data: data})
+		if err != nil {
+			return nil, err
+		}
+		envelope, err := proto.Marshal(&Envelope{Payload: payload})
+		if err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, envelope)
+		if tx.valid {
+			validationCodes = append(validationCodes, TX_VALIDATION_CODE_VALID)
+		} else {
+			validationCodes = append(validationCodes, TX_VALIDATION_CODE_MVCC_CONFLICT)
+		}
+	}
+
+	return &Block{
+		Header:   &BlockHeader{Number: number},
+		Data:     &BlockData{Data: envelopes},
+		Metadata: &BlockMetadata{Metadata: [][]byte{{}, {}, validationCodes, {}}}}, nil
+}
+
+func fixtureBlocks() ([]*Block, error) {
+	var blocks []*Block
+
+	customerKey := fixtureKey(utils.CUSTOMER_RECORD_KEY_PREFIX, utils.TEST_CUSTOMER_ID)
+	bankAccountKey := fixtureKey(utils.BANK_ACCOUNT_KEY_PREFIX, utils.TEST_CUSTOMER_BA_ID)
+	closedAt := time.Date(2020, 5, 1, 12, 0, 3, 0, time.UTC)
+
+	blockTxs := [][]fixtureTx{
+		nil,
+		{
+			// A customer stored before subscriptions, with its bank account, index entry and the unique ID
+			{txId: "tx1", valid: true, namespace: "beatchain", writes: []*KVWrite{
+				{Key: bankAccountKey, Value: fixtureValue(&utils.BankAccount{Id: utils.TEST_CUSTOMER_BA_ID, Balance: 1000, InUse: true, SchemaVersion: 1})},
+				{Key: customerKey, Value: []byte(`{"id":"2222","bankaccountid":"2222","appdevid":"1111","subscriptionfee":1,"subscriptionduedate":"2020-06-01T00:00:00Z"}`)},
+				{Key: fixtureKey(utils.SUBSCRIPTION_KEY_PREFIX, utils.TEST_APPDEV_ID, utils.TEST_CUSTOMER_ID), Value: []byte{0x00}},
+				{Key: utils.UNIQUE_ID_KEY, Value: []byte("100000001")}}},
+			// Writes of other chaincodes are not exported
+			{txId: "deploy", valid: true, namespace: "lscc", writes: []*KVWrite{
+				{Key: "beatchain", Value: []byte("chaincode data")}}},
+		},
+		{
+			{txId: "tx2", valid: true, namespace: "beatchain", writes: []*KVWrite{
+				{Key: fixtureKey(utils.PRODUCT_KEY_PREFIX, utils.TEST_PRODUCT_ID), Value: fixtureValue(&utils.Product{
+					Id: utils.TEST_PRODUCT_ID, CreatorId: utils.TEST_CREATOR_ID, ProductName: "Test Product", TotalListens: 6, IsActive: true, SchemaVersion: 1})}}},
+			// Invalidated by the committing peer, so the balance never changed
+			{txId: "tx3", valid: false, namespace: "beatchain", writes: []*KVWrite{
+				{Key: bankAccountKey, Value: fixtureValue(&utils.BankAccount{Id: utils.TEST_CUSTOMER_BA_ID, Balance: 0, SchemaVersion: 1})}}},
+		},
+		{
+			// The customer is closed
+			{txId: "tx4", valid: true, namespace: "beatchain", writes: []*KVWrite{
+				{Key: customerKey, IsDelete: true},
+				{Key: fixtureKey(utils.TOMBSTONE_KEY_PREFIX, utils.CUSTOMER_RECORD_KEY_PREFIX, utils.TEST_CUSTOMER_ID), Value: fixtureValue(&utils.Tombstone{
+					RecordType: utils.CUSTOMER_RECORD_KEY_PREFIX, Id: utils.TEST_CUSTOMER_ID, BankAccountId: utils.TEST_CUSTOMER_BA_ID,
+					Disposition: "payout", FinalBalance: 1000, ClosedBy: "admin", ClosedAt: closedAt, TxId: "tx4"})}}},
+		},
+	}
+	for number, txs := range blockTxs {
+		block, err := fixtureBlock(uint64(number), txs)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+func writeFixtures() error {
+	/*
+		Writes blocks 0-2 as a peer ledger file and block 3 as fetched with `peer channel fetch`
+	*/
+	var ledgerFile []byte
+
+	blocks, err := fixtureBlocks()
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks[:3] {
+		blockBytes, err := proto.Marshal(block)
+		if err != nil {
+			return err
+		}
+		ledgerFile = append(ledgerFile, proto.EncodeVarint(uint64(len(blockBytes)))...)
+		ledgerFile = append(ledgerFile, blockBytes...)
+	}
+	fetchedBlock, err := proto.Marshal(blocks[3])
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range []string{ledgerFixture, fetchedFixture} {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(ledgerFixture, "blockfile_000000"), ledgerFile, 0644)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(fetchedFixture, "fullchannel_3.block"), fetchedBlock, 0644)
+}
+
+func readExported(t *testing.T, path string) []map[string]interface{} {
+	var records []map[string]interface{}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestExportNDJSON(t *testing.T) {
+	outDir, err := ioutil.TempDir("", "blockexport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+
+	checkpoint, err := Export(&ExportOptions{Sources: []string{ledgerFixture}, OutDir: outDir, Format: FORMAT_NDJSON, Namespace: "beatchain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.LastBlock != 2 {
+		t.Fatalf("Expected to export through block 2. Exported through %d", checkpoint.LastBlock)
+	}
+
+	// The invalid transaction's write is skipped
+	bankAccounts := readExported(t, filepath.Join(outDir, "BankAccount.ndjson"))
+	if len(bankAccounts) != 1 || bankAccounts[0]["txid"] != "tx1" || bankAccounts[0]["record"].(map[string]interface{})["balance"] != 1000.0 {
+		t.Fatalf("Unexpected BankAccount records: %v", bankAccounts)
+	}
+
+	// Records stored by earlier schema versions are exported at the current version
+	customers := readExported(t, filepath.Join(outDir, "CustomerRecord.ndjson"))
+	if len(customers) != 1 {
+		t.Fatalf("Expected 1 CustomerRecord. Found %v", customers)
+	}
+	subscriptions := customers[0]["record"].(map[string]interface{})["subscriptions"].(map[string]interface{})
+	if _, ok := subscriptions[utils.TEST_APPDEV_ID]; !ok {
+		t.Fatalf("Legacy CustomerRecord not migrated: %v", customers[0])
+	}
+
+	// Index entries, ledger settings and other chaincodes' keys are not records
+	for _, name := range []string{"Subscription.ndjson", "UNIQUE_ID_VARIABLE.ndjson"} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("Unexpected output file %s", name)
+		}
+	}
+	if len(checkpoint.Exported) != 3 || checkpoint.Exported[utils.PRODUCT_KEY_PREFIX] != 1 {
+		t.Fatalf("Unexpected records exported: %v", checkpoint.Exported)
+	}
+}
+
+func TestIncrementalExport(t *testing.T) {
+	outDir, err := ioutil.TempDir("", "blockexport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+	options := &ExportOptions{
+		Sources:     []string{ledgerFixture},
+		OutDir:      outDir,
+		Format:      FORMAT_NDJSON,
+		Namespace:   "beatchain",
+		Checkpoint:  filepath.Join(outDir, "checkpoint.json"),
+		Incremental: true}
+
+	_, err = Export(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the block after the checkpoint is exported, though the ledger files are read again
+	options.Sources = []string{ledgerFixture, fetchedFixture}
+	checkpoint, err := Export(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.LastBlock != 3 || len(checkpoint.Exported) != 2 ||
+		checkpoint.Exported[utils.CUSTOMER_RECORD_KEY_PREFIX] != 1 || checkpoint.Exported[utils.TOMBSTONE_KEY_PREFIX] != 1 {
+		t.Fatalf("Unexpected incremental export: %+v", checkpoint)
+	}
+	customers := readExported(t, filepath.Join(outDir, "CustomerRecord.ndjson"))
+	if len(customers) != 2 || customers[1]["deleted"] != true || customers[1]["record"] != nil {
+		t.Fatalf("Expected the CustomerRecord and its deletion. Found %v", customers)
+	}
+
+	stored, err := ReadCheckpoint(options.Checkpoint)
+	if err != nil || stored.LastBlock != 3 {
+		t.Fatalf("Checkpoint not advanced: %+v %v", stored, err)
+	}
+	checkpoint, err = Export(options)
+	if err != nil || checkpoint.LastBlock != 3 || len(checkpoint.Exported) != 0 {
+		t.Fatalf("Expected nothing new to export: %+v %v", checkpoint, err)
+	}
+
+	// Blocks missing between the checkpoint and those given are reported, not skipped
+	err = WriteCheckpoint(options.Checkpoint, &Checkpoint{LastBlock: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.Sources = []string{fetchedFixture}
+	_, err = Export(options)
+	if err == nil || !strings.Contains(err.Error(), "block 2 is missing") {
+		t.Fatalf("Expected block 2 to be reported missing. Found %v", err)
+	}
+
+	// The failed export left the output and the checkpoint as they were
+	customers = readExported(t, filepath.Join(outDir, "CustomerRecord.ndjson"))
+	stored, _ = ReadCheckpoint(options.Checkpoint)
+	if len(customers) != 2 || stored.LastBlock != 1 {
+		t.Fatalf("Failed export changed the output: %v %+v", customers, stored)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(outDir, "*.tmp")); len(matches) != 0 {
+		t.Fatalf("Temporary files left behind: %v", matches)
+	}
+
+	// Incremental exports need a checkpoint to resume from
+	options.Checkpoint = ""
+	_, err = Export(options)
+	if err == nil {
+		t.Fatal("Incremental export ran without a checkpoint")
+	}
+}
+
+func TestFullExportReplacesOutput(t *testing.T) {
+	outDir, err := ioutil.TempDir("", "blockexport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+	options := &ExportOptions{
+		Sources:    []string{ledgerFixture, fetchedFixture},
+		OutDir:     outDir,
+		Format:     FORMAT_NDJSON,
+		Namespace:  "beatchain",
+		Checkpoint: filepath.Join(outDir, "checkpoint.json")}
+
+	// Exporting every block again replaces the records rather than appending duplicates
+	for i := 0; i < 2; i++ {
+		_, err = Export(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	customers := readExported(t, filepath.Join(outDir, "CustomerRecord.ndjson"))
+	if len(customers) != 2 {
+		t.Fatalf("Expected the CustomerRecord and its deletion once each. Found %v", customers)
+	}
+
+	// A full export must start from block 0, or the checkpoint would skip the blocks before
+	options.Sources = []string{fetchedFixture}
+	_, err = Export(options)
+	if err == nil || !strings.Contains(err.Error(), "block 0 is missing") {
+		t.Fatalf("Expected block 0 to be reported missing. Found %v", err)
+	}
+
+	// Types no longer exported by a full export are not left over from an earlier one
+	options.Sources = []string{ledgerFixture, fetchedFixture}
+	options.Namespace = "othercc"
+	_, err = Export(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(outDir, "Product.ndjson")); !os.IsNotExist(err) {
+		t.Fatalf("Product output left over from an earlier export: %v", err)
+	}
+	stored, err := ReadCheckpoint(options.Checkpoint)
+	if err != nil || stored.LastBlock != 3 {
+		t.Fatalf("Checkpoint not written by a full export: %+v %v", stored, err)
+	}
+}
+
+func TestIndexBlocks(t *testing.T) {
+	locations, err := IndexBlocks([]string{fetchedFixture, ledgerFixture})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locations) != 4 || locations[3].Offset != 0 || locations[2].File != filepath.Join(ledgerFixture, "blockfile_000000") {
+		t.Fatalf("Unexpected block locations: %+v", locations)
+	}
+	for number, location := range locations {
+		block, err := ReadBlock(location)
+		if err != nil || block.Header.Number != uint64(number) {
+			t.Fatalf("Cannot read block %d at %+v: %v", number, location, err)
+		}
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	outDir, err := ioutil.TempDir("", "blockexport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+
+	_, err = Export(&ExportOptions{Sources: []string{ledgerFixture, fetchedFixture}, OutDir: outDir, Format: FORMAT_CSV, Namespace: "beatchain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	csvBytes, err := ioutil.ReadFile(filepath.Join(outDir, "CustomerRecord.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "block,txid,timestamp,key,deleted,id,bankaccountid,subscriptions,queuedsong,previoussong,schemaversion\n" +
+		"1,tx1,2020-05-01T12:00:01Z,2222,false,2222,2222,\"{\"\"1111\"\":{\"\"appdevid\"\":\"\"1111\"\",\"\"subscriptionfee\"\":1,\"\"subscriptionduedate\"\":\"\"2020-06-01T00:00:00Z\"\"}}\",,,2\n" +
+		"3,tx4,2020-05-01T12:00:03Z,2222,true,,,,,,\n"
+	if string(csvBytes) != expected {
+		t.Fatalf("Unexpected CustomerRecord CSV:\n%s", csvBytes)
+	}
+}
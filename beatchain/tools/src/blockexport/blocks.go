@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+type KeyWrite struct {
+	/*
+		Defines a key written by a valid transaction of a block
+	*/
+	Block     uint64
+	TxId      string
+	Timestamp time.Time
+	Key       string
+	IsDelete  bool
+	Value     []byte
+}
+
+type BlockLocation struct {
+	/*
+		Defines where a block is stored, so that it can be read without reading the blocks
+		stored before it
+	*/
+	Number uint64
+	File   string
+	Offset int64 // Offset of the marshaled block within the file
+	Length int64 // Length of the marshaled block
+}
+
+func IndexBlocks(paths []string) ([]*BlockLocation, error) {
+	/*
+		Locates the blocks stored in the given files, in block number order. A file ending in
+		.block holds a single marshaled block, as written by `peer channel fetch`. Any other
+		file is read as a stream of blocks each prefixed by its varint length, as in the
+		peer's blockfile_NNNNNN ledger files. Directories are read file by file. Only the
+		header of each block is read; the rest is seeked past.
+
+		Args:
+			paths: Block files and directories of block files
+
+		Returns:
+			locations: Location of each block number, once
+			err: Error object. nil if no error occurred.
+	*/
+	var locations []*BlockLocation
+	var files []string
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+
+	seen := make(map[uint64]bool)
+	for _, file := range files {
+		fileLocations, err := indexBlockFile(file)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("error reading blocks from %s: %s", file, err.Error()))
+		}
+		for _, location := range fileLocations {
+			// The same block may be both in the ledger files and fetched from the channel
+			if !seen[location.Number] {
+				seen[location.Number] = true
+				locations = append(locations, location)
+			}
+		}
+	}
+
+	sort.Slice(locations, func(i, j int) bool { return locations[i].Number < locations[j].Number })
+	return locations, nil
+}
+
+func indexBlockFile(path string) ([]*BlockLocation, error) {
+	var locations []*BlockLocation
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(path, ".block") {
+		number, err := readBlockNumber(file, 0, info.Size())
+		if err != nil {
+			return nil, err
+		}
+		return []*BlockLocation{{Number: number, File: path, Offset: 0, Length: info.Size()}}, nil
+	}
+
+	for offset := int64(0); offset < info.Size(); {
+		length, n, err := readVarint(file, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(n)
+		if length > uint64(info.Size()-offset) {
+			return nil, errors.New("truncated block")
+		}
+		number, err := readBlockNumber(file, offset, int64(length))
+		if err != nil {
+			return nil, err
+		}
+		locations = append(locations, &BlockLocation{Number: number, File: path, Offset: offset, Length: int64(length)})
+		offset += int64(length)
+	}
+	return locations, nil
+}
+
+func readVarint(file *os.File, offset int64) (uint64, int, error) {
+	/*
+		Reads the varint at an offset of a file
+
+		Returns:
+			value: The varint's value
+			n: Number of bytes the varint takes
+			err: Error object. nil if no error occurred.
+	*/
+	varintBytes := make([]byte, binary.MaxVarintLen64)
+	read, err := file.ReadAt(varintBytes, offset)
+	if err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+	value, n := proto.DecodeVarint(varintBytes[:read])
+	if n == 0 {
+		return 0, 0, errors.New("truncated block length")
+	}
+	return value, n, nil
+}
+
+func readBlockNumber(file *os.File, offset int64, length int64) (uint64, error) {
+	/*
+		Reads the number of the block marshaled at an offset of a file from its header alone.
+		Marshaled blocks start with their header, field 1.
+	*/
+	tag, n, err := readVarint(file, offset)
+	if err != nil {
+		return 0, err
+	}
+	if tag != 1<<3|proto.WireBytes {
+		return 0, errors.New(fmt.Sprintf("block at offset %d does not start with its header", offset))
+	}
+	headerLength, m, err := readVarint(file, offset+int64(n))
+	if err != nil {
+		return 0, err
+	}
+	start := offset + int64(n+m)
+	if headerLength > uint64(offset+length-start) {
+		return 0, errors.New(fmt.Sprintf("truncated block header at offset %d", offset))
+	}
+	headerBytes := make([]byte, headerLength)
+	_, err = file.ReadAt(headerBytes, start)
+	if err != nil {
+		return 0, err
+	}
+	header := &BlockHeader{}
+	err = proto.Unmarshal(headerBytes, header)
+	if err != nil {
+		return 0, err
+	}
+	return header.Number, nil
+}
+
+func ReadBlock(location *BlockLocation) (*Block, error) {
+	/*
+		Reads and decodes a single block from where IndexBlocks located it
+	*/
+	file, err := os.Open(location.File)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	blockBytes := make([]byte, location.Length)
+	_, err = file.ReadAt(blockBytes, location.Offset)
+	if err != nil {
+		return nil, err
+	}
+	block := &Block{}
+	err = proto.Unmarshal(blockBytes, block)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error decoding block %d from %s: %s", location.Number, location.File, err.Error()))
+	}
+	if block.Header == nil || block.Header.Number != location.Number {
+		return nil, errors.New(fmt.Sprintf("block %d in %s changed while being exported", location.Number, location.File))
+	}
+	return block, nil
+}
+
+func BlockWrites(block *Block, namespace string) ([]*KeyWrite, error) {
+	/*
+		Extracts the keys written to a chaincode's namespace by the valid endorser
+		transactions of a block, in transaction order. Transactions marked invalid by the
+		committing peer changed nothing and are skipped. Private data writes appear in blocks
+		only as hashes and are not extracted.
+
+		Args:
+			block: Block to decode
+			namespace: Name of the chaincode, e.g. beatchain
+
+		Returns:
+			writes: Keys written
+			err: Error object. nil if no error occurred.
+	*/
+	var writes []*KeyWrite
+	var validationCodes []byte
+
+	if block.Data == nil {
+		return nil, nil
+	}
+	if block.Metadata != nil && len(block.Metadata.Metadata) > METADATA_TRANSACTIONS_FILTER {
+		validationCodes = block.Metadata.Metadata[METADATA_TRANSACTIONS_FILTER]
+	}
+
+	for i, envelopeBytes := range block.Data.Data {
+		if i < len(validationCodes) && validationCodes[i] != TX_VALIDATION_CODE_VALID {
+			continue
+		}
+		txWrites, err := transactionWrites(block.Header.Number, envelopeBytes, namespace)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("error decoding transaction %d of block %d: %s", i, block.Header.Number, err.Error()))
+		}
+		writes = append(writes, txWrites...)
+	}
+	return writes, nil
+}
+
+func transactionWrites(blockNumber uint64, envelopeBytes []byte, namespace string) ([]*KeyWrite, error) {
+	var writes []*KeyWrite
+	envelope := &Envelope{}
+	payload := &Payload{}
+	channelHeader := &ChannelHeader{}
+	transaction := &Transaction{}
+
+	err := proto.Unmarshal(envelopeBytes, envelope)
+	if err == nil {
+		err = proto.Unmarshal(envelope.Payload, payload)
+	}
+	if err == nil && payload.Header == nil {
+		err = errors.New("payload without a header")
+	}
+	if err == nil {
+		err = proto.Unmarshal(payload.Header.ChannelHeader, channelHeader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Config and other system transactions carry no chaincode writes
+	if channelHeader.Type != HEADER_TYPE_ENDORSER_TRANSACTION {
+		return nil, nil
+	}
+	err = proto.Unmarshal(payload.Data, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	var timestamp time.Time
+	if channelHeader.Timestamp != nil {
+		timestamp = time.Unix(channelHeader.Timestamp.Seconds, int64(channelHeader.Timestamp.Nanos)).UTC()
+	}
+
+	for _, action := range transaction.Actions {
+		actionPayload := &ChaincodeActionPayload{}
+		responsePayload := &ProposalResponsePayload{}
+		chaincodeAction := &ChaincodeAction{}
+		txRwset := &TxReadWriteSet{}
+
+		err = proto.Unmarshal(action.Payload, actionPayload)
+		if err == nil && actionPayload.Action == nil {
+			err = errors.New("action without an endorsed action")
+		}
+		if err == nil {
+			err = proto.Unmarshal(actionPayload.Action.ProposalResponsePayload, responsePayload)
+		}
+		if err == nil {
+			err = proto.Unmarshal(responsePayload.Extension, chaincodeAction)
+		}
+		if err == nil {
+			err = proto.Unmarshal(chaincodeAction.Results, txRwset)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, nsRwset := range txRwset.NsRwset {
+			if nsRwset.Namespace != namespace {
+				continue
+			}
+			kvRwset := &KVRWSet{}
+			err = proto.Unmarshal(nsRwset.Rwset, kvRwset)
+			if err != nil {
+				return nil, err
+			}
+			for _, write := range kvRwset.Writes {
+				writes = append(writes, &KeyWrite{
+					Block:     blockNumber,
+					TxId:      channelHeader.TxId,
+					Timestamp: timestamp,
+					Key:       write.Key,
+					IsDelete:  write.IsDelete,
+					Value:     write.Value})
+			}
+		}
+	}
+	return writes, nil
+}
@@ -0,0 +1,150 @@
+/*
+Exports the Beatchain records written to a channel's blocks for loading into an analytics
+warehouse, without going through the chaincode or the middleware
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+type ExportOptions struct {
+	/*
+		Defines what an export reads and where it writes
+	*/
+	Sources     []string // Block files and directories of block files
+	OutDir      string   // Directory of the per-type output files
+	Format      string   // FORMAT_NDJSON or FORMAT_CSV
+	Namespace   string   // Name of the chaincode whose writes are exported
+	Checkpoint  string   // Checkpoint file advanced by each export. Empty to keep none.
+	Incremental bool     // Append the blocks after the checkpoint to the output instead of replacing it with every block
+}
+
+func Export(options *ExportOptions) (*Checkpoint, error) {
+	/*
+		Writes the records written by the blocks in the sources to the per-type output
+		files, replacing their contents. In incremental mode the records are appended
+		instead, and blocks up to the checkpoint's last block are skipped without being
+		read. The output files are only replaced once complete, and the checkpoint is
+		advanced after them.
+
+		Args:
+			options: Sources, output and checkpoint of the export
+
+		Returns:
+			checkpoint: Last block exported and the records exported per type
+			err: Error object. nil if no error occurred.
+	*/
+	var previous *Checkpoint
+	var err error
+
+	if options.Incremental {
+		if options.Checkpoint == "" {
+			return nil, errors.New("an incremental export needs a checkpoint file")
+		}
+		previous, err = ReadCheckpoint(options.Checkpoint)
+		if err != nil {
+			return nil, err
+		}
+	}
+	locations, err := IndexBlocks(options.Sources)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := newRecordWriter(options.OutDir, options.Format, options.Incremental)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := &Checkpoint{ExportedAt: time.Now().UTC(), Exported: make(map[string]int)}
+	if previous != nil {
+		checkpoint.LastBlock = previous.LastBlock
+	}
+	started := previous != nil
+	for _, location := range locations {
+		if previous != nil && location.Number <= previous.LastBlock {
+			continue
+		}
+		// Without a checkpoint the export starts from block 0, and a gap would advance the
+		// checkpoint past blocks never exported
+		next := uint64(0)
+		if started {
+			next = checkpoint.LastBlock + 1
+		}
+		if location.Number != next {
+			writer.Abort()
+			return nil, errors.New(fmt.Sprintf("block %d is missing from the sources", next))
+		}
+		started = true
+		block, err := ReadBlock(location)
+		if err != nil {
+			writer.Abort()
+			return nil, err
+		}
+		writes, err := BlockWrites(block, options.Namespace)
+		if err != nil {
+			writer.Abort()
+			return nil, err
+		}
+		for _, write := range writes {
+			record, err := ExportRecord(write)
+			if err != nil {
+				writer.Abort()
+				return nil, err
+			}
+			if record == nil {
+				continue
+			}
+			err = writer.Write(record)
+			if err != nil {
+				writer.Abort()
+				return nil, err
+			}
+			checkpoint.Exported[record.Type] += 1
+		}
+		checkpoint.LastBlock = block.Header.Number
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	if options.Checkpoint != "" {
+		err = WriteCheckpoint(options.Checkpoint, checkpoint)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return checkpoint, nil
+}
+
+func main() {
+	options := &ExportOptions{}
+	flag.StringVar(&options.OutDir, "out", ".", "directory the <type>.ndjson or <type>.csv files are written to")
+	flag.StringVar(&options.Format, "format", FORMAT_NDJSON, "output format: ndjson or csv")
+	flag.StringVar(&options.Namespace, "chaincode", "beatchain", "name of the chaincode whose writes are exported")
+	flag.StringVar(&options.Checkpoint, "checkpoint", "", "checkpoint file recording the last block exported")
+	flag.BoolVar(&options.Incremental, "incremental", false, "append only the blocks after the checkpoint instead of replacing the output")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <block file or directory>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	options.Sources = flag.Args()
+	if len(options.Sources) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	checkpoint, err := Export(options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Export failed:", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("Exported through block %d: %v\n", checkpoint.LastBlock, checkpoint.Exported)
+}
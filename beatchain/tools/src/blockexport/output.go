@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const FORMAT_NDJSON = "ndjson"
+const FORMAT_CSV = "csv"
+
+type Checkpoint struct {
+	/*
+		Defines the progress of an incremental export. Blocks up to LastBlock have been
+		exported and are skipped by the next run.
+	*/
+	LastBlock  uint64         `json:"lastblock"`
+	ExportedAt time.Time      `json:"exportedat"`
+	Exported   map[string]int `json:"exported"` // Record type -> records exported by the last run
+}
+
+func ReadCheckpoint(path string) (*Checkpoint, error) {
+	/*
+		Reads the checkpoint of an incremental export. Returns nil if none was written yet.
+	*/
+	var checkpoint *Checkpoint
+
+	checkpointBytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(checkpointBytes, &checkpoint)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid checkpoint %s: %s", path, err.Error()))
+	}
+	return checkpoint, nil
+}
+
+func WriteCheckpoint(path string, checkpoint *Checkpoint) error {
+	/*
+		Writes the checkpoint of an incremental export, replacing the last one only once the
+		new one is complete
+	*/
+	checkpointBytes, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(checkpointBytes)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+type recordWriter struct {
+	/*
+		Writes the exported records of each type to <dir>/<type>.ndjson or <dir>/<type>.csv.
+		Records are written to a temporary copy of each file, which replaces it on Close, so
+		a failed export leaves the output as it was.
+	*/
+	dir       string
+	format    string
+	appending bool // Records are appended to the existing output rather than replacing it
+	files     map[string]*os.File
+	csv       map[string]*csv.Writer
+}
+
+func newRecordWriter(dir string, format string, appending bool) (*recordWriter, error) {
+	if format != FORMAT_NDJSON && format != FORMAT_CSV {
+		return nil, errors.New(fmt.Sprintf("format must be %s or %s. Given: %s", FORMAT_NDJSON, FORMAT_CSV, format))
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &recordWriter{
+		dir:       dir,
+		format:    format,
+		appending: appending,
+		files:     make(map[string]*os.File),
+		csv:       make(map[string]*csv.Writer)}, nil
+}
+
+func (writer *recordWriter) path(recordType string) string {
+	return filepath.Join(writer.dir, recordType+"."+writer.format)
+}
+
+func (writer *recordWriter) file(recordType string) (*os.File, bool, error) {
+	/*
+		Opens the temporary output file of a record type. When appending it starts as a copy
+		of the existing output file.
+
+		Returns:
+			file: The open file
+			created: true if the file is empty, and so needs a CSV header
+			err: Error object. nil if no error occurred.
+	*/
+	var copied int64
+
+	if file, ok := writer.files[recordType]; ok {
+		return file, false, nil
+	}
+	path := writer.path(recordType)
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	writer.files[recordType] = file
+
+	if writer.appending {
+		existing, err := os.Open(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, false, err
+		}
+		if err == nil {
+			copied, err = io.Copy(file, existing)
+			existing.Close()
+			if err != nil {
+				return nil, false, err
+			}
+		}
+	}
+	return file, copied == 0, nil
+}
+
+func (writer *recordWriter) Write(record *ExportedRecord) error {
+	file, created, err := writer.file(record.Type)
+	if err != nil {
+		return err
+	}
+
+	if writer.format == FORMAT_NDJSON {
+		recordBytes, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(append(recordBytes, '\n'))
+		return err
+	}
+
+	columns := recordColumns(record.Type)
+	csvWriter, ok := writer.csv[record.Type]
+	if !ok {
+		csvWriter = csv.NewWriter(file)
+		writer.csv[record.Type] = csvWriter
+	}
+	if created {
+		err = csvWriter.Write(append([]string{"block", "txid", "timestamp", "key", "deleted"}, columns...))
+		if err != nil {
+			return err
+		}
+	}
+	row := []string{
+		strconv.FormatUint(record.Block, 10),
+		record.TxId,
+		record.Timestamp.Format(time.RFC3339Nano),
+		strings.Join(record.Id, "/"),
+		strconv.FormatBool(record.Deleted)}
+	values, err := csvValues(record.Record, columns)
+	if err != nil {
+		return err
+	}
+	return csvWriter.Write(append(row, values...))
+}
+
+func (writer *recordWriter) Close() error {
+	/*
+		Flushes the temporary output files to disk and renames each over its output file.
+		When not appending, the output files of types without records are removed, so that
+		none is left over from an earlier export. On error the output is left as it was
+		where possible and the temporary files are removed.
+	*/
+	for recordType, file := range writer.files {
+		if csvWriter, ok := writer.csv[recordType]; ok {
+			csvWriter.Flush()
+			if err := csvWriter.Error(); err != nil {
+				writer.Abort()
+				return err
+			}
+		}
+		if err := file.Sync(); err != nil {
+			writer.Abort()
+			return err
+		}
+	}
+	if err := writer.closeFiles(); err != nil {
+		writer.Abort()
+		return err
+	}
+
+	for recordType := range writer.files {
+		path := writer.path(recordType)
+		if err := os.Rename(path+".tmp", path); err != nil {
+			writer.Abort()
+			return err
+		}
+	}
+	if !writer.appending {
+		for recordType := range exportedTypes {
+			if _, ok := writer.files[recordType]; ok {
+				continue
+			}
+			if err := os.Remove(writer.path(recordType)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (writer *recordWriter) Abort() {
+	/*
+		Closes and removes the temporary output files, leaving the output as it was
+	*/
+	writer.closeFiles()
+	for recordType := range writer.files {
+		os.Remove(writer.path(recordType) + ".tmp")
+	}
+}
+
+func (writer *recordWriter) closeFiles() error {
+	/*
+		Closes the temporary output files, returning the first error met. Files already
+		closed report an error too, so Abort ignores them.
+	*/
+	var firstErr error
+
+	for _, file := range writer.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func recordColumns(recordType string) []string {
+	/*
+		Returns the JSON field names of a record type in declaration order, used as its CSV
+		columns after the write's own
+	*/
+	var columns []string
+
+	recordStruct := reflect.TypeOf(exportedTypes[recordType]()).Elem()
+	for i := 0; i < recordStruct.NumField(); i++ {
+		name := strings.Split(recordStruct.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
+func csvValues(record interface{}, columns []string) ([]string, error) {
+	/*
+		Returns the values of a record's fields as CSV cells. Strings are written bare;
+		numbers, booleans, lists and maps as their JSON. A deleted record has empty cells.
+	*/
+	var fields map[string]json.RawMessage
+	values := make([]string, len(columns))
+
+	if record == nil {
+		return values, nil
+	}
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(recordBytes, &fields)
+	if err != nil {
+		return nil, err
+	}
+	for i, column := range columns {
+		raw := fields[column]
+		if len(raw) > 0 && raw[0] == '"' {
+			var value string
+			err = json.Unmarshal(raw, &value)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		} else if string(raw) != "null" {
+			values[i] = string(raw)
+		}
+	}
+	return values, nil
+}
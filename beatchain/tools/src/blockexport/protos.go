@@ -0,0 +1,157 @@
+package main
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+The block, transaction and endorsement messages of Fabric's protos/common and protos/peer
+packages. The export is built outside the chaincode's tree, where only the protobuf runtime
+is shared with it, so these are declared here as the read-write set messages are. Only the
+fields the export reads are declared; the decoder skips the others.
+*/
+
+// Values of the Fabric enums the export compares against
+const (
+	HEADER_TYPE_CONFIG               int32 = 1  // common.HeaderType_CONFIG
+	HEADER_TYPE_ENDORSER_TRANSACTION int32 = 3  // common.HeaderType_ENDORSER_TRANSACTION
+	TX_VALIDATION_CODE_VALID         byte  = 0  // peer.TxValidationCode_VALID
+	TX_VALIDATION_CODE_MVCC_CONFLICT byte  = 11 // peer.TxValidationCode_MVCC_READ_CONFLICT
+	METADATA_TRANSACTIONS_FILTER           = 2  // common.BlockMetadataIndex_TRANSACTIONS_FILTER
+)
+
+type Block struct {
+	Header   *BlockHeader   `protobuf:"bytes,1,opt,name=header"`
+	Data     *BlockData     `protobuf:"bytes,2,opt,name=data"`
+	Metadata *BlockMetadata `protobuf:"bytes,3,opt,name=metadata"`
+}
+
+func (m *Block) Reset()         { *m = Block{} }
+func (m *Block) String() string { return proto.CompactTextString(m) }
+func (*Block) ProtoMessage()    {}
+
+type BlockHeader struct {
+	Number uint64 `protobuf:"varint,1,opt,name=number"`
+}
+
+func (m *BlockHeader) Reset()         { *m = BlockHeader{} }
+func (m *BlockHeader) String() string { return proto.CompactTextString(m) }
+func (*BlockHeader) ProtoMessage()    {}
+
+type BlockData struct {
+	Data [][]byte `protobuf:"bytes,1,rep,name=data,proto3"` // Marshaled Envelopes
+}
+
+func (m *BlockData) Reset()         { *m = BlockData{} }
+func (m *BlockData) String() string { return proto.CompactTextString(m) }
+func (*BlockData) ProtoMessage()    {}
+
+type BlockMetadata struct {
+	Metadata [][]byte `protobuf:"bytes,1,rep,name=metadata,proto3"`
+}
+
+func (m *BlockMetadata) Reset()         { *m = BlockMetadata{} }
+func (m *BlockMetadata) String() string { return proto.CompactTextString(m) }
+func (*BlockMetadata) ProtoMessage()    {}
+
+type Envelope struct {
+	Payload []byte `protobuf:"bytes,1,opt,name=payload,proto3"` // Marshaled Payload
+}
+
+func (m *Envelope) Reset()         { *m = Envelope{} }
+func (m *Envelope) String() string { return proto.CompactTextString(m) }
+func (*Envelope) ProtoMessage()    {}
+
+type Payload struct {
+	Header *Header `protobuf:"bytes,1,opt,name=header"`
+	Data   []byte  `protobuf:"bytes,2,opt,name=data,proto3"` // Marshaled Transaction of an endorser transaction
+}
+
+func (m *Payload) Reset()         { *m = Payload{} }
+func (m *Payload) String() string { return proto.CompactTextString(m) }
+func (*Payload) ProtoMessage()    {}
+
+type Header struct {
+	ChannelHeader []byte `protobuf:"bytes,1,opt,name=channel_header,json=channelHeader,proto3"` // Marshaled ChannelHeader
+}
+
+func (m *Header) Reset()         { *m = Header{} }
+func (m *Header) String() string { return proto.CompactTextString(m) }
+func (*Header) ProtoMessage()    {}
+
+type ChannelHeader struct {
+	Type      int32      `protobuf:"varint,1,opt,name=type"`
+	Timestamp *Timestamp `protobuf:"bytes,3,opt,name=timestamp"`
+	ChannelId string     `protobuf:"bytes,4,opt,name=channel_id,json=channelId"`
+	TxId      string     `protobuf:"bytes,5,opt,name=tx_id,json=txId"`
+}
+
+func (m *ChannelHeader) Reset()         { *m = ChannelHeader{} }
+func (m *ChannelHeader) String() string { return proto.CompactTextString(m) }
+func (*ChannelHeader) ProtoMessage()    {}
+
+type Timestamp struct {
+	Seconds int64 `protobuf:"varint,1,opt,name=seconds"`
+	Nanos   int32 `protobuf:"varint,2,opt,name=nanos"`
+}
+
+func (m *Timestamp) Reset()         { *m = Timestamp{} }
+func (m *Timestamp) String() string { return proto.CompactTextString(m) }
+func (*Timestamp) ProtoMessage()    {}
+
+type Transaction struct {
+	Actions []*TransactionAction `protobuf:"bytes,1,rep,name=actions"`
+}
+
+func (m *Transaction) Reset()         { *m = Transaction{} }
+func (m *Transaction) String() string { return proto.CompactTextString(m) }
+func (*Transaction) ProtoMessage()    {}
+
+type TransactionAction struct {
+	Payload []byte `protobuf:"bytes,2,opt,name=payload,proto3"` // Marshaled ChaincodeActionPayload
+}
+
+func (m *TransactionAction) Reset()         { *m = TransactionAction{} }
+func (m *TransactionAction) String() string { return proto.CompactTextString(m) }
+func (*TransactionAction) ProtoMessage()    {}
+
+type ChaincodeActionPayload struct {
+	Action *ChaincodeEndorsedAction `protobuf:"bytes,2,opt,name=action"`
+}
+
+func (m *ChaincodeActionPayload) Reset()         { *m = ChaincodeActionPayload{} }
+func (m *ChaincodeActionPayload) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeActionPayload) ProtoMessage()    {}
+
+type ChaincodeEndorsedAction struct {
+	ProposalResponsePayload []byte `protobuf:"bytes,1,opt,name=proposal_response_payload,json=proposalResponsePayload,proto3"`
+}
+
+func (m *ChaincodeEndorsedAction) Reset()         { *m = ChaincodeEndorsedAction{} }
+func (m *ChaincodeEndorsedAction) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeEndorsedAction) ProtoMessage()    {}
+
+type ProposalResponsePayload struct {
+	Extension []byte `protobuf:"bytes,2,opt,name=extension,proto3"` // Marshaled ChaincodeAction
+}
+
+func (m *ProposalResponsePayload) Reset()         { *m = ProposalResponsePayload{} }
+func (m *ProposalResponsePayload) String() string { return proto.CompactTextString(m) }
+func (*ProposalResponsePayload) ProtoMessage()    {}
+
+type ChaincodeAction struct {
+	Results     []byte       `protobuf:"bytes,1,opt,name=results,proto3"` // Marshaled TxReadWriteSet
+	ChaincodeId *ChaincodeID `protobuf:"bytes,4,opt,name=chaincode_id,json=chaincodeId"`
+}
+
+func (m *ChaincodeAction) Reset()         { *m = ChaincodeAction{} }
+func (m *ChaincodeAction) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeAction) ProtoMessage()    {}
+
+type ChaincodeID struct {
+	Name string `protobuf:"bytes,2,opt,name=name"`
+}
+
+func (m *ChaincodeID) Reset()         { *m = ChaincodeID{} }
+func (m *ChaincodeID) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeID) ProtoMessage()    {}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/beatchain/utils"
+)
+
+type ExportedRecord struct {
+	/*
+		Defines a record written by a transaction, as exported for analytics
+	*/
+	Block     uint64      `json:"block"`
+	TxId      string      `json:"txid"`
+	Timestamp time.Time   `json:"timestamp"`
+	Type      string      `json:"type"` // Key prefix of the record, e.g. utils.PRODUCT_KEY_PREFIX
+	Id        []string    `json:"id"`   // IDs following the key prefix
+	Deleted   bool        `json:"deleted"`
+	Record    interface{} `json:"record"` // Record as of the write. nil if deleted.
+}
+
+// Record type -> constructor of the record stored under keys of the type
+var exportedTypes = map[string]func() interface{}{
+	utils.CUSTOMER_RECORD_KEY_PREFIX:   func() interface{} { return &utils.CustomerRecord{} },
+	utils.CREATOR_RECORD_KEY_PREFIX:    func() interface{} { return &utils.CreatorRecord{} },
+	utils.APPDEV_RECORD_KEY_PREFIX:     func() interface{} { return &utils.AppDevRecord{} },
+	utils.BANK_ACCOUNT_KEY_PREFIX:      func() interface{} { return &utils.BankAccount{} },
+	utils.PRODUCT_KEY_PREFIX:           func() interface{} { return &utils.Product{} },
+	utils.CONTRACT_KEY_PREFIX:          func() interface{} { return &utils.Contract{} },
+	utils.TOMBSTONE_KEY_PREFIX:         func() interface{} { return &utils.Tombstone{} },
+	utils.WITHDRAWAL_KEY_PREFIX:        func() interface{} { return &utils.Withdrawal{} },
+	utils.TRANSFER_PROPOSAL_KEY_PREFIX: func() interface{} { return &utils.TransferProposal{} },
+	utils.PLAY_LOG_KEY_PREFIX:          func() interface{} { return &utils.PlayLog{} },
+	utils.FLAGGED_STREAM_KEY_PREFIX:    func() interface{} { return &utils.FlaggedStream{} },
+	utils.PLAYLIST_KEY_PREFIX:          func() interface{} { return &utils.Playlist{} },
+	utils.PLAY_QUEUE_KEY_PREFIX:        func() interface{} { return &utils.PlayQueue{} },
+	utils.DISPUTE_KEY_PREFIX:           func() interface{} { return &utils.Dispute{} },
+}
+
+func splitRecordKey(key string) (string, []string, bool) {
+	/*
+		Splits a composite key of the chaincode's KEY_OBJECT_FORMAT into the record type and
+		IDs, as stub.SplitCompositeKey does
+
+		Returns:
+			recordType: Key prefix, e.g. utils.PRODUCT_KEY_PREFIX
+			ids: IDs following the key prefix
+			ok: false if the key is not a composite key of KEY_OBJECT_FORMAT
+	*/
+	// Composite keys are "\x00" followed by the object type and attributes, each ending in "\x00"
+	if !strings.HasPrefix(key, "\x00") || !strings.HasSuffix(key, "\x00") {
+		return "", nil, false
+	}
+	components := strings.Split(key[1:len(key)-1], "\x00")
+	if len(components) < 2 || components[0] != utils.KEY_OBJECT_FORMAT {
+		return "", nil, false
+	}
+	return components[1], components[2:], true
+}
+
+func ExportRecord(write *KeyWrite) (*ExportedRecord, error) {
+	/*
+		Decodes a key write into the typed record it stores. Records stored by earlier schema
+		versions are migrated to the current version, as the chaincode reads them.
+
+		Returns:
+			record: The exported record. nil if the key holds no exported record type, such as
+				an index entry or a ledger setting.
+			err: Error object. nil if no error occurred.
+	*/
+	recordType, ids, ok := splitRecordKey(write.Key)
+	if !ok {
+		return nil, nil
+	}
+	newRecord, ok := exportedTypes[recordType]
+	if !ok {
+		return nil, nil
+	}
+
+	exported := &ExportedRecord{
+		Block:     write.Block,
+		TxId:      write.TxId,
+		Timestamp: write.Timestamp,
+		Type:      recordType,
+		Id:        ids,
+		Deleted:   write.IsDelete}
+	if write.IsDelete {
+		return exported, nil
+	}
+	record := newRecord()
+	err := utils.UnmarshalRecord(recordType, write.Value, record)
+	if err != nil {
+		return nil, utils.WrapError(err, "error decoding %s record written by %s", recordType, write.TxId)
+	}
+	exported.Record = record
+	return exported, nil
+}
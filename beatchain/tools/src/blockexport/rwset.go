@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+The read-write set messages of Fabric's protos/ledger/rwset and protos/ledger/rwset/kvrwset
+packages, which are not vendored with the shim. Only the fields the export reads are
+declared; the decoder skips the others.
+*/
+
+type TxReadWriteSet struct {
+	DataModel int32             `protobuf:"varint,1,opt,name=data_model,json=dataModel"`
+	NsRwset   []*NsReadWriteSet `protobuf:"bytes,2,rep,name=ns_rwset,json=nsRwset"`
+}
+
+func (m *TxReadWriteSet) Reset()         { *m = TxReadWriteSet{} }
+func (m *TxReadWriteSet) String() string { return proto.CompactTextString(m) }
+func (*TxReadWriteSet) ProtoMessage()    {}
+
+type NsReadWriteSet struct {
+	Namespace string `protobuf:"bytes,1,opt,name=namespace"`
+	Rwset     []byte `protobuf:"bytes,2,opt,name=rwset,proto3"` // Marshaled KVRWSet
+}
+
+func (m *NsReadWriteSet) Reset()         { *m = NsReadWriteSet{} }
+func (m *NsReadWriteSet) String() string { return proto.CompactTextString(m) }
+func (*NsReadWriteSet) ProtoMessage()    {}
+
+type KVRWSet struct {
+	Writes []*KVWrite `protobuf:"bytes,3,rep,name=writes"`
+}
+
+func (m *KVRWSet) Reset()         { *m = KVRWSet{} }
+func (m *KVRWSet) String() string { return proto.CompactTextString(m) }
+func (*KVRWSet) ProtoMessage()    {}
+
+type KVWrite struct {
+	Key      string `protobuf:"bytes,1,opt,name=key"`
+	IsDelete bool   `protobuf:"varint,2,opt,name=is_delete,json=isDelete"`
+	Value    []byte `protobuf:"bytes,3,opt,name=value,proto3"`
+}
+
+func (m *KVWrite) Reset()         { *m = KVWrite{} }
+func (m *KVWrite) String() string { return proto.CompactTextString(m) }
+func (*KVWrite) ProtoMessage()    {}